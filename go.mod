@@ -3,10 +3,14 @@ module gitlab.com/project-emco/samples/temporal/migrate-workflow
 go 1.16
 
 require (
+	github.com/gogo/protobuf v1.3.2
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/stretchr/testify v1.7.0
 	gitlab.com/project-emco/core/emco-base/src/workflowmgr v0.0.0-00010101000000-000000000000
+	go.temporal.io/api v1.7.0
 	go.temporal.io/sdk v1.13.1
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 replace (
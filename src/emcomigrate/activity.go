@@ -12,6 +12,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 )
 
 // TODO REVISIT Copied from EMCO as import leads to conflicts
@@ -72,9 +80,17 @@ type AnyOf struct {
 // more app intents. An app intent specifies the cluster mapping for a
 // single app (helm chart).
 func GetDigAppIntents(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
 
 	fmt.Printf("GetDigAppIntents got params: %#v\n", migParam)
 
+	rule, err := newIntentRule(migParam.InParams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "GetDigAppIntents: %s\n", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidParam", nil)
+	}
+
 	gpiUrl := buildGenericPlacementIntentsURL(migParam.InParams)
 	fmt.Printf("\nGetDigAppIntents: gpiUrl = %s\n", gpiUrl)
 
@@ -115,11 +131,16 @@ func GetDigAppIntents(ctx context.Context, migParam MigParam) (*MigParam, error)
 		// Build list of appName/appIbtentName pairs for this gpIntent
 		appIntentNames := make([]AppNameIntentPair, 0, len(appIntents))
 		for _, appIntent := range appIntents {
+			sourceIntent := appIntent.Spec.Intent
 			pair := AppNameIntentPair{
 				AppName:       appIntent.Spec.AppName,
 				AppIntentName: appIntent.MetaData.Name,
+				OnTarget:      isOnTarget(appIntent.Spec.Intent, rule),
+				SourceIntent:  &sourceIntent,
 			}
 			appIntentNames = append(appIntentNames, pair)
+			migParam.SourceClusters = appendClusters(migParam.SourceClusters,
+				appIntent.Spec.Intent)
 		}
 		migParam.AppNameIntentPairs[gpIntent.MetaData.Name] = appIntentNames
 	}
@@ -127,72 +148,268 @@ func GetDigAppIntents(ctx context.Context, migParam MigParam) (*MigParam, error)
 	return &migParam, nil
 }
 
+// Workflow params that spread the updates of the app intents of a DIG.
+const (
+	// How many app intents an UpdateAppIntents activity updates at once.
+	// The default, 1, updates them one after another.
+	UpdateParallelismParam = "updateParallelism"
+	// "true" runs one UpdateAppIntents activity per generic placement
+	// intent, all at the same time. The default is "false".
+	UpdatePerGPIParam = "updatePerGPI"
+)
+
+// updateOptions returns the UpdateParallelismParam and UpdatePerGPIParam
+// params.
+func updateOptions(inParams map[string]string) (int, bool, error) {
+	parallelism := 1
+	if s, ok := inParams[UpdateParallelismParam]; ok {
+		var err error
+		if parallelism, err = strconv.Atoi(s); err != nil || parallelism < 1 {
+			return 0, false, fmt.Errorf("Invalid %s param %q: expect a positive number",
+				UpdateParallelismParam, s)
+		}
+	}
+	perGPI, err := boolParam(inParams, UpdatePerGPIParam)
+	if err != nil {
+		return 0, false, err
+	}
+	return parallelism, perGPI, nil
+}
+
+// boolParam returns the given "true" or "false" param; false if it is not
+// set.
+func boolParam(inParams map[string]string, name string) (bool, error) {
+	s, ok := inParams[name]
+	if !ok {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s param %q: expect true or false", name, s)
+	}
+	return value, nil
+}
+
 // UpdateAppIntents updates the app intents for a DIG to map all apps in that
 // DIG to a given target cluster. It builds the modified app intents locally
 // and then does a POST call to EMCO API to update the DIG's app intents.
 // The actual app migration happens only in the next activity, not here.
+// How each placement intent is rewritten is set by IntentRewriteParam.
+// App intents that already have the target placement are not PUT again,
+// so the activity can be retried. The app intents that the migration
+// changed are recorded in migParam.ChangedAppIntents, including those that
+// an earlier attempt changed, with the diffs of their placement intents in
+// migParam.IntentDiffs. If there are none, and the apps are deployed as the
+// rewrite mode places them on the target cluster, migParam.AlreadyOnTarget
+// is set.
+// The progress is recorded in heartbeats after each app intent, and a
+// retried attempt skips the app intents that an earlier attempt did.
+// Up to UpdateParallelismParam app intents are updated at once. If some of
+// them fail, the others are still updated, and the error lists the
+// failures per app.
 func UpdateAppIntents(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	hb := startHeartbeat(ctx)
+	defer hb.stop()
 
-	// Update the intents, walking through migParam.AppNameIntentPairs map
-	newAppSpecIntent := IntentStruc{ // all apps get this spec intent
-		AllOfArray: []AllOf{
-			{
-				ProviderName: migParam.InParams["targetClusterProvider"],
-				ClusterName:  migParam.InParams["targetClusterName"],
-			},
-		},
+	var rule intentRule
+	parallelism, _, err := updateOptions(migParam.InParams)
+	if err == nil {
+		rule, err = newIntentRule(migParam.InParams)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "UpdateAppIntents: %s\n", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidParam", nil)
 	}
 
-	for gpIntentName, appNameIntentPairs := range migParam.AppNameIntentPairs {
+	var progress updateProgress
+	if activity.HasHeartbeatDetails(ctx) {
+		if err := activity.GetHeartbeatDetails(ctx, &progress); err != nil {
+			fmt.Fprintf(os.Stderr, "UpdateAppIntents: ignoring progress of "+
+				"earlier attempt: %s\n", err)
+			progress = updateProgress{}
+		} else {
+			fmt.Printf("UpdateAppIntents: resuming after %d app intents\n",
+				len(progress.Done))
+		}
+	}
+	done := make(map[string]bool, len(progress.Done))
+	for _, key := range progress.Done {
+		done[key] = true
+	}
+	if progress.Changed == nil {
+		progress.Changed = []string{}
+	}
+	if progress.Diffs == nil {
+		progress.Diffs = map[string][]string{}
+	}
+
+	// Update the intents, walking through migParam.AppNameIntentPairs map
+	var (
+		mu       sync.Mutex // guards progress and failures
+		failures []string   // per app
+		wg       sync.WaitGroup
+	)
+	slots := make(chan struct{}, parallelism)
+update:
+	for _, gpIntentName := range sortedGPIs(migParam.AppNameIntentPairs) {
 		appIntentBaseURL := buildAppIntentsURL(
 			migParam.GenericPlacementIntentURL, gpIntentName)
-		for _, appNameIntentPair := range appNameIntentPairs {
-			appIntentURL := appIntentBaseURL + "/" + appNameIntentPair.AppIntentName
-			newAppIntent := AppIntent{
-				MetaData: MetaData{Name: appNameIntentPair.AppIntentName},
-				Spec: SpecData{
-					AppName: appNameIntentPair.AppName,
-					Intent:  newAppSpecIntent,
-				},
+		for _, appNameIntentPair := range migParam.AppNameIntentPairs[gpIntentName] {
+			key := gpIntentName + "/" + appNameIntentPair.AppIntentName
+			if done[key] {
+				continue
 			}
-			appIntentJSON, err := json.Marshal(newAppIntent)
-			if err != nil {
-				encodeErr := fmt.Errorf("Error marshaling appIntent %#v\n"+
-					"Marshal error; %#v\n", newAppIntent, err)
-				fmt.Fprintf(os.Stderr, encodeErr.Error())
-				return nil, encodeErr
+			slots <- struct{}{}
+			if ctx.Err() != nil {
+				break update
 			}
 
-			fmt.Printf("\nappIntentURL: %s\nappIntent: %#v\n\n",
-				appIntentURL, newAppIntent)
-
-			req, err := http.NewRequest(http.MethodPut,
-				appIntentURL, bytes.NewBuffer(appIntentJSON))
-			req.Header.Set("Content-Type", "application/json; charset=utf-8")
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				putErr := fmt.Errorf("HTTP PUT failed for URL %s.\nError: %s\n",
-					appIntentURL, err)
-				fmt.Fprintf(os.Stderr, putErr.Error())
-				return nil, putErr
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				putErr := fmt.Errorf("HTTP PUT returned status code %s for URL %s.\n",
-					resp.Status, appIntentURL)
-				fmt.Fprintf(os.Stderr, putErr.Error())
-				return nil, putErr
-			}
+			appIntentURL := appIntentBaseURL + "/" + appNameIntentPair.AppIntentName
+			wg.Add(1)
+			go func(appNameIntentPair AppNameIntentPair, key, appIntentURL string) {
+				defer wg.Done()
+				defer func() { <-slots }()
+
+				put, diff, err := updateAppIntent(appIntentURL, appNameIntentPair,
+					rule.rewrite)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failures = append(failures, fmt.Sprintf("app %s (%s): %s",
+						appNameIntentPair.AppName, key, strings.TrimSpace(err.Error())))
+					return
+				}
+				// Changed by this migration, maybe in an earlier attempt, or by
+				// someone else since GetDigAppIntents.
+				if !appNameIntentPair.OnTarget || put {
+					progress.Changed = append(progress.Changed, key)
+					if !put && appNameIntentPair.SourceIntent != nil {
+						source := *appNameIntentPair.SourceIntent
+						diff = intentDiff(source, rule.rewrite(source))
+					}
+					if diff != nil {
+						progress.Diffs[key] = diff
+					}
+				}
+				progress.Done = append(progress.Done, key)
+				hb.record(progress.copy())
+			}(appNameIntentPair, key, appIntentURL)
 		}
 	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		updateErr := fmt.Errorf("Failed to update %d app intents:\n  %s\n",
+			len(failures), strings.Join(failures, "\n  "))
+		fmt.Fprintf(os.Stderr, updateErr.Error())
+		return nil, updateErr
+	}
 
+	changed := append([]string{}, progress.Changed...)
+	sort.Strings(changed)
+	migParam.ChangedAppIntents = changed
+	migParam.IntentDiffs = progress.Diffs
+	migParam.AlreadyOnTarget = len(changed) == 0 && isDeployedOnTarget(migParam.InParams, rule)
 	return &migParam, nil
 }
 
+// updateProgress is the progress of UpdateAppIntents, recorded in its
+// heartbeats. App intents are given as gpIntentName/appIntentName.
+type updateProgress struct {
+	Done    []string            // app intents that are on the target
+	Changed []string            // app intents that the migration changed
+	Diffs   map[string][]string // intent diffs of the changed app intents
+}
+
+// copy returns a copy that does not change when p is changed.
+func (p updateProgress) copy() updateProgress {
+	diffs := make(map[string][]string, len(p.Diffs))
+	for key, diff := range p.Diffs {
+		diffs[key] = diff
+	}
+	return updateProgress{
+		Done:    append([]string{}, p.Done...),
+		Changed: append([]string{}, p.Changed...),
+		Diffs:   diffs,
+	}
+}
+
+// updateAppIntent PUTs the app intent at the given URL with its placement
+// intent rewritten by the given function, unless that leaves it as it is.
+// It reports whether it did, and the diff of the placement intent.
+func updateAppIntent(appIntentURL string, appNameIntentPair AppNameIntentPair,
+	rewrite func(IntentStruc) IntentStruc) (bool, []string, error) {
+
+	respBody, err := getHttpRespBody(appIntentURL)
+	if err != nil {
+		return false, nil, err
+	}
+	var curAppIntent AppIntent
+	if err := json.Unmarshal(respBody, &curAppIntent); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET responde body for "+
+			"URL %s.\nDecoder error: %#v\n", appIntentURL, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return false, nil, decodeErr
+	}
+	newAppSpecIntent := rewrite(curAppIntent.Spec.Intent)
+	if curAppIntent.Spec.AppName == appNameIntentPair.AppName &&
+		reflect.DeepEqual(curAppIntent.Spec.Intent, newAppSpecIntent) {
+		fmt.Printf("\nappIntentURL: %s has the placement intent already, skipping\n",
+			appIntentURL)
+		return false, nil, nil
+	}
+	diff := intentDiff(curAppIntent.Spec.Intent, newAppSpecIntent)
+
+	newAppIntent := AppIntent{
+		MetaData: MetaData{Name: appNameIntentPair.AppIntentName},
+		Spec: SpecData{
+			AppName: appNameIntentPair.AppName,
+			Intent:  newAppSpecIntent,
+		},
+	}
+	appIntentJSON, err := json.Marshal(newAppIntent)
+	if err != nil {
+		encodeErr := fmt.Errorf("Error marshaling appIntent %#v\n"+
+			"Marshal error; %#v\n", newAppIntent, err)
+		fmt.Fprintf(os.Stderr, encodeErr.Error())
+		return false, nil, encodeErr
+	}
+
+	fmt.Printf("\nappIntentURL: %s\nappIntent: %#v\n\n",
+		appIntentURL, newAppIntent)
+
+	req, err := http.NewRequest(http.MethodPut,
+		appIntentURL, bytes.NewBuffer(appIntentJSON))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		putErr := fmt.Errorf("HTTP PUT failed for URL %s.\nError: %s\n",
+			appIntentURL, err)
+		fmt.Fprintf(os.Stderr, putErr.Error())
+		return false, nil, putErr
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		putErr := fmt.Errorf("HTTP PUT returned status code %s for URL %s.\n",
+			resp.Status, appIntentURL)
+		fmt.Fprintf(os.Stderr, putErr.Error())
+		return false, nil, putErr
+	}
+	fmt.Printf("appIntentURL: %s intent diff:\n  %s\n", appIntentURL,
+		strings.Join(diff, "\n  "))
+	return true, diff, nil
+}
+
 // DoDigUpdate calls EMCO's /update API to migrate the app.
 func DoDigUpdate(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
 
 	// POST dig update operation
 	digURL := buildDigURL(migParam.InParams)
@@ -216,6 +433,128 @@ func DoDigUpdate(ctx context.Context, migParam MigParam) (*MigParam, error) {
 	return &migParam, nil
 }
 
+// appendClusters appends the clusters named in the given intent to the list,
+// as provider+cluster, skipping duplicates. Label-based placements are not
+// resolved to clusters.
+func appendClusters(clusters []string, intent IntentStruc) []string {
+	add := func(provider, cluster string) {
+		if cluster == "" {
+			return
+		}
+		name := provider + "+" + cluster
+		for _, c := range clusters {
+			if c == name {
+				return
+			}
+		}
+		clusters = append(clusters, name)
+	}
+	for _, allOf := range intent.AllOfArray {
+		add(allOf.ProviderName, allOf.ClusterName)
+		for _, anyOf := range allOf.AnyOfArray {
+			add(anyOf.ProviderName, anyOf.ClusterName)
+		}
+	}
+	for _, anyOf := range intent.AnyOfArray {
+		add(anyOf.ProviderName, anyOf.ClusterName)
+	}
+	return clusters
+}
+
+// isOnTarget reports whether the given placement intent is as the rule
+// rewrites it: with IntentRewriteAddTarget, once allOf names the target
+// cluster; with IntentRewriteReplaceCluster, once the replaced cluster is
+// not named any more.
+func isOnTarget(intent IntentStruc, rule intentRule) bool {
+	return reflect.DeepEqual(rule.rewrite(intent), intent)
+}
+
+// digStatus is the part of EMCO's DIG status that tells the state of the
+// DIG and where its apps are deployed.
+type digStatus struct {
+	States struct {
+		Actions []struct {
+			State string `json:"state"`
+		} `json:"actions"`
+	} `json:"states"`
+	DeployedStatus string `json:"deployedStatus"`
+	Apps           []struct {
+		Name     string `json:"name"`
+		Clusters []struct {
+			ProviderName   string `json:"clusterProvider"`
+			ClusterName    string `json:"cluster"`
+			DeployedStatus string `json:"deployedStatus"`
+			ReadyStatus    string `json:"readyStatus"`
+		} `json:"clusters"`
+	} `json:"apps"`
+}
+
+// state returns the lifecycle state of the DIG, such as "Instantiated".
+func (s *digStatus) state() string {
+	if n := len(s.States.Actions); n > 0 {
+		return s.States.Actions[n-1].State
+	}
+	return s.DeployedStatus
+}
+
+// appsOn returns how many apps of the DIG are deployed on the given cluster.
+func (s *digStatus) appsOn(provider, cluster string) int {
+	n := 0
+	for _, app := range s.Apps {
+		for _, c := range app.Clusters {
+			if c.ProviderName == provider && c.ClusterName == cluster {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// deployedOnlyOn reports whether all apps of the DIG are deployed on the
+// given cluster, and on no other cluster.
+func (s *digStatus) deployedOnlyOn(provider, cluster string) bool {
+	if len(s.Apps) == 0 {
+		return false
+	}
+	for _, app := range s.Apps {
+		if len(app.Clusters) != 1 ||
+			app.Clusters[0].ProviderName != provider ||
+			app.Clusters[0].ClusterName != cluster {
+			return false
+		}
+	}
+	return true
+}
+
+// getDigStatus gets the status of the DIG of a migration.
+func getDigStatus(params map[string]string) (*digStatus, error) {
+	statusURL := buildDigURL(params) + "/status"
+	respBody, err := getHttpRespBody(statusURL)
+	if err != nil {
+		return nil, err
+	}
+	var status digStatus
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET response body for URL %s.\n"+
+			"Decoder error: %#v\n", statusURL, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return nil, decodeErr
+	}
+	return &status, nil
+}
+
+// isDeployedOnTarget reports whether EMCO has deployed the apps of the DIG
+// as the given rule places them on the target cluster. It returns false if
+// the status cannot be read.
+func isDeployedOnTarget(params map[string]string, rule intentRule) bool {
+	status, err := getDigStatus(params)
+	if err != nil {
+		return false
+	}
+	return rule.isDeployed(status)
+}
+
 func buildDigURL(params map[string]string) string {
 	url := params["emcoURL"]
 	url += "/v2/projects/" + params["project"]
@@ -238,6 +577,21 @@ func buildAppIntentsURL(gpiURL string, gpiName string) string {
 	return url
 }
 
+// httpStatusError is the error of an EMCO call that returned an unexpected
+// HTTP status code.
+type httpStatusError struct {
+	statusCode int
+	msg        string
+}
+
+func (e *httpStatusError) Error() string { return e.msg }
+
+// isNotFound reports whether err is an EMCO call that returned 404.
+func isNotFound(err error) bool {
+	statusErr, ok := err.(*httpStatusError)
+	return ok && statusErr.statusCode == http.StatusNotFound
+}
+
 // func getHttpRespBody(url string) (io.ReadCloser, error) {
 func getHttpRespBody(url string) ([]byte, error) {
 	resp, err := http.Get(url)
@@ -250,8 +604,11 @@ func getHttpRespBody(url string) ([]byte, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		getErr := fmt.Errorf("HTTP GET returned status code %s for URL %s.\n",
-			resp.Status, url)
+		getErr := &httpStatusError{
+			statusCode: resp.StatusCode,
+			msg: fmt.Sprintf("HTTP GET returned status code %s for URL %s.\n",
+				resp.Status, url),
+		}
 		fmt.Fprintf(os.Stderr, getErr.Error())
 		return nil, getErr
 	}
@@ -263,3 +620,49 @@ func getHttpRespBody(url string) ([]byte, error) {
 
 	return b, nil
 }
+
+// postToEmco POSTs the given body as JSON, or no body if it is nil, and
+// expects the given status code.
+func postToEmco(url string, body interface{}, wantStatus int) error {
+	_, err := sendToEmco(http.MethodPost, url, body, wantStatus)
+	return err
+}
+
+// sendToEmco sends a request with the given body as JSON, or no body if it
+// is nil, and returns the status code, which must be one of the given ones.
+func sendToEmco(method, url string, body interface{}, okStatus ...int) (int, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			encodeErr := fmt.Errorf("Error marshaling %#v\nMarshal error; %#v\n",
+				body, err)
+			fmt.Fprintf(os.Stderr, encodeErr.Error())
+			return 0, encodeErr
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		sendErr := fmt.Errorf("HTTP %s failed for URL %s.\nError: %s\n", method, url, err)
+		fmt.Fprintf(os.Stderr, sendErr.Error())
+		return 0, sendErr
+	}
+	defer resp.Body.Close()
+
+	for _, status := range okStatus {
+		if resp.StatusCode == status {
+			return resp.StatusCode, nil
+		}
+	}
+	sendErr := &httpStatusError{resp.StatusCode,
+		fmt.Sprintf("HTTP %s returned status code %s for URL %s.\n", method, resp.Status, url)}
+	fmt.Fprintf(os.Stderr, sendErr.Error())
+	return resp.StatusCode, sendErr
+}
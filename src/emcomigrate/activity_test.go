@@ -0,0 +1,590 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+)
+
+const (
+	testDigPath = "/v2/projects/proj1/composite-apps/capp1/v1/deployment-intent-groups/dig1"
+	testGpiPath = testDigPath + "/generic-placement-intents"
+	testAppPath = testGpiPath + "/dig1-placement-intent/app-intents"
+)
+
+// recordedResponse is an EMCO response, with the body read from a file
+// under testdata/emco.
+type recordedResponse struct {
+	status int
+	file   string
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	body   []byte
+}
+
+// recordedEmco serves recorded EMCO responses by method and path, and
+// records the requests that it gets.
+type recordedEmco struct {
+	*httptest.Server
+	t         *testing.T
+	responses map[string]recordedResponse // indexed by "METHOD path"
+	mu        sync.Mutex
+	requests  []recordedRequest
+}
+
+func newRecordedEmco(t *testing.T, responses map[string]recordedResponse) *recordedEmco {
+	e := &recordedEmco{t: t, responses: responses}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.serve))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *recordedEmco) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mu.Lock()
+	e.requests = append(e.requests, recordedRequest{r.Method, r.URL.Path, body})
+	e.mu.Unlock()
+
+	resp, ok := e.responses[r.Method+" "+r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if resp.file == "" {
+		w.WriteHeader(resp.status)
+		return
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "emco", resp.file))
+	if err != nil {
+		e.t.Errorf("failed to read recorded response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	w.Write(data)
+}
+
+func (e *recordedEmco) requestsFor(method string) []recordedRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	reqs := []recordedRequest{}
+	for _, req := range e.requests {
+		if req.method == method {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+func (e *recordedEmco) inParams() map[string]string {
+	params := testInParams()
+	params["emcoURL"] = e.URL
+	return params
+}
+
+func runActivity(t *testing.T, activity interface{}, migParam MigParam) (*MigParam, error) {
+	var ts testsuite.WorkflowTestSuite
+	env := ts.NewTestActivityEnvironment()
+	env.RegisterActivity(activity)
+	value, err := env.ExecuteActivity(activity, migParam)
+	if err != nil {
+		return nil, err
+	}
+	var result MigParam
+	require.NoError(t, value.Get(&result))
+	return &result, nil
+}
+
+func TestGetDigAppIntents(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testGpiPath: {http.StatusOK, "generic-placement-intents.json"},
+		"GET " + testAppPath: {http.StatusOK, "app-intents.json"},
+	})
+
+	result, err := runActivity(t, GetDigAppIntents, MigParam{InParams: emco.inParams()})
+	require.NoError(t, err)
+
+	assert.Equal(t, emco.URL+testGpiPath, result.GenericPlacementIntentURL)
+	assert.Equal(t, map[string][]AppNameIntentPair{
+		"dig1-placement-intent": {
+			{
+				AppName:       "collectd",
+				AppIntentName: "collectd-placement-intent",
+				SourceIntent: &IntentStruc{AllOfArray: []AllOf{
+					{ProviderName: "provider1", ClusterName: "cluster1"},
+				}},
+			},
+			{
+				AppName:       "operator",
+				AppIntentName: "operator-placement-intent",
+				SourceIntent: &IntentStruc{AllOfArray: []AllOf{{AnyOfArray: []AnyOf{
+					{ProviderName: "provider1", ClusterName: "cluster1"},
+					{ProviderName: "provider1", ClusterName: "cluster3"},
+				}}}},
+			},
+		},
+	}, result.AppNameIntentPairs)
+	assert.Equal(t, []string{"provider1+cluster1", "provider1+cluster3"},
+		result.SourceClusters)
+	assert.Equal(t, emco.inParams(), result.InParams)
+}
+
+func TestGetDigAppIntentsNoDig(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+
+	_, err := runActivity(t, GetDigAppIntents, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404 Not Found")
+}
+
+func TestGetDigAppIntentsBadResponse(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testGpiPath: {http.StatusOK, "app-intent-updated.json"},
+	})
+
+	_, err := runActivity(t, GetDigAppIntents, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to decode")
+}
+
+func TestGetDigAppIntentsAppIntentsError(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testGpiPath: {http.StatusOK, "generic-placement-intents.json"},
+		"GET " + testAppPath: {http.StatusInternalServerError, ""},
+	})
+
+	_, err := runActivity(t, GetDigAppIntents, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "500 Internal Server Error")
+}
+
+const (
+	testCollectdPath = testAppPath + "/collectd-placement-intent"
+	testOperatorPath = testAppPath + "/operator-placement-intent"
+)
+
+func TestUpdateAppIntents(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "collectd-placement-intent.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"PUT " + testCollectdPath: {http.StatusOK, "app-intent-updated.json"},
+		"PUT " + testOperatorPath: {http.StatusOK, ""},
+	})
+	migParam := MigParam{
+		InParams:                  emco.inParams(),
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+				{AppName: "operator", AppIntentName: "operator-placement-intent"},
+			},
+		},
+	}
+
+	result, err := runActivity(t, UpdateAppIntents, migParam)
+	require.NoError(t, err)
+	expected := migParam
+	expected.ChangedAppIntents = []string{
+		"dig1-placement-intent/collectd-placement-intent",
+		"dig1-placement-intent/operator-placement-intent",
+	}
+	expected.IntentDiffs = map[string][]string{
+		"dig1-placement-intent/collectd-placement-intent": {
+			"- allOf provider1+cluster1",
+			"+ allOf provider2+cluster2",
+		},
+		"dig1-placement-intent/operator-placement-intent": {
+			"- allOf anyOf(provider1+cluster1, provider1+cluster3)",
+			"+ allOf provider2+cluster2",
+		},
+	}
+	assert.Equal(t, expected, *result)
+
+	puts := emco.requestsFor(http.MethodPut)
+	require.Len(t, puts, 2)
+	sort.Slice(puts, func(i, j int) bool { return puts[i].path < puts[j].path })
+	assert.Equal(t, testCollectdPath, puts[0].path)
+	assert.Equal(t, testOperatorPath, puts[1].path)
+
+	var appIntent AppIntent
+	require.NoError(t, json.Unmarshal(puts[0].body, &appIntent))
+	assert.Equal(t, AppIntent{
+		MetaData: MetaData{Name: "collectd-placement-intent"},
+		Spec: SpecData{
+			AppName: "collectd",
+			Intent: IntentStruc{
+				AllOfArray: []AllOf{{ProviderName: "provider2", ClusterName: "cluster2"}},
+			},
+		},
+	}, appIntent)
+}
+
+func TestUpdateAppIntentsRetried(t *testing.T) {
+	// collectd was updated by an earlier attempt
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "app-intent-updated.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"PUT " + testOperatorPath: {http.StatusOK, ""},
+	})
+	migParam := MigParam{
+		InParams:                  emco.inParams(),
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+				{AppName: "operator", AppIntentName: "operator-placement-intent"},
+			},
+		},
+	}
+
+	result, err := runActivity(t, UpdateAppIntents, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"dig1-placement-intent/collectd-placement-intent",
+		"dig1-placement-intent/operator-placement-intent",
+	}, result.ChangedAppIntents)
+	assert.False(t, result.AlreadyOnTarget)
+
+	puts := emco.requestsFor(http.MethodPut)
+	require.Len(t, puts, 1)
+	assert.Equal(t, testOperatorPath, puts[0].path)
+}
+
+func TestUpdateAppIntentsResumed(t *testing.T) {
+	// An earlier attempt updated collectd and then timed out.
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"PUT " + testOperatorPath: {http.StatusOK, ""},
+	})
+	migParam := MigParam{
+		InParams:                  emco.inParams(),
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+				{AppName: "operator", AppIntentName: "operator-placement-intent"},
+			},
+		},
+	}
+	var ts testsuite.WorkflowTestSuite
+	env := ts.NewTestActivityEnvironment()
+	env.RegisterActivity(UpdateAppIntents)
+	env.SetHeartbeatDetails(updateProgress{
+		Done:    []string{"dig1-placement-intent/collectd-placement-intent"},
+		Changed: []string{"dig1-placement-intent/collectd-placement-intent"},
+	})
+
+	value, err := env.ExecuteActivity(UpdateAppIntents, migParam)
+	require.NoError(t, err)
+	var result MigParam
+	require.NoError(t, value.Get(&result))
+	assert.Equal(t, []string{
+		"dig1-placement-intent/collectd-placement-intent",
+		"dig1-placement-intent/operator-placement-intent",
+	}, result.ChangedAppIntents)
+
+	assert.Len(t, emco.requestsFor(http.MethodGet), 1)
+	puts := emco.requestsFor(http.MethodPut)
+	require.Len(t, puts, 1)
+	assert.Equal(t, testOperatorPath, puts[0].path)
+}
+
+func TestUpdateAppIntentsAlreadyOnTarget(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		statusFile      string
+		alreadyOnTarget bool
+	}{
+		{"deployed on target", "dig-status-on-target.json", true},
+		// as after a migration that failed before DoDigUpdate
+		{"deployed on source", "dig-status.json", false},
+		{"no status", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			responses := map[string]recordedResponse{
+				"GET " + testCollectdPath: {http.StatusOK, "app-intent-updated.json"},
+			}
+			if tc.statusFile != "" {
+				responses["GET "+testDigPath+"/status"] = recordedResponse{
+					http.StatusOK, tc.statusFile}
+			}
+			emco := newRecordedEmco(t, responses)
+			migParam := MigParam{
+				InParams:                  emco.inParams(),
+				GenericPlacementIntentURL: emco.URL + testGpiPath,
+				AppNameIntentPairs: map[string][]AppNameIntentPair{
+					"dig1-placement-intent": {
+						{AppName: "collectd", AppIntentName: "collectd-placement-intent",
+							OnTarget: true},
+					},
+				},
+			}
+
+			result, err := runActivity(t, UpdateAppIntents, migParam)
+			require.NoError(t, err)
+			assert.Empty(t, result.ChangedAppIntents)
+			assert.Equal(t, tc.alreadyOnTarget, result.AlreadyOnTarget)
+			assert.Empty(t, emco.requestsFor(http.MethodPut))
+		})
+	}
+}
+
+func TestUpdateAppIntentsError(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "collectd-placement-intent.json"},
+		"PUT " + testCollectdPath: {http.StatusBadRequest, ""},
+	})
+	migParam := MigParam{
+		InParams:                  emco.inParams(),
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+			},
+		},
+	}
+
+	_, err := runActivity(t, UpdateAppIntents, migParam)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request")
+}
+
+func TestUpdateAppIntentsParallel(t *testing.T) {
+	otherAppPath := testGpiPath + "/other-placement-intent/app-intents"
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "collectd-placement-intent.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"GET " + otherAppPath + "/collectd-placement-intent": {
+			http.StatusOK, "collectd-placement-intent.json"},
+		"PUT " + testCollectdPath:                            {http.StatusOK, ""},
+		"PUT " + testOperatorPath:                            {http.StatusOK, ""},
+		"PUT " + otherAppPath + "/collectd-placement-intent": {http.StatusOK, ""},
+	})
+	inParams := emco.inParams()
+	inParams[UpdateParallelismParam] = "2"
+	migParam := MigParam{
+		InParams:                  inParams,
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+				{AppName: "operator", AppIntentName: "operator-placement-intent"},
+			},
+			"other-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+			},
+		},
+	}
+
+	result, err := runActivity(t, UpdateAppIntents, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"dig1-placement-intent/collectd-placement-intent",
+		"dig1-placement-intent/operator-placement-intent",
+		"other-placement-intent/collectd-placement-intent",
+	}, result.ChangedAppIntents)
+
+	puts := emco.requestsFor(http.MethodPut)
+	paths := []string{}
+	for _, put := range puts {
+		paths = append(paths, put.path)
+	}
+	assert.ElementsMatch(t, []string{testCollectdPath, testOperatorPath,
+		otherAppPath + "/collectd-placement-intent"}, paths)
+}
+
+func TestUpdateAppIntentsErrorsPerApp(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "collectd-placement-intent.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"PUT " + testCollectdPath: {http.StatusBadRequest, ""},
+		"PUT " + testOperatorPath: {http.StatusOK, ""},
+	})
+	migParam := MigParam{
+		InParams:                  emco.inParams(),
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+				{AppName: "operator", AppIntentName: "operator-placement-intent"},
+			},
+		},
+	}
+
+	_, err := runActivity(t, UpdateAppIntents, migParam)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to update 1 app intents")
+	assert.Contains(t, err.Error(),
+		"app collectd (dig1-placement-intent/collectd-placement-intent)")
+	assert.NotContains(t, err.Error(), "app operator")
+	// The failure of collectd does not stop the update of operator.
+	assert.Len(t, emco.requestsFor(http.MethodPut), 2)
+}
+
+func TestUpdateAppIntentsAlreadyOnTargetModes(t *testing.T) {
+	replaceCluster := map[string]string{
+		IntentRewriteParam:  IntentRewriteReplaceCluster,
+		ReplaceClusterParam: "provider1+cluster1",
+	}
+	addTarget := map[string]string{IntentRewriteParam: IntentRewriteAddTarget}
+	for _, tc := range []struct {
+		name            string
+		params          map[string]string
+		intentFile      string
+		statusFile      string
+		alreadyOnTarget bool
+	}{
+		{"replace cluster deployed on target", replaceCluster,
+			"app-intent-updated.json", "dig-status-on-target.json", true},
+		{"replace cluster with other apps elsewhere", map[string]string{
+			IntentRewriteParam:  IntentRewriteReplaceCluster,
+			ReplaceClusterParam: "provider1+cluster3",
+		}, "app-intent-updated.json", "dig-status-wave.json", true},
+		{"replace cluster deployed on source", replaceCluster,
+			"app-intent-updated.json", "dig-status.json", false},
+		{"replace cluster deployed on both", replaceCluster,
+			"app-intent-updated.json", "dig-status-added.json", false},
+		{"add target deployed on both", addTarget,
+			"app-intent-added.json", "dig-status-added.json", true},
+		{"add target deployed on target", addTarget,
+			"app-intent-added.json", "dig-status-on-target.json", true},
+		{"add target deployed on source", addTarget,
+			"app-intent-added.json", "dig-status.json", false},
+		{"add target with an app elsewhere", addTarget,
+			"app-intent-added.json", "dig-status-wave.json", false},
+		{"replace placement deployed on both", map[string]string{},
+			"app-intent-updated.json", "dig-status-added.json", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			emco := newRecordedEmco(t, map[string]recordedResponse{
+				"GET " + testCollectdPath:        {http.StatusOK, tc.intentFile},
+				"GET " + testDigPath + "/status": {http.StatusOK, tc.statusFile},
+			})
+			inParams := emco.inParams()
+			for key, value := range tc.params {
+				inParams[key] = value
+			}
+			migParam := MigParam{
+				InParams:                  inParams,
+				GenericPlacementIntentURL: emco.URL + testGpiPath,
+				AppNameIntentPairs: map[string][]AppNameIntentPair{
+					"dig1-placement-intent": {
+						{AppName: "collectd", AppIntentName: "collectd-placement-intent",
+							OnTarget: true},
+					},
+				},
+			}
+
+			result, err := runActivity(t, UpdateAppIntents, migParam)
+			require.NoError(t, err)
+			assert.Empty(t, result.ChangedAppIntents)
+			assert.Equal(t, tc.alreadyOnTarget, result.AlreadyOnTarget)
+			assert.Empty(t, emco.requestsFor(http.MethodPut))
+		})
+	}
+}
+
+func TestUpdateAppIntentsInvalidParallelism(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+	inParams := emco.inParams()
+	inParams[UpdateParallelismParam] = "0"
+
+	_, err := runActivity(t, UpdateAppIntents, MigParam{InParams: inParams})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid updateParallelism param")
+	assert.Empty(t, emco.requestsFor(http.MethodGet))
+}
+
+func TestUpdateAppIntentsReplaceCluster(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "collectd-placement-intent.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"PUT " + testOperatorPath: {http.StatusOK, ""},
+	})
+	inParams := emco.inParams()
+	inParams[IntentRewriteParam] = IntentRewriteReplaceCluster
+	inParams[ReplaceClusterParam] = "provider1+cluster3"
+	migParam := MigParam{
+		InParams:                  inParams,
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent",
+					OnTarget: true},
+				{AppName: "operator", AppIntentName: "operator-placement-intent"},
+			},
+		},
+	}
+
+	result, err := runActivity(t, UpdateAppIntents, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dig1-placement-intent/operator-placement-intent"},
+		result.ChangedAppIntents)
+	assert.Equal(t, map[string][]string{
+		"dig1-placement-intent/operator-placement-intent": {
+			"- allOf anyOf(provider1+cluster1, provider1+cluster3)",
+			"+ allOf anyOf(provider1+cluster1, provider2+cluster2)",
+		},
+	}, result.IntentDiffs)
+
+	// The failover set of operator is kept, and collectd is not on cluster3.
+	puts := emco.requestsFor(http.MethodPut)
+	require.Len(t, puts, 1)
+	var appIntent AppIntent
+	require.NoError(t, json.Unmarshal(puts[0].body, &appIntent))
+	assert.Equal(t, IntentStruc{AllOfArray: []AllOf{{AnyOfArray: []AnyOf{
+		{ProviderName: "provider1", ClusterName: "cluster1"},
+		{ProviderName: "provider2", ClusterName: "cluster2"},
+	}}}}, appIntent.Spec.Intent)
+}
+
+func TestUpdateAppIntentsInvalidRewrite(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+	inParams := emco.inParams()
+	inParams[IntentRewriteParam] = "swap"
+
+	for _, activity := range []interface{}{GetDigAppIntents, UpdateAppIntents} {
+		_, err := runActivity(t, activity, MigParam{InParams: inParams})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Invalid intentRewrite param")
+	}
+	assert.Empty(t, emco.requestsFor(http.MethodGet))
+}
+
+func TestDoDigUpdate(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"POST " + testDigPath + "/update": {http.StatusAccepted, ""},
+	})
+	migParam := MigParam{InParams: emco.inParams()}
+
+	result, err := runActivity(t, DoDigUpdate, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+	assert.Len(t, emco.requestsFor(http.MethodPost), 1)
+}
+
+func TestDoDigUpdateNotInstantiated(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"POST " + testDigPath + "/update": {http.StatusConflict, ""},
+	})
+
+	_, err := runActivity(t, DoDigUpdate, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "409 Conflict")
+}
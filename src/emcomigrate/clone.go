@@ -0,0 +1,585 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Workflow params that choose how a migration moves the apps of a DIG.
+const (
+	// StrategyUpdate, StrategyCloneDIG or StrategyWaves. The default is
+	// StrategyUpdate.
+	StrategyParam = "strategy"
+	// The name of the DIG that StrategyCloneDIG creates. The default is
+	// <dig>-<targetClusterName>.
+	CloneDIGParam = "cloneDig"
+	// The version of the DIG that StrategyCloneDIG creates. The default is
+	// the version of the migrated DIG.
+	CloneVersionParam = "cloneVersion"
+)
+
+// StrategyParam values
+const (
+	// Update the app intents of the DIG, and the DIG, in place.
+	StrategyUpdate = "update"
+	// Clone the DIG with its intents, with the app intents retargeted, and
+	// instantiate the clone. Once the clone is verified, terminate the DIG.
+	StrategyCloneDIG = "clone-dig"
+	// Update the app intents of the DIG, and the DIG, in place, a few apps
+	// at a time, as set by WavesParam. Each wave is verified before the
+	// next one starts.
+	StrategyWaves = "waves"
+)
+
+// The intent collections of a DIG that CloneDIG copies, by the collection
+// that they are in, with the collections of each of their resources. The
+// DIG's own collections are copied in digIntentCollections order: the
+// intents that the DIG's "intents" refer to are copied first.
+var (
+	digIntentCollections = []string{
+		"generic-placement-intents",
+		"generic-k8s-intents",
+		"network-controller-intent",
+		"traffic-group-intents",
+		"intents",
+	}
+	intentSubCollections = map[string][]string{
+		"generic-placement-intents": {"app-intents"},
+		"generic-k8s-intents":       {"resources"},
+		"resources":                 {"customizations"},
+		"network-controller-intent": {"workload-intents"},
+		"workload-intents":          {"interfaces"},
+		"traffic-group-intents":     {"inbound-intents"},
+		"inbound-intents":           {"clients"},
+		"clients":                   {"access-points"},
+	}
+)
+
+// How often VerifyDIG checks the status of a DIG.
+var verifyInterval = 5 * time.Second
+
+// Types of the non-retryable errors of the clone-dig strategy
+const (
+	CloneExists      = "CloneExists"
+	DeploymentFailed = "DeploymentFailed"
+)
+
+// migrationStrategy returns the StrategyParam param.
+func migrationStrategy(inParams map[string]string) (string, error) {
+	switch strategy := inParams[StrategyParam]; strategy {
+	case "":
+		return StrategyUpdate, nil
+	case StrategyUpdate, StrategyCloneDIG, StrategyWaves:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("Invalid %s param %q: expect %s, %s or %s",
+			StrategyParam, strategy, StrategyUpdate, StrategyCloneDIG, StrategyWaves)
+	}
+}
+
+// cloneName returns the name of the DIG that the clone-dig strategy
+// creates.
+func cloneName(params map[string]string) string {
+	if name := params[CloneDIGParam]; name != "" {
+		return name
+	}
+	return params["deploymentIntentGroup"] + "-" + params["targetClusterName"]
+}
+
+// withDIG returns a copy of the given workflow params for another DIG of
+// the same composite app.
+func withDIG(params map[string]string, dig string) map[string]string {
+	digParams := make(map[string]string, len(params))
+	for key, value := range params {
+		digParams[key] = value
+	}
+	digParams["deploymentIntentGroup"] = dig
+	return digParams
+}
+
+// cloneDescription is the description of a clone, by which the activities
+// tell it from a DIG that they did not create.
+func cloneDescription(params map[string]string, workflowID string) string {
+	return "clone of DIG " + digKey(params) + " by migration " + workflowID
+}
+
+// CloneDIG creates a clone of the DIG of a migration, named as given by
+// CloneDIGParam, with a copy of each of its intents, and instantiates it.
+// The placement intents of the app intents are rewritten as by
+// UpdateAppIntents, and recorded in migParam.ChangedAppIntents and
+// migParam.IntentDiffs. The clone is recorded in migParam.CloneDIG. Resources
+// that an earlier attempt created are replaced, so the activity can be
+// retried, but a DIG of the same name that another migration or user
+// created is a non-retryable error.
+func CloneDIG(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	params := migParam.InParams
+	rule, err := newIntentRule(params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "CloneDIG: %s\n", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidParam", nil)
+	}
+	name := cloneName(params)
+	cloneParams := withDIG(params, name)
+	digURL, cloneURL := buildDigURL(params), buildDigURL(cloneParams)
+
+	var dig map[string]interface{}
+	if err := getJSON(digURL, &dig); err != nil {
+		return nil, err
+	}
+	description := cloneDescription(params, activity.GetInfo(ctx).WorkflowExecution.ID)
+	metadata := map[string]interface{}{"name": name, "description": description}
+	dig["metadata"] = metadata
+	if version := params[CloneVersionParam]; version != "" {
+		spec, _ := dig["spec"].(map[string]interface{})
+		if spec == nil {
+			spec = map[string]interface{}{}
+			dig["spec"] = spec
+		}
+		spec["version"] = version
+	}
+	status, err := sendToEmco(http.MethodPost, parentURL(cloneURL), dig,
+		http.StatusCreated, http.StatusConflict)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusConflict {
+		if isClone, err := isCloneOf(cloneURL, description); err != nil {
+			return nil, err
+		} else if !isClone {
+			msg := fmt.Sprintf("DIG %s exists, and is not a clone by this migration",
+				digKey(cloneParams))
+			fmt.Fprintf(os.Stderr, "CloneDIG: %s\n", msg)
+			return nil, temporal.NewNonRetryableApplicationError(msg, CloneExists, nil)
+		}
+		fmt.Printf("CloneDIG: reusing DIG %s of an earlier attempt\n", digKey(cloneParams))
+	}
+
+	clusters := newClusterMap(rule, migParam.SourceClusters)
+	report, err := checkIntentTypes(digURL, "copied")
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	diffs := map[string][]string{}
+	for _, collection := range digIntentCollections {
+		err := forEachIntent(ctx, digURL, collection, func(collection string,
+			resource map[string]interface{}) error {
+
+			key := collection + "/" + resourceName(resource)
+			if gpIntentName, ok := appIntentsOf(collection); ok {
+				appIntentKey := gpIntentName + "/" + resourceName(resource)
+				diff, err := rewriteAppIntent(rule, resource)
+				if err != nil {
+					return err
+				}
+				if len(diff) > 0 {
+					changed = append(changed, appIntentKey)
+					diffs[appIntentKey] = diff
+				}
+			} else if collection != "intents" {
+				entry, err := clusters.rewriteIntent(params, collection, resource)
+				if err != nil {
+					return err
+				}
+				if entry.Changes != nil || entry.Problems != nil {
+					entry.Intent = key
+					report = append(report, entry)
+				}
+			}
+			return copyIntent(cloneURL+"/"+collection, resource)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	logIntentReport("CloneDIG", report)
+
+	// Created -> Approved -> Instantiated
+	cloneStatus, err := getDigStatus(cloneParams)
+	if err != nil {
+		return nil, err
+	}
+	switch cloneStatus.state() {
+	case "Created":
+		if err := postToEmco(cloneURL+"/approve", nil, http.StatusAccepted); err != nil {
+			return nil, err
+		}
+		fallthrough
+	case "Approved":
+		if err := postToEmco(cloneURL+"/instantiate", nil, http.StatusAccepted); err != nil {
+			return nil, err
+		}
+	}
+
+	migParam.CloneDIG = name
+	migParam.ChangedAppIntents = changed
+	migParam.IntentDiffs = diffs
+	migParam.IntentReport = report
+	fmt.Printf("CloneDIG: instantiated DIG %s, a clone of %s\n",
+		digKey(cloneParams), digKey(params))
+	return &migParam, nil
+}
+
+// rewriteAppIntent rewrites the placement intent of an app intent resource
+// by the given rule, and returns its diff.
+func rewriteAppIntent(rule intentRule, resource map[string]interface{}) ([]string, error) {
+	spec, _ := resource["spec"].(map[string]interface{})
+	if spec == nil {
+		return nil, nil
+	}
+	var intent IntentStruc
+	if err := remarshal(spec["intent"], &intent); err != nil {
+		return nil, err
+	}
+	newIntent := rule.rewrite(intent)
+	if reflect.DeepEqual(intent, newIntent) {
+		return nil, nil
+	}
+	spec["intent"] = newIntent
+	return intentDiff(intent, newIntent), nil
+}
+
+// appIntentsOf returns the generic placement intent of the given app
+// intents collection, as given to forEachIntent.
+func appIntentsOf(collection string) (string, bool) {
+	parts := strings.Split(collection, "/")
+	if len(parts) != 3 || parts[0] != "generic-placement-intents" ||
+		parts[2] != "app-intents" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// forEachIntent calls visit with each resource of the given intent
+// collection of a DIG, and of the collections under them, parents first.
+// The collection is given by its path under the DIG, such as
+// "generic-k8s-intents/gki1/resources". Collections that EMCO does not
+// serve are skipped.
+func forEachIntent(ctx context.Context, digURL, collection string,
+	visit func(collection string, resource map[string]interface{}) error) error {
+
+	var resources []map[string]interface{}
+	if err := getJSON(digURL+"/"+collection, &resources); err != nil {
+		if isNotFound(err) {
+			return nil // not supported by this EMCO
+		}
+		return err
+	}
+	for _, resource := range resources {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := resourceName(resource)
+		if err := visit(collection, resource); err != nil {
+			return err
+		}
+		for _, sub := range intentSubCollections[path.Base(collection)] {
+			if err := forEachIntent(ctx, digURL, collection+"/"+name+"/"+sub,
+				visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyIntent creates an intent resource in the given collection, or
+// replaces the resource that an earlier attempt created.
+func copyIntent(collectionURL string, resource map[string]interface{}) error {
+	status, err := sendToEmco(http.MethodPost, collectionURL, resource,
+		http.StatusCreated, http.StatusConflict)
+	if err != nil || status != http.StatusConflict {
+		return err
+	}
+	_, err = sendToEmco(http.MethodPut, collectionURL+"/"+resourceName(resource),
+		resource, http.StatusOK, http.StatusCreated)
+	return err
+}
+
+// VerifyDIG waits till the DIG that a migration deploys its apps with, the
+// clone of migParam.CloneDIG or else the migrated DIG, is instantiated and
+// all its apps are ready, with some on the target cluster. During a wave of
+// the waves strategy, only the apps of the wave are checked, and each must
+// be placed as the intent rewrite mode places it: on the target cluster, or
+// with IntentRewriteReplaceCluster, off the replaced cluster, since an app
+// that was not on it stays where it is. A failed deployment is a
+// non-retryable error.
+// It checks the DIG status every verifyInterval, and fails with the reason
+// that the DIG is not ready when the activity times out.
+func VerifyDIG(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	params := migParam.InParams
+	if migParam.CloneDIG != "" {
+		params = withDIG(params, migParam.CloneDIG)
+	}
+	rule, err := newIntentRule(params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "VerifyDIG: %s\n", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidParam", nil)
+	}
+	err = waitForDIG(ctx, "VerifyDIG", params, func(status *digStatus) (string, bool) {
+		return status.notReady(rule, migParam.waveApps())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &migParam, nil
+}
+
+// waitForDIG checks the status of the DIG of the given params every
+// verifyInterval, till notReady returns "". A failed deployment is a
+// non-retryable error, and the activity fails with the reason that the
+// DIG is not ready when it is about to time out.
+func waitForDIG(ctx context.Context, activityName string, params map[string]string,
+	notReady func(*digStatus) (reason string, failed bool)) error {
+
+	for {
+		status, err := getDigStatus(params)
+		if err != nil {
+			return err
+		}
+		reason, failed := notReady(status)
+		if reason == "" {
+			fmt.Printf("%s: DIG %s is ready\n", activityName, digKey(params))
+			return nil
+		}
+		msg := fmt.Sprintf("DIG %s is not ready: %s", digKey(params), reason)
+		if failed {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", activityName, msg)
+			return temporal.NewNonRetryableApplicationError(msg, DeploymentFailed, nil)
+		}
+		fmt.Printf("%s: %s\n", activityName, msg)
+
+		wait := verifyInterval
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// Report why, rather than just time out.
+			return fmt.Errorf("%s", msg)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// notReady tells why the DIG is not ready with some apps on the target
+// cluster of the given rule, or returns "" if it is; failed is set if a
+// deployment failed. If apps are given, only they are checked, and each
+// must be placed as the rule places it.
+func (s *digStatus) notReady(rule intentRule, apps []string) (reason string, failed bool) {
+	provider, cluster := rule.target.ProviderName, rule.target.ClusterName
+	if state := s.state(); state != digStateInstantiated {
+		return state + ", not " + digStateInstantiated, false
+	}
+	checked := map[string]bool{}
+	for _, app := range apps {
+		checked[app] = true
+	}
+	onTarget := false
+	for _, app := range s.Apps {
+		if len(apps) > 0 && !checked[app.Name] {
+			continue
+		}
+		appOnTarget, appOnSource := false, false
+		for _, c := range app.Clusters {
+			where := fmt.Sprintf("app %s on %s+%s", app.Name, c.ProviderName, c.ClusterName)
+			switch {
+			case c.DeployedStatus == "Deleted":
+				continue
+			case c.DeployedStatus == "Failed":
+				return where + " failed", true
+			case c.ReadyStatus != "Ready":
+				return where + " is " + c.ReadyStatus, false
+			}
+			appOnTarget = appOnTarget || rule.isTarget(c.ProviderName, c.ClusterName)
+			appOnSource = appOnSource || rule.isSource(c.ProviderName, c.ClusterName)
+		}
+		switch {
+		case len(apps) == 0:
+		case rule.mode == IntentRewriteReplaceCluster && appOnSource:
+			return "app " + app.Name + " is still on " + rule.source.ProviderName + "+" +
+				rule.source.ClusterName, false
+		case rule.mode != IntentRewriteReplaceCluster && !appOnTarget:
+			return "app " + app.Name + " is not on " + provider + "+" + cluster, false
+		}
+		onTarget = onTarget || appOnTarget
+		delete(checked, app.Name)
+	}
+	for _, app := range apps {
+		if checked[app] {
+			return "app " + app + " is not deployed", false
+		}
+	}
+	// The apps of a wave are each checked above.
+	if !onTarget && len(apps) == 0 {
+		return "no app on " + provider + "+" + cluster, false
+	}
+	return "", false
+}
+
+// TerminateDIG terminates the DIG of a migration, once its clone took over.
+// A DIG that is terminated already is left as it is.
+func TerminateDIG(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	params := migParam.InParams
+	status, err := getDigStatus(params)
+	if err != nil {
+		return nil, err
+	}
+	if state := status.state(); state == "Terminated" {
+		return &migParam, nil
+	}
+	if err := postToEmco(buildDigURL(params)+"/terminate", nil, http.StatusAccepted); err != nil {
+		return nil, err
+	}
+	fmt.Printf("TerminateDIG: terminated DIG %s\n", digKey(params))
+	return &migParam, nil
+}
+
+// DeleteDIGClone deletes the clone that CloneDIG created, after
+// terminating it, to clean up after a failed migration. The intents of the
+// clone are deleted before the clone, the intents under others first. A
+// DIG that CloneDIG did not create is not deleted.
+func DeleteDIGClone(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	// The clone may have been created by an attempt that failed.
+	name := migParam.CloneDIG
+	if name == "" {
+		name = cloneName(migParam.InParams)
+	}
+	cloneParams := withDIG(migParam.InParams, name)
+	cloneURL := buildDigURL(cloneParams)
+	isClone, err := isCloneOf(cloneURL,
+		cloneDescription(migParam.InParams, activity.GetInfo(ctx).WorkflowExecution.ID))
+	if err != nil {
+		if isNotFound(err) {
+			migParam.CloneDIG = ""
+			return &migParam, nil
+		}
+		return nil, err
+	}
+	if !isClone {
+		migParam.CloneDIG = ""
+		return &migParam, nil
+	}
+
+	status, err := getDigStatus(cloneParams)
+	if err != nil {
+		return nil, err
+	}
+	if status.state() == digStateInstantiated {
+		if err := postToEmco(cloneURL+"/terminate", nil, http.StatusAccepted); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(digIntentCollections) - 1; i >= 0; i-- {
+		if err := deleteCollection(ctx, cloneURL, digIntentCollections[i]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := sendToEmco(http.MethodDelete, cloneURL, nil,
+		http.StatusNoContent, http.StatusNotFound); err != nil {
+		return nil, err
+	}
+	fmt.Printf("DeleteDIGClone: deleted DIG %s\n", digKey(cloneParams))
+	migParam.CloneDIG = ""
+	return &migParam, nil
+}
+
+// deleteCollection deletes the resources of the given intent collection of
+// a DIG, and the collections under them first.
+func deleteCollection(ctx context.Context, ownerURL, collection string) error {
+	var resources []map[string]interface{}
+	if err := getJSON(ownerURL+"/"+collection, &resources); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, resource := range resources {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		resourceURL := ownerURL + "/" + collection + "/" + resourceName(resource)
+		for _, sub := range intentSubCollections[collection] {
+			if err := deleteCollection(ctx, resourceURL, sub); err != nil {
+				return err
+			}
+		}
+		if _, err := sendToEmco(http.MethodDelete, resourceURL, nil,
+			http.StatusNoContent, http.StatusNotFound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isCloneOf reports whether the DIG at the given URL has the given clone
+// description.
+func isCloneOf(digURL, description string) (bool, error) {
+	var dig struct {
+		Metadata MetaData `json:"metadata"`
+	}
+	if err := getJSON(digURL, &dig); err != nil {
+		return false, err
+	}
+	return dig.Metadata.Description == description, nil
+}
+
+// getJSON GETs the given URL and decodes the JSON response body.
+func getJSON(url string, value interface{}) error {
+	respBody, err := getHttpRespBody(url)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBody, value); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET response body for URL %s.\n"+
+			"Decoder error: %#v\n", url, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return decodeErr
+	}
+	return nil
+}
+
+// remarshal converts a decoded JSON value to the given type.
+func remarshal(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// resourceName returns the metadata.name of an EMCO resource.
+func resourceName(resource map[string]interface{}) string {
+	metadata, _ := resource["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+// parentURL returns the URL of the collection of the resource at the given
+// URL.
+func parentURL(url string) string {
+	return url[:strings.LastIndex(url, "/")]
+}
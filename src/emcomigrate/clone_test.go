@@ -0,0 +1,328 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+)
+
+const (
+	testDigsPath      = "/v2/projects/proj1/composite-apps/capp1/v1/deployment-intent-groups"
+	testClonePath     = testDigsPath + "/dig1-cluster2"
+	testCloneGpiPath  = testClonePath + "/generic-placement-intents"
+	testCloneAppPath  = testCloneGpiPath + "/dig1-placement-intent/app-intents"
+	testCloneIntents  = testClonePath + "/intents"
+	testCloneIntent   = testCloneIntents + "/dig1-intent"
+	testCloneAppPath1 = testCloneAppPath + "/collectd-placement-intent"
+	testCloneAppPath2 = testCloneAppPath + "/operator-placement-intent"
+)
+
+// cloneResponses are the EMCO responses for cloning dig1, with its generic
+// placement intent, its two app intents and its "intents", into a new DIG.
+func cloneResponses() map[string]recordedResponse {
+	return map[string]recordedResponse{
+		"GET " + testDigPath:                     {http.StatusOK, "dig.json"},
+		"POST " + testDigsPath:                   {http.StatusCreated, ""},
+		"GET " + testGpiPath:                     {http.StatusOK, "generic-placement-intents.json"},
+		"POST " + testCloneGpiPath:               {http.StatusCreated, ""},
+		"GET " + testAppPath:                     {http.StatusOK, "app-intents.json"},
+		"POST " + testCloneAppPath:               {http.StatusCreated, ""},
+		"GET " + testDigPath + "/intents":        {http.StatusOK, "dig-intents.json"},
+		"POST " + testCloneIntents:               {http.StatusCreated, ""},
+		"GET " + testClonePath + "/status":       {http.StatusOK, "clone-dig-status-created.json"},
+		"POST " + testClonePath + "/approve":     {http.StatusAccepted, ""},
+		"POST " + testClonePath + "/instantiate": {http.StatusAccepted, ""},
+	}
+}
+
+func TestCloneDIG(t *testing.T) {
+	emco := newRecordedEmco(t, cloneResponses())
+	migParam := MigParam{InParams: emco.inParams()}
+
+	result, err := runActivity(t, CloneDIG, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, "dig1-cluster2", result.CloneDIG)
+	assert.Equal(t, []string{"dig1-placement-intent/collectd-placement-intent",
+		"dig1-placement-intent/operator-placement-intent"}, result.ChangedAppIntents)
+	assert.Equal(t, []string{"- allOf provider1+cluster1", "+ allOf provider2+cluster2"},
+		result.IntentDiffs["dig1-placement-intent/collectd-placement-intent"])
+
+	// The intents that "intents" refers to are created first.
+	posts := emco.requestsFor(http.MethodPost)
+	assert.Equal(t, []string{testDigsPath, testCloneGpiPath, testCloneAppPath,
+		testCloneAppPath, testCloneIntents, testClonePath + "/approve",
+		testClonePath + "/instantiate"}, paths(posts))
+
+	var dig struct {
+		Metadata MetaData               `json:"metadata"`
+		Spec     map[string]interface{} `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(posts[0].body, &dig))
+	assert.Equal(t, "dig1-cluster2", dig.Metadata.Name)
+	assert.Equal(t, "clone of DIG proj1/capp1/v1/dig1 by migration default-test-workflow-id",
+		dig.Metadata.Description)
+	assert.Equal(t, "default", dig.Spec["logicalCloud"])
+
+	var appIntent struct {
+		Spec struct {
+			App    string      `json:"app"`
+			Intent IntentStruc `json:"intent"`
+		} `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(posts[3].body, &appIntent))
+	assert.Equal(t, "operator", appIntent.Spec.App)
+	assert.Equal(t, IntentStruc{AllOfArray: []AllOf{
+		{ProviderName: "provider2", ClusterName: "cluster2"}}}, appIntent.Spec.Intent)
+}
+
+func TestCloneDIGOtherIntents(t *testing.T) {
+	responses := cloneResponses()
+	for request, response := range otherIntentResponses() {
+		responses[request] = response
+	}
+	cloneCustomizations := testClonePath + "/generic-k8s-intents/gki1/resources/" +
+		"collectd-config/customizations"
+	for _, collection := range []string{
+		"/generic-k8s-intents",
+		"/generic-k8s-intents/gki1/resources",
+		"/generic-k8s-intents/gki1/resources/collectd-config/customizations",
+		"/network-controller-intent",
+		"/network-controller-intent/netctl1/workload-intents",
+		"/network-controller-intent/netctl1/workload-intents/collectd-workload/interfaces",
+	} {
+		responses["POST "+testClonePath+collection] = recordedResponse{http.StatusCreated, ""}
+	}
+	emco := newRecordedEmco(t, responses)
+	migParam := MigParam{
+		InParams:       emco.inParams(),
+		SourceClusters: []string{"provider1+cluster1"},
+	}
+
+	result, err := runActivity(t, CloneDIG, migParam)
+	require.NoError(t, err)
+	expected := append([]IntentReport{}, testIntentReport...)
+	expected[0].Problems = []string{"intent type sfc (sfc-intent1) is unknown, copied"}
+	assert.Equal(t, expected, result.IntentReport)
+	assert.Empty(t, emco.requestsFor(http.MethodPut))
+
+	var customizations []map[string]interface{}
+	for _, post := range emco.requestsFor(http.MethodPost) {
+		if post.path == cloneCustomizations {
+			var customization map[string]interface{}
+			require.NoError(t, json.Unmarshal(post.body, &customization))
+			customizations = append(customizations, customization)
+		}
+	}
+	require.Len(t, customizations, 3)
+	clusterInfo := customizations[0]["spec"].(map[string]interface{})["clusterInfo"]
+	assert.Equal(t, "cluster2", clusterInfo.(map[string]interface{})["cluster"])
+	clusterInfo = customizations[1]["spec"].(map[string]interface{})["clusterInfo"]
+	assert.Equal(t, "cluster1", clusterInfo.(map[string]interface{})["cluster"])
+}
+
+func TestCloneDIGVersion(t *testing.T) {
+	emco := newRecordedEmco(t, cloneResponses())
+	params := emco.inParams()
+	params[CloneVersionParam] = "r2"
+
+	_, err := runActivity(t, CloneDIG, MigParam{InParams: params})
+	require.NoError(t, err)
+	var dig struct {
+		Spec map[string]interface{} `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(emco.requestsFor(http.MethodPost)[0].body, &dig))
+	assert.Equal(t, "r2", dig.Spec["version"])
+	assert.Equal(t, "capp1-profile", dig.Spec["compositeProfile"])
+}
+
+func TestCloneName(t *testing.T) {
+	params := testInParams()
+	assert.Equal(t, "dig1-cluster2", cloneName(params))
+	params[CloneDIGParam] = "dig1-v2"
+	assert.Equal(t, "dig1-v2", cloneName(params))
+}
+
+func TestCloneDIGRetried(t *testing.T) {
+	// An earlier attempt created the clone and its intents.
+	responses := cloneResponses()
+	responses["POST "+testDigsPath] = recordedResponse{http.StatusConflict, ""}
+	responses["GET "+testClonePath] = recordedResponse{http.StatusOK, "clone-dig.json"}
+	responses["POST "+testCloneAppPath] = recordedResponse{http.StatusConflict, ""}
+	responses["PUT "+testCloneAppPath1] = recordedResponse{http.StatusOK, ""}
+	responses["PUT "+testCloneAppPath2] = recordedResponse{http.StatusOK, ""}
+	responses["GET "+testClonePath+"/status"] = recordedResponse{http.StatusOK, "dig-status.json"}
+	emco := newRecordedEmco(t, responses)
+
+	result, err := runActivity(t, CloneDIG, MigParam{InParams: emco.inParams()})
+	require.NoError(t, err)
+	assert.Equal(t, "dig1-cluster2", result.CloneDIG)
+	assert.Equal(t, []string{testCloneAppPath1, testCloneAppPath2},
+		paths(emco.requestsFor(http.MethodPut)))
+	// instantiated already
+	assert.Equal(t, []string{testDigsPath, testCloneGpiPath, testCloneAppPath,
+		testCloneAppPath, testCloneIntents}, paths(emco.requestsFor(http.MethodPost)))
+}
+
+func TestCloneDIGExists(t *testing.T) {
+	responses := cloneResponses()
+	responses["POST "+testDigsPath] = recordedResponse{http.StatusConflict, ""}
+	responses["GET "+testClonePath] = recordedResponse{http.StatusOK, "dig.json"}
+	emco := newRecordedEmco(t, responses)
+
+	_, err := runActivity(t, CloneDIG, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, CloneExists, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+	assert.Equal(t, []string{testDigsPath}, paths(emco.requestsFor(http.MethodPost)))
+}
+
+func TestVerifyDIG(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testClonePath + "/status": {http.StatusOK, "dig-status-on-target.json"},
+	})
+	migParam := MigParam{InParams: emco.inParams(), CloneDIG: "dig1-cluster2"}
+
+	result, err := runActivity(t, VerifyDIG, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
+
+func TestVerifyDIGWaveReplaceCluster(t *testing.T) {
+	// With replace-cluster, the wave of an app that was never on the
+	// replaced cluster is ready where the app is.
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testDigPath + "/status": {http.StatusOK, "dig-status-wave.json"},
+	})
+	inParams := emco.inParams()
+	inParams[IntentRewriteParam] = IntentRewriteReplaceCluster
+	inParams[ReplaceClusterParam] = "provider3+cluster3"
+	migParam := MigParam{InParams: inParams,
+		Waves: [][]string{{"collectd"}, {"operator"}}, Wave: 2}
+
+	result, err := runActivity(t, VerifyDIG, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
+
+func TestVerifyDIGFailed(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testClonePath + "/status": {http.StatusOK, "dig-status-failed.json"},
+	})
+	migParam := MigParam{InParams: emco.inParams(), CloneDIG: "dig1-cluster2"}
+
+	_, err := runActivity(t, VerifyDIG, migParam)
+	require.Error(t, err)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, DeploymentFailed, appErr.Type())
+	assert.Contains(t, err.Error(), "app collectd on provider2+cluster2 failed")
+}
+
+func TestDigStatusNotReady(t *testing.T) {
+	target := AllOf{ProviderName: "provider2", ClusterName: "cluster2"}
+	replacePlacement := intentRule{mode: IntentRewriteReplacePlacement, target: target}
+	// operator, on provider1+cluster1, was never on the replaced cluster.
+	replaceOther := intentRule{mode: IntentRewriteReplaceCluster, target: target,
+		source: AllOf{ProviderName: "provider3", ClusterName: "cluster3"}}
+	replaceOperator := intentRule{mode: IntentRewriteReplaceCluster, target: target,
+		source: AllOf{ProviderName: "provider1", ClusterName: "cluster1"}}
+	tests := []struct {
+		file   string
+		rule   intentRule
+		apps   []string
+		reason string
+	}{
+		{"dig-status-on-target.json", replacePlacement, nil, ""},
+		{"dig-status.json", replacePlacement, nil, "no app on provider2+cluster2"},
+		{"dig-status-approved.json", replacePlacement, nil, "Approved, not Instantiated"},
+		{"dig-status-failed.json", replacePlacement, nil,
+			"app collectd on provider2+cluster2 failed"},
+		{"dig-status-wave.json", replacePlacement, nil, ""},
+		{"dig-status-wave.json", replacePlacement, []string{"collectd"}, ""},
+		{"dig-status-wave.json", replacePlacement, []string{"operator"},
+			"app operator is not on provider2+cluster2"},
+		{"dig-status-wave.json", replacePlacement, []string{"collectd", "sink"},
+			"app sink is not deployed"},
+		{"dig-status-wave.json", replaceOther, []string{"collectd"}, ""},
+		{"dig-status-wave.json", replaceOther, []string{"operator"}, ""},
+		{"dig-status-wave.json", replaceOperator, []string{"operator"},
+			"app operator is still on provider1+cluster1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file+" "+tc.rule.mode+" "+strings.Join(tc.apps, ","), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "emco", tc.file))
+			require.NoError(t, err)
+			var status digStatus
+			require.NoError(t, json.Unmarshal(data, &status))
+			reason, _ := status.notReady(tc.rule, tc.apps)
+			assert.Equal(t, tc.reason, reason)
+		})
+	}
+}
+
+func TestTerminateDIG(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testDigPath + "/status":     {http.StatusOK, "dig-status.json"},
+		"POST " + testDigPath + "/terminate": {http.StatusAccepted, ""},
+	})
+
+	_, err := runActivity(t, TerminateDIG, MigParam{InParams: emco.inParams()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{testDigPath + "/terminate"},
+		paths(emco.requestsFor(http.MethodPost)))
+}
+
+func TestDeleteDIGClone(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testClonePath:                                  {http.StatusOK, "clone-dig.json"},
+		"GET " + testClonePath + "/status":                      {http.StatusOK, "dig-status-on-target.json"},
+		"POST " + testClonePath + "/terminate":                  {http.StatusAccepted, ""},
+		"GET " + testCloneGpiPath:                               {http.StatusOK, "generic-placement-intents.json"},
+		"GET " + testCloneAppPath:                               {http.StatusOK, "app-intents.json"},
+		"DELETE " + testCloneAppPath1:                           {http.StatusNoContent, ""},
+		"DELETE " + testCloneGpiPath + "/dig1-placement-intent": {http.StatusNoContent, ""},
+		"GET " + testCloneIntents:                               {http.StatusOK, "dig-intents.json"},
+		"DELETE " + testCloneIntent:                             {http.StatusNoContent, ""},
+		"DELETE " + testClonePath:                               {http.StatusNoContent, ""},
+	})
+	migParam := MigParam{InParams: emco.inParams(), CloneDIG: "dig1-cluster2"}
+
+	result, err := runActivity(t, DeleteDIGClone, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, "", result.CloneDIG)
+	assert.Equal(t, []string{testClonePath + "/terminate"},
+		paths(emco.requestsFor(http.MethodPost)))
+	// children first; the second app intent was deleted by an earlier attempt
+	assert.Equal(t, []string{testCloneIntent, testCloneAppPath1, testCloneAppPath2,
+		testCloneGpiPath + "/dig1-placement-intent", testClonePath},
+		paths(emco.requestsFor(http.MethodDelete)))
+}
+
+func TestDeleteDIGCloneNotOurs(t *testing.T) {
+	for _, file := range []string{"dig.json", ""} {
+		responses := map[string]recordedResponse{}
+		if file != "" {
+			responses["GET "+testClonePath] = recordedResponse{http.StatusOK, file}
+		}
+		emco := newRecordedEmco(t, responses)
+		migParam := MigParam{InParams: emco.inParams()}
+
+		result, err := runActivity(t, DeleteDIGClone, migParam)
+		require.NoError(t, err)
+		assert.Equal(t, "", result.CloneDIG)
+		assert.Empty(t, emco.requestsFor(http.MethodDelete))
+	}
+}
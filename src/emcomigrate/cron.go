@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a five-field cron expression: minute, hour, day of month,
+// month and day of week. A field is "*", or a comma-separated list of
+// values, ranges such as "1-5", and steps such as "*/15" or "1-5/2".
+// Months and days of the week may be given by their first three letters,
+// and Sunday as 0 or 7. As in the classic cron, a time matches if both the
+// day of month and the day of week match, or either if neither is "*".
+type CronSchedule struct {
+	spec                     string
+	minute, hour, dom, month uint64 // bit n is set if value n matches
+	dow                      uint64
+	domWildcard, dowWildcard bool
+}
+
+var (
+	cronMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug",
+		"sep", "oct", "nov", "dec"}
+	cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// How far ahead CronSchedule.Next looks for a matching time. A schedule that
+// matches only on February 29 matches within it.
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// ParseCronSchedule parses a five-field cron expression.
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression %q: expect 5 fields, "+
+			"minute hour day-of-month month day-of-week", spec)
+	}
+	s := &CronSchedule{spec: spec}
+	var err error
+	parse := func(field string, min, max int, names []string) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = parseCronField(field, min, max, names)
+		if err != nil {
+			err = fmt.Errorf("Invalid cron expression %q: %s", spec, err)
+		}
+		return bits
+	}
+	s.minute = parse(fields[0], 0, 59, nil)
+	s.hour = parse(fields[1], 0, 23, nil)
+	s.dom = parse(fields[2], 1, 31, nil)
+	s.month = parse(fields[3], 1, 12, cronMonths)
+	s.dow = parse(fields[4], 0, 7, cronDays)
+	if err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // Sunday
+	}
+	s.domWildcard = strings.HasPrefix(fields[2], "*")
+	s.dowWildcard = strings.HasPrefix(fields[4], "*")
+	if _, ok := s.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); !ok {
+		return nil, fmt.Errorf("Invalid cron expression %q: matches no time", spec)
+	}
+	return s, nil
+}
+
+// parseCronField returns the values that a field of a cron expression
+// matches, within min and max, as bits.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		first, last := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if first, err = cronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = cronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				last = max // "5/15" is "5-max/15"
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a value of a cron field, a number or a name.
+func cronValue(s string, min, max int, names []string) (int, error) {
+	for value, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return value, nil
+		}
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("invalid value %q: expect %d to %d", s, min, max)
+	}
+	return value, nil
+}
+
+// String returns the cron expression.
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+// Matches reports whether the minute of t, in its location, matches.
+func (s *CronSchedule) Matches(t time.Time) bool {
+	return s.month&(1<<uint(t.Month())) != 0 && s.matchesDay(t) &&
+		s.hour&(1<<uint(t.Hour())) != 0 && s.minute&(1<<uint(t.Minute())) != 0
+}
+
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domWildcard || s.dowWildcard {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first matching minute at or after t, in the location of
+// t, or false if there is none within cronHorizon.
+func (s *CronSchedule) Next(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	if rounded := t.Truncate(time.Minute); rounded.Before(t) {
+		t = rounded.Add(time.Minute)
+	}
+	limit := t.Add(cronHorizon)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case s.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchesDay(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next := time.Date(year, month, day, t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) { // the hour repeats as daylight saving time ends
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
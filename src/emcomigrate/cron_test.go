@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{"0 2 * *", "expect 5 fields"},
+		{"60 2 * * *", `invalid value "60": expect 0 to 59`},
+		{"0 2 0 * *", `invalid value "0": expect 1 to 31`},
+		{"0 2 * FOO *", `invalid value "FOO": expect 1 to 12`},
+		{"*/0 2 * * *", `invalid step in "*/0"`},
+		{"0 5-2 * * *", `invalid range "5-2"`},
+		{"0 2 30 FEB *", "matches no time"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			_, err := ParseCronSchedule(tc.spec)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	// a Saturday
+	from := time.Date(2022, 6, 4, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		{"* * * * *", from, time.Date(2022, 6, 4, 10, 31, 0, 0, time.UTC)},
+		{"30 10 * * *", from.Truncate(time.Minute), from.Truncate(time.Minute)},
+		{"*/15 * * * *", from, time.Date(2022, 6, 4, 10, 45, 0, 0, time.UTC)},
+		{"5/20 9-11 * * *", from, time.Date(2022, 6, 4, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * sat", from, time.Date(2022, 6, 11, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * 7", from, time.Date(2022, 6, 5, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 JAN,jul *", from, time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// the 15th, or any Monday
+		{"0 0 15 * MON", from, time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */5 * MON", from, time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)},
+		// the 15th, if a Wednesday
+		{"0 0 15 * * ", from, time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * WED", from, time.Date(2022, 6, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 2 * * *", from.In(paris), time.Date(2022, 6, 5, 2, 0, 0, 0, paris)},
+		// 2:30 does not exist in Paris on March 27, 2022.
+		{"30 2 * * *", time.Date(2022, 3, 27, 0, 0, 0, 0, paris),
+			time.Date(2022, 3, 28, 2, 30, 0, 0, paris)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			s, err := ParseCronSchedule(tc.spec)
+			require.NoError(t, err)
+			next, ok := s.Next(tc.from)
+			require.True(t, ok)
+			assert.True(t, tc.next.Equal(next), "expect %s, got %s", tc.next, next)
+			assert.True(t, s.Matches(next))
+			assert.Equal(t, tc.from.Location(), next.Location())
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Workflow param: "true" moves the apps of the DIG without regard to the
+// dependencies between them. The default is "false".
+const IgnoreDependenciesParam = "ignoreDependencies"
+
+// State of EmcoMigrateWorkflow while it waits for the dependencies of the
+// apps of the next wave, after they are ready
+const StateWaitingForDependencies = "waiting-for-dependencies"
+
+// AppDependency is a dependency of an app of a composite app, as given by
+// EMCO's app dependency API: the app is deployed once App reaches
+// OpStatus, "Ready" or "Deployed", and Wait more seconds have passed.
+type AppDependency struct {
+	App      string `json:"app"`
+	OpStatus string `json:"opStatus,omitempty"`
+	Wait     int    `json:"wait,omitempty"`
+}
+
+// buildAppURL returns the URL of an app of the composite app of a migration.
+func buildAppURL(params map[string]string, app string) string {
+	url := params["emcoURL"]
+	url += "/v2/projects/" + params["project"]
+	url += "/composite-apps/" + params["compositeApp"]
+	url += "/" + params["compositeAppVersion"]
+	url += "/apps/" + app
+
+	return url
+}
+
+// appNames returns the apps of the app intents of migParam, in order.
+func (migParam MigParam) appNames() []string {
+	seen := map[string]bool{}
+	apps := []string{}
+	for _, pairs := range migParam.AppNameIntentPairs {
+		for _, pair := range pairs {
+			if !seen[pair.AppName] {
+				seen[pair.AppName] = true
+				apps = append(apps, pair.AppName)
+			}
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// GetAppDependencies reads the dependencies of the apps of a migration from
+// EMCO into migParam.AppDependencies. Apps without dependencies are left
+// out, as are apps of an EMCO without the app dependency API.
+func GetAppDependencies(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	dependencies := map[string][]AppDependency{}
+	for _, app := range migParam.appNames() {
+		var resources []struct {
+			Metadata MetaData      `json:"metadata"`
+			Spec     AppDependency `json:"spec"`
+		}
+		err := getJSON(buildAppURL(migParam.InParams, app)+"/dependency", &resources)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			dependencies[app] = append(dependencies[app], resource.Spec)
+			fmt.Printf("GetAppDependencies: app %s depends on app %s\n", app,
+				resource.Spec.App)
+		}
+	}
+	migParam.AppDependencies = nil
+	if len(dependencies) > 0 {
+		migParam.AppDependencies = dependencies
+	}
+	return &migParam, nil
+}
+
+// dependencyWaves returns the given apps in waves, so that each app comes
+// after the apps that it depends on: the first wave has the apps that
+// depend on none of the others, and each later one the apps whose
+// dependencies are all in earlier waves. Dependencies on other apps are
+// ignored, and a dependency cycle is an error.
+func dependencyWaves(apps []string, dependencies map[string][]AppDependency) (
+	[][]string, error) {
+
+	pending := map[string]bool{}
+	for _, app := range apps {
+		pending[app] = true
+	}
+	waves := [][]string{}
+	for len(pending) > 0 {
+		wave := []string{}
+		for _, app := range apps {
+			if pending[app] && !dependsOnAny(app, pending, dependencies) {
+				wave = append(wave, app)
+			}
+		}
+		if len(wave) == 0 {
+			cycle := []string{}
+			for _, app := range apps {
+				if pending[app] {
+					cycle = append(cycle, app)
+				}
+			}
+			return nil, fmt.Errorf("Apps %s depend on each other",
+				strings.Join(cycle, ", "))
+		}
+		for _, app := range wave {
+			delete(pending, app)
+		}
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+// dependsOnAny reports whether app depends on any of the given apps, other
+// than itself.
+func dependsOnAny(app string, apps map[string]bool,
+	dependencies map[string][]AppDependency) bool {
+
+	for _, dependency := range dependencies[app] {
+		if dependency.App != app && apps[dependency.App] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWaveOrder returns an error if an app of the given waves is in the
+// same wave as an app that it depends on, or in an earlier one.
+func checkWaveOrder(waves [][]string, dependencies map[string][]AppDependency) error {
+	later := map[string]bool{} // the apps of the wave and those after it
+	for _, wave := range waves {
+		for _, app := range wave {
+			later[app] = true
+		}
+	}
+	for i, wave := range waves {
+		for _, app := range wave {
+			for _, dependency := range dependencies[app] {
+				if dependency.App != app && later[dependency.App] {
+					return fmt.Errorf("App %s of wave %d depends on app %s, "+
+						"which is not in an earlier wave", app, i+1, dependency.App)
+				}
+			}
+		}
+		for _, app := range wave {
+			delete(later, app)
+		}
+	}
+	return nil
+}
+
+// dependencyWait returns how long to wait before the wave in progress, as
+// its apps' dependencies on the apps of earlier waves ask, in seconds.
+func (migParam MigParam) dependencyWait() int {
+	earlier := map[string]bool{}
+	for _, wave := range migParam.Waves[:migParam.Wave-1] {
+		for _, app := range wave {
+			earlier[app] = true
+		}
+	}
+	wait := 0
+	for _, app := range migParam.waveApps() {
+		for _, dependency := range migParam.AppDependencies[app] {
+			if earlier[dependency.App] && dependency.Wait > wait {
+				wait = dependency.Wait
+			}
+		}
+	}
+	return wait
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAppsPath = "/v2/projects/proj1/composite-apps/capp1/v1/apps"
+
+func TestGetAppDependencies(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testAppsPath + "/operator/dependency": {http.StatusOK, "app-dependency.json"},
+	})
+	migParam := MigParam{InParams: emco.inParams(), AppNameIntentPairs: testWaveIntents}
+
+	result, err := runActivity(t, GetAppDependencies, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]AppDependency{
+		"operator": {{App: "collectd", OpStatus: "Ready", Wait: 10}},
+	}, result.AppDependencies)
+	assert.Equal(t, []string{
+		testAppsPath + "/collectd/dependency",
+		testAppsPath + "/operator/dependency",
+		testAppsPath + "/sink/dependency",
+	}, paths(emco.requestsFor(http.MethodGet)))
+}
+
+func TestGetAppDependenciesNone(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+	migParam := MigParam{InParams: emco.inParams(), AppNameIntentPairs: testWaveIntents}
+
+	result, err := runActivity(t, GetAppDependencies, migParam)
+	require.NoError(t, err)
+	assert.Nil(t, result.AppDependencies)
+}
+
+func TestDependencyWaves(t *testing.T) {
+	apps := []string{"collectd", "operator", "sink"}
+	tests := []struct {
+		name         string
+		dependencies map[string][]AppDependency
+		waves        [][]string
+		err          string
+	}{
+		{"none", nil, [][]string{apps}, ""},
+		{"chain", map[string][]AppDependency{
+			"collectd": {{App: "sink"}},
+			"operator": {{App: "collectd"}},
+		}, [][]string{{"sink"}, {"collectd"}, {"operator"}}, ""},
+		{"two on one", map[string][]AppDependency{
+			"collectd": {{App: "sink"}},
+			"operator": {{App: "sink"}},
+		}, [][]string{{"sink"}, {"collectd", "operator"}}, ""},
+		{"on other apps and itself", map[string][]AppDependency{
+			"collectd": {{App: "nginx"}, {App: "collectd"}},
+		}, [][]string{apps}, ""},
+		{"cycle", map[string][]AppDependency{
+			"collectd": {{App: "sink"}},
+			"operator": {{App: "collectd"}},
+			"sink":     {{App: "operator"}},
+		}, nil, "Apps collectd, operator, sink depend on each other"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			waves, err := dependencyWaves(apps, tc.dependencies)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.waves, waves)
+		})
+	}
+}
+
+func TestCheckWaveOrder(t *testing.T) {
+	dependencies := map[string][]AppDependency{"operator": {{App: "collectd"}}}
+	assert.NoError(t, checkWaveOrder([][]string{{"collectd"}, {"operator"}}, dependencies))
+	assert.NoError(t, checkWaveOrder([][]string{{"operator"}}, dependencies))
+	err := checkWaveOrder([][]string{{"collectd", "operator"}}, dependencies)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "App operator of wave 1 depends on app collectd, "+
+		"which is not in an earlier wave")
+	assert.Error(t, checkWaveOrder([][]string{{"operator"}, {"collectd"}}, dependencies))
+}
+
+func TestDependencyWait(t *testing.T) {
+	migParam := MigParam{
+		AppDependencies: map[string][]AppDependency{
+			"operator": {{App: "collectd", Wait: 10}, {App: "sink", Wait: 30}},
+			"sink":     {{App: "collectd", Wait: 5}},
+		},
+		Waves: [][]string{{"collectd"}, {"operator", "sink"}},
+	}
+	migParam.Wave = 1
+	assert.Equal(t, 0, migParam.dependencyWait())
+	// sink is in the same wave
+	migParam.Wave = 2
+	assert.Equal(t, 10, migParam.dependencyWait())
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Workflow params of temporary migrations, which move the apps back to
+// where they were once they are done with the target cluster.
+const (
+	// "true" makes the migration temporary: once it succeeded, it waits
+	// for a ReturnSignal, and then moves the apps back. The default is
+	// "false".
+	TemporaryParam = "temporary"
+	// How long a temporary migration stays on the target cluster before it
+	// moves the apps back, as a Go duration such as "72h", unless a
+	// ReturnSignal comes first. Setting it makes the migration temporary.
+	// By default it waits for the signal only.
+	ReturnAfterParam = "returnAfter"
+)
+
+// Signal that makes a temporary migration move the apps back. It has no
+// data. A signal sent before the migration succeeded takes effect as soon
+// as it has.
+const ReturnSignal = "return"
+
+// State of EmcoMigrateWorkflow while a temporary migration waits to move
+// the apps back
+const StateWaitingToReturn = "waiting-to-return"
+
+// failbackPlan is when a temporary migration moves the apps back.
+type failbackPlan struct {
+	temporary bool
+	after     time.Duration // 0 to wait for a ReturnSignal only
+}
+
+// newFailbackPlan returns the failback plan of the workflow params, for a
+// migration with the given strategy.
+func newFailbackPlan(inParams map[string]string, strategy string) (*failbackPlan, error) {
+	temporary, err := boolParam(inParams, TemporaryParam)
+	if err != nil {
+		return nil, err
+	}
+	plan := &failbackPlan{temporary: temporary}
+	if param := strings.TrimSpace(inParams[ReturnAfterParam]); param != "" {
+		after, err := time.ParseDuration(param)
+		if err != nil || after <= 0 {
+			return nil, fmt.Errorf("Invalid %s param %q: expect a positive duration, "+
+				"as 72h", ReturnAfterParam, param)
+		}
+		plan.temporary, plan.after = true, after
+	}
+	// The clone-dig strategy terminates the DIG that the apps would go
+	// back to.
+	if plan.temporary && strategy == StrategyCloneDIG {
+		param, value := TemporaryParam, inParams[TemporaryParam]
+		if value == "" {
+			param, value = ReturnAfterParam, inParams[ReturnAfterParam]
+		}
+		return nil, fmt.Errorf("Invalid %s param %q: the %s strategy cannot be "+
+			"temporary", param, value, StrategyCloneDIG)
+	}
+	return plan, nil
+}
+
+// changedSince lists the app intents, as gpIntentName/appIntentName, that
+// are not as the given migration left them, in the app intents that
+// GetDigAppIntents found in migParam with the params of the migration.
+func (migParam MigParam) changedSince(migrated MigParam) []string {
+	found := map[string]bool{}
+	changed := []string{}
+	for gpIntentName, pairs := range migParam.AppNameIntentPairs {
+		for _, pair := range pairs {
+			key := gpIntentName + "/" + pair.AppIntentName
+			found[key] = true
+			if !pair.OnTarget {
+				changed = append(changed, key)
+			}
+		}
+	}
+	for gpIntentName, pairs := range migrated.AppNameIntentPairs {
+		for _, pair := range pairs {
+			key := gpIntentName + "/" + pair.AppIntentName
+			if !found[key] {
+				changed = append(changed, key)
+			}
+			delete(found, key)
+		}
+	}
+	for key := range found {
+		changed = append(changed, key) // added since
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// VerifyReturn waits till the DIG of a temporary migration that moved its
+// apps back is instantiated, and each app is ready on one of the clusters
+// that its placement intent named before the migration, and no longer on
+// the target cluster, unless that is one of them. Apps placed by cluster
+// labels only are just checked to be ready. Like VerifyDIG, it fails with
+// the reason that the DIG is not ready when the activity times out.
+func VerifyReturn(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	params := migParam.InParams
+	err := waitForDIG(ctx, "VerifyReturn", params, func(status *digStatus) (string, bool) {
+		return status.notReturned(migParam)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &migParam, nil
+}
+
+// notReturned tells why the apps of the given migration are not back on
+// their source clusters, or returns "" if they are; failed is set if a
+// deployment failed.
+func (s *digStatus) notReturned(migParam MigParam) (reason string, failed bool) {
+	if state := s.state(); state != digStateInstantiated {
+		return state + ", not " + digStateInstantiated, false
+	}
+	sources := map[string][]string{} // by app, as provider+cluster
+	for _, pairs := range migParam.AppNameIntentPairs {
+		for _, pair := range pairs {
+			if pair.SourceIntent != nil {
+				sources[pair.AppName] = appendClusters(sources[pair.AppName],
+					*pair.SourceIntent)
+			}
+		}
+	}
+	target := migParam.InParams["targetClusterProvider"] + "+" +
+		migParam.InParams["targetClusterName"]
+	for _, app := range s.Apps {
+		deployed := map[string]bool{}
+		for _, c := range app.Clusters {
+			where := fmt.Sprintf("app %s on %s+%s", app.Name, c.ProviderName, c.ClusterName)
+			switch {
+			case c.DeployedStatus == "Deleted":
+				continue
+			case c.DeployedStatus == "Failed":
+				return where + " failed", true
+			case c.ReadyStatus != "Ready":
+				return where + " is " + c.ReadyStatus, false
+			}
+			deployed[c.ProviderName+"+"+c.ClusterName] = true
+		}
+		appSources := sources[app.Name]
+		if len(appSources) == 0 {
+			continue
+		}
+		onSource, targetIsSource := false, false
+		for _, cluster := range appSources {
+			onSource = onSource || deployed[cluster]
+			targetIsSource = targetIsSource || cluster == target
+		}
+		if !onSource {
+			return "app " + app.Name + " is not back on " +
+				strings.Join(appSources, " or "), false
+		}
+		if deployed[target] && !targetIsSource {
+			return "app " + app.Name + " is still on " + target, false
+		}
+	}
+	return "", false
+}
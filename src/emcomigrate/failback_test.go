@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFailbackPlan(t *testing.T) {
+	tests := []struct {
+		params   map[string]string
+		strategy string
+		plan     failbackPlan
+		err      string
+	}{
+		{map[string]string{}, StrategyUpdate, failbackPlan{}, ""},
+		{map[string]string{TemporaryParam: "true"}, StrategyWaves,
+			failbackPlan{temporary: true}, ""},
+		{map[string]string{ReturnAfterParam: "72h"}, StrategyUpdate,
+			failbackPlan{temporary: true, after: 72 * time.Hour}, ""},
+		{map[string]string{TemporaryParam: "false"}, StrategyCloneDIG, failbackPlan{}, ""},
+		{map[string]string{TemporaryParam: "yes"}, StrategyUpdate, failbackPlan{},
+			`Invalid temporary param "yes": expect true or false`},
+		{map[string]string{ReturnAfterParam: "3 days"}, StrategyUpdate, failbackPlan{},
+			`Invalid returnAfter param "3 days": expect a positive duration`},
+		{map[string]string{ReturnAfterParam: "-1h"}, StrategyUpdate, failbackPlan{},
+			`Invalid returnAfter param "-1h": expect a positive duration`},
+		{map[string]string{TemporaryParam: "true"}, StrategyCloneDIG, failbackPlan{},
+			`Invalid temporary param "true": the clone-dig strategy cannot be temporary`},
+		{map[string]string{ReturnAfterParam: "1h"}, StrategyCloneDIG, failbackPlan{},
+			`Invalid returnAfter param "1h": the clone-dig strategy cannot be temporary`},
+	}
+	for _, tc := range tests {
+		plan, err := newFailbackPlan(tc.params, tc.strategy)
+		if tc.err != "" {
+			require.Error(t, err, "%v", tc.params)
+			assert.Contains(t, err.Error(), tc.err)
+			continue
+		}
+		require.NoError(t, err, "%v", tc.params)
+		assert.Equal(t, tc.plan, *plan, "%v", tc.params)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	pairs := func(onTarget ...bool) map[string][]AppNameIntentPair {
+		names := []string{"collectd", "operator", "sink"}
+		gpi := []AppNameIntentPair{}
+		for i, on := range onTarget {
+			gpi = append(gpi, AppNameIntentPair{AppName: names[i],
+				AppIntentName: names[i] + "-placement-intent", OnTarget: on})
+		}
+		return map[string][]AppNameIntentPair{"dig1-placement-intent": gpi}
+	}
+	migrated := MigParam{AppNameIntentPairs: pairs(false, true)}
+	tests := []struct {
+		name    string
+		current MigParam
+		changed []string
+	}{
+		{"unchanged", MigParam{AppNameIntentPairs: pairs(true, true)}, []string{}},
+		{"moved", MigParam{AppNameIntentPairs: pairs(true, false)},
+			[]string{"dig1-placement-intent/operator-placement-intent"}},
+		{"removed", MigParam{AppNameIntentPairs: pairs(true)},
+			[]string{"dig1-placement-intent/operator-placement-intent"}},
+		{"added", MigParam{AppNameIntentPairs: pairs(true, true, true)},
+			[]string{"dig1-placement-intent/sink-placement-intent"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.changed, tc.current.changedSince(migrated))
+		})
+	}
+}
+
+// testReturnParam returns a migration of collectd and operator from
+// provider1+cluster1, with the given params.
+func testReturnParam(inParams map[string]string) MigParam {
+	source := &IntentStruc{AllOfArray: []AllOf{
+		{ProviderName: "provider1", ClusterName: "cluster1"}}}
+	return MigParam{
+		InParams: inParams,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent",
+					SourceIntent: source},
+				{AppName: "operator", AppIntentName: "operator-placement-intent",
+					SourceIntent: source},
+			},
+		},
+	}
+}
+
+func TestDigStatusNotReturned(t *testing.T) {
+	tests := []struct {
+		file   string
+		reason string
+	}{
+		{"dig-status.json", ""},
+		{"dig-status-on-target.json", "app collectd is not back on provider1+cluster1"},
+		{"dig-status-wave.json", "app collectd is not back on provider1+cluster1"},
+		{"dig-status-approved.json", "Approved, not Instantiated"},
+		{"dig-status-failed.json", "app collectd on provider2+cluster2 failed"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "emco", tc.file))
+			require.NoError(t, err)
+			var status digStatus
+			require.NoError(t, json.Unmarshal(data, &status))
+			reason, _ := status.notReturned(testReturnParam(testInParams()))
+			assert.Equal(t, tc.reason, reason)
+		})
+	}
+
+	// still on the target cluster too
+	var status digStatus
+	require.NoError(t, json.Unmarshal([]byte(`{"deployedStatus": "Instantiated",
+		"apps": [{"name": "collectd", "clusters": [
+			{"clusterProvider": "provider1", "cluster": "cluster1", "readyStatus": "Ready"},
+			{"clusterProvider": "provider2", "cluster": "cluster2", "readyStatus": "Ready"}]}]}`),
+		&status))
+	reason, _ := status.notReturned(testReturnParam(testInParams()))
+	assert.Equal(t, "app collectd is still on provider2+cluster2", reason)
+}
+
+func TestVerifyReturn(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testDigPath + "/status": {http.StatusOK, "dig-status.json"},
+	})
+	migParam := testReturnParam(emco.inParams())
+
+	result, err := runActivity(t, VerifyReturn, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// heartbeater heartbeats an activity that has a heartbeat timeout. It
+// records the progress of the activity as heartbeat details, so that a
+// retried attempt can resume from there, and keeps heartbeating in the
+// background between progress updates, so that a slow EMCO call does not
+// time out the heartbeat. stop must be called when the activity returns.
+type heartbeater struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	details interface{} // the latest progress, or nil
+	done    chan struct{}
+}
+
+func startHeartbeat(ctx context.Context) *heartbeater {
+	h := &heartbeater{ctx: ctx, done: make(chan struct{})}
+	if timeout := activity.GetInfo(ctx).HeartbeatTimeout; timeout > 0 {
+		go h.run(timeout / 2)
+	}
+	return h
+}
+
+// record records the given progress.
+func (h *heartbeater) record(details interface{}) {
+	h.mu.Lock()
+	h.details = details
+	h.mu.Unlock()
+	activity.RecordHeartbeat(h.ctx, details)
+}
+
+func (h *heartbeater) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.mu.Lock()
+			details := h.details
+			h.mu.Unlock()
+			if details == nil {
+				activity.RecordHeartbeat(h.ctx)
+			} else {
+				activity.RecordHeartbeat(h.ctx, details)
+			}
+		case <-h.done:
+			return
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *heartbeater) stop() {
+	close(h.done)
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Workflow params that choose how UpdateAppIntents rewrites the placement
+// intent of each app intent.
+const (
+	// One of the IntentRewrite* modes. The default is
+	// IntentRewriteReplacePlacement.
+	IntentRewriteParam = "intentRewrite"
+	// The cluster that IntentRewriteReplaceCluster replaces, as
+	// provider+cluster.
+	ReplaceClusterParam = "replaceCluster"
+)
+
+// IntentRewriteParam values
+const (
+	// Replace the whole placement intent with the target cluster.
+	IntentRewriteReplacePlacement = "replace-placement"
+	// Replace the ReplaceClusterParam cluster with the target cluster
+	// wherever it appears, in allOf and anyOf alike. Other clusters and
+	// label-based placements are kept.
+	IntentRewriteReplaceCluster = "replace-cluster"
+	// Add the target cluster to the placement intent, so that the apps are
+	// deployed there as well as where they are now.
+	IntentRewriteAddTarget = "add-target"
+)
+
+// intentRule rewrites placement intents for a migration.
+type intentRule struct {
+	mode   string
+	source AllOf // the replaced cluster, for IntentRewriteReplaceCluster
+	target AllOf
+}
+
+// newIntentRule returns the intent rule of a migration with the given
+// workflow params.
+func newIntentRule(params map[string]string) (intentRule, error) {
+	rule := intentRule{
+		mode: params[IntentRewriteParam],
+		target: AllOf{
+			ProviderName: params["targetClusterProvider"],
+			ClusterName:  params["targetClusterName"],
+		},
+	}
+	switch rule.mode {
+	case "":
+		rule.mode = IntentRewriteReplacePlacement
+	case IntentRewriteReplacePlacement, IntentRewriteAddTarget:
+	case IntentRewriteReplaceCluster:
+		parts := strings.Split(params[ReplaceClusterParam], "+")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return intentRule{}, fmt.Errorf("Invalid %s param %q: expect provider+cluster",
+				ReplaceClusterParam, params[ReplaceClusterParam])
+		}
+		rule.source = AllOf{ProviderName: parts[0], ClusterName: parts[1]}
+	default:
+		return intentRule{}, fmt.Errorf("Invalid %s param %q: expect %s, %s or %s",
+			IntentRewriteParam, rule.mode, IntentRewriteReplacePlacement,
+			IntentRewriteReplaceCluster, IntentRewriteAddTarget)
+	}
+	return rule, nil
+}
+
+// rewrite returns the given placement intent rewritten by the rule. It does
+// not change the given intent. Rewriting a rewritten intent again does not
+// change it, so that an update can be retried.
+func (r intentRule) rewrite(intent IntentStruc) IntentStruc {
+	switch r.mode {
+	case IntentRewriteReplaceCluster:
+		return IntentStruc{
+			AllOfArray: r.replaceAllOf(intent.AllOfArray),
+			AnyOfArray: r.replaceAnyOf(intent.AnyOfArray),
+		}
+
+	case IntentRewriteAddTarget:
+		for _, allOf := range intent.AllOfArray {
+			if r.isTarget(allOf.ProviderName, allOf.ClusterName) {
+				return intent
+			}
+		}
+		return IntentStruc{
+			AllOfArray: append(append([]AllOf{}, intent.AllOfArray...), r.target),
+			AnyOfArray: intent.AnyOfArray,
+		}
+	}
+	return IntentStruc{AllOfArray: []AllOf{r.target}}
+}
+
+// isSource reports whether the given placement names the replaced cluster.
+func (r intentRule) isSource(provider, cluster string) bool {
+	return provider == r.source.ProviderName && cluster == r.source.ClusterName
+}
+
+// isTarget reports whether the given placement names the target cluster.
+func (r intentRule) isTarget(provider, cluster string) bool {
+	return provider == r.target.ProviderName && cluster == r.target.ClusterName
+}
+
+// isDeployed reports whether the given DIG status has the apps deployed as
+// the rule places them. With IntentRewriteReplacePlacement, all apps must
+// be on the target cluster and on no other cluster; with
+// IntentRewriteAddTarget, all apps must be on the target cluster, and may
+// stay on others; with IntentRewriteReplaceCluster, no app may be on the
+// replaced cluster, and some must be on the target cluster, since the apps
+// that were not on the replaced cluster are left where they are.
+func (r intentRule) isDeployed(status *digStatus) bool {
+	switch r.mode {
+	case IntentRewriteReplaceCluster:
+		return status.appsOn(r.target.ProviderName, r.target.ClusterName) > 0 &&
+			status.appsOn(r.source.ProviderName, r.source.ClusterName) == 0
+	case IntentRewriteAddTarget:
+		return len(status.Apps) > 0 &&
+			status.appsOn(r.target.ProviderName, r.target.ClusterName) == len(status.Apps)
+	}
+	return status.deployedOnlyOn(r.target.ProviderName, r.target.ClusterName)
+}
+
+// replaceAllOf replaces the source cluster with the target cluster, and
+// drops the placements that are then repeated.
+func (r intentRule) replaceAllOf(allOfs []AllOf) []AllOf {
+	if allOfs == nil {
+		return nil
+	}
+	replaced := make([]AllOf, 0, len(allOfs))
+	for _, allOf := range allOfs {
+		if r.isSource(allOf.ProviderName, allOf.ClusterName) {
+			allOf.ProviderName = r.target.ProviderName
+			allOf.ClusterName = r.target.ClusterName
+		}
+		allOf.AnyOfArray = r.replaceAnyOf(allOf.AnyOfArray)
+		repeated := false
+		for _, other := range replaced {
+			repeated = repeated || reflect.DeepEqual(allOf, other)
+		}
+		if !repeated {
+			replaced = append(replaced, allOf)
+		}
+	}
+	return replaced
+}
+
+// replaceAnyOf is replaceAllOf for anyOf placements.
+func (r intentRule) replaceAnyOf(anyOfs []AnyOf) []AnyOf {
+	if anyOfs == nil {
+		return nil
+	}
+	replaced := make([]AnyOf, 0, len(anyOfs))
+	for _, anyOf := range anyOfs {
+		if r.isSource(anyOf.ProviderName, anyOf.ClusterName) {
+			anyOf.ProviderName = r.target.ProviderName
+			anyOf.ClusterName = r.target.ClusterName
+		}
+		repeated := false
+		for _, other := range replaced {
+			repeated = repeated || anyOf == other
+		}
+		if !repeated {
+			replaced = append(replaced, anyOf)
+		}
+	}
+	return replaced
+}
+
+// intentDiff returns the placements that differ between two placement
+// intents: those of from that to lacks, as "- placement", then those of to
+// that from lacks, as "+ placement". It is empty if they place apps alike.
+func intentDiff(from, to IntentStruc) []string {
+	fromLines, toLines := placementLines(from), placementLines(to)
+	removed := lineSubtract(fromLines, toLines)
+	added := lineSubtract(toLines, fromLines)
+	diff := make([]string, 0, len(removed)+len(added))
+	for _, line := range removed {
+		diff = append(diff, "- "+line)
+	}
+	for _, line := range added {
+		diff = append(diff, "+ "+line)
+	}
+	return diff
+}
+
+// placementLines describes each placement of a placement intent, such as
+// "allOf provider1+cluster1" or
+// "allOf anyOf(provider1+cluster1, provider1 label=edge)".
+func placementLines(intent IntentStruc) []string {
+	lines := []string{}
+	for _, allOf := range intent.AllOfArray {
+		line := "allOf"
+		if allOf.ClusterName != "" || allOf.ClusterLabelName != "" {
+			line += " " + placementName(allOf.ProviderName, allOf.ClusterName,
+				allOf.ClusterLabelName)
+		}
+		if len(allOf.AnyOfArray) > 0 {
+			names := make([]string, len(allOf.AnyOfArray))
+			for i, anyOf := range allOf.AnyOfArray {
+				names[i] = placementName(anyOf.ProviderName, anyOf.ClusterName,
+					anyOf.ClusterLabelName)
+			}
+			line += " anyOf(" + strings.Join(names, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	for _, anyOf := range intent.AnyOfArray {
+		lines = append(lines, "anyOf "+placementName(anyOf.ProviderName,
+			anyOf.ClusterName, anyOf.ClusterLabelName))
+	}
+	return lines
+}
+
+func placementName(provider, cluster, label string) string {
+	if cluster == "" {
+		return provider + " label=" + label
+	}
+	return provider + "+" + cluster
+}
+
+// lineSubtract returns the lines of a that are not in b, counting
+// repeated lines.
+func lineSubtract(a, b []string) []string {
+	left := make(map[string]int, len(b))
+	for _, line := range b {
+		left[line]++
+	}
+	lines := []string{}
+	for _, line := range a {
+		if left[line] > 0 {
+			left[line]--
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
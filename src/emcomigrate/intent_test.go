@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFailoverIntent places an app on cluster1 and on cluster3 or a cluster
+// labeled edge, and on any cluster of provider3 labeled spare.
+func testFailoverIntent() IntentStruc {
+	return IntentStruc{
+		AllOfArray: []AllOf{
+			{ProviderName: "provider1", ClusterName: "cluster1"},
+			{AnyOfArray: []AnyOf{
+				{ProviderName: "provider1", ClusterName: "cluster3"},
+				{ProviderName: "provider1", ClusterLabelName: "edge"},
+			}},
+		},
+		AnyOfArray: []AnyOf{
+			{ProviderName: "provider3", ClusterLabelName: "spare"},
+		},
+	}
+}
+
+func TestIntentRewrite(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		params   map[string]string
+		intent   IntentStruc
+		expected IntentStruc
+		diff     []string
+	}{
+		{
+			name:   "replace placement",
+			params: map[string]string{},
+			intent: testFailoverIntent(),
+			expected: IntentStruc{AllOfArray: []AllOf{
+				{ProviderName: "provider2", ClusterName: "cluster2"},
+			}},
+			diff: []string{
+				"- allOf provider1+cluster1",
+				"- allOf anyOf(provider1+cluster3, provider1 label=edge)",
+				"- anyOf provider3 label=spare",
+				"+ allOf provider2+cluster2",
+			},
+		},
+		{
+			name: "replace cluster in allOf",
+			params: map[string]string{
+				IntentRewriteParam:  IntentRewriteReplaceCluster,
+				ReplaceClusterParam: "provider1+cluster1",
+			},
+			intent: testFailoverIntent(),
+			expected: IntentStruc{
+				AllOfArray: []AllOf{
+					{ProviderName: "provider2", ClusterName: "cluster2"},
+					{AnyOfArray: []AnyOf{
+						{ProviderName: "provider1", ClusterName: "cluster3"},
+						{ProviderName: "provider1", ClusterLabelName: "edge"},
+					}},
+				},
+				AnyOfArray: []AnyOf{
+					{ProviderName: "provider3", ClusterLabelName: "spare"},
+				},
+			},
+			diff: []string{
+				"- allOf provider1+cluster1",
+				"+ allOf provider2+cluster2",
+			},
+		},
+		{
+			name: "replace cluster in anyOf",
+			params: map[string]string{
+				IntentRewriteParam:  IntentRewriteReplaceCluster,
+				ReplaceClusterParam: "provider1+cluster3",
+			},
+			intent: testFailoverIntent(),
+			expected: IntentStruc{
+				AllOfArray: []AllOf{
+					{ProviderName: "provider1", ClusterName: "cluster1"},
+					{AnyOfArray: []AnyOf{
+						{ProviderName: "provider2", ClusterName: "cluster2"},
+						{ProviderName: "provider1", ClusterLabelName: "edge"},
+					}},
+				},
+				AnyOfArray: []AnyOf{
+					{ProviderName: "provider3", ClusterLabelName: "spare"},
+				},
+			},
+			diff: []string{
+				"- allOf anyOf(provider1+cluster3, provider1 label=edge)",
+				"+ allOf anyOf(provider2+cluster2, provider1 label=edge)",
+			},
+		},
+		{
+			name: "replace cluster next to the target",
+			params: map[string]string{
+				IntentRewriteParam:  IntentRewriteReplaceCluster,
+				ReplaceClusterParam: "provider1+cluster1",
+			},
+			intent: IntentStruc{AllOfArray: []AllOf{
+				{ProviderName: "provider1", ClusterName: "cluster1"},
+				{ProviderName: "provider2", ClusterName: "cluster2"},
+			}},
+			expected: IntentStruc{AllOfArray: []AllOf{
+				{ProviderName: "provider2", ClusterName: "cluster2"},
+			}},
+			diff: []string{"- allOf provider1+cluster1"},
+		},
+		{
+			name: "replace absent cluster",
+			params: map[string]string{
+				IntentRewriteParam:  IntentRewriteReplaceCluster,
+				ReplaceClusterParam: "provider9+cluster9",
+			},
+			intent:   testFailoverIntent(),
+			expected: testFailoverIntent(),
+			diff:     []string{},
+		},
+		{
+			name:   "add target",
+			params: map[string]string{IntentRewriteParam: IntentRewriteAddTarget},
+			intent: testFailoverIntent(),
+			expected: IntentStruc{
+				AllOfArray: []AllOf{
+					{ProviderName: "provider1", ClusterName: "cluster1"},
+					{AnyOfArray: []AnyOf{
+						{ProviderName: "provider1", ClusterName: "cluster3"},
+						{ProviderName: "provider1", ClusterLabelName: "edge"},
+					}},
+					{ProviderName: "provider2", ClusterName: "cluster2"},
+				},
+				AnyOfArray: []AnyOf{
+					{ProviderName: "provider3", ClusterLabelName: "spare"},
+				},
+			},
+			diff: []string{"+ allOf provider2+cluster2"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := testInParams()
+			for key, value := range tc.params {
+				params[key] = value
+			}
+			rule, err := newIntentRule(params)
+			require.NoError(t, err)
+
+			before, err := json.Marshal(tc.intent)
+			require.NoError(t, err)
+			rewritten := rule.rewrite(tc.intent)
+			assert.Equal(t, tc.expected, rewritten)
+			after, err := json.Marshal(tc.intent)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(before), string(after), "input changed")
+			assert.Equal(t, tc.diff, intentDiff(tc.intent, rewritten))
+			// a retry does not change it again
+			assert.Equal(t, rewritten, rule.rewrite(rewritten))
+			assert.True(t, isOnTarget(rewritten, rule))
+		})
+	}
+}
+
+func TestIsOnTarget(t *testing.T) {
+	source := AllOf{ProviderName: "provider1", ClusterName: "cluster1"}
+	target := AllOf{ProviderName: "provider2", ClusterName: "cluster2"}
+	other := AllOf{ProviderName: "provider1", ClusterName: "cluster3"}
+	replaceCluster := map[string]string{
+		IntentRewriteParam:  IntentRewriteReplaceCluster,
+		ReplaceClusterParam: "provider1+cluster1",
+	}
+	addTarget := map[string]string{IntentRewriteParam: IntentRewriteAddTarget}
+	for _, tc := range []struct {
+		name     string
+		params   map[string]string
+		allOf    []AllOf
+		onTarget bool
+	}{
+		{"replace placement on target", map[string]string{}, []AllOf{target}, true},
+		{"replace placement on source", map[string]string{}, []AllOf{source}, false},
+		{"replace placement on target and source", map[string]string{},
+			[]AllOf{source, target}, false},
+
+		{"replace cluster on target", replaceCluster, []AllOf{target, other}, true},
+		{"replace cluster on source", replaceCluster, []AllOf{source, other}, false},
+		{"replace cluster on target and source", replaceCluster,
+			[]AllOf{target, source}, false},
+		{"replace cluster in anyOf", replaceCluster,
+			[]AllOf{{AnyOfArray: []AnyOf{{ProviderName: "provider1", ClusterName: "cluster1"}}}},
+			false},
+
+		{"add target on target", addTarget, []AllOf{source, target}, true},
+		{"add target on target only", addTarget, []AllOf{target}, true},
+		{"add target labeled", addTarget, []AllOf{source,
+			{ProviderName: "provider2", ClusterName: "cluster2", ClusterLabelName: "edge"}},
+			true},
+		{"add target on source", addTarget, []AllOf{source}, false},
+		{"add target in anyOf", addTarget,
+			[]AllOf{{AnyOfArray: []AnyOf{{ProviderName: "provider2", ClusterName: "cluster2"}}}},
+			false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := testInParams()
+			for key, value := range tc.params {
+				params[key] = value
+			}
+			rule, err := newIntentRule(params)
+			require.NoError(t, err)
+			intent := IntentStruc{AllOfArray: tc.allOf}
+
+			assert.Equal(t, tc.onTarget, isOnTarget(intent, rule))
+			if tc.onTarget {
+				assert.Empty(t, intentDiff(intent, rule.rewrite(intent)))
+			} else {
+				assert.NotEmpty(t, intentDiff(intent, rule.rewrite(intent)))
+			}
+		})
+	}
+}
+
+func TestIntentRewriteInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		params   map[string]string
+		contains string
+	}{
+		{
+			name:     "unknown mode",
+			params:   map[string]string{IntentRewriteParam: "swap"},
+			contains: `Invalid intentRewrite param "swap"`,
+		},
+		{
+			name:     "no replaced cluster",
+			params:   map[string]string{IntentRewriteParam: IntentRewriteReplaceCluster},
+			contains: `Invalid replaceCluster param "": expect provider+cluster`,
+		},
+		{
+			name: "bad replaced cluster",
+			params: map[string]string{
+				IntentRewriteParam:  IntentRewriteReplaceCluster,
+				ReplaceClusterParam: "cluster1",
+			},
+			contains: `Invalid replaceCluster param "cluster1"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := testInParams()
+			for key, value := range tc.params {
+				params[key] = value
+			}
+			_, err := newIntentRule(params)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.contains)
+		})
+	}
+}
@@ -0,0 +1,339 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	wf "go.temporal.io/sdk/workflow"
+)
+
+// Migrations of the same DIG are serialized by a DigLockWorkflow per DIG.
+// A migration sends it an AcquireLockSignal with signal-with-start, waits
+// for a LockGrantSignal in return, and sends a ReleaseLockSignal when it
+// is done. The lock workflow grants the lock in the order of the requests,
+// and completes when nobody holds or waits for the lock.
+
+// Workflow param that selects what a migration does if another migration
+// holds the lock of its DIG: DigLockWait (the default) or DigLockFailFast.
+const DigLockParam = "digLock"
+
+const (
+	DigLockWait     = "wait"      // wait for the other migration to finish
+	DigLockFailFast = "fail-fast" // fail right away
+)
+
+// Signals of the DIG lock.
+const (
+	AcquireLockSignal = "acquire-lock" // LockRequest, to DigLockWorkflow
+	ReleaseLockSignal = "release-lock" // LockRequest, to DigLockWorkflow
+	LockGrantSignal   = "lock-grant"   // LockGrant, to the requester
+)
+
+// Query type that returns the DigLockState of a DigLockWorkflow.
+const LockStateQuery = "lock-state"
+
+// State of EmcoMigrateWorkflow while it waits for the lock of its DIG
+const StateWaitingForLock = "waiting-for-lock"
+
+// LockRequest identifies the workflow run that requests or releases a lock.
+type LockRequest struct {
+	WorkflowID string
+	RunID      string
+	FailFast   bool `json:",omitempty"`
+}
+
+// LockGrant answers a LockRequest.
+type LockGrant struct {
+	Granted bool
+	// workflow ID of the lock holder, if not granted
+	Holder string `json:",omitempty"`
+}
+
+// DigLockState is the state of a DigLockWorkflow, which it carries over
+// when it continues as new.
+type DigLockState struct {
+	DIG    string // project/compositeApp/compositeAppVersion/deploymentIntentGroup
+	Holder *LockRequest
+	Queue  []LockRequest
+}
+
+// After this many grants, or this many wake-ups on signals and holder
+// checks, DigLockWorkflow continues as new to keep its history short. A
+// migration that holds the lock for long, such as a temporary one, adds a
+// holder check every lockCheckInterval without any grant.
+const (
+	maxLockGrants  = 500
+	maxLockWakeups = 1000
+)
+
+// lockCheckInterval is how often DigLockWorkflow checks that the lock
+// holder is still running, so that a terminated holder does not keep the
+// lock forever.
+const lockCheckInterval = time.Minute
+
+var lockCheckOpts = wf.ActivityOptions{
+	StartToCloseTimeout: 10 * time.Second,
+	RetryPolicy: &temporal.RetryPolicy{
+		MaximumAttempts: 3,
+	},
+}
+
+// DigLockWorkflowID returns the workflow ID of the lock of the DIG of a
+// migration with the given params.
+func DigLockWorkflowID(inParams map[string]string) string {
+	return "dig-lock/" + digKey(inParams)
+}
+
+func digKey(inParams map[string]string) string {
+	return strings.Join([]string{inParams["project"], inParams["compositeApp"],
+		inParams["compositeAppVersion"], inParams["deploymentIntentGroup"]}, "/")
+}
+
+// DigLockWorkflow holds the lock of a DIG for one migration at a time.
+func DigLockWorkflow(ctx wf.Context, state DigLockState) error {
+	err := wf.SetQueryHandler(ctx, LockStateQuery, func() (DigLockState, error) {
+		return state, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	acquireCh := wf.GetSignalChannel(ctx, AcquireLockSignal)
+	releaseCh := wf.GetSignalChannel(ctx, ReleaseLockSignal)
+	checkCtx := wf.WithActivityOptions(ctx, lockCheckOpts)
+	grants, wakeups := 0, 0
+	for {
+		var req LockRequest
+		for acquireCh.ReceiveAsync(&req) {
+			state.acquire(ctx, req)
+		}
+		for releaseCh.ReceiveAsync(&req) {
+			state.release(req)
+		}
+		grants += state.grantNext(ctx)
+
+		if state.Holder == nil {
+			// Nobody waits. Temporal retries the completion if a signal
+			// comes in meanwhile, so no request is lost.
+			return nil
+		}
+		if grants >= maxLockGrants || wakeups >= maxLockWakeups {
+			return wf.NewContinueAsNewError(ctx, DigLockWorkflow, state)
+		}
+
+		timerCtx, cancelTimer := wf.WithCancel(ctx)
+		selector := wf.NewSelector(ctx)
+		selector.AddReceive(acquireCh, func(c wf.ReceiveChannel, more bool) {
+			c.Receive(ctx, &req)
+			state.acquire(ctx, req)
+		})
+		selector.AddReceive(releaseCh, func(c wf.ReceiveChannel, more bool) {
+			c.Receive(ctx, &req)
+			state.release(req)
+		})
+		selector.AddFuture(wf.NewTimer(timerCtx, lockCheckInterval), func(f wf.Future) {
+			if f.Get(ctx, nil) != nil {
+				return
+			}
+			holder := *state.Holder
+			var running bool
+			err := wf.ExecuteActivity(checkCtx, IsWorkflowRunning,
+				holder.WorkflowID, holder.RunID).Get(checkCtx, &running)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "DigLockWorkflow: failed to check lock holder %s: %s\n",
+					holder.WorkflowID, err)
+			} else if !running {
+				fmt.Fprintf(os.Stderr, "DigLockWorkflow: lock holder %s is gone, releasing %s\n",
+					holder.WorkflowID, state.DIG)
+				state.release(holder)
+			}
+		})
+		selector.Select(ctx)
+		cancelTimer()
+		wakeups++
+	}
+}
+
+// acquire queues the given request, or denies it if it is fail-fast and
+// the lock is taken. Repeated requests are ignored.
+func (s *DigLockState) acquire(ctx wf.Context, req LockRequest) {
+	if s.Holder != nil && s.Holder.RunID == req.RunID {
+		return
+	}
+	for _, queued := range s.Queue {
+		if queued.RunID == req.RunID {
+			return
+		}
+	}
+	if req.FailFast && s.Holder != nil {
+		signalRequester(ctx, req, LockGrant{Holder: s.Holder.WorkflowID})
+		return
+	}
+	if req.FailFast && len(s.Queue) > 0 {
+		signalRequester(ctx, req, LockGrant{Holder: s.Queue[0].WorkflowID})
+		return
+	}
+	s.Queue = append(s.Queue, req)
+}
+
+// release frees the lock if the given request holds it, or else withdraws
+// the request from the queue.
+func (s *DigLockState) release(req LockRequest) {
+	if s.Holder != nil && s.Holder.RunID == req.RunID {
+		s.Holder = nil
+		return
+	}
+	for i, queued := range s.Queue {
+		if queued.RunID == req.RunID {
+			s.Queue = append(s.Queue[:i], s.Queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// grantNext grants the lock to the next request in the queue, if the lock
+// is free, skipping requesters that are gone. It returns the number of
+// grants made.
+func (s *DigLockState) grantNext(ctx wf.Context) int {
+	grants := 0
+	for s.Holder == nil && len(s.Queue) > 0 {
+		next := s.Queue[0]
+		s.Queue = s.Queue[1:]
+		// Hold the lock while signaling, so that a release that comes in
+		// meanwhile frees it.
+		s.Holder = &next
+		if err := signalRequester(ctx, next, LockGrant{Granted: true}); err != nil {
+			s.Holder = nil
+			continue
+		}
+		grants++
+	}
+	return grants
+}
+
+func signalRequester(ctx wf.Context, req LockRequest, grant LockGrant) error {
+	err := wf.SignalExternalWorkflow(ctx, req.WorkflowID, req.RunID,
+		LockGrantSignal, grant).Get(ctx, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DigLockWorkflow: failed to signal %s: %s\n",
+			req.WorkflowID, err)
+	}
+	return err
+}
+
+// lockDIG acquires the lock of the DIG of a migration for the calling
+// workflow run. It waits for other migrations of the DIG to release the
+// lock, unless the DigLockParam param is DigLockFailFast. It returns a
+// function that releases the lock, or withdraws the request if the lock
+// was not granted, which must be called if it is not nil, even on error.
+func lockDIG(ctx, actCtx wf.Context, inParams map[string]string) (func(), error) {
+	failFast := false
+	switch mode := inParams[DigLockParam]; mode {
+	case "", DigLockWait:
+	case DigLockFailFast:
+		failFast = true
+	default:
+		return nil, fmt.Errorf("Invalid %s param %q: expect %s or %s",
+			DigLockParam, mode, DigLockWait, DigLockFailFast)
+	}
+
+	info := wf.GetInfo(ctx)
+	req := LockRequest{
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+		FailFast:   failFast,
+	}
+	lockID := DigLockWorkflowID(inParams)
+	release := func() {
+		// Release even if the workflow is canceled.
+		releaseCtx, _ := wf.NewDisconnectedContext(ctx)
+		err := wf.SignalExternalWorkflow(releaseCtx, lockID, "",
+			ReleaseLockSignal, req).Get(releaseCtx, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to release lock %s: %s\n", lockID, err)
+		}
+	}
+
+	err := wf.ExecuteActivity(actCtx, RequestDIGLock, lockID, digKey(inParams), req).
+		Get(actCtx, nil)
+	if err != nil {
+		// The request may have got through before the activity failed.
+		return release, err
+	}
+
+	var grant LockGrant
+	selector := wf.NewSelector(ctx)
+	selector.AddReceive(wf.GetSignalChannel(ctx, LockGrantSignal),
+		func(c wf.ReceiveChannel, more bool) {
+			c.Receive(ctx, &grant)
+		})
+	selector.AddReceive(ctx.Done(), func(c wf.ReceiveChannel, more bool) {})
+	selector.Select(ctx)
+	if err := ctx.Err(); err != nil {
+		return release, err
+	}
+	if !grant.Granted {
+		return nil, fmt.Errorf("DIG %s is being migrated by workflow %s",
+			digKey(inParams), grant.Holder)
+	}
+	return release, nil
+}
+
+// temporalClient is used by the activities that talk to Temporal itself.
+var temporalClient client.Client
+
+// SetTemporalClient sets the client of the activities that talk to
+// Temporal itself. The worker must set it before it runs them.
+func SetTemporalClient(c client.Client) {
+	temporalClient = c
+}
+
+// RequestDIGLock sends a lock request to the DigLockWorkflow with the given
+// ID, starting it if it is not running.
+func RequestDIGLock(ctx context.Context, lockID string, dig string, req LockRequest) error {
+	defer startHeartbeat(ctx).stop()
+	if temporalClient == nil {
+		return temporal.NewNonRetryableApplicationError(
+			"RequestDIGLock: the worker has no Temporal client", "NoClient", nil)
+	}
+	options := client.StartWorkflowOptions{
+		ID:        lockID,
+		TaskQueue: MigTaskQueue,
+	}
+	_, err := temporalClient.SignalWithStartWorkflow(ctx, lockID, AcquireLockSignal,
+		req, options, DigLockWorkflow, DigLockState{DIG: dig})
+	if err != nil {
+		lockErr := fmt.Errorf("Failed to request lock %s: %s\n", lockID, err)
+		fmt.Fprintf(os.Stderr, lockErr.Error())
+		return lockErr
+	}
+	return nil
+}
+
+// IsWorkflowRunning reports whether the given workflow run is still
+// running.
+func IsWorkflowRunning(ctx context.Context, workflowID, runID string) (bool, error) {
+	if temporalClient == nil {
+		return false, temporal.NewNonRetryableApplicationError(
+			"IsWorkflowRunning: the worker has no Temporal client", "NoClient", nil)
+	}
+	resp, err := temporalClient.DescribeWorkflowExecution(ctx, workflowID, runID)
+	if err != nil {
+		if _, ok := err.(*serviceerror.NotFound); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	status := resp.GetWorkflowExecutionInfo().GetStatus()
+	return status == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING, nil
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	wf "go.temporal.io/sdk/workflow"
+)
+
+type DigLockTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func TestDigLockTestSuite(t *testing.T) {
+	suite.Run(t, new(DigLockTestSuite))
+}
+
+func (s *DigLockTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+}
+
+func (s *DigLockTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+var (
+	migA = LockRequest{WorkflowID: "migrate-a", RunID: "run-a"}
+	migB = LockRequest{WorkflowID: "migrate-b", RunID: "run-b"}
+)
+
+// expectGrant expects the lock to be granted to req. The grant fails if
+// err is not nil.
+func (s *DigLockTestSuite) expectGrant(req LockRequest, err error) {
+	s.env.OnSignalExternalWorkflow(mock.Anything, req.WorkflowID, req.RunID,
+		LockGrantSignal, LockGrant{Granted: true}).Return(err).Once()
+}
+
+// signalAt sends the given signal to the lock after the given delay.
+func (s *DigLockTestSuite) signalAt(delay time.Duration, signal string, req LockRequest) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(signal, req)
+	}, delay)
+}
+
+func (s *DigLockTestSuite) lockState() DigLockState {
+	value, err := s.env.QueryWorkflow(LockStateQuery)
+	s.NoError(err)
+	var state DigLockState
+	s.NoError(value.Get(&state))
+	return state
+}
+
+// The lock is started by the signal-with-start of the first request, which
+// is in the queue when the workflow starts.
+func (s *DigLockTestSuite) start() {
+	s.env.ExecuteWorkflow(DigLockWorkflow, DigLockState{
+		DIG:   "proj1/capp1/v1/dig1",
+		Queue: []LockRequest{migA},
+	})
+}
+
+func (s *DigLockTestSuite) Test_GrantInOrder() {
+	s.expectGrant(migA, nil)
+	s.expectGrant(migB, nil)
+	s.signalAt(time.Second, AcquireLockSignal, migB)
+	s.signalAt(2*time.Second, AcquireLockSignal, migA) // repeated
+	s.env.RegisterDelayedCallback(func() {
+		state := s.lockState()
+		s.Equal(&migA, state.Holder)
+		s.Equal([]LockRequest{migB}, state.Queue)
+	}, 3*time.Second)
+	s.signalAt(4*time.Second, ReleaseLockSignal, migA)
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(&migB, s.lockState().Holder)
+	}, 5*time.Second)
+	s.signalAt(6*time.Second, ReleaseLockSignal, migB)
+
+	s.start()
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *DigLockTestSuite) Test_FailFast() {
+	failFast := migB
+	failFast.FailFast = true
+	s.expectGrant(migA, nil)
+	s.env.OnSignalExternalWorkflow(mock.Anything, migB.WorkflowID, migB.RunID,
+		LockGrantSignal, LockGrant{Holder: migA.WorkflowID}).Return(nil).Once()
+	s.signalAt(time.Second, AcquireLockSignal, failFast)
+	s.signalAt(2*time.Second, ReleaseLockSignal, migA)
+
+	s.start()
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *DigLockTestSuite) Test_WithdrawnRequest() {
+	s.expectGrant(migA, nil)
+	s.signalAt(time.Second, AcquireLockSignal, migB)
+	s.signalAt(2*time.Second, ReleaseLockSignal, migB) // canceled while waiting
+	s.signalAt(3*time.Second, ReleaseLockSignal, migA)
+
+	s.start()
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *DigLockTestSuite) Test_RequesterGone() {
+	s.expectGrant(migA, errors.New("workflow not found"))
+	s.expectGrant(migB, nil)
+	s.signalAt(time.Second, AcquireLockSignal, migB)
+	s.signalAt(2*time.Second, ReleaseLockSignal, migB)
+	s.env.ExecuteWorkflow(DigLockWorkflow, DigLockState{
+		DIG:   "proj1/capp1/v1/dig1",
+		Queue: []LockRequest{migA, migB},
+	})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *DigLockTestSuite) Test_HolderGone() {
+	s.expectGrant(migA, nil)
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, migA.WorkflowID, migA.RunID).
+		Return(true, nil).Once()
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, migA.WorkflowID, migA.RunID).
+		Return(false, nil).Once()
+
+	s.start()
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *DigLockTestSuite) Test_ContinueAsNewWhileHeld() {
+	s.expectGrant(migA, nil)
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, migA.WorkflowID, migA.RunID).
+		Return(true, nil).Times(maxLockWakeups - 1)
+	s.signalAt(time.Second, AcquireLockSignal, migB) // the other wake-up
+
+	s.start()
+
+	s.True(s.env.IsWorkflowCompleted())
+	var continued *wf.ContinueAsNewError
+	s.True(errors.As(s.env.GetWorkflowError(), &continued))
+	var state DigLockState
+	s.NoError(converter.GetDefaultDataConverter().FromPayloads(continued.Input, &state))
+	s.Equal(&migA, state.Holder)
+	s.Equal([]LockRequest{migB}, state.Queue)
+}
@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// Workflow param: "true" adds the target cluster to the logical cloud of
+// the DIG if it is not in it, after the pre-flight checks. The default is
+// "false".
+const AddToLogicalCloudParam = "addToLogicalCloud"
+
+// Workflow param: the load balancer IP of the cluster reference that
+// AddClusterToLogicalCloud adds. By default the reference has none.
+const LoadBalancerIPParam = "loadbalancerIp"
+
+// The logical cloud state in which it can be updated
+const cloudStateInstantiated = "Instantiated"
+
+// cloudProgress is the progress of AddClusterToLogicalCloud, recorded in
+// its heartbeats.
+type cloudProgress struct {
+	Added        string // name of the added cluster reference
+	Instantiated bool   // the logical cloud was instantiated
+}
+
+// AddClusterToLogicalCloud adds the target cluster to the logical cloud of
+// the DIG of a migration, as a cluster reference, if it is not in it
+// already. It then updates the logical cloud, or instantiates it if it is
+// not instantiated. What it did is recorded in migParam, so that
+// RemoveClusterFromLogicalCloud can undo it.
+func AddClusterToLogicalCloud(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	hb := startHeartbeat(ctx)
+	defer hb.stop()
+
+	params := migParam.InParams
+	provider := params["targetClusterProvider"]
+	cluster := params["targetClusterName"]
+	logicalCloud, err := digLogicalCloud(params)
+	if err != nil {
+		return nil, err
+	}
+	migParam.LogicalCloud = logicalCloud
+	cloudURL := logicalCloudURL(params, logicalCloud)
+
+	// An earlier attempt may have added the cluster reference already.
+	var progress cloudProgress
+	if activity.HasHeartbeatDetails(ctx) {
+		if err := activity.GetHeartbeatDetails(ctx, &progress); err != nil {
+			progress = cloudProgress{}
+		}
+	}
+	if progress.Added == "" {
+		inCloud, err := isInLogicalCloud(params, logicalCloud, provider, cluster)
+		if err != nil {
+			return nil, err
+		}
+		if inCloud {
+			fmt.Printf("AddClusterToLogicalCloud: %s+%s is in logical cloud %s already\n",
+				provider, cluster, logicalCloud)
+			return &migParam, nil
+		}
+
+		spec := map[string]string{
+			"clusterProvider": provider,
+			"cluster":         cluster,
+		}
+		if ip := params[LoadBalancerIPParam]; ip != "" {
+			spec["loadbalancerIp"] = ip
+		}
+		ref := map[string]interface{}{
+			"metadata": map[string]string{
+				"name":        provider + "-" + cluster,
+				"description": "added by migration " + activity.GetInfo(ctx).WorkflowExecution.ID,
+			},
+			"spec": spec,
+		}
+		if err := postToEmco(cloudURL+"/cluster-references", ref, http.StatusCreated); err != nil {
+			return nil, err
+		}
+		progress.Added = provider + "-" + cluster
+		hb.record(progress)
+	}
+	migParam.AddedClusterReference = progress.Added
+
+	state, err := logicalCloudState(cloudURL)
+	if err != nil {
+		return nil, err
+	}
+	if state == cloudStateInstantiated && !progress.Instantiated {
+		err = postToEmco(cloudURL+"/update", nil, http.StatusAccepted)
+	} else if state != cloudStateInstantiated {
+		err = postToEmco(cloudURL+"/instantiate", nil, http.StatusAccepted)
+		progress.Instantiated = true
+		hb.record(progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+	migParam.InstantiatedLogicalCloud = progress.Instantiated
+	fmt.Printf("AddClusterToLogicalCloud: added %s+%s to logical cloud %s\n",
+		provider, cluster, logicalCloud)
+	return &migParam, nil
+}
+
+// RemoveClusterFromLogicalCloud undoes AddClusterToLogicalCloud, to roll
+// back a failed migration: it terminates the logical cloud if the migration
+// instantiated it, removes the cluster reference that the migration added,
+// and updates the logical cloud if it is instantiated.
+func RemoveClusterFromLogicalCloud(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	if migParam.AddedClusterReference == "" {
+		return &migParam, nil
+	}
+	cloudURL := logicalCloudURL(migParam.InParams, migParam.LogicalCloud)
+
+	state, err := logicalCloudState(cloudURL)
+	if err != nil {
+		return nil, err
+	}
+	if migParam.InstantiatedLogicalCloud && state == cloudStateInstantiated {
+		if err := postToEmco(cloudURL+"/terminate", nil, http.StatusAccepted); err != nil {
+			return nil, err
+		}
+		state = ""
+	}
+
+	// Not found if an earlier attempt deleted it.
+	refURL := cloudURL + "/cluster-references/" + migParam.AddedClusterReference
+	if _, err := sendToEmco(http.MethodDelete, refURL, nil,
+		http.StatusNoContent, http.StatusNotFound); err != nil {
+		return nil, err
+	}
+
+	if state == cloudStateInstantiated {
+		if err := postToEmco(cloudURL+"/update", nil, http.StatusAccepted); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("RemoveClusterFromLogicalCloud: removed %s from logical cloud %s\n",
+		migParam.AddedClusterReference, migParam.LogicalCloud)
+	migParam.AddedClusterReference = ""
+	migParam.InstantiatedLogicalCloud = false
+	return &migParam, nil
+}
+
+func logicalCloudURL(params map[string]string, logicalCloud string) string {
+	return params["emcoURL"] + "/v2/projects/" + params["project"] +
+		"/logical-clouds/" + logicalCloud
+}
+
+// logicalCloudState returns the lifecycle state of the logical cloud at the
+// given URL, such as "Instantiated". Its status has the same form as the
+// status of a DIG.
+func logicalCloudState(cloudURL string) (string, error) {
+	statusURL := cloudURL + "/status"
+	respBody, err := getHttpRespBody(statusURL)
+	if err != nil {
+		return "", err
+	}
+	var status digStatus
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET response body for URL %s.\n"+
+			"Decoder error: %#v\n", statusURL, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return "", decodeErr
+	}
+	return status.state(), nil
+}
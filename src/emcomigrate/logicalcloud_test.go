@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCloudPath      = "/v2/projects/proj1/logical-clouds/default"
+	testAddedCloudPath = testCloudRefPath + "/provider2-cluster2"
+)
+
+// addToCloudResponses are the EMCO responses for adding provider2+cluster2
+// to the instantiated logical cloud of the DIG.
+func addToCloudResponses() map[string]recordedResponse {
+	return map[string]recordedResponse{
+		"GET " + testDigPath:                {http.StatusOK, "dig.json"},
+		"GET " + testCloudRefPath:           {http.StatusOK, "cluster-references-provider1.json"},
+		"POST " + testCloudRefPath:          {http.StatusCreated, ""},
+		"GET " + testCloudPath + "/status":  {http.StatusOK, "logical-cloud-status.json"},
+		"POST " + testCloudPath + "/update": {http.StatusAccepted, ""},
+	}
+}
+
+func paths(reqs []recordedRequest) []string {
+	paths := make([]string, len(reqs))
+	for i, req := range reqs {
+		paths[i] = req.path
+	}
+	return paths
+}
+
+func TestAddClusterToLogicalCloud(t *testing.T) {
+	emco := newRecordedEmco(t, addToCloudResponses())
+	migParam := MigParam{InParams: emco.inParams()}
+
+	result, err := runActivity(t, AddClusterToLogicalCloud, migParam)
+	require.NoError(t, err)
+	expected := migParam
+	expected.LogicalCloud = "default"
+	expected.AddedClusterReference = "provider2-cluster2"
+	assert.Equal(t, expected, *result)
+
+	posts := emco.requestsFor(http.MethodPost)
+	assert.Equal(t, []string{testCloudRefPath, testCloudPath + "/update"}, paths(posts))
+	var ref struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec map[string]string `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(posts[0].body, &ref))
+	assert.Equal(t, "provider2-cluster2", ref.Metadata.Name)
+	assert.Equal(t, map[string]string{
+		"clusterProvider": "provider2",
+		"cluster":         "cluster2",
+	}, ref.Spec)
+}
+
+func TestAddClusterToLogicalCloudLoadBalancerIP(t *testing.T) {
+	emco := newRecordedEmco(t, addToCloudResponses())
+	inParams := emco.inParams()
+	inParams[LoadBalancerIPParam] = "10.10.10.6"
+
+	_, err := runActivity(t, AddClusterToLogicalCloud, MigParam{InParams: inParams})
+	require.NoError(t, err)
+	posts := emco.requestsFor(http.MethodPost)
+	require.NotEmpty(t, posts)
+	var ref struct {
+		Spec map[string]string `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(posts[0].body, &ref))
+	assert.Equal(t, "10.10.10.6", ref.Spec["loadbalancerIp"])
+}
+
+func TestAddClusterToLogicalCloudInstantiates(t *testing.T) {
+	responses := addToCloudResponses()
+	responses["GET "+testCloudPath+"/status"] = recordedResponse{
+		http.StatusOK, "logical-cloud-status-created.json"}
+	responses["POST "+testCloudPath+"/instantiate"] = recordedResponse{http.StatusAccepted, ""}
+	emco := newRecordedEmco(t, responses)
+
+	result, err := runActivity(t, AddClusterToLogicalCloud, MigParam{InParams: emco.inParams()})
+	require.NoError(t, err)
+	assert.True(t, result.InstantiatedLogicalCloud)
+	assert.Equal(t, []string{testCloudRefPath, testCloudPath + "/instantiate"},
+		paths(emco.requestsFor(http.MethodPost)))
+}
+
+func TestAddClusterToLogicalCloudAlreadyIn(t *testing.T) {
+	responses := addToCloudResponses()
+	responses["GET "+testCloudRefPath] = recordedResponse{http.StatusOK, "cluster-references.json"}
+	emco := newRecordedEmco(t, responses)
+
+	result, err := runActivity(t, AddClusterToLogicalCloud, MigParam{InParams: emco.inParams()})
+	require.NoError(t, err)
+	assert.Equal(t, "", result.AddedClusterReference)
+	assert.Empty(t, emco.requestsFor(http.MethodPost))
+}
+
+func TestAddClusterToLogicalCloudError(t *testing.T) {
+	responses := addToCloudResponses()
+	responses["POST "+testCloudRefPath] = recordedResponse{http.StatusConflict, ""}
+	emco := newRecordedEmco(t, responses)
+
+	_, err := runActivity(t, AddClusterToLogicalCloud, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "409 Conflict")
+}
+
+func TestRemoveClusterFromLogicalCloud(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCloudPath + "/status":  {http.StatusOK, "logical-cloud-status.json"},
+		"DELETE " + testAddedCloudPath:      {http.StatusNoContent, ""},
+		"POST " + testCloudPath + "/update": {http.StatusAccepted, ""},
+	})
+	migParam := MigParam{
+		InParams:              emco.inParams(),
+		LogicalCloud:          "default",
+		AddedClusterReference: "provider2-cluster2",
+	}
+
+	result, err := runActivity(t, RemoveClusterFromLogicalCloud, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, "", result.AddedClusterReference)
+	assert.Equal(t, []string{testAddedCloudPath}, paths(emco.requestsFor(http.MethodDelete)))
+	assert.Equal(t, []string{testCloudPath + "/update"}, paths(emco.requestsFor(http.MethodPost)))
+}
+
+func TestRemoveClusterFromLogicalCloudTerminates(t *testing.T) {
+	// The reference was deleted by an earlier attempt.
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCloudPath + "/status":     {http.StatusOK, "logical-cloud-status.json"},
+		"POST " + testCloudPath + "/terminate": {http.StatusAccepted, ""},
+	})
+	migParam := MigParam{
+		InParams:                 emco.inParams(),
+		LogicalCloud:             "default",
+		AddedClusterReference:    "provider2-cluster2",
+		InstantiatedLogicalCloud: true,
+	}
+
+	result, err := runActivity(t, RemoveClusterFromLogicalCloud, migParam)
+	require.NoError(t, err)
+	assert.False(t, result.InstantiatedLogicalCloud)
+	assert.Equal(t, []string{testCloudPath + "/terminate"},
+		paths(emco.requestsFor(http.MethodPost)))
+}
+
+func TestRemoveClusterFromLogicalCloudNothingAdded(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+	migParam := MigParam{InParams: emco.inParams()}
+
+	result, err := runActivity(t, RemoveClusterFromLogicalCloud, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
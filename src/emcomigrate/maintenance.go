@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Time zones for maintenance windows on workers without zoneinfo
+	_ "time/tzdata"
+)
+
+// Workflow params of maintenance windows
+const (
+	// The times at which the migration may change the DIG, separated by
+	// ";". Each window is a cron expression of when it opens, as parsed
+	// by ParseCronSchedule, and a duration, as in "0 2 * * SAT 4h" for
+	// 2am to 6am each Saturday. By default the migration may run at any
+	// time.
+	MaintenanceWindowsParam = "maintenanceWindows"
+	// The IANA time zone of the maintenance windows, as "Europe/Paris".
+	// The default is UTC.
+	MaintenanceTimeZoneParam = "maintenanceTimeZone"
+	// A time, in RFC 3339 format, before which the migration does not
+	// change the DIG. By default it may start at once.
+	NotBeforeParam = "notBefore"
+)
+
+// State of EmcoMigrateWorkflow while it waits for a maintenance window to
+// open, or for its notBefore time
+const StateWaitingForWindow = "waiting-for-window"
+
+// The longest maintenance window
+const maxWindowDuration = 7 * 24 * time.Hour
+
+// maintenanceWindow is a maintenance window that opens at the times of a
+// cron schedule, for a given duration.
+type maintenanceWindow struct {
+	opens    *CronSchedule
+	duration time.Duration
+}
+
+// maintenancePlan is when a migration may change the DIG: not before
+// notBefore, if set, and within one of the windows, if there are any.
+type maintenancePlan struct {
+	windows   []maintenanceWindow
+	location  *time.Location
+	notBefore time.Time
+}
+
+// newMaintenancePlan returns the maintenance plan of the workflow params.
+func newMaintenancePlan(inParams map[string]string) (*maintenancePlan, error) {
+	plan := &maintenancePlan{location: time.UTC}
+	if zone := strings.TrimSpace(inParams[MaintenanceTimeZoneParam]); zone != "" {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s param %q: %s", MaintenanceTimeZoneParam,
+				zone, err.Error())
+		}
+		plan.location = location
+	}
+	if param := strings.TrimSpace(inParams[NotBeforeParam]); param != "" {
+		notBefore, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s param %q: expect an RFC 3339 time, "+
+				"as 2022-06-04T02:00:00Z", NotBeforeParam, param)
+		}
+		plan.notBefore = notBefore
+	}
+	param := strings.TrimSpace(inParams[MaintenanceWindowsParam])
+	if param == "" {
+		return plan, nil
+	}
+	for _, spec := range strings.Split(param, ";") {
+		fields := strings.Fields(spec)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("Invalid %s param %q: expect a cron expression "+
+				"and a duration for each window, as \"0 2 * * SAT 4h\"",
+				MaintenanceWindowsParam, spec)
+		}
+		duration, err := time.ParseDuration(fields[5])
+		if err != nil || duration < time.Minute || duration > maxWindowDuration {
+			return nil, fmt.Errorf("Invalid %s param %q: expect a duration of "+
+				"1m to %s", MaintenanceWindowsParam, spec, maxWindowDuration)
+		}
+		opens, err := ParseCronSchedule(strings.Join(fields[:5], " "))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s param: %s", MaintenanceWindowsParam,
+				err.Error())
+		}
+		plan.windows = append(plan.windows, maintenanceWindow{opens, duration})
+	}
+	return plan, nil
+}
+
+// isSet reports whether the plan restricts when the migration may run.
+func (plan *maintenancePlan) isSet() bool {
+	return len(plan.windows) > 0 || !plan.notBefore.IsZero()
+}
+
+// nextWindow returns when the migration may next change the DIG, at or
+// after now, and when it must stop: the end of the window, or of the
+// windows that overlap it, or a zero time if there are no windows.
+func (plan *maintenancePlan) nextWindow(now time.Time) (opens, closes time.Time, err error) {
+	opens = now
+	if opens.Before(plan.notBefore) {
+		opens = plan.notBefore
+	}
+	if len(plan.windows) == 0 {
+		return opens, time.Time{}, nil
+	}
+	opens = opens.In(plan.location)
+	closes = plan.openUntil(opens)
+	if closes.IsZero() {
+		next := time.Time{}
+		for _, window := range plan.windows {
+			if t, ok := window.opens.Next(opens); ok && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+		if next.IsZero() {
+			return time.Time{}, time.Time{}, fmt.Errorf("No maintenance window "+
+				"opens within %s of %s", cronHorizon, opens.Format(time.RFC3339))
+		}
+		opens = next
+		closes = plan.openUntil(opens)
+	}
+	// Windows that open before the end of the one open follow it, up to
+	// maxWindowDuration, after which nextWindow is asked again.
+	for closes.Before(opens.Add(maxWindowDuration)) {
+		later := plan.openUntil(closes)
+		if !later.After(closes) {
+			break
+		}
+		closes = later
+	}
+	return opens.UTC(), closes.UTC(), nil
+}
+
+// openUntil returns the end of the windows open at t, or a zero time if
+// none is.
+func (plan *maintenancePlan) openUntil(t time.Time) time.Time {
+	t = t.In(plan.location)
+	closes := time.Time{}
+	for _, window := range plan.windows {
+		// The window is open if it opened at most its duration ago.
+		opened, ok := window.opens.Next(t.Add(-window.duration).Add(time.Second))
+		for ok && !opened.After(t) {
+			if end := opened.Add(window.duration); end.After(closes) {
+				closes = end
+			}
+			opened, ok = window.opens.Next(opened.Add(time.Minute))
+		}
+	}
+	return closes
+}
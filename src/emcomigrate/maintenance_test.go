@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMaintenancePlanInvalid(t *testing.T) {
+	tests := []struct {
+		param, value string
+		err          string
+	}{
+		{MaintenanceWindowsParam, "0 2 * * SAT", "expect a cron expression and a duration"},
+		{MaintenanceWindowsParam, "0 2 * * SAT 4h; 0 2 * * SUN 30s", "expect a duration"},
+		{MaintenanceWindowsParam, "0 2 * * SAT 8d", "expect a duration"},
+		{MaintenanceWindowsParam, "0 25 * * SAT 4h", `invalid value "25"`},
+		{MaintenanceTimeZoneParam, "Mars/Olympus", "unknown time zone"},
+		{NotBeforeParam, "2022-06-04 02:00", "expect an RFC 3339 time"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			_, err := newMaintenancePlan(map[string]string{tc.param: tc.value})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "Invalid "+tc.param+" param")
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	// a Saturday
+	now := time.Date(2022, 6, 4, 3, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2022, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name          string
+		params        map[string]string
+		opens, closes time.Time
+	}{
+		{"any time", map[string]string{}, now, time.Time{}},
+		{"not before", map[string]string{NotBeforeParam: "2022-06-04T08:00:00+02:00"},
+			at(4, 6, 0), time.Time{}},
+		{"not before past", map[string]string{NotBeforeParam: "2022-06-01T00:00:00Z"},
+			now, time.Time{}},
+		{"window open", map[string]string{MaintenanceWindowsParam: "0 2 * * SAT 4h"},
+			now, at(4, 6, 0)},
+		{"window closed", map[string]string{MaintenanceWindowsParam: "0 2 * * SAT 1h"},
+			at(11, 2, 0), at(11, 3, 0)},
+		{"next window", map[string]string{MaintenanceWindowsParam: "0 2 * * SAT 1h; 0 4 * * * 30m"},
+			at(4, 4, 0), at(4, 4, 30)},
+		{"overlapping windows", map[string]string{
+			MaintenanceWindowsParam: "0 2 * * SAT 2h; 30 2 * * * 1h; 0 3 * * * 2h"},
+			now, at(4, 5, 0)},
+		{"following windows", map[string]string{MaintenanceWindowsParam: "0 * * * * 1h"},
+			now, at(11, 3, 0)},
+		{"time zone", map[string]string{MaintenanceWindowsParam: "0 2 * * SAT 4h",
+			MaintenanceTimeZoneParam: "America/New_York"}, at(4, 6, 0), at(4, 10, 0)},
+		{"window after not before", map[string]string{
+			MaintenanceWindowsParam: "0 2 * * * 4h", NotBeforeParam: "2022-06-04T05:59:00Z"},
+			at(4, 5, 59), at(4, 6, 0)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, err := newMaintenancePlan(tc.params)
+			require.NoError(t, err)
+			assert.Equal(t, len(tc.params) > 0, plan.isSet())
+			opens, closes, err := plan.nextWindow(now)
+			require.NoError(t, err)
+			assert.True(t, tc.opens.Equal(opens), "opens %s, expect %s", opens, tc.opens)
+			assert.True(t, tc.closes.Equal(closes), "closes %s, expect %s", closes, tc.closes)
+		})
+	}
+}
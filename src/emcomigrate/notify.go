@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	wf "go.temporal.io/sdk/workflow"
+)
+
+// CloudEvents types emitted by EmcoMigrateWorkflow.
+const (
+	EventMigrationStarted       = "migration.started"
+	EventMigrationStepCompleted = "migration.step.completed"
+	EventMigrationWaveCompleted = "migration.wave.completed"
+	EventMigrationSucceeded     = "migration.succeeded"
+	EventMigrationFailed        = "migration.failed"
+	EventMigrationRolledBack    = "migration.rolledback"
+	EventMigrationReturning     = "migration.returning"
+	EventMigrationReturned      = "migration.returned"
+	EventMigrationCanceled      = "migration.canceled"
+)
+
+// Workflow param with a comma-separated list of URLs to POST events to.
+const NotifyURLsParam = "notifyURLs"
+
+// CloudEvent is a CloudEvents v1.0 event in structured JSON mode.
+type CloudEvent struct {
+	SpecVersion     string       `json:"specversion"`
+	ID              string       `json:"id"`
+	Source          string       `json:"source"`
+	Type            string       `json:"type"`
+	Subject         string       `json:"subject,omitempty"`
+	Time            time.Time    `json:"time"`
+	DataContentType string       `json:"datacontenttype"`
+	Sequence        int          `json:"sequence"`
+	Data            MigEventData `json:"data"`
+}
+
+// MigEventData identifies the migration that an event is about.
+type MigEventData struct {
+	WorkflowID          string   `json:"workflowID"`
+	RunID               string   `json:"runID"`
+	Project             string   `json:"project"`
+	CompositeApp        string   `json:"compositeApp"`
+	CompositeAppVersion string   `json:"compositeAppVersion"`
+	DIG                 string   `json:"deploymentIntentGroup"`
+	SourceClusters      []string `json:"sourceClusters,omitempty"`
+	TargetCluster       string   `json:"targetCluster"`
+	Strategy            string   `json:"strategy,omitempty"`
+	Step                string   `json:"step,omitempty"`
+	Error               string   `json:"error,omitempty"`
+	Result              string   `json:"result,omitempty"`
+	Wave                int      `json:"wave,omitempty"`
+}
+
+// SendNotification POSTs a CloudEvent to a callback URL. Any response other
+// than 2xx is returned as an error, so that Temporal retries the delivery.
+func SendNotification(ctx context.Context, url string, event CloudEvent) error {
+	defer startHeartbeat(ctx).stop()
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		encodeErr := fmt.Errorf("Error marshaling event %#v\n"+
+			"Marshal error; %#v\n", event, err)
+		fmt.Fprintf(os.Stderr, encodeErr.Error())
+		return temporal.NewNonRetryableApplicationError(encodeErr.Error(),
+			"EncodeError", encodeErr)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url,
+		bytes.NewBuffer(eventJSON))
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidURL", err)
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		postErr := fmt.Errorf("HTTP POST failed for URL %s.\nError: %s\n",
+			url, err)
+		fmt.Fprintf(os.Stderr, postErr.Error())
+		return postErr
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		postErr := fmt.Errorf("HTTP POST returned status code %s for URL %s.\n",
+			resp.Status, url)
+		fmt.Fprintf(os.Stderr, postErr.Error())
+		return postErr
+	}
+	return nil
+}
+
+// defaultNotificationOpts bound the retries of SendNotification, so that an
+// unreachable callback URL does not keep the workflow open forever.
+var defaultNotificationOpts = wf.ActivityOptions{
+	StartToCloseTimeout: 30 * time.Second,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:    5 * time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    5 * time.Minute,
+		MaximumAttempts:    20,
+	},
+}
+
+// notifier sends the events of one workflow run to all callback URLs.
+// Each delivery is a separate activity, so a failing URL does not cause
+// repeated deliveries to the others. Events are sent asynchronously and
+// wait must be called before the workflow returns.
+type notifier struct {
+	ctx     wf.Context
+	urls    []string
+	source  string
+	subject string
+	base    MigEventData
+	seq     int
+	pending []wf.Future
+}
+
+func newNotifier(ctx wf.Context, inParams map[string]string) *notifier {
+	info := wf.GetInfo(ctx)
+	return &notifier{
+		ctx:     ctx,
+		urls:    parseNotifyURLs(inParams[NotifyURLsParam]),
+		source:  "/emco/migrate-workflow/" + info.WorkflowExecution.ID,
+		subject: strings.TrimPrefix(buildDigURL(inParams), inParams["emcoURL"]),
+		base: MigEventData{
+			WorkflowID:          info.WorkflowExecution.ID,
+			RunID:               info.WorkflowExecution.RunID,
+			Project:             inParams["project"],
+			CompositeApp:        inParams["compositeApp"],
+			CompositeAppVersion: inParams["compositeAppVersion"],
+			DIG:                 inParams["deploymentIntentGroup"],
+			TargetCluster:       targetCluster(inParams),
+		},
+	}
+}
+
+func parseNotifyURLs(param string) []string {
+	urls := []string{}
+	for _, url := range strings.Split(param, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// emit sends an event of the given type for the given step and error.
+// The source clusters are taken from migParam if it is known by then.
+func (n *notifier) emit(eventType string, migParam *MigParam, step string, err error) {
+	if len(n.urls) == 0 {
+		return
+	}
+	n.seq++
+	data := n.base
+	data.Step = step
+	if migParam != nil {
+		data.SourceClusters = migParam.SourceClusters
+		data.Result = migParam.Result
+		data.Wave = migParam.Wave
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	event := CloudEvent{
+		SpecVersion: "1.0",
+		// Deterministic, so that receivers can drop duplicate deliveries.
+		ID:              fmt.Sprintf("%s/%s/%d", data.WorkflowID, data.RunID, n.seq),
+		Source:          n.source,
+		Type:            eventType,
+		Subject:         n.subject,
+		Time:            wf.Now(n.ctx),
+		DataContentType: "application/json",
+		Sequence:        n.seq,
+		Data:            data,
+	}
+	for _, url := range n.urls {
+		n.pending = append(n.pending,
+			wf.ExecuteActivity(n.ctx, SendNotification, url, event))
+	}
+}
+
+// wait blocks till all events sent so far are delivered or have failed.
+// Delivery failures are logged but do not fail the migration.
+func (n *notifier) wait() {
+	for _, future := range n.pending {
+		if err := future.Get(n.ctx, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "SendNotification failed: %s\n", err)
+		}
+	}
+	n.pending = nil
+}
@@ -0,0 +1,342 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// The intent types of a DIG that the migration knows, as named in the
+// DIG's "intents", with the collections that hold them.
+var intentTypeCollections = map[string]string{
+	"genericPlacementIntent": "generic-placement-intents",
+	"gac":                    "generic-k8s-intents",
+	"ovnaction":              "network-controller-intent",
+	"dtc":                    "traffic-group-intents",
+}
+
+// IntentReport tells how the migration transformed an intent resource of
+// the DIG other than an app intent, or why it could not. Resources with
+// neither are not reported.
+type IntentReport struct {
+	// path of the resource under the DIG, such as
+	// generic-k8s-intents/gki1/resources/r1/customizations/c1
+	Intent string
+	// cluster references rewritten to the target cluster
+	Changes []string `json:",omitempty"`
+	// what may not work on the target cluster, and was left as it is
+	Problems []string `json:",omitempty"`
+}
+
+// clusterMap maps the clusters that a migration moves apps off to the
+// target cluster.
+type clusterMap struct {
+	from   map[string]bool // as provider+cluster
+	names  map[string]*regexp.Regexp
+	target AllOf
+}
+
+// newClusterMap returns the cluster map of a migration with the given
+// intent rule, from the given source clusters. With IntentRewriteAddTarget
+// the apps stay on the source clusters, so no cluster is mapped.
+func newClusterMap(rule intentRule, sourceClusters []string) clusterMap {
+	m := clusterMap{
+		from:   map[string]bool{},
+		names:  map[string]*regexp.Regexp{},
+		target: rule.target,
+	}
+	add := func(provider, cluster string) {
+		if provider == rule.target.ProviderName && cluster == rule.target.ClusterName {
+			return
+		}
+		m.from[provider+"+"+cluster] = true
+		m.names[cluster] = regexp.MustCompile(`(^|[^\w-])` + regexp.QuoteMeta(cluster) +
+			`($|[^\w-])`)
+	}
+	switch rule.mode {
+	case IntentRewriteReplaceCluster:
+		add(rule.source.ProviderName, rule.source.ClusterName)
+	case IntentRewriteReplacePlacement:
+		for _, sourceCluster := range sourceClusters {
+			if parts := strings.Split(sourceCluster, "+"); len(parts) == 2 {
+				add(parts[0], parts[1])
+			}
+		}
+	}
+	return m
+}
+
+// rewriteIntent rewrites the references to the mapped clusters in an
+// intent resource of the given collection, as given to forEachIntent, and
+// reports what it changed, and what it found that may not work on the
+// target cluster. A reference is an object with clusterProvider and cluster
+// fields, such as the clusterInfo of a generic K8s customization, unless it
+// has the "deny" mode: what was denied on the source cluster need not be
+// denied on the target. Other mentions of the mapped clusters are reported.
+// The networks of network interfaces are looked up on the target cluster.
+func (m clusterMap) rewriteIntent(params map[string]string, collection string,
+	resource map[string]interface{}) (IntentReport, error) {
+
+	var entry IntentReport
+	for key, value := range resource {
+		if key != "metadata" {
+			resource[key] = m.rewriteValue(value, key, &entry)
+		}
+	}
+	if path.Base(collection) == "interfaces" {
+		problems, err := m.checkInterface(params, resource)
+		if err != nil {
+			return IntentReport{}, err
+		}
+		entry.Problems = append(entry.Problems, problems...)
+	}
+	sort.Strings(entry.Changes)
+	sort.Strings(entry.Problems)
+	return entry, nil
+}
+
+// rewriteValue is rewriteIntent for a JSON value at the given field path.
+func (m clusterMap) rewriteValue(value interface{}, field string,
+	entry *IntentReport) interface{} {
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		provider, _ := value["clusterProvider"].(string)
+		cluster, hasCluster := value["cluster"].(string)
+		isRef := provider != "" && hasCluster && cluster != ""
+		if isRef && m.from[provider+"+"+cluster] && value["mode"] != "deny" {
+			value["clusterProvider"] = m.target.ProviderName
+			value["cluster"] = m.target.ClusterName
+			entry.Changes = append(entry.Changes, fmt.Sprintf("%s: %s+%s -> %s+%s",
+				field, provider, cluster, m.target.ProviderName, m.target.ClusterName))
+		}
+		for key, item := range value {
+			if isRef && (key == "clusterProvider" || key == "cluster") {
+				continue
+			}
+			value[key] = m.rewriteValue(item, field+"."+key, entry)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = m.rewriteValue(item, fmt.Sprintf("%s[%d]", field, i), entry)
+		}
+	case string:
+		for cluster, re := range m.names {
+			if re.MatchString(value) {
+				entry.Problems = append(entry.Problems,
+					fmt.Sprintf("%s mentions cluster %s", field, cluster))
+			}
+		}
+	}
+	return value
+}
+
+// checkInterface reports what may not work on the target cluster in a
+// network interface of an ovnaction workload intent: a network that the
+// target cluster lacks, and a static IP address.
+func (m clusterMap) checkInterface(params map[string]string,
+	resource map[string]interface{}) ([]string, error) {
+
+	spec, _ := resource["spec"].(map[string]interface{})
+	problems := []string{}
+	if ip, _ := spec["ipAddress"].(string); ip != "" {
+		problems = append(problems, "spec.ipAddress "+ip+" is static")
+	}
+	network, _ := spec["name"].(string)
+	if network == "" {
+		return problems, nil
+	}
+	clusterURL := params["emcoURL"] + "/v2/cluster-providers/" +
+		m.target.ProviderName + "/clusters/" + m.target.ClusterName
+	for _, networks := range []string{"networks", "provider-networks"} {
+		_, err := getHttpRespBody(clusterURL + "/" + networks + "/" + network)
+		if err == nil {
+			return problems, nil
+		}
+		if !isNotFound(err) {
+			return nil, err
+		}
+	}
+	return append(problems, fmt.Sprintf("network %s is not defined on %s+%s",
+		network, m.target.ProviderName, m.target.ClusterName)), nil
+}
+
+// checkIntentTypes reports the intent types in the "intents" of the DIG at
+// the given URL that the migration does not know, which are left as they
+// are: "copied" by CloneDIG, or "unchanged" by UpdateOtherIntents.
+func checkIntentTypes(digURL, leftAs string) ([]IntentReport, error) {
+	var digIntents []struct {
+		Metadata MetaData `json:"metadata"`
+		Spec     struct {
+			Intent map[string]interface{} `json:"intent"`
+		} `json:"spec"`
+	}
+	if err := getJSON(digURL+"/intents", &digIntents); err != nil {
+		if isNotFound(err) {
+			return []IntentReport{}, nil
+		}
+		return nil, err
+	}
+	report := []IntentReport{}
+	for _, digIntent := range digIntents {
+		entry := IntentReport{Intent: "intents/" + digIntent.Metadata.Name}
+		for intentType, name := range digIntent.Spec.Intent {
+			if _, ok := intentTypeCollections[intentType]; !ok {
+				entry.Problems = append(entry.Problems, fmt.Sprintf(
+					"intent type %s (%v) is unknown, %s", intentType, name, leftAs))
+			}
+		}
+		if entry.Problems != nil {
+			sort.Strings(entry.Problems)
+			report = append(report, entry)
+		}
+	}
+	return report, nil
+}
+
+// otherIntentCollections returns the intent collections of a DIG other
+// than its placement intents and its "intents".
+func otherIntentCollections() []string {
+	collections := []string{}
+	for _, collection := range digIntentCollections {
+		if collection != "generic-placement-intents" && collection != "intents" {
+			collections = append(collections, collection)
+		}
+	}
+	return collections
+}
+
+// logIntentReport prints the report of an activity.
+func logIntentReport(activityName string, report []IntentReport) {
+	for _, entry := range report {
+		for _, change := range entry.Changes {
+			fmt.Printf("%s: %s: %s\n", activityName, entry.Intent, change)
+		}
+		for _, problem := range entry.Problems {
+			fmt.Printf("%s: %s: not transformed: %s\n", activityName, entry.Intent, problem)
+		}
+	}
+}
+
+// otherIntentProgress is the progress of UpdateOtherIntents, recorded in its
+// heartbeats.
+type otherIntentProgress struct {
+	// the resources before the migration, by path under the DIG
+	Sources map[string]json.RawMessage
+	// the changes to them, for the report
+	Changes map[string][]string
+}
+
+// copy returns a copy that does not change when p is changed.
+func (p otherIntentProgress) copy() otherIntentProgress {
+	c := otherIntentProgress{
+		Sources: make(map[string]json.RawMessage, len(p.Sources)),
+		Changes: make(map[string][]string, len(p.Changes)),
+	}
+	for key, source := range p.Sources {
+		c.Sources[key] = source
+	}
+	for key, changes := range p.Changes {
+		c.Changes[key] = changes
+	}
+	return c
+}
+
+// UpdateOtherIntents rewrites the references to the source clusters in the
+// intents of the DIG of a migration other than its placement intents: the
+// generic K8s intents, and the ovnaction and DTC network intents. It
+// reports what it changed, and what it could not transform, in
+// migParam.IntentReport. The resources are recorded before they are
+// changed in migParam.OtherIntentSources, for RevertOtherIntents. Like
+// UpdateAppIntents, it can be retried: rewritten resources are not changed
+// again.
+func UpdateOtherIntents(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	hb := startHeartbeat(ctx)
+	defer hb.stop()
+
+	params := migParam.InParams
+	rule, err := newIntentRule(params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "UpdateOtherIntents: %s\n", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidParam", nil)
+	}
+	clusters := newClusterMap(rule, migParam.SourceClusters)
+	digURL := buildDigURL(params)
+
+	// An earlier attempt may have changed some resources already.
+	progress := otherIntentProgress{
+		Sources: map[string]json.RawMessage{},
+		Changes: map[string][]string{},
+	}
+	if activity.HasHeartbeatDetails(ctx) {
+		var recorded otherIntentProgress
+		if err := activity.GetHeartbeatDetails(ctx, &recorded); err == nil &&
+			recorded.Sources != nil && recorded.Changes != nil {
+			progress = recorded
+		}
+	}
+
+	report, err := checkIntentTypes(digURL, "unchanged")
+	if err != nil {
+		return nil, err
+	}
+	for _, collection := range otherIntentCollections() {
+		err := forEachIntent(ctx, digURL, collection, func(collection string,
+			resource map[string]interface{}) error {
+
+			key := collection + "/" + resourceName(resource)
+			source, err := json.Marshal(resource)
+			if err != nil {
+				return err
+			}
+			entry, err := clusters.rewriteIntent(params, collection, resource)
+			if err != nil {
+				return err
+			}
+			changed := entry.Changes != nil
+			if !changed {
+				entry.Changes = progress.Changes[key]
+			}
+			if entry.Changes == nil && entry.Problems == nil {
+				return nil
+			}
+			entry.Intent = key
+			report = append(report, entry)
+			if !changed {
+				return nil
+			}
+			// Recorded first, since the PUT may take effect and still fail.
+			if _, ok := progress.Sources[key]; !ok {
+				progress.Sources[key] = source
+				progress.Changes[key] = entry.Changes
+				hb.record(progress.copy())
+			}
+			_, err = sendToEmco(http.MethodPut, digURL+"/"+key, resource, http.StatusOK)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	logIntentReport("UpdateOtherIntents", report)
+
+	if len(progress.Sources) > 0 {
+		migParam.OtherIntentSources = progress.Sources
+		migParam.AlreadyOnTarget = false // the DIG must be updated
+	}
+	migParam.IntentReport = report
+	return &migParam, nil
+}
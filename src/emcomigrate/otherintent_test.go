@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGkiPath            = testDigPath + "/generic-k8s-intents"
+	testCustomizationsPath = testGkiPath + "/gki1/resources/collectd-config/customizations"
+	testCustomizationPath  = testCustomizationsPath + "/cluster1-endpoint"
+	testNetctlPath         = testDigPath + "/network-controller-intent"
+	testWorkloadsPath      = testNetctlPath + "/netctl1/workload-intents"
+	testInterfacesPath     = testWorkloadsPath + "/collectd-workload/interfaces"
+	testTargetClusterPath  = "/v2/cluster-providers/provider2/clusters/cluster2"
+)
+
+// otherIntentResponses are the EMCO responses for the generic K8s and
+// ovnaction intents of dig1, whose DIG intents name an unknown intent type.
+func otherIntentResponses() map[string]recordedResponse {
+	return map[string]recordedResponse{
+		"GET " + testDigPath + "/intents":                          {http.StatusOK, "dig-intents-sfc.json"},
+		"GET " + testGkiPath:                                       {http.StatusOK, "generic-k8s-intents.json"},
+		"GET " + testGkiPath + "/gki1/resources":                   {http.StatusOK, "k8s-resources.json"},
+		"GET " + testCustomizationsPath:                            {http.StatusOK, "customizations.json"},
+		"PUT " + testCustomizationPath:                             {http.StatusOK, ""},
+		"GET " + testNetctlPath:                                    {http.StatusOK, "network-controller-intents.json"},
+		"GET " + testWorkloadsPath:                                 {http.StatusOK, "workload-intents.json"},
+		"GET " + testInterfacesPath:                                {http.StatusOK, "interfaces.json"},
+		"GET " + testTargetClusterPath + "/networks/protected-net": {http.StatusOK, "interfaces.json"},
+	}
+}
+
+// testIntentReport is the report of rewriting the intents of
+// otherIntentResponses from provider1+cluster1.
+var testIntentReport = []IntentReport{
+	{
+		Intent:   "intents/dig1-intent",
+		Problems: []string{"intent type sfc (sfc-intent1) is unknown, unchanged"},
+	},
+	{
+		Intent:   "generic-k8s-intents/gki1/resources/collectd-config/customizations/cluster1-endpoint",
+		Changes:  []string{"spec.clusterInfo: provider1+cluster1 -> provider2+cluster2"},
+		Problems: []string{"spec.patchJson[0].value mentions cluster cluster1"},
+	},
+	{
+		Intent:   "network-controller-intent/netctl1/workload-intents/collectd-workload/interfaces/eth1",
+		Problems: []string{"spec.ipAddress 192.168.20.3 is static"},
+	},
+}
+
+func readResource(t *testing.T, file string, i int) map[string]interface{} {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "emco", file))
+	require.NoError(t, err)
+	var resources []map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &resources))
+	return resources[i]
+}
+
+func TestRewriteIntent(t *testing.T) {
+	tests := []struct {
+		name          string
+		rewrite       string
+		customization int
+		changes       []string
+		problems      []string
+	}{
+		{
+			name:          "allowed on source",
+			customization: 0,
+			changes:       []string{"spec.clusterInfo: provider1+cluster1 -> provider2+cluster2"},
+			problems:      []string{"spec.patchJson[0].value mentions cluster cluster1"},
+		},
+		{name: "denied on source", customization: 1},
+		{name: "by label", customization: 2},
+		{name: "add target", rewrite: IntentRewriteAddTarget, customization: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			params := testInParams()
+			params[IntentRewriteParam] = tc.rewrite
+			rule, err := newIntentRule(params)
+			require.NoError(t, err)
+			clusters := newClusterMap(rule, []string{"provider1+cluster1", "provider2+cluster2"})
+			resource := readResource(t, "customizations.json", tc.customization)
+			metadata := resource["metadata"]
+
+			entry, err := clusters.rewriteIntent(params,
+				"generic-k8s-intents/gki1/resources/r1/customizations", resource)
+			require.NoError(t, err)
+			assert.Equal(t, tc.changes, entry.Changes)
+			assert.Equal(t, tc.problems, entry.Problems)
+			clusterInfo := resource["spec"].(map[string]interface{})["clusterInfo"].(map[string]interface{})
+			if tc.changes != nil {
+				assert.Equal(t, "provider2", clusterInfo["clusterProvider"])
+				assert.Equal(t, "cluster2", clusterInfo["cluster"])
+			} else {
+				assert.Equal(t, "provider1", clusterInfo["clusterProvider"])
+			}
+			assert.Equal(t, metadata, resource["metadata"])
+		})
+	}
+}
+
+func TestRewriteIntentMissingNetwork(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+	params := emco.inParams()
+	rule, err := newIntentRule(params)
+	require.NoError(t, err)
+	clusters := newClusterMap(rule, []string{"provider1+cluster1"})
+
+	entry, err := clusters.rewriteIntent(params, "network-controller-intent/n1/"+
+		"workload-intents/w1/interfaces", readResource(t, "interfaces.json", 0))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"network protected-net is not defined on provider2+cluster2",
+		"spec.ipAddress 192.168.20.3 is static"}, entry.Problems)
+	assert.Equal(t, []string{
+		testTargetClusterPath + "/networks/protected-net",
+		testTargetClusterPath + "/provider-networks/protected-net",
+	}, paths(emco.requestsFor(http.MethodGet)))
+}
+
+func TestUpdateOtherIntents(t *testing.T) {
+	emco := newRecordedEmco(t, otherIntentResponses())
+	migParam := MigParam{
+		InParams:        emco.inParams(),
+		SourceClusters:  []string{"provider1+cluster1"},
+		AlreadyOnTarget: true,
+	}
+
+	result, err := runActivity(t, UpdateOtherIntents, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, testIntentReport, result.IntentReport)
+	assert.False(t, result.AlreadyOnTarget)
+
+	puts := emco.requestsFor(http.MethodPut)
+	require.Equal(t, []string{testCustomizationPath}, paths(puts))
+	var put map[string]interface{}
+	require.NoError(t, json.Unmarshal(puts[0].body, &put))
+	clusterInfo := put["spec"].(map[string]interface{})["clusterInfo"]
+	assert.Equal(t, "cluster2", clusterInfo.(map[string]interface{})["cluster"])
+
+	// The source is recorded as it was.
+	key := "generic-k8s-intents/gki1/resources/collectd-config/customizations/cluster1-endpoint"
+	require.Contains(t, result.OtherIntentSources, key)
+	var source map[string]interface{}
+	require.NoError(t, json.Unmarshal(result.OtherIntentSources[key], &source))
+	assert.Equal(t, readResource(t, "customizations.json", 0), source)
+}
+
+func TestUpdateOtherIntentsNothingToChange(t *testing.T) {
+	emco := newRecordedEmco(t, otherIntentResponses())
+	migParam := MigParam{
+		InParams:        emco.inParams(),
+		SourceClusters:  []string{"provider3+cluster9"},
+		AlreadyOnTarget: true,
+	}
+
+	result, err := runActivity(t, UpdateOtherIntents, migParam)
+	require.NoError(t, err)
+	assert.Empty(t, emco.requestsFor(http.MethodPut))
+	assert.Nil(t, result.OtherIntentSources)
+	assert.True(t, result.AlreadyOnTarget)
+}
+
+func TestRevertOtherIntents(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"PUT " + testCustomizationPath: {http.StatusOK, ""},
+	})
+	key := "generic-k8s-intents/gki1/resources/collectd-config/customizations/cluster1-endpoint"
+	source := json.RawMessage(`{"metadata":{"name":"cluster1-endpoint"}}`)
+	migParam := MigParam{
+		InParams: emco.inParams(),
+		OtherIntentSources: map[string]json.RawMessage{
+			key:                             source,
+			"generic-k8s-intents/gki1/gone": source,
+		},
+	}
+
+	_, err := runActivity(t, RevertOtherIntents, migParam)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to revert 1 intents")
+	assert.Contains(t, err.Error(), "generic-k8s-intents/gki1/gone: ")
+	puts := emco.requestsFor(http.MethodPut)
+	require.Len(t, puts, 2)
+	assert.JSONEq(t, string(source), string(puts[1].body))
+}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Types of the non-retryable errors of PreflightCheck
+const (
+	PreflightClusterNotFound   = "ClusterNotFound"
+	PreflightNotInLogicalCloud = "NotInLogicalCloud"
+	PreflightDigNotFound       = "DigNotFound"
+	PreflightDigNotReady       = "DigNotInstantiated"
+	PreflightSameCluster       = "SameCluster"
+)
+
+// Workflow param that makes PreflightCheck fail with PreflightSameCluster
+// if the apps are deployed on the target cluster already. By default they
+// pass, and PreflightCheck sets MigParam.PreflightOnTarget, so that a
+// migration that is run again ends with ResultAlreadyOnTarget.
+const FailIfOnTargetParam = "failIfOnTarget"
+
+// The DIG state in which its apps can be migrated
+const digStateInstantiated = "Instantiated"
+
+// PreflightCheck checks that the migration can be done, before any app
+// intent is changed. The cluster that the replace-cluster mode replaces
+// must not be the target cluster, the target cluster must exist in CLM and
+// belong to the logical cloud of the DIG in DCM, unless the
+// AddToLogicalCloudParam param adds it afterwards, and the DIG must be
+// instantiated. Apps that are deployed on the target cluster already, as
+// the intent rewrite mode places them, fail only if the FailIfOnTargetParam
+// param is true. If a check fails, it returns a non-retryable error; if EMCO cannot be
+// reached, a retryable one.
+func PreflightCheck(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	params := migParam.InParams
+	provider := params["targetClusterProvider"]
+	cluster := params["targetClusterName"]
+	rule, err := newIntentRule(params)
+	var failIfOnTarget, addToLogicalCloud bool
+	if err == nil {
+		failIfOnTarget, err = boolParam(params, FailIfOnTargetParam)
+	}
+	if err == nil {
+		addToLogicalCloud, err = boolParam(params, AddToLogicalCloudParam)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PreflightCheck: %s\n", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(),
+			"InvalidParam", nil)
+	}
+
+	// The replaced cluster is not the target cluster
+	if params[IntentRewriteParam] == IntentRewriteReplaceCluster &&
+		params[ReplaceClusterParam] == provider+"+"+cluster {
+		return nil, preflightError(PreflightSameCluster,
+			"Cluster %s+%s cannot be replaced by itself", provider, cluster)
+	}
+
+	// CLM: the target cluster exists
+	providerURL := params["emcoURL"] + "/v2/cluster-providers/" + provider
+	if _, err := getHttpRespBody(providerURL); err != nil {
+		if isNotFound(err) {
+			return nil, preflightError(PreflightClusterNotFound,
+				"Cluster provider %s does not exist", provider)
+		}
+		return nil, err
+	}
+	if _, err := getHttpRespBody(providerURL + "/clusters/" + cluster); err != nil {
+		if isNotFound(err) {
+			return nil, preflightError(PreflightClusterNotFound,
+				"Cluster %s of provider %s does not exist", cluster, provider)
+		}
+		return nil, err
+	}
+
+	// DCM: the target cluster is in the logical cloud of the DIG, or is
+	// added to it after these checks
+	logicalCloud, err := digLogicalCloud(params)
+	if err != nil {
+		return nil, err
+	}
+	inCloud := addToLogicalCloud
+	if !inCloud {
+		inCloud, err = isInLogicalCloud(params, logicalCloud, provider, cluster)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !inCloud {
+		return nil, preflightError(PreflightNotInLogicalCloud,
+			"Cluster %s+%s is not in logical cloud %s of DIG %s",
+			provider, cluster, logicalCloud, digKey(params))
+	}
+
+	// The DIG is deployed, and maybe on the target cluster already
+	status, err := getDigStatus(params)
+	if err != nil {
+		return nil, err
+	}
+	if state := status.state(); state != digStateInstantiated {
+		return nil, preflightError(PreflightDigNotReady,
+			"DIG %s is %s, not %s", digKey(params), state, digStateInstantiated)
+	}
+	migParam.PreflightOnTarget = rule.isDeployed(status)
+	if migParam.PreflightOnTarget {
+		if failIfOnTarget {
+			return nil, preflightError(PreflightSameCluster,
+				"The apps of DIG %s are already deployed on cluster %s+%s",
+				digKey(params), provider, cluster)
+		}
+		fmt.Fprintf(os.Stderr, "PreflightCheck: the apps of DIG %s are already "+
+			"deployed on cluster %s+%s\n", digKey(params), provider, cluster)
+	}
+
+	return &migParam, nil
+}
+
+// preflightError returns a non-retryable error of the given type, and
+// prints it.
+func preflightError(errType string, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(os.Stderr, "PreflightCheck: %s\n", msg)
+	return temporal.NewNonRetryableApplicationError(msg, errType, nil)
+}
+
+// digLogicalCloud returns the logical cloud of the DIG of a migration. Older
+// EMCO releases keep it in the generic placement intents instead of the DIG.
+func digLogicalCloud(params map[string]string) (string, error) {
+	type resource struct {
+		Spec struct {
+			LogicalCloud string `json:"logicalCloud"`
+		} `json:"spec"`
+	}
+
+	digURL := buildDigURL(params)
+	respBody, err := getHttpRespBody(digURL)
+	if err != nil {
+		if isNotFound(err) {
+			return "", preflightError(PreflightDigNotFound,
+				"DIG %s does not exist", digKey(params))
+		}
+		return "", err
+	}
+	var dig resource
+	if err := json.Unmarshal(respBody, &dig); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET response body for URL %s.\n"+
+			"Decoder error: %#v\n", digURL, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return "", decodeErr
+	}
+	if dig.Spec.LogicalCloud != "" {
+		return dig.Spec.LogicalCloud, nil
+	}
+
+	gpiURL := buildGenericPlacementIntentsURL(params)
+	respBody, err = getHttpRespBody(gpiURL)
+	if err != nil {
+		return "", err
+	}
+	var gpIntents []resource
+	if err := json.Unmarshal(respBody, &gpIntents); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET response body for URL %s.\n"+
+			"Decoder error: %#v\n", gpiURL, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return "", decodeErr
+	}
+	for _, gpIntent := range gpIntents {
+		if gpIntent.Spec.LogicalCloud != "" {
+			return gpIntent.Spec.LogicalCloud, nil
+		}
+	}
+	return "", preflightError(PreflightNotInLogicalCloud,
+		"DIG %s has no logical cloud", digKey(params))
+}
+
+// isInLogicalCloud reports whether the given cluster is referenced by the
+// given logical cloud of the project of a migration.
+func isInLogicalCloud(params map[string]string, logicalCloud, provider, cluster string) (bool, error) {
+	refsURL := logicalCloudURL(params, logicalCloud) + "/cluster-references"
+	respBody, err := getHttpRespBody(refsURL)
+	if err != nil {
+		if isNotFound(err) {
+			return false, preflightError(PreflightNotInLogicalCloud,
+				"Logical cloud %s of DIG %s does not exist", logicalCloud, digKey(params))
+		}
+		return false, err
+	}
+	var refs []struct {
+		Spec struct {
+			ProviderName string `json:"clusterProvider"`
+			ClusterName  string `json:"cluster"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(respBody, &refs); err != nil {
+		decodeErr := fmt.Errorf("Failed to decode GET response body for URL %s.\n"+
+			"Decoder error: %#v\n", refsURL, err)
+		fmt.Fprintf(os.Stderr, decodeErr.Error())
+		return false, decodeErr
+	}
+	for _, ref := range refs {
+		if ref.Spec.ProviderName == provider && ref.Spec.ClusterName == cluster {
+			return true, nil
+		}
+	}
+	return false, nil
+}
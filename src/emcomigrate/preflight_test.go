@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+)
+
+const (
+	testProviderPath = "/v2/cluster-providers/provider2"
+	testClusterPath  = testProviderPath + "/clusters/cluster2"
+	testCloudRefPath = "/v2/projects/proj1/logical-clouds/default/cluster-references"
+)
+
+// preflightResponses are the EMCO responses for a migration that passes
+// the pre-flight checks.
+func preflightResponses() map[string]recordedResponse {
+	return map[string]recordedResponse{
+		"GET " + testProviderPath:        {http.StatusOK, ""},
+		"GET " + testClusterPath:         {http.StatusOK, ""},
+		"GET " + testDigPath:             {http.StatusOK, "dig.json"},
+		"GET " + testCloudRefPath:        {http.StatusOK, "cluster-references.json"},
+		"GET " + testDigPath + "/status": {http.StatusOK, "dig-status.json"},
+	}
+}
+
+func TestPreflightCheck(t *testing.T) {
+	emco := newRecordedEmco(t, preflightResponses())
+	migParam := MigParam{InParams: emco.inParams()}
+
+	result, err := runActivity(t, PreflightCheck, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
+
+func TestPreflightCheckOnTarget(t *testing.T) {
+	// A migration that is run again passes, to report that it is on target.
+	responses := preflightResponses()
+	responses["GET "+testDigPath+"/status"] = recordedResponse{http.StatusOK,
+		"dig-status-on-target.json"}
+	emco := newRecordedEmco(t, responses)
+	migParam := MigParam{InParams: emco.inParams()}
+
+	result, err := runActivity(t, PreflightCheck, migParam)
+	require.NoError(t, err)
+	migParam.PreflightOnTarget = true
+	assert.Equal(t, migParam, *result)
+}
+
+func TestPreflightCheckAddToLogicalCloud(t *testing.T) {
+	// The target cluster is added to the logical cloud after the checks.
+	responses := preflightResponses()
+	responses["GET "+testCloudRefPath] = recordedResponse{http.StatusOK,
+		"cluster-references-provider1.json"}
+	emco := newRecordedEmco(t, responses)
+	migParam := MigParam{InParams: emco.inParams()}
+	migParam.InParams[AddToLogicalCloudParam] = "true"
+
+	result, err := runActivity(t, PreflightCheck, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
+
+func TestPreflightCheckFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		params   map[string]string
+		change   map[string]recordedResponse
+		errType  string
+		contains string
+	}{
+		{
+			name: "cluster replaced by itself",
+			params: map[string]string{
+				IntentRewriteParam:  IntentRewriteReplaceCluster,
+				ReplaceClusterParam: "provider2+cluster2",
+			},
+			errType:  PreflightSameCluster,
+			contains: "Cluster provider2+cluster2 cannot be replaced by itself",
+		},
+		{
+			name:     "no provider",
+			change:   map[string]recordedResponse{"GET " + testProviderPath: {http.StatusNotFound, ""}},
+			errType:  PreflightClusterNotFound,
+			contains: "Cluster provider provider2 does not exist",
+		},
+		{
+			name:     "no cluster",
+			change:   map[string]recordedResponse{"GET " + testClusterPath: {http.StatusNotFound, ""}},
+			errType:  PreflightClusterNotFound,
+			contains: "Cluster cluster2 of provider provider2 does not exist",
+		},
+		{
+			name:     "no DIG",
+			change:   map[string]recordedResponse{"GET " + testDigPath: {http.StatusNotFound, ""}},
+			errType:  PreflightDigNotFound,
+			contains: "DIG proj1/capp1/v1/dig1 does not exist",
+		},
+		{
+			name: "not in logical cloud",
+			change: map[string]recordedResponse{
+				"GET " + testCloudRefPath: {http.StatusOK, "cluster-references-provider1.json"}},
+			errType:  PreflightNotInLogicalCloud,
+			contains: "Cluster provider2+cluster2 is not in logical cloud default",
+		},
+		{
+			name: "DIG not instantiated",
+			change: map[string]recordedResponse{
+				"GET " + testDigPath + "/status": {http.StatusOK, "dig-status-approved.json"}},
+			errType:  PreflightDigNotReady,
+			contains: "DIG proj1/capp1/v1/dig1 is Approved, not Instantiated",
+		},
+		{
+			name:   "already on target",
+			params: map[string]string{FailIfOnTargetParam: "true"},
+			change: map[string]recordedResponse{
+				"GET " + testDigPath + "/status": {http.StatusOK, "dig-status-on-target.json"}},
+			errType:  PreflightSameCluster,
+			contains: "already deployed on cluster provider2+cluster2",
+		},
+		{
+			name:     "invalid param",
+			params:   map[string]string{FailIfOnTargetParam: "yes"},
+			errType:  "InvalidParam",
+			contains: `Invalid failIfOnTarget param "yes"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			responses := preflightResponses()
+			for key, resp := range tc.change {
+				responses[key] = resp
+			}
+			emco := newRecordedEmco(t, responses)
+			inParams := emco.inParams()
+			for key, value := range tc.params {
+				inParams[key] = value
+			}
+
+			_, err := runActivity(t, PreflightCheck, MigParam{InParams: inParams})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.contains)
+			var appErr *temporal.ApplicationError
+			require.True(t, errors.As(err, &appErr))
+			assert.Equal(t, tc.errType, appErr.Type())
+			assert.True(t, appErr.NonRetryable())
+		})
+	}
+}
+
+func TestPreflightCheckEmcoDown(t *testing.T) {
+	responses := preflightResponses()
+	responses["GET "+testCloudRefPath] = recordedResponse{http.StatusServiceUnavailable, ""}
+	emco := newRecordedEmco(t, responses)
+
+	_, err := runActivity(t, PreflightCheck, MigParam{InParams: emco.inParams()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503 Service Unavailable")
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		assert.False(t, appErr.NonRetryable())
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/sdk/worker"
+)
+
+// historiesDir has the event histories of past EmcoMigrateWorkflow runs, as
+// exported with "migrate_workflowclient history -w <id> -o <file>".
+var historiesDir = filepath.Join("testdata", "histories")
+
+// TestReplayHistories replays the recorded histories with the current
+// workflow code. It fails if the code would make different decisions than
+// the code that recorded them, which would break running workflows when the
+// worker is upgraded. Such changes must be made with workflow.GetVersion.
+func TestReplayHistories(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(historiesDir, "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files, "no histories in %s", historiesDir)
+
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflow(EmcoMigrateWorkflow)
+
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			history, err := readHistory(file)
+			require.NoError(t, err)
+			for _, prefix := range historyPrefixes(history) {
+				require.NoError(t, replayer.ReplayWorkflowHistory(nil, prefix),
+					"replaying events 1-%d", len(prefix.Events))
+			}
+		})
+	}
+}
+
+func readHistory(filename string) (*historypb.History, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var history historypb.History
+	if err := jsonpb.Unmarshal(f, &history); err != nil {
+		return nil, fmt.Errorf("Failed to parse history %s: %s", filename, err)
+	}
+	return &history, nil
+}
+
+// commandEvents are the types of the events that record the commands of
+// the workflow code, other than closing the workflow.
+var commandEvents = map[enumspb.EventType]bool{
+	enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED:                              true,
+	enumspb.EVENT_TYPE_ACTIVITY_TASK_CANCEL_REQUESTED:                       true,
+	enumspb.EVENT_TYPE_TIMER_STARTED:                                        true,
+	enumspb.EVENT_TYPE_TIMER_CANCELED:                                       true,
+	enumspb.EVENT_TYPE_MARKER_RECORDED:                                      true,
+	enumspb.EVENT_TYPE_UPSERT_WORKFLOW_SEARCH_ATTRIBUTES:                    true,
+	enumspb.EVENT_TYPE_START_CHILD_WORKFLOW_EXECUTION_INITIATED:             true,
+	enumspb.EVENT_TYPE_SIGNAL_EXTERNAL_WORKFLOW_EXECUTION_INITIATED:         true,
+	enumspb.EVENT_TYPE_REQUEST_CANCEL_EXTERNAL_WORKFLOW_EXECUTION_INITIATED: true,
+}
+
+// historyPrefixes returns the history up to the commands of each completed
+// workflow task, and the whole history. The replayer checks the commands
+// of the workflow code against the history only if the workflow has not
+// closed by the end of the replay, so replaying the whole history alone
+// would only catch changes of the workflow result.
+func historyPrefixes(history *historypb.History) []*historypb.History {
+	prefixes := []*historypb.History{}
+	events := history.Events
+	for i, event := range events {
+		if event.GetEventType() != enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED {
+			continue
+		}
+		end := i + 1
+		for end < len(events) && commandEvents[events[end].GetEventType()] {
+			end++
+		}
+		if end > i+1 && end < len(events) {
+			// The replayer caches workflows by run ID, so each prefix is
+			// replayed with a new random run ID.
+			prefix := proto.Clone(history).(*historypb.History)
+			prefix.Events = prefix.Events[:end]
+			prefix.Events[0].GetWorkflowExecutionStartedEventAttributes().OriginalExecutionRunId = ""
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return append(prefixes, history)
+}
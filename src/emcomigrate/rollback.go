@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Workflow param: "true" undoes the steps of a failed migration, in reverse
+// order. The default is "false".
+const RollbackParam = "rollbackOnFailure"
+
+// States of EmcoMigrateWorkflow while it rolls back a failed migration, or
+// only cleans up after it
+const (
+	StateRollingBack = "rolling-back"
+	StateCleaningUp  = "cleaning-up"
+)
+
+// RevertAppIntents puts the placement intents that the app intents of a DIG
+// had before the migration back, as recorded by GetDigAppIntents. App
+// intents that have them already are not PUT again, so the activity can be
+// retried, and it can revert an UpdateAppIntents activity that failed
+// partway. If some app intents fail, the others are still reverted, and
+// the error lists the failures per app.
+func RevertAppIntents(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	failures := []string{}
+	for _, gpIntentName := range sortedGPIs(migParam.AppNameIntentPairs) {
+		appIntentBaseURL := buildAppIntentsURL(
+			migParam.GenericPlacementIntentURL, gpIntentName)
+		for _, appNameIntentPair := range migParam.AppNameIntentPairs[gpIntentName] {
+			if appNameIntentPair.SourceIntent == nil {
+				continue // recorded by an older worker
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			appIntentURL := appIntentBaseURL + "/" + appNameIntentPair.AppIntentName
+			sourceIntent := *appNameIntentPair.SourceIntent
+			_, _, err := updateAppIntent(appIntentURL, appNameIntentPair,
+				func(IntentStruc) IntentStruc { return sourceIntent })
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("app %s (%s/%s): %s",
+					appNameIntentPair.AppName, gpIntentName,
+					appNameIntentPair.AppIntentName, strings.TrimSpace(err.Error())))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		revertErr := fmt.Errorf("Failed to revert %d app intents:\n  %s\n",
+			len(failures), strings.Join(failures, "\n  "))
+		fmt.Fprintf(os.Stderr, revertErr.Error())
+		return nil, revertErr
+	}
+
+	migParam.ChangedAppIntents = nil
+	migParam.IntentDiffs = nil
+	return &migParam, nil
+}
+
+// RevertOtherIntents puts back the intents other than app intents that
+// UpdateOtherIntents changed, as it recorded them. If some fail, the others
+// are still reverted, and the error lists the failures.
+func RevertOtherIntents(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	digURL := buildDigURL(migParam.InParams)
+	keys := make([]string, 0, len(migParam.OtherIntentSources))
+	for key := range migParam.OtherIntentSources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	failures := []string{}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := sendToEmco(http.MethodPut, digURL+"/"+key,
+			migParam.OtherIntentSources[key], http.StatusOK); err != nil {
+			failures = append(failures, key+": "+strings.TrimSpace(err.Error()))
+		}
+	}
+	if len(failures) > 0 {
+		revertErr := fmt.Errorf("Failed to revert %d intents:\n  %s\n",
+			len(failures), strings.Join(failures, "\n  "))
+		fmt.Fprintf(os.Stderr, revertErr.Error())
+		return nil, revertErr
+	}
+
+	migParam.OtherIntentSources = nil
+	return &migParam, nil
+}
+
+// rollbackStep undoes a step of a migration.
+type rollbackStep struct {
+	name string // for the error
+	undo func() error
+	// the step cleans up after a failed migration, so it runs even if the
+	// migration is not rolled back
+	always bool
+}
+
+// rollback is the list of steps that undo what a migration did so far.
+// A step is pushed before the step it undoes runs, since a failed step may
+// have done part of its work; undoing must be harmless if it did nothing.
+// The steps run their activities in disconnected contexts, so that they
+// run even if the workflow is cancelled.
+type rollback struct {
+	steps []rollbackStep
+}
+
+// push adds a step, which runs before those pushed earlier.
+func (r *rollback) push(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+// pushCleanup adds a step that runs whether the migration is rolled back
+// or not.
+func (r *rollback) pushCleanup(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo, always: true})
+}
+
+// hasCleanup reports whether some step runs even without a rollback.
+func (r *rollback) hasCleanup() bool {
+	for _, step := range r.steps {
+		if step.always {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs the steps in reverse order, or only the cleanup steps unless
+// all is set, and stops at the first that fails.
+func (r *rollback) run(all bool) error {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		if !all && !step.always {
+			continue
+		}
+		if err := step.undo(); err != nil {
+			return fmt.Errorf("%s failed: %s", step.name, err.Error())
+		}
+	}
+	return nil
+}
+
+// sortedGPIs returns the names of the generic placement intents of the
+// given app intents, in order.
+func sortedGPIs(appNameIntentPairs map[string][]AppNameIntentPair) []string {
+	gpIntentNames := make([]string, 0, len(appNameIntentPairs))
+	for gpIntentName := range appNameIntentPairs {
+		gpIntentNames = append(gpIntentNames, gpIntentName)
+	}
+	sort.Strings(gpIntentNames)
+	return gpIntentNames
+}
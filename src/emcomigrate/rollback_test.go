@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// revertParam is a migParam that moved collectd from provider1+cluster1.
+func revertParam(emco *recordedEmco) MigParam {
+	return MigParam{
+		InParams:                  emco.inParams(),
+		GenericPlacementIntentURL: emco.URL + testGpiPath,
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{
+					AppName:       "collectd",
+					AppIntentName: "collectd-placement-intent",
+					SourceIntent: &IntentStruc{AllOfArray: []AllOf{
+						{ProviderName: "provider1", ClusterName: "cluster1"},
+					}},
+				},
+				{
+					AppName:       "operator",
+					AppIntentName: "operator-placement-intent",
+					SourceIntent: &IntentStruc{AllOfArray: []AllOf{{AnyOfArray: []AnyOf{
+						{ProviderName: "provider1", ClusterName: "cluster1"},
+						{ProviderName: "provider1", ClusterName: "cluster3"},
+					}}}},
+				},
+			},
+		},
+		ChangedAppIntents: []string{"dig1-placement-intent/collectd-placement-intent"},
+	}
+}
+
+func TestRevertAppIntents(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "app-intent-updated.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "operator-placement-intent.json"},
+		"PUT " + testCollectdPath: {http.StatusOK, ""},
+	})
+
+	result, err := runActivity(t, RevertAppIntents, revertParam(emco))
+	require.NoError(t, err)
+	assert.Empty(t, result.ChangedAppIntents)
+
+	// operator has its source intent still
+	puts := emco.requestsFor(http.MethodPut)
+	require.Len(t, puts, 1)
+	assert.Equal(t, testCollectdPath, puts[0].path)
+	var appIntent AppIntent
+	require.NoError(t, json.Unmarshal(puts[0].body, &appIntent))
+	assert.Equal(t, AppIntent{
+		MetaData: MetaData{Name: "collectd-placement-intent"},
+		Spec: SpecData{
+			AppName: "collectd",
+			Intent: IntentStruc{
+				AllOfArray: []AllOf{{ProviderName: "provider1", ClusterName: "cluster1"}},
+			},
+		},
+	}, appIntent)
+}
+
+func TestRevertAppIntentsErrorsPerApp(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET " + testCollectdPath: {http.StatusOK, "app-intent-updated.json"},
+		"GET " + testOperatorPath: {http.StatusOK, "app-intent-updated.json"},
+		"PUT " + testCollectdPath: {http.StatusInternalServerError, ""},
+		"PUT " + testOperatorPath: {http.StatusOK, ""},
+	})
+
+	_, err := runActivity(t, RevertAppIntents, revertParam(emco))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to revert 1 app intents")
+	assert.Contains(t, err.Error(),
+		"app collectd (dig1-placement-intent/collectd-placement-intent)")
+	assert.Len(t, emco.requestsFor(http.MethodPut), 2)
+}
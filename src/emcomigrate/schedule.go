@@ -0,0 +1,361 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
+	enumspb "go.temporal.io/api/enums/v1"
+	filterpb "go.temporal.io/api/filter/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	wf "go.temporal.io/sdk/workflow"
+)
+
+// A recurring migration is run by a MigScheduleWorkflow, which starts an
+// EmcoMigrateWorkflow at each time of a cron schedule, to each of its
+// targets in turn. The runs are child workflows that are left running if
+// the schedule is deleted.
+
+// Signals of MigScheduleWorkflow, without data
+const (
+	PauseScheduleSignal  = "pause-schedule"
+	ResumeScheduleSignal = "resume-schedule"
+)
+
+// Query type that returns the MigSchedule of a MigScheduleWorkflow.
+const ScheduleStateQuery = "schedule-state"
+
+// Workflow type name that MigScheduleWorkflow is registered under
+const migScheduleWorkflowType = "MigScheduleWorkflow"
+
+// After this many scheduled times, MigScheduleWorkflow continues as new to
+// keep its history short.
+const maxScheduleFires = 100
+
+// MigSchedule is a recurring migration, and the state of the
+// MigScheduleWorkflow that runs it, which it carries over when it
+// continues as new.
+type MigSchedule struct {
+	ID string `json:"id"`
+	// when to migrate, as parsed by ParseCronSchedule
+	Cron string `json:"cron"`
+	// IANA time zone of Cron; the default is UTC
+	TimeZone string `json:"timeZone,omitempty"`
+	// target clusters, as provider+cluster, taken in turn
+	Targets []string `json:"targets"`
+	// params of each run, without the target cluster
+	WorkflowParams eta.WorkflowParams `json:"workflowParams"`
+
+	Paused bool `json:"paused"`
+	// index in Targets of the target of the next run
+	NextTarget int `json:"nextTarget"`
+	// time of the next run, in RFC 3339 format, unless paused
+	NextRun string `json:"nextRun,omitempty"`
+	// the scheduled time that fired last, so that it does not fire again
+	// once the workflow continues as new
+	LastFire *time.Time `json:"lastFire,omitempty"`
+	// runs started, times skipped as the last run was still running, and
+	// runs that failed to start
+	Runs         int           `json:"runs"`
+	Skipped      int           `json:"skipped"`
+	FailedStarts int           `json:"failedStarts"`
+	LastRun      *ScheduledRun `json:"lastRun,omitempty"`
+}
+
+// ScheduledRun is a migration started by a MigScheduleWorkflow.
+type ScheduledRun struct {
+	WorkflowID string    `json:"workflowID"`
+	RunID      string    `json:"runID"`
+	Target     string    `json:"target"`
+	Time       time.Time `json:"time"` // the scheduled time
+}
+
+// MigScheduleWorkflowID returns the workflow ID of the MigScheduleWorkflow
+// of a schedule.
+func MigScheduleWorkflowID(scheduleID string) string {
+	return "migration-schedule/" + scheduleID
+}
+
+// Validate checks that a schedule can be run.
+func (s MigSchedule) Validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("Invalid schedule: expect an ID")
+	}
+	if _, _, err := s.parse(); err != nil {
+		return err
+	}
+	if len(s.Targets) == 0 {
+		return fmt.Errorf("Invalid schedule %s: expect one or more targets", s.ID)
+	}
+	for _, target := range s.Targets {
+		parts := strings.Split(target, "+")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Invalid target %q of schedule %s: expect provider+cluster",
+				target, s.ID)
+		}
+	}
+	inParams, ok := s.WorkflowParams.ActivityParams[ALL_ACTIVITIES]
+	if !ok {
+		return fmt.Errorf("Invalid schedule %s: expect %s parameters", s.ID,
+			ALL_ACTIVITIES)
+	}
+	missing := []string{}
+	for _, param := range NeededParams {
+		if param == "targetClusterProvider" || param == "targetClusterName" {
+			continue
+		}
+		if _, ok := inParams[param]; !ok {
+			missing = append(missing, param)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Invalid schedule %s: expect params %s", s.ID,
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parse returns the cron schedule and the time zone of a schedule.
+func (s MigSchedule) parse() (*CronSchedule, *time.Location, error) {
+	cron, err := ParseCronSchedule(s.Cron)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid schedule %s: %s", s.ID, err.Error())
+	}
+	location := time.UTC
+	if s.TimeZone != "" {
+		if location, err = time.LoadLocation(s.TimeZone); err != nil {
+			return nil, nil, fmt.Errorf("Invalid time zone %q of schedule %s: %s",
+				s.TimeZone, s.ID, err.Error())
+		}
+	}
+	return cron, location, nil
+}
+
+// runParams returns the workflow params of a run to the given target.
+func (s MigSchedule) runParams(target string) eta.WorkflowParams {
+	params := s.WorkflowParams
+	params.ActivityParams = map[string]map[string]string{}
+	for name, activityParams := range s.WorkflowParams.ActivityParams {
+		params.ActivityParams[name] = activityParams
+	}
+	inParams := map[string]string{}
+	for name, value := range s.WorkflowParams.ActivityParams[ALL_ACTIVITIES] {
+		inParams[name] = value
+	}
+	parts := strings.SplitN(target, "+", 2)
+	inParams["targetClusterProvider"] = parts[0]
+	inParams["targetClusterName"] = parts[1]
+	params.ActivityParams[ALL_ACTIVITIES] = inParams
+	return params
+}
+
+// MigScheduleWorkflow starts a migration at each time of a schedule, unless
+// paused, or the migration it started last is still running.
+func MigScheduleWorkflow(ctx wf.Context, schedule MigSchedule) error {
+	err := wf.SetQueryHandler(ctx, ScheduleStateQuery, func() (MigSchedule, error) {
+		return schedule, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := schedule.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		return err
+	}
+	cron, location, _ := schedule.parse()
+
+	pauseCh := wf.GetSignalChannel(ctx, PauseScheduleSignal)
+	resumeCh := wf.GetSignalChannel(ctx, ResumeScheduleSignal)
+	checkCtx := wf.WithActivityOptions(ctx, lockCheckOpts)
+	fires := 0
+	for {
+		if fires >= maxScheduleFires {
+			return wf.NewContinueAsNewError(ctx, MigScheduleWorkflow, schedule)
+		}
+
+		timerCtx, cancelTimer := wf.WithCancel(ctx)
+		selector := wf.NewSelector(ctx)
+		selector.AddReceive(pauseCh, func(c wf.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			schedule.Paused = true
+		})
+		selector.AddReceive(resumeCh, func(c wf.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			schedule.Paused = false
+		})
+		selector.AddReceive(ctx.Done(), func(c wf.ReceiveChannel, more bool) {})
+		schedule.NextRun = ""
+		next := time.Time{}
+		fired := false
+		if !schedule.Paused {
+			// Each time fires once, even if the timer fires early.
+			now := wf.Now(ctx).In(location)
+			from := now
+			if schedule.LastFire != nil && from.Before(schedule.LastFire.Add(time.Minute)) {
+				from = schedule.LastFire.Add(time.Minute).In(location)
+			}
+			var ok bool
+			if next, ok = cron.Next(from); !ok {
+				cancelTimer()
+				return fmt.Errorf("Schedule %s has no time within %s", schedule.ID,
+					cronHorizon)
+			}
+			schedule.NextRun = next.UTC().Format(time.RFC3339)
+			selector.AddFuture(wf.NewTimer(timerCtx, next.Sub(now)), func(f wf.Future) {
+				fired = f.Get(ctx, nil) == nil
+			})
+		}
+		selector.Select(ctx)
+		cancelTimer()
+		if err := ctx.Err(); err != nil {
+			// The schedule is deleted.
+			return err
+		}
+		if fired {
+			fires++
+			lastFire := next.UTC()
+			schedule.LastFire = &lastFire
+			schedule.fire(ctx, checkCtx, next)
+		}
+	}
+}
+
+// fire starts the run of the given time, to the next target, unless the
+// last run is still running. The next run goes to the target after it
+// either way, so that each target keeps its times.
+func (s *MigSchedule) fire(ctx, checkCtx wf.Context, at time.Time) {
+	target := s.Targets[s.NextTarget%len(s.Targets)]
+	s.NextTarget = (s.NextTarget + 1) % len(s.Targets)
+
+	if s.LastRun != nil {
+		running := true // unless known to be done
+		err := wf.ExecuteActivity(checkCtx, IsWorkflowRunning, s.LastRun.WorkflowID,
+			s.LastRun.RunID).Get(checkCtx, &running)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "MigScheduleWorkflow: failed to check run %s: %s\n",
+				s.LastRun.WorkflowID, err)
+		}
+		if running {
+			fmt.Printf("MigScheduleWorkflow: skipping the run of %s to %s, as run %s "+
+				"is still running\n", at.UTC().Format(time.RFC3339), target,
+				s.LastRun.WorkflowID)
+			s.Skipped++
+			return
+		}
+	}
+
+	run := ScheduledRun{
+		WorkflowID: s.ID + "-" + at.UTC().Format("20060102T1504Z"),
+		Target:     target,
+		Time:       at.UTC(),
+	}
+	params := s.runParams(target)
+	childCtx := wf.WithChildOptions(ctx, wf.ChildWorkflowOptions{
+		WorkflowID:        run.WorkflowID,
+		TaskQueue:         MigTaskQueue,
+		ParentClosePolicy: enumspb.PARENT_CLOSE_POLICY_ABANDON,
+		Memo: map[string]interface{}{
+			MemoPlan: NewMigPlan(params.ActivityParams[ALL_ACTIVITIES]),
+		},
+	})
+	var execution wf.Execution
+	err := wf.ExecuteChildWorkflow(childCtx, EmcoMigrateWorkflow, &params).
+		GetChildWorkflowExecution().Get(ctx, &execution)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "MigScheduleWorkflow: failed to start run %s: %s\n",
+			run.WorkflowID, err)
+		s.FailedStarts++
+		return
+	}
+	run.RunID = execution.RunID
+	s.Runs++
+	s.LastRun = &run
+	fmt.Printf("MigScheduleWorkflow: started run %s to %s\n", run.WorkflowID, target)
+}
+
+// StartMigSchedule validates a schedule and starts its MigScheduleWorkflow.
+// It fails if a schedule with the same ID is running.
+func StartMigSchedule(ctx context.Context, c client.Client, schedule MigSchedule) (
+	client.WorkflowRun, error) {
+
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+	options := client.StartWorkflowOptions{
+		ID:                                       MigScheduleWorkflowID(schedule.ID),
+		TaskQueue:                                MigTaskQueue,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+	return c.ExecuteWorkflow(ctx, options, MigScheduleWorkflow, schedule)
+}
+
+// GetMigSchedule returns the state of a running schedule.
+func GetMigSchedule(ctx context.Context, c client.Client, scheduleID string) (
+	MigSchedule, error) {
+
+	var schedule MigSchedule
+	value, err := c.QueryWorkflow(ctx, MigScheduleWorkflowID(scheduleID), "",
+		ScheduleStateQuery)
+	if err == nil {
+		err = value.Get(&schedule)
+	}
+	return schedule, err
+}
+
+// ListMigSchedules returns the state of the running schedules.
+func ListMigSchedules(ctx context.Context, c client.Client) ([]MigSchedule, error) {
+	schedules := []MigSchedule{}
+	var pageToken []byte
+	for {
+		resp, err := c.ListOpenWorkflow(ctx, &workflowservice.ListOpenWorkflowExecutionsRequest{
+			NextPageToken: pageToken,
+			Filters: &workflowservice.ListOpenWorkflowExecutionsRequest_TypeFilter{
+				TypeFilter: &filterpb.WorkflowTypeFilter{Name: migScheduleWorkflowType},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range resp.GetExecutions() {
+			execution := info.GetExecution()
+			value, err := c.QueryWorkflow(ctx, execution.GetWorkflowId(),
+				execution.GetRunId(), ScheduleStateQuery)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to query schedule %s: %s",
+					execution.GetWorkflowId(), err)
+			}
+			var schedule MigSchedule
+			if err := value.Get(&schedule); err != nil {
+				return nil, err
+			}
+			schedules = append(schedules, schedule)
+		}
+		if pageToken = resp.GetNextPageToken(); len(pageToken) == 0 {
+			return schedules, nil
+		}
+	}
+}
+
+// PauseMigSchedule pauses a schedule, or resumes it if pause is false.
+func PauseMigSchedule(ctx context.Context, c client.Client, scheduleID string,
+	pause bool) error {
+
+	signal := PauseScheduleSignal
+	if !pause {
+		signal = ResumeScheduleSignal
+	}
+	return c.SignalWorkflow(ctx, MigScheduleWorkflowID(scheduleID), "", signal, nil)
+}
+
+// DeleteMigSchedule stops a schedule. The migration it started last, if
+// still running, runs on.
+func DeleteMigSchedule(ctx context.Context, c client.Client, scheduleID string) error {
+	return c.CancelWorkflow(ctx, MigScheduleWorkflowID(scheduleID), "")
+}
@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	wf "go.temporal.io/sdk/workflow"
+)
+
+type MigScheduleTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+	// the runs started, as "workflowID target"
+	runs []string
+}
+
+func TestMigScheduleTestSuite(t *testing.T) {
+	suite.Run(t, new(MigScheduleTestSuite))
+}
+
+func (s *MigScheduleTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	// a Saturday
+	s.env.SetStartTime(time.Date(2022, 6, 4, 7, 59, 30, 0, time.UTC))
+	s.runs = nil
+	s.env.RegisterWorkflow(EmcoMigrateWorkflow)
+	s.env.OnWorkflow(EmcoMigrateWorkflow, mock.Anything, mock.Anything).Return(
+		func(ctx wf.Context, params *eta.WorkflowParams) (*MigParam, error) {
+			inParams := params.ActivityParams[ALL_ACTIVITIES]
+			s.runs = append(s.runs, wf.GetInfo(ctx).WorkflowExecution.ID+" "+
+				inParams["targetClusterProvider"]+"+"+inParams["targetClusterName"])
+			return &MigParam{InParams: inParams}, nil
+		})
+}
+
+func (s *MigScheduleTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+// testSchedule returns a schedule that migrates at 8am and 4pm to three
+// clusters in turn.
+func testSchedule() MigSchedule {
+	inParams := testInParams()
+	delete(inParams, "targetClusterProvider")
+	delete(inParams, "targetClusterName")
+	return MigSchedule{
+		ID:      "follow-the-sun",
+		Cron:    "0 8,16 * * *",
+		Targets: []string{"provider1+cluster1", "provider2+cluster2", "provider3+cluster3"},
+		WorkflowParams: eta.WorkflowParams{
+			ActivityParams: map[string]map[string]string{ALL_ACTIVITIES: inParams},
+		},
+	}
+}
+
+func (s *MigScheduleTestSuite) scheduleState() MigSchedule {
+	value, err := s.env.QueryWorkflow(ScheduleStateQuery)
+	s.NoError(err)
+	var schedule MigSchedule
+	s.NoError(value.Get(&schedule))
+	return schedule
+}
+
+// run runs the schedule for the given time, and then deletes it.
+func (s *MigScheduleTestSuite) run(schedule MigSchedule, d time.Duration) {
+	s.env.RegisterDelayedCallback(s.env.CancelWorkflow, d)
+	s.env.ExecuteWorkflow(MigScheduleWorkflow, schedule)
+	s.True(s.env.IsWorkflowCompleted())
+	s.True(temporal.IsCanceledError(s.env.GetWorkflowError()))
+}
+
+func (s *MigScheduleTestSuite) Test_RotateTargets() {
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, mock.Anything, mock.Anything).
+		Return(false, nil).Twice()
+	s.env.RegisterDelayedCallback(func() {
+		state := s.scheduleState()
+		s.Equal(1, state.Runs)
+		s.Equal(1, state.NextTarget)
+		s.Equal("2022-06-04T16:00:00Z", state.NextRun)
+		s.Equal(time.Date(2022, 6, 4, 8, 0, 0, 0, time.UTC), *state.LastFire)
+		s.Equal(&ScheduledRun{
+			WorkflowID: "follow-the-sun-20220604T0800Z",
+			Target:     "provider1+cluster1",
+			Time:       time.Date(2022, 6, 4, 8, 0, 0, 0, time.UTC),
+		}, withoutRunID(state.LastRun))
+	}, time.Hour)
+
+	s.run(testSchedule(), 25*time.Hour)
+
+	s.Equal([]string{
+		"follow-the-sun-20220604T0800Z provider1+cluster1",
+		"follow-the-sun-20220604T1600Z provider2+cluster2",
+		"follow-the-sun-20220605T0800Z provider3+cluster3",
+	}, s.runs)
+}
+
+// withoutRunID returns a copy of run without its generated run ID.
+func withoutRunID(run *ScheduledRun) *ScheduledRun {
+	if run == nil {
+		return nil
+	}
+	copy := *run
+	copy.RunID = ""
+	return &copy
+}
+
+func (s *MigScheduleTestSuite) Test_SkipWhileRunning() {
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, "follow-the-sun-20220604T0800Z",
+		mock.Anything).Return(true, nil).Once()
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, "follow-the-sun-20220604T0800Z",
+		mock.Anything).Return(false, nil).Once()
+
+	s.run(testSchedule(), 25*time.Hour)
+
+	// The skipped run of 4pm would have gone to cluster2.
+	s.Equal([]string{
+		"follow-the-sun-20220604T0800Z provider1+cluster1",
+		"follow-the-sun-20220605T0800Z provider3+cluster3",
+	}, s.runs)
+}
+
+func (s *MigScheduleTestSuite) Test_PauseResume() {
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, mock.Anything, mock.Anything).
+		Never()
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PauseScheduleSignal, nil)
+	}, 10*time.Second)
+	s.env.RegisterDelayedCallback(func() {
+		state := s.scheduleState()
+		s.True(state.Paused)
+		s.Empty(state.NextRun)
+	}, time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ResumeScheduleSignal, nil)
+	}, 20*time.Hour)
+
+	s.run(testSchedule(), 25*time.Hour)
+
+	s.Equal([]string{"follow-the-sun-20220605T0800Z provider1+cluster1"}, s.runs)
+}
+
+func (s *MigScheduleTestSuite) Test_ContinuedAsNew() {
+	// The run continued as new when the timer of 8am fired, a moment early.
+	s.env.SetStartTime(time.Date(2022, 6, 4, 7, 59, 59, 0, time.UTC))
+	s.env.OnActivity(IsWorkflowRunning, mock.Anything, mock.Anything, mock.Anything).
+		Return(false, nil).Once()
+	schedule := testSchedule()
+	lastFire := time.Date(2022, 6, 4, 8, 0, 0, 0, time.UTC)
+	schedule.LastFire = &lastFire
+	schedule.NextTarget = 1
+	schedule.Runs = 1
+	schedule.LastRun = &ScheduledRun{WorkflowID: "follow-the-sun-20220604T0800Z",
+		RunID: "run1", Target: "provider1+cluster1", Time: lastFire}
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal("2022-06-04T16:00:00Z", s.scheduleState().NextRun)
+	}, time.Minute)
+
+	s.run(schedule, 10*time.Hour)
+
+	// 8am does not fire again.
+	s.Equal([]string{"follow-the-sun-20220604T1600Z provider2+cluster2"}, s.runs)
+}
+
+func (s *MigScheduleTestSuite) Test_TimeZone() {
+	schedule := testSchedule()
+	schedule.Cron = "0 10 * * *"
+	schedule.TimeZone = "Europe/Paris"
+	schedule.Targets = schedule.Targets[:2]
+
+	s.run(schedule, time.Hour)
+
+	s.Equal([]string{"follow-the-sun-20220604T0800Z provider1+cluster1"}, s.runs)
+}
+
+func TestMigScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*MigSchedule)
+		err    string
+	}{
+		{"no ID", func(s *MigSchedule) { s.ID = "" }, "expect an ID"},
+		{"cron", func(s *MigSchedule) { s.Cron = "daily" }, "Invalid cron expression"},
+		{"time zone", func(s *MigSchedule) { s.TimeZone = "Mars/Olympus" },
+			`Invalid time zone "Mars/Olympus"`},
+		{"no targets", func(s *MigSchedule) { s.Targets = nil }, "expect one or more targets"},
+		{"target", func(s *MigSchedule) { s.Targets = []string{"cluster1"} },
+			`Invalid target "cluster1"`},
+		{"params", func(s *MigSchedule) {
+			delete(s.WorkflowParams.ActivityParams[ALL_ACTIVITIES], "emcoURL")
+		}, "expect params emcoURL"},
+	}
+	assert.NoError(t, testSchedule().Validate())
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule := testSchedule()
+			tc.change(&schedule)
+			err := schedule.Validate()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestMigScheduleRunParams(t *testing.T) {
+	schedule := testSchedule()
+	params := schedule.runParams("provider2+cluster2")
+	assert.Equal(t, testInParams(), params.ActivityParams[ALL_ACTIVITIES])
+	assert.NotContains(t, schedule.WorkflowParams.ActivityParams[ALL_ACTIVITIES],
+		"targetClusterName")
+}
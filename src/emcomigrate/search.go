@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/converter"
+	wf "go.temporal.io/sdk/workflow"
+)
+
+// Custom search attributes of EmcoMigrateWorkflow, to find migrations with
+// visibility queries such as "EmcoDIG='dig1' and TargetCluster='provider2+cluster2'".
+// Clusters are given as provider+cluster. All of them are of type Keyword,
+// and must be registered with the Temporal server before they can be used.
+const (
+	SearchAttrProject        = "EmcoProject"
+	SearchAttrCompositeApp   = "EmcoCompositeApp"
+	SearchAttrDIG            = "EmcoDIG"
+	SearchAttrSourceCluster  = "SourceCluster"
+	SearchAttrTargetCluster  = "TargetCluster"
+	SearchAttrMigrationPhase = "MigrationPhase"
+)
+
+// Treat this as a const
+var SearchAttributes = []string{
+	SearchAttrProject, SearchAttrCompositeApp, SearchAttrDIG,
+	SearchAttrSourceCluster, SearchAttrTargetCluster, SearchAttrMigrationPhase}
+
+// MigrationPhase values, besides the names of the activities while they run
+const (
+	PhaseStarted   = "started"
+	PhaseCompleted = "completed"
+	PhaseFailed    = "failed"
+	// failed, and rolled back on request
+	PhaseRolledBack = "rolled-back"
+	// a temporary migration cancelled before it moved the apps back, which
+	// stay on the target cluster
+	PhaseCanceled = "canceled"
+)
+
+// MemoPlan is the memo key of the MigPlan of a migration.
+const MemoPlan = "plan"
+
+// MigPlan describes what a migration is meant to do. The workflow client
+// stores it in the memo of the workflow, which is shown with the workflow
+// when it is listed.
+type MigPlan struct {
+	EmcoURL             string   `json:"emcoURL"`
+	Project             string   `json:"project"`
+	CompositeApp        string   `json:"compositeApp"`
+	CompositeAppVersion string   `json:"compositeAppVersion"`
+	DIG                 string   `json:"deploymentIntentGroup"`
+	TargetCluster       string   `json:"targetCluster"` // provider+cluster
+	Steps               []string `json:"steps"`
+}
+
+// NewMigPlan returns the plan of a migration with the given workflow params.
+func NewMigPlan(inParams map[string]string) MigPlan {
+	return MigPlan{
+		EmcoURL:             inParams["emcoURL"],
+		Project:             inParams["project"],
+		CompositeApp:        inParams["compositeApp"],
+		CompositeAppVersion: inParams["compositeAppVersion"],
+		DIG:                 inParams["deploymentIntentGroup"],
+		TargetCluster:       targetCluster(inParams),
+		Steps:               migSteps(inParams),
+	}
+}
+
+// migSteps returns the steps that a migration with the given workflow
+// params runs, if it succeeds. With the waves strategy, the steps after
+// GetAppDependencies are run for each wave, and UpdateOtherIntents for the
+// last one only. Apps that depend on others may be moved in waves without
+// it too.
+func migSteps(inParams map[string]string) []string {
+	steps := []string{}
+	if add, _ := boolParam(inParams, AddToLogicalCloudParam); add {
+		steps = append(steps, "AddClusterToLogicalCloud")
+	}
+	steps = append(steps, "PreflightCheck", "GetDigAppIntents")
+	strategy, _ := migrationStrategy(inParams)
+	if strategy == StrategyCloneDIG {
+		return append(steps, "CloneDIG", "VerifyDIG", "TerminateDIG")
+	}
+	if ignore, _ := boolParam(inParams, IgnoreDependenciesParam); !ignore {
+		steps = append(steps, "GetAppDependencies")
+	}
+	if strategy == StrategyWaves {
+		steps = append(steps, "UpdateAppIntents", "UpdateOtherIntents", "DoDigUpdate",
+			"VerifyDIG")
+		if inParams[HealthCheckURLParam] != "" {
+			steps = append(steps, "CheckWaveHealth")
+		}
+		return steps
+	}
+	return append(steps, "UpdateAppIntents", "UpdateOtherIntents", "DoDigUpdate")
+}
+
+func targetCluster(inParams map[string]string) string {
+	return inParams["targetClusterProvider"] + "+" + inParams["targetClusterName"]
+}
+
+// MigFilter selects migrations by their search attributes, for listing
+// them with the visibility API. Empty fields match all migrations.
+type MigFilter struct {
+	Project       string
+	CompositeApp  string
+	DIG           string
+	Cluster       string // source or target cluster, as provider+cluster
+	TargetCluster string // as provider+cluster
+	Phase         string
+}
+
+// Query returns the visibility query for the migrations that match the
+// filter, or "" if the filter matches all of them.
+func (f MigFilter) Query() (string, error) {
+	conds := []string{}
+	add := func(format, value string) error {
+		if value == "" {
+			return nil
+		}
+		if strings.ContainsAny(value, `'"\`) {
+			return fmt.Errorf("Invalid search attribute value %q", value)
+		}
+		conds = append(conds, strings.ReplaceAll(format, "?", "'"+value+"'"))
+		return nil
+	}
+	for _, cond := range []struct{ format, value string }{
+		{SearchAttrProject + " = ?", f.Project},
+		{SearchAttrCompositeApp + " = ?", f.CompositeApp},
+		{SearchAttrDIG + " = ?", f.DIG},
+		{"(" + SearchAttrSourceCluster + " = ? or " + SearchAttrTargetCluster + " = ?)", f.Cluster},
+		{SearchAttrTargetCluster + " = ?", f.TargetCluster},
+		{SearchAttrMigrationPhase + " = ?", f.Phase},
+	} {
+		if err := add(cond.format, cond.value); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(conds, " and "), nil
+}
+
+// SearchAttrStrings decodes the given search attribute of a workflow. It
+// returns nil if the workflow does not have it.
+func SearchAttrStrings(attrs *commonpb.SearchAttributes, name string) []string {
+	payload, ok := attrs.GetIndexedFields()[name]
+	if !ok {
+		return nil
+	}
+	dc := converter.GetDefaultDataConverter()
+	var values []string
+	if err := dc.FromPayload(payload, &values); err == nil {
+		return values
+	}
+	var value string
+	if err := dc.FromPayload(payload, &value); err != nil {
+		return nil
+	}
+	return []string{value}
+}
+
+// DecodeMigPlan decodes the MigPlan in the memo of a workflow. It returns
+// nil if the workflow has none.
+func DecodeMigPlan(memo *commonpb.Memo) *MigPlan {
+	payload, ok := memo.GetFields()[MemoPlan]
+	if !ok {
+		return nil
+	}
+	var plan MigPlan
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &plan); err != nil {
+		return nil
+	}
+	return &plan
+}
+
+// searchAttributesEnabled is set by the worker, see EnableSearchAttributes.
+var searchAttributesEnabled = false
+
+// EnableSearchAttributes makes the workflow runs started from now on keep
+// their search attributes up to date. The worker enables them only if the
+// Temporal server has them registered, since upserting unregistered search
+// attributes blocks the workflow.
+func EnableSearchAttributes(enable bool) {
+	searchAttributesEnabled = enable
+}
+
+// MissingSearchAttributes returns the search attributes of the workflow
+// that are not among the given registered ones.
+func MissingSearchAttributes(registered map[string]enumspb.IndexedValueType) []string {
+	missing := []string{}
+	for _, name := range SearchAttributes {
+		if _, ok := registered[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// searchIndexer upserts the search attributes of a workflow run, if they
+// were enabled on the worker that started it. The decision is recorded in
+// the history, so that the run keeps to it when replayed elsewhere.
+type searchIndexer struct {
+	ctx     wf.Context
+	enabled bool
+}
+
+func newSearchIndexer(ctx wf.Context, inParams map[string]string) *searchIndexer {
+	s := &searchIndexer{ctx: ctx}
+	wf.SideEffect(ctx, func(wf.Context) interface{} {
+		return searchAttributesEnabled
+	}).Get(&s.enabled)
+
+	s.upsert(map[string]interface{}{
+		SearchAttrProject:        inParams["project"],
+		SearchAttrCompositeApp:   inParams["compositeApp"],
+		SearchAttrDIG:            inParams["deploymentIntentGroup"],
+		SearchAttrTargetCluster:  targetCluster(inParams),
+		SearchAttrMigrationPhase: PhaseStarted,
+	})
+	return s
+}
+
+func (s *searchIndexer) setPhase(phase string) {
+	s.upsert(map[string]interface{}{SearchAttrMigrationPhase: phase})
+}
+
+func (s *searchIndexer) setSourceClusters(clusters []string) {
+	if len(clusters) > 0 {
+		s.upsert(map[string]interface{}{SearchAttrSourceCluster: clusters})
+	}
+}
+
+// upsert upserts the given search attributes. Failures are only logged, as
+// the search attributes do not affect the migration.
+func (s *searchIndexer) upsert(attributes map[string]interface{}) {
+	if !s.enabled {
+		return
+	}
+	if err := wf.UpsertSearchAttributes(s.ctx, attributes); err != nil {
+		fmt.Fprintf(os.Stderr, "UpsertSearchAttributes failed: %s\n", err)
+	}
+}
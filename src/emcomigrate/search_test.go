@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigFilterQuery(t *testing.T) {
+	for _, tc := range []struct {
+		filter MigFilter
+		query  string
+	}{
+		{MigFilter{}, ""},
+		{MigFilter{DIG: "dig1"}, "EmcoDIG = 'dig1'"},
+		{MigFilter{Project: "proj1", TargetCluster: "provider2+cluster2", Phase: "failed"},
+			"EmcoProject = 'proj1' and TargetCluster = 'provider2+cluster2' and " +
+				"MigrationPhase = 'failed'"},
+		{MigFilter{Cluster: "provider1+cluster1"},
+			"(SourceCluster = 'provider1+cluster1' or TargetCluster = 'provider1+cluster1')"},
+	} {
+		query, err := tc.filter.Query()
+		require.NoError(t, err)
+		require.Equal(t, tc.query, query)
+	}
+
+	_, err := MigFilter{DIG: "dig1' or EmcoDIG = 'dig2"}.Query()
+	require.Error(t, err)
+}
+
+func TestMigFilterQueryCombinations(t *testing.T) {
+	// each field, set to value, and the condition that it adds
+	fields := []struct {
+		set  func(f *MigFilter, value string)
+		cond string
+	}{
+		{func(f *MigFilter, v string) { f.Project = v }, "EmcoProject = 'proj1'"},
+		{func(f *MigFilter, v string) { f.CompositeApp = v }, "EmcoCompositeApp = 'capp1'"},
+		{func(f *MigFilter, v string) { f.DIG = v }, "EmcoDIG = 'dig1'"},
+		{func(f *MigFilter, v string) { f.Cluster = v },
+			"(SourceCluster = 'provider1+cluster1' or TargetCluster = 'provider1+cluster1')"},
+		{func(f *MigFilter, v string) { f.TargetCluster = v },
+			"TargetCluster = 'provider2+cluster2'"},
+		{func(f *MigFilter, v string) { f.Phase = v }, "MigrationPhase = 'completed'"},
+	}
+	values := []string{"proj1", "capp1", "dig1", "provider1+cluster1",
+		"provider2+cluster2", "completed"}
+
+	// All the fields that are set are combined, in a fixed order.
+	for set := 0; set < 1<<len(fields); set++ {
+		filter := MigFilter{}
+		conds := []string{}
+		for i, field := range fields {
+			if set&(1<<i) != 0 {
+				field.set(&filter, values[i])
+				conds = append(conds, field.cond)
+			}
+		}
+		query, err := filter.Query()
+		require.NoError(t, err, "%+v", filter)
+		require.Equal(t, strings.Join(conds, " and "), query, "%+v", filter)
+	}
+
+	// A value cannot end the quoted string that it is put in, whatever
+	// the field.
+	for i, field := range fields {
+		for _, value := range []string{
+			"dig1' or EmcoDIG = 'dig2",
+			"dig1'",
+			`dig1\' or EmcoDIG = \'dig2`,
+			`dig1" or EmcoDIG = "dig2`,
+			`dig1\`,
+		} {
+			filter := MigFilter{}
+			for j, other := range fields {
+				if j != i {
+					other.set(&filter, values[j])
+				}
+			}
+			field.set(&filter, value)
+			query, err := filter.Query()
+			require.Error(t, err, "%+v", filter)
+			require.Equal(t, fmt.Sprintf("Invalid search attribute value %q", value),
+				err.Error())
+			require.Empty(t, query)
+		}
+	}
+}
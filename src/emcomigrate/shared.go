@@ -3,11 +3,77 @@
 
 package emcomigrate
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
 const MigTaskQueue = "MIGRATION_TASK_Q"
 
+// Query type that returns the current state of EmcoMigrateWorkflow: the name
+// of the ongoing activity, "started" or "completed"
+const CurrentStateQuery = "current-state"
+
+// Query type that returns the current state of EmcoMigrateWorkflow, as a
+// MigState
+const MigrationStateQuery = "migration-state"
+
+// MigState is the result of MigrationStateQuery.
+type MigState struct {
+	// name of ongoing activity, "started" or "completed"
+	State string `json:"state"`
+	// code version that the run follows; 0 if it started before versioning
+	WorkflowVersion int `json:"workflowVersion"`
+	// the migration strategy, once the params are checked: the strategy
+	// param, or StrategyWaves if the apps depend on each other and the
+	// update strategy moves them in waves
+	Strategy string `json:"strategy,omitempty"`
+	// the wave in progress of the waves strategy, as "2/3"
+	Wave string `json:"wave,omitempty"`
+	// when the maintenance window that the run waits for opens, or when a
+	// temporary migration moves the apps back, in RFC 3339 format
+	WaitingUntil string `json:"waitingUntil,omitempty"`
+}
+
+// DecodeMigState decodes the result of MigrationStateQuery, or the state name
+// that CurrentStateQuery returns.
+func DecodeMigState(value converter.EncodedValue) (MigState, error) {
+	var state MigState
+	if err := value.Get(&state); err != nil {
+		if value.Get(&state.State) != nil {
+			return MigState{}, err
+		}
+	}
+	return state, nil
+}
+
+// QueryMigState runs MigrationStateQuery on the given workflow run. Workers
+// that predate it fail the query, and CurrentStateQuery is run instead.
+func QueryMigState(ctx context.Context, c client.Client, workflowID, runID string) (MigState, error) {
+	value, err := c.QueryWorkflow(ctx, workflowID, runID, MigrationStateQuery)
+	var queryFailed *serviceerror.QueryFailed
+	if errors.As(err, &queryFailed) {
+		value, err = c.QueryWorkflow(ctx, workflowID, runID, CurrentStateQuery)
+	}
+	if err != nil {
+		return MigState{}, err
+	}
+	return DecodeMigState(value)
+}
+
 type AppNameIntentPair struct {
 	AppName       string
 	AppIntentName string
+	// the app intent already had the placement that the migration gives it
+	// before the migration
+	OnTarget bool `json:",omitempty"`
+	// the placement intent before the migration, which a rollback restores
+	SourceIntent *IntentStruc `json:",omitempty"`
 }
 
 type MigParam struct {
@@ -16,4 +82,48 @@ type MigParam struct {
 	GenericPlacementIntents   []string
 	// map indexed by generic placement intent name
 	AppNameIntentPairs map[string][]AppNameIntentPair
+	// clusters in the app intents before migration, as provider+cluster
+	SourceClusters []string
+	// code version that the workflow run follows; 0 if unversioned
+	WorkflowVersion int `json:",omitempty"`
+	// app intents changed by the migration, as gpIntentName/appIntentName
+	ChangedAppIntents []string `json:",omitempty"`
+	// diffs of the placement intents of ChangedAppIntents, as "- placement"
+	// and "+ placement" lines
+	IntentDiffs map[string][]string `json:",omitempty"`
+	// PreflightCheck found the apps deployed on the target cluster already
+	PreflightOnTarget bool `json:",omitempty"`
+	// no app intent changed, and the apps are deployed on the target cluster
+	AlreadyOnTarget bool `json:",omitempty"`
+	// ResultMigrated or ResultAlreadyOnTarget, once the migration
+	// succeeded, or ResultReturned once a temporary one moved the apps back
+	Result string `json:",omitempty"`
+	// logical cloud of the DIG, if the migration added the target cluster
+	// to it as AddedClusterReference
+	LogicalCloud          string `json:",omitempty"`
+	AddedClusterReference string `json:",omitempty"`
+	// the migration instantiated the logical cloud
+	InstantiatedLogicalCloud bool `json:",omitempty"`
+	// the clone of the DIG that the clone-dig strategy created
+	CloneDIG string `json:",omitempty"`
+	// what the migration did with the intents of the DIG other than app
+	// intents, and what it could not transform
+	IntentReport []IntentReport `json:",omitempty"`
+	// the intents other than app intents before the migration changed them,
+	// by path under the DIG, which a rollback restores
+	OtherIntentSources map[string]json.RawMessage `json:",omitempty"`
+	// the dependencies of the apps, by app
+	AppDependencies map[string][]AppDependency `json:",omitempty"`
+	// the apps of each wave of the waves strategy, in order
+	Waves [][]string `json:",omitempty"`
+	// the wave in progress, from 1, or the last one once the waves are done
+	Wave int `json:",omitempty"`
 }
+
+// MigParam.Result values
+const (
+	ResultMigrated        = "migrated"
+	ResultAlreadyOnTarget = "already on target"
+	// a temporary migration moved the apps back
+	ResultReturned = "returned"
+)
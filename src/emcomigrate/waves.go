@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Workflow params of the waves strategy
+const (
+	// The apps of each wave, in order. Waves are separated by ";" and the
+	// apps of a wave by ",", as in "app1,app2;app3". The apps of the DIG
+	// that no wave names are migrated in a last wave, or in as many as
+	// their dependencies need. The default is one wave per app, in the
+	// order of their dependencies, and then of their names.
+	WavesParam = "waves"
+	// A URL that must return a 2xx status for each app of a wave once the
+	// wave is deployed, for the migration to go on. "{app}", "{provider}"
+	// and "{cluster}" in it are replaced with the app and the target
+	// cluster. By default there is no health check.
+	HealthCheckURLParam = "healthCheckURL"
+)
+
+// parseWaves returns the WavesParam param, or nil if it is not set.
+func parseWaves(inParams map[string]string) ([][]string, error) {
+	param := strings.TrimSpace(inParams[WavesParam])
+	if param == "" {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	waves := [][]string{}
+	for i, wave := range strings.Split(param, ";") {
+		apps := []string{}
+		for _, app := range strings.Split(wave, ",") {
+			app = strings.TrimSpace(app)
+			if app == "" {
+				return nil, fmt.Errorf("Invalid %s param %q: wave %d has an empty "+
+					"app name", WavesParam, param, i+1)
+			}
+			if seen[app] {
+				return nil, fmt.Errorf("Invalid %s param %q: app %s is in two waves",
+					WavesParam, param, app)
+			}
+			seen[app] = true
+			apps = append(apps, app)
+		}
+		waves = append(waves, apps)
+	}
+	return waves, nil
+}
+
+// planWaves returns the apps of each wave of a migration with the given
+// waves, as given by parseWaves, of the apps of migParam. The apps that no
+// wave names follow, in as many waves as their dependencies need, or one
+// app per wave if no waves are given. An app that is not in the app
+// intents, or that is not in a later wave than an app that it depends on,
+// is an error.
+func planWaves(waves [][]string, migParam MigParam) ([][]string, error) {
+	apps := map[string]bool{}
+	for _, app := range migParam.appNames() {
+		apps[app] = true
+	}
+	planned := [][]string{}
+	for _, wave := range waves {
+		for _, app := range wave {
+			if !apps[app] {
+				return nil, fmt.Errorf("App %s of %s param has no app intent in the DIG",
+					app, WavesParam)
+			}
+			delete(apps, app)
+		}
+		planned = append(planned, wave)
+	}
+	rest := make([]string, 0, len(apps))
+	for app := range apps {
+		rest = append(rest, app)
+	}
+	sort.Strings(rest)
+	levels, err := dependencyWaves(rest, migParam.AppDependencies)
+	if err != nil {
+		return nil, err
+	}
+	for _, level := range levels {
+		if waves != nil {
+			planned = append(planned, level)
+			continue
+		}
+		for _, app := range level {
+			planned = append(planned, []string{app})
+		}
+	}
+	if err := checkWaveOrder(planned, migParam.AppDependencies); err != nil {
+		return nil, err
+	}
+	return planned, nil
+}
+
+// waveApps returns the apps of the wave in progress, or nil if the
+// migration is not in waves.
+func (migParam MigParam) waveApps() []string {
+	if migParam.Wave < 1 || migParam.Wave > len(migParam.Waves) {
+		return nil
+	}
+	return migParam.Waves[migParam.Wave-1]
+}
+
+// forWave returns a copy of migParam with the app intents of the apps of
+// the wave in progress only, and without the changes of the waves before.
+func (migParam MigParam) forWave() MigParam {
+	apps := map[string]bool{}
+	for _, app := range migParam.waveApps() {
+		apps[app] = true
+	}
+	pairs := map[string][]AppNameIntentPair{}
+	for gpIntentName, gpiPairs := range migParam.AppNameIntentPairs {
+		for _, pair := range gpiPairs {
+			if apps[pair.AppName] {
+				pairs[gpIntentName] = append(pairs[gpIntentName], pair)
+			}
+		}
+	}
+	migParam.AppNameIntentPairs = pairs
+	migParam.ChangedAppIntents = nil
+	migParam.IntentDiffs = nil
+	return migParam
+}
+
+// CheckWaveHealth GETs the HealthCheckURLParam URL of each app of the wave
+// in progress, and fails unless each returns a 2xx status. The error lists
+// the apps that failed, and is retryable, so the activity's retry policy
+// sets how long a wave may take to become healthy.
+func CheckWaveHealth(ctx context.Context, migParam MigParam) (*MigParam, error) {
+	defer startHeartbeat(ctx).stop()
+
+	params := migParam.InParams
+	template := params[HealthCheckURLParam]
+	if template == "" {
+		return &migParam, nil
+	}
+	replacer := strings.NewReplacer("{provider}", params["targetClusterProvider"],
+		"{cluster}", params["targetClusterName"])
+	failures := []string{}
+	for _, app := range migParam.waveApps() {
+		url := strings.ReplaceAll(replacer.Replace(template), "{app}", app)
+		if err := checkHealth(ctx, url); err != nil {
+			failures = append(failures, "app "+app+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		checkErr := fmt.Errorf("Health check of wave %d failed for %d apps:\n  %s\n",
+			migParam.Wave, len(failures), strings.Join(failures, "\n  "))
+		fmt.Fprintf(os.Stderr, checkErr.Error())
+		return nil, checkErr
+	}
+	fmt.Printf("CheckWaveHealth: wave %d is healthy\n", migParam.Wave)
+	return &migParam, nil
+}
+
+// checkHealth GETs a health check URL, and expects a 2xx status.
+func checkHealth(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP GET failed for URL %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP GET returned status code %s for URL %s",
+			resp.Status, url)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWaveIntents are the app intents of three apps, one of them in two
+// generic placement intents.
+var testWaveIntents = map[string][]AppNameIntentPair{
+	"gpi1": {
+		{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+		{AppName: "operator", AppIntentName: "operator-placement-intent"},
+	},
+	"gpi2": {
+		{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+		{AppName: "sink", AppIntentName: "sink-placement-intent"},
+	},
+}
+
+func TestParseWaves(t *testing.T) {
+	tests := []struct {
+		param string
+		waves [][]string
+		err   string
+	}{
+		{"", nil, ""},
+		{"collectd", [][]string{{"collectd"}}, ""},
+		{"collectd, sink; operator", [][]string{{"collectd", "sink"}, {"operator"}}, ""},
+		{"collectd;;operator", nil, "wave 2 has an empty app name"},
+		{"collectd,", nil, "wave 1 has an empty app name"},
+		{"collectd;operator,collectd", nil, "app collectd is in two waves"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.param, func(t *testing.T) {
+			waves, err := parseWaves(map[string]string{WavesParam: tc.param})
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.waves, waves)
+		})
+	}
+}
+
+func TestPlanWaves(t *testing.T) {
+	tests := []struct {
+		name    string
+		waves   [][]string
+		planned [][]string
+	}{
+		{"one app per wave", nil, [][]string{{"collectd"}, {"operator"}, {"sink"}}},
+		{"all given", [][]string{{"sink"}, {"collectd", "operator"}},
+			[][]string{{"sink"}, {"collectd", "operator"}}},
+		{"rest last", [][]string{{"operator"}},
+			[][]string{{"operator"}, {"collectd", "sink"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			planned, err := planWaves(tc.waves, MigParam{AppNameIntentPairs: testWaveIntents})
+			require.NoError(t, err)
+			assert.Equal(t, tc.planned, planned)
+		})
+	}
+
+	_, err := planWaves([][]string{{"collectd", "nginx"}},
+		MigParam{AppNameIntentPairs: testWaveIntents})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "App nginx of waves param has no app intent")
+}
+
+func TestPlanWavesByDependencies(t *testing.T) {
+	migParam := MigParam{
+		AppNameIntentPairs: testWaveIntents,
+		AppDependencies: map[string][]AppDependency{
+			"collectd": {{App: "sink"}},
+			"operator": {{App: "sink"}},
+		},
+	}
+	tests := []struct {
+		name    string
+		waves   [][]string
+		planned [][]string
+		err     string
+	}{
+		{"one app per wave", nil, [][]string{{"sink"}, {"collectd"}, {"operator"}}, ""},
+		{"rest by dependencies", [][]string{}, [][]string{{"sink"}, {"collectd", "operator"}}, ""},
+		{"all given", [][]string{{"sink"}, {"collectd", "operator"}},
+			[][]string{{"sink"}, {"collectd", "operator"}}, ""},
+		{"dependency later", [][]string{{"collectd"}}, nil,
+			"App collectd of wave 1 depends on app sink, which is not in an earlier wave"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			planned, err := planWaves(tc.waves, migParam)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.planned, planned)
+		})
+	}
+}
+
+func TestForWave(t *testing.T) {
+	migParam := MigParam{
+		AppNameIntentPairs: testWaveIntents,
+		Waves:              [][]string{{"operator", "sink"}, {"collectd"}},
+	}
+	assert.Empty(t, migParam.forWave().AppNameIntentPairs)
+
+	migParam.Wave = 1
+	assert.Equal(t, map[string][]AppNameIntentPair{
+		"gpi1": {{AppName: "operator", AppIntentName: "operator-placement-intent"}},
+		"gpi2": {{AppName: "sink", AppIntentName: "sink-placement-intent"}},
+	}, migParam.forWave().AppNameIntentPairs)
+
+	migParam.Wave = 2
+	wave := migParam.forWave()
+	assert.Equal(t, []string{"gpi1", "gpi2"}, sortedGPIs(wave.AppNameIntentPairs))
+	assert.Equal(t, testWaveIntents, migParam.AppNameIntentPairs)
+}
+
+func TestCheckWaveHealth(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{
+		"GET /health/provider2/cluster2/collectd": {http.StatusOK, ""},
+		"GET /health/provider2/cluster2/operator": {http.StatusServiceUnavailable, ""},
+	})
+	params := emco.inParams()
+	params[HealthCheckURLParam] = emco.URL + "/health/{provider}/{cluster}/{app}"
+	migParam := MigParam{
+		InParams: params,
+		Waves:    [][]string{{"sink"}, {"collectd", "operator", "sink"}},
+		Wave:     2,
+	}
+
+	_, err := runActivity(t, CheckWaveHealth, migParam)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Health check of wave 2 failed for 2 apps")
+	assert.Contains(t, err.Error(), "app operator: HTTP GET returned status code 503")
+	assert.Contains(t, err.Error(), "app sink: HTTP GET returned status code 404")
+	requested := paths(emco.requestsFor(http.MethodGet))
+	sort.Strings(requested)
+	assert.Equal(t, []string{
+		"/health/provider2/cluster2/collectd",
+		"/health/provider2/cluster2/operator",
+		"/health/provider2/cluster2/sink",
+	}, requested)
+
+	migParam.Waves[1] = []string{"collectd"}
+	result, err := runActivity(t, CheckWaveHealth, migParam)
+	require.NoError(t, err)
+	assert.Equal(t, migParam, *result)
+}
+
+func TestCheckWaveHealthNotSet(t *testing.T) {
+	emco := newRecordedEmco(t, map[string]recordedResponse{})
+	migParam := MigParam{InParams: emco.inParams(), Waves: [][]string{{"collectd"}}, Wave: 1}
+
+	_, err := runActivity(t, CheckWaveHealth, migParam)
+	require.NoError(t, err)
+	assert.Empty(t, emco.requests)
+}
@@ -6,6 +6,8 @@ package emcomigrate
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
@@ -15,6 +17,56 @@ import (
 // special name that matches all activities
 const ALL_ACTIVITIES = "all-activities"
 
+// Versions of the EmcoMigrateWorkflow code, as recorded by wf.GetVersion
+// with versionChangeID when a run starts. A run follows the code of the
+// version it recorded for its whole life, even if the worker is upgraded.
+// Runs started before versioning was introduced get wf.DefaultVersion and
+// follow the versionBase code.
+//
+// To change what the workflow does, add a version, make it currentVersion,
+// and put the change under "if version >= newVersion", keeping the old code
+// for older runs. Old versions can be removed once no runs of them are left.
+const (
+	versionChangeID = "emco-migrate-workflow"
+
+	// GetDigAppIntents, UpdateAppIntents and DoDigUpdate, with notifications
+	versionBase wf.Version = 1
+	// search attributes
+	versionSearchAttributes wf.Version = 2
+	// per-DIG lock
+	versionDIGLock wf.Version = 3
+	// no DoDigUpdate if the apps are already on the target cluster
+	versionAlreadyOnTarget wf.Version = 4
+	// one UpdateAppIntents activity per generic placement intent, on request
+	versionUpdatePerGPI wf.Version = 5
+	// PreflightCheck before GetDigAppIntents
+	versionPreflight wf.Version = 6
+	// AddClusterToLogicalCloud and rollback on failure, on request
+	versionRollback wf.Version = 7
+	// intent rewrite rules, checked before any activity
+	versionIntentRewrite wf.Version = 8
+	// clone-dig strategy, on request
+	versionCloneDIG wf.Version = 9
+	// UpdateOtherIntents after UpdateAppIntents
+	versionOtherIntents wf.Version = 10
+	// waves strategy, on request
+	versionWaves wf.Version = 11
+	// GetAppDependencies, and waves by dependencies
+	versionDependencies wf.Version = 12
+	// maintenance windows and notBefore, on request
+	versionMaintenance wf.Version = 13
+	// temporary migrations, which move the apps back, on request
+	versionFailback wf.Version = 14
+	// PreflightCheck before AddClusterToLogicalCloud
+	versionPreflightFirst wf.Version = 15
+	// a temporary migration cancelled before it moves the apps back ends
+	// in PhaseCanceled, with EventMigrationCanceled
+	versionReturnCanceled wf.Version = 16
+
+	// the version of new runs
+	currentVersion = versionReturnCanceled
+)
+
 // Treat this as a const
 var NeededParams = []string{ // parameters needed for this workflow
 	"emcoURL", "project", "compositeApp", "compositeAppVersion", "deploymentIntentGroup",
@@ -25,24 +77,59 @@ var NeededParams = []string{ // parameters needed for this workflow
 // It expects an "all-activities" parameter inside wfParam.InParams that
 // specifies the common retry/timeput policies for all activities. It may
 // have other activity-specific options on top of that.
+// Changes to the activities it runs, or their order, must be versioned, or
+// running workflows will fail to replay; the histories in
+// testdata/histories are replayed by the tests to catch that.
 func EmcoMigrateWorkflow(ctx wf.Context, wfParam *eta.WorkflowParams) (*MigParam, error) {
 	// List all activities for this workflow
 	activityNames := []string{
 		"GetDigAppIntents",
 		"UpdateAppIntents",
 		"DoDigUpdate",
+		"SendNotification",
+		"RequestDIGLock",
+		"PreflightCheck",
+		"AddClusterToLogicalCloud",
+		"RemoveClusterFromLogicalCloud",
+		"RevertAppIntents",
+		"CloneDIG",
+		"VerifyDIG",
+		"TerminateDIG",
+		"DeleteDIGClone",
+		"UpdateOtherIntents",
+		"RevertOtherIntents",
+		"CheckWaveHealth",
+		"GetAppDependencies",
+		"VerifyReturn",
+	}
+
+	// The code version that this run follows
+	version := wf.GetVersion(ctx, versionChangeID, wf.DefaultVersion, currentVersion)
+	workflowVersion := 0 // reported for unversioned runs
+	if version != wf.DefaultVersion {
+		workflowVersion = int(version)
 	}
 
 	// Set current state and define workflow queries
 	currentState := "started" // name of ongoing activity, "started" or "completed"
-	queryType := "current-state"
-	err := wf.SetQueryHandler(ctx, queryType, func() (string, error) {
+	currentWave := ""         // as "2/3", with the waves strategy
+	waitingUntil := ""        // RFC 3339, while waiting for a maintenance window
+	currentStrategy := ""     // once the params are checked
+	err := wf.SetQueryHandler(ctx, CurrentStateQuery, func() (string, error) {
 		return currentState, nil
 	})
 	if err != nil {
 		currentState = "failed to register current state query handler"
 		return nil, err
 	}
+	err = wf.SetQueryHandler(ctx, MigrationStateQuery, func() (MigState, error) {
+		return MigState{State: currentState, WorkflowVersion: workflowVersion,
+			Strategy: currentStrategy, Wave: currentWave, WaitingUntil: waitingUntil}, nil
+	})
+	if err != nil {
+		currentState = "failed to register migration state query handler"
+		return nil, err
+	}
 
 	// Check that we got "all-activities" params
 	all_activities_params, ok := wfParam.ActivityParams[ALL_ACTIVITIES]
@@ -55,6 +142,74 @@ func EmcoMigrateWorkflow(ctx wf.Context, wfParam *eta.WorkflowParams) (*MigParam
 	if err := validateParams(all_activities_params); err != nil {
 		return nil, err
 	}
+	updatePerGPI := false
+	if version >= versionUpdatePerGPI {
+		if _, updatePerGPI, err = updateOptions(all_activities_params); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	addToLogicalCloud, rollbackOnFailure := false, false
+	if version >= versionRollback {
+		if addToLogicalCloud, err = boolParam(all_activities_params,
+			AddToLogicalCloudParam); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+		if rollbackOnFailure, err = boolParam(all_activities_params,
+			RollbackParam); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	if version >= versionIntentRewrite {
+		if _, err := newIntentRule(all_activities_params); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	strategy := StrategyUpdate
+	if version >= versionCloneDIG {
+		if strategy, err = migrationStrategy(all_activities_params); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+		if strategy == StrategyWaves && version < versionWaves {
+			err := fmt.Errorf("Invalid %s param %q: expect %s or %s", StrategyParam,
+				strategy, StrategyUpdate, StrategyCloneDIG)
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	ignoreDependencies := false
+	if version >= versionDependencies {
+		if ignoreDependencies, err = boolParam(all_activities_params,
+			IgnoreDependenciesParam); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	maintenance := &maintenancePlan{} // any time
+	if version >= versionMaintenance {
+		if maintenance, err = newMaintenancePlan(all_activities_params); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	failback := &failbackPlan{} // not temporary
+	if version >= versionFailback {
+		if failback, err = newFailbackPlan(all_activities_params, strategy); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
+	var waves [][]string
+	if strategy == StrategyWaves {
+		if waves, err = parseWaves(all_activities_params); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return nil, err
+		}
+	}
 
 	// Print activity options from the workflow parameters.
 	optsMap := wfParam.ActivityOpts
@@ -70,41 +225,630 @@ func EmcoMigrateWorkflow(ctx wf.Context, wfParam *eta.WorkflowParams) (*MigParam
 		return nil, err
 	}
 
-	migParam := MigParam{InParams: all_activities_params}
+	// Notifications are delivered even if the workflow gets cancelled.
+	notifyCtx, _ := wf.NewDisconnectedContext(ctxMap["SendNotification"])
+	if _, ok := optsMap["SendNotification"]; !ok {
+		notifyCtx = wf.WithActivityOptions(notifyCtx, defaultNotificationOpts)
+	}
+	notify := newNotifier(notifyCtx, all_activities_params)
+	currentStrategy = strategy
+	notify.base.Strategy = strategy
+
+	index := &searchIndexer{} // disabled
+	if version >= versionSearchAttributes {
+		index = newSearchIndexer(ctx, all_activities_params)
+	}
+
+	migParam := MigParam{
+		InParams:        all_activities_params,
+		WorkflowVersion: workflowVersion,
+	}
+	notify.emit(EventMigrationStarted, &migParam, "", nil)
+
+	// fail ends a failed run, after rolling it back if asked to, or else
+	// after cleaning up after it.
+	undo := &rollback{}
+	fail := func(wferr error) (*MigParam, error) {
+		fmt.Fprintf(os.Stderr, wferr.Error())
+		failedState := currentState
+		rolledBack := false
+		if rollbackOnFailure && len(undo.steps) > 0 {
+			currentState = StateRollingBack
+			index.setPhase(currentState)
+			if err := undo.run(true); err != nil {
+				wferr = fmt.Errorf("%s; rollback failed: %s", wferr.Error(), err.Error())
+				fmt.Fprintf(os.Stderr, wferr.Error())
+			} else {
+				rolledBack = true
+			}
+			currentState = failedState
+		} else if undo.hasCleanup() {
+			currentState = StateCleaningUp
+			index.setPhase(currentState)
+			if err := undo.run(false); err != nil {
+				wferr = fmt.Errorf("%s; cleanup failed: %s", wferr.Error(), err.Error())
+				fmt.Fprintf(os.Stderr, wferr.Error())
+			}
+			currentState = failedState
+		}
+		if rolledBack {
+			index.setPhase(PhaseRolledBack)
+		} else {
+			index.setPhase(PhaseFailed)
+		}
+		notify.emit(EventMigrationFailed, &migParam, failedState, wferr)
+		if rolledBack {
+			notify.emit(EventMigrationRolledBack, &migParam, failedState, nil)
+		}
+		notify.wait()
+		return nil, wferr
+	}
+	// The rollback steps run their activities in contexts that are not
+	// cancelled with the workflow.
+	undoCtx := func(activityName string) wf.Context {
+		ctx, _ := wf.NewDisconnectedContext(ctxMap[activityName])
+		return ctx
+	}
+
+	// waitForWindow waits till the migration may change the DIG, and sets
+	// windowCloses to when it must stop.
+	windowCloses := time.Time{} // zero if there are no maintenance windows
+	waitForWindow := func() error {
+		for {
+			now := wf.Now(ctx)
+			opens, closes, err := maintenance.nextWindow(now)
+			if err != nil {
+				return err
+			}
+			if !opens.After(now) {
+				windowCloses = closes
+				return nil
+			}
+			fmt.Printf("EmcoMigrateWorkflow: waiting for the maintenance window "+
+				"at %s\n", opens.Format(time.RFC3339))
+			lastState := currentState
+			currentState = StateWaitingForWindow
+			index.setPhase(currentState)
+			waitingUntil = opens.Format(time.RFC3339)
+			err = wf.Sleep(ctx, opens.Sub(now))
+			currentState, waitingUntil = lastState, ""
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Wait for the window before locking the DIG, so that other migrations
+	// of it may run meanwhile, and again once it is locked.
+	if maintenance.isSet() {
+		if err := waitForWindow(); err != nil {
+			return fail(fmt.Errorf("Failed waiting for a maintenance window: %s",
+				err.Error()))
+		}
+	}
+
+	// Keep other migrations of the DIG out till this one is done.
+	var releaseLock func() // releases the DIG lock, if requested
+	defer func() {
+		if releaseLock != nil {
+			releaseLock()
+		}
+	}()
+	if version >= versionDIGLock {
+		currentState = StateWaitingForLock
+		index.setPhase(currentState)
+		releaseLock, err = lockDIG(ctx, ctxMap["RequestDIGLock"], all_activities_params)
+		if err != nil {
+			return fail(fmt.Errorf("Failed to lock DIG: %s", err.Error()))
+		}
+	}
+	if maintenance.isSet() {
+		if err := waitForWindow(); err != nil {
+			return fail(fmt.Errorf("Failed waiting for a maintenance window: %s",
+				err.Error()))
+		}
+	}
+
+	preflight := func() error {
+		currentState = "PreflightCheck"
+		index.setPhase(currentState)
+		ctx0 := ctxMap["PreflightCheck"]
+		err := wf.ExecuteActivity(ctx0, PreflightCheck, migParam).Get(ctx0, &migParam)
+		if err != nil {
+			return fmt.Errorf("PreflightCheck failed: %s", err.Error())
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+		return nil
+	}
+
+	// A target cluster that CLM does not know is not added to the logical
+	// cloud.
+	if version >= versionPreflightFirst {
+		if err := preflight(); err != nil {
+			return fail(err)
+		}
+	}
+
+	if addToLogicalCloud {
+		currentState = "AddClusterToLogicalCloud"
+		index.setPhase(currentState)
+		undo.push("RemoveClusterFromLogicalCloud", func() error {
+			ctx0 := undoCtx("RemoveClusterFromLogicalCloud")
+			return wf.ExecuteActivity(ctx0, RemoveClusterFromLogicalCloud, migParam).
+				Get(ctx0, &migParam)
+		})
+		ctx0 := ctxMap["AddClusterToLogicalCloud"]
+		err = wf.ExecuteActivity(ctx0, AddClusterToLogicalCloud, migParam).Get(ctx0, &migParam)
+		if err != nil {
+			return fail(fmt.Errorf("AddClusterToLogicalCloud failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+	}
+
+	if version >= versionPreflight && version < versionPreflightFirst {
+		if err := preflight(); err != nil {
+			return fail(err)
+		}
+	}
 
 	currentState = "GetDigAppIntents"
+	index.setPhase(currentState)
 	ctx1 := ctxMap["GetDigAppIntents"]
 	err = wf.ExecuteActivity(ctx1, GetDigAppIntents, migParam).Get(ctx1, &migParam)
 	if err != nil {
-		wferr := fmt.Errorf("GetDigAppIntents failed: %s", err.Error())
-		fmt.Fprintf(os.Stderr, wferr.Error())
-		return nil, wferr
+		return fail(fmt.Errorf("GetDigAppIntents failed: %s", err.Error()))
 	}
+	index.setSourceClusters(migParam.SourceClusters)
+	notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
 
-	currentState = "UpdateAppIntents"
-	ctx2 := ctxMap["UpdateAppIntents"]
-	err = wf.ExecuteActivity(ctx2, UpdateAppIntents, migParam).Get(ctx2, &migParam)
-	if err != nil {
-		wferr := fmt.Errorf("UpdateAppIntents failed: %s", err.Error())
-		fmt.Fprintf(os.Stderr, wferr.Error())
-		return nil, wferr
+	// A clone is instantiated at once, and EMCO deploys its apps in order.
+	if version >= versionDependencies && strategy != StrategyCloneDIG && !ignoreDependencies {
+		currentState = "GetAppDependencies"
+		index.setPhase(currentState)
+		ctx9 := ctxMap["GetAppDependencies"]
+		err = wf.ExecuteActivity(ctx9, GetAppDependencies, migParam).Get(ctx9, &migParam)
+		if err != nil {
+			return fail(fmt.Errorf("GetAppDependencies failed: %s", err.Error()))
+		}
+
+		// The apps that others depend on are moved first, in waves. The
+		// migration state and the events after this one tell the strategy.
+		if strategy == StrategyUpdate && migParam.AppDependencies != nil {
+			levels, err := dependencyWaves(migParam.appNames(), migParam.AppDependencies)
+			if err != nil {
+				return fail(err)
+			}
+			if len(levels) > 1 {
+				strategy, waves = StrategyWaves, levels
+				currentStrategy = strategy
+				notify.base.Strategy = strategy
+				fmt.Printf("EmcoMigrateWorkflow: the apps depend on each other, "+
+					"so the %s strategy moves them in %d waves\n", StrategyUpdate, len(levels))
+			}
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
 	}
 
-	currentState = "DoDigUpdate"
-	ctx3 := ctxMap["DoDigUpdate"]
-	err = wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, &migParam)
-	if err != nil {
-		wferr := fmt.Errorf("DoDigUpdate failed: %s", err.Error())
-		fmt.Fprintf(os.Stderr, wferr.Error())
-		return nil, wferr
+	if strategy == StrategyCloneDIG {
+		currentState = "CloneDIG"
+		index.setPhase(currentState)
+		cloneVerified := false // the clone took over, and is kept
+		undo.pushCleanup("DeleteDIGClone", func() error {
+			if cloneVerified {
+				return nil
+			}
+			ctx4 := undoCtx("DeleteDIGClone")
+			return wf.ExecuteActivity(ctx4, DeleteDIGClone, migParam).Get(ctx4, &migParam)
+		})
+		ctx4 := ctxMap["CloneDIG"]
+		err = wf.ExecuteActivity(ctx4, CloneDIG, migParam).Get(ctx4, &migParam)
+		if err != nil {
+			return fail(fmt.Errorf("CloneDIG failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+		currentState = "VerifyDIG"
+		index.setPhase(currentState)
+		ctx5 := ctxMap["VerifyDIG"]
+		err = wf.ExecuteActivity(ctx5, VerifyDIG, migParam).Get(ctx5, &migParam)
+		if err != nil {
+			return fail(fmt.Errorf("VerifyDIG failed: %s", err.Error()))
+		}
+		cloneVerified = true
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+		currentState = "TerminateDIG"
+		index.setPhase(currentState)
+		ctx6 := ctxMap["TerminateDIG"]
+		err = wf.ExecuteActivity(ctx6, TerminateDIG, migParam).Get(ctx6, &migParam)
+		if err != nil {
+			return fail(fmt.Errorf("TerminateDIG failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+		migParam.Result = ResultMigrated
+	} else if strategy == StrategyWaves {
+		if migParam.Waves, err = planWaves(waves, migParam); err != nil {
+			return fail(err)
+		}
+		// The waves done so far are reverted whether the migration is rolled
+		// back or not.
+		digUpdated := false // DoDigUpdate ran, maybe partly
+		undo.pushCleanup("RevertAppIntents", func() error {
+			ctx2 := undoCtx("RevertAppIntents")
+			err := wf.ExecuteActivity(ctx2, RevertAppIntents, migParam).Get(ctx2, &migParam)
+			if err != nil || !digUpdated {
+				return err
+			}
+			ctx3 := undoCtx("DoDigUpdate")
+			return wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, &migParam)
+		})
+		changed := []string{}
+		diffs := map[string][]string{}
+		for i := range migParam.Waves {
+			migParam.Wave = i + 1
+			currentWave = fmt.Sprintf("%d/%d", i+1, len(migParam.Waves))
+			fmt.Printf("EmcoMigrateWorkflow: wave %s: %s\n", currentWave,
+				strings.Join(migParam.waveApps(), ", "))
+
+			if wait := migParam.dependencyWait(); wait > 0 {
+				currentState = StateWaitingForDependencies
+				index.setPhase(currentState)
+				if err := wf.Sleep(ctx, time.Duration(wait)*time.Second); err != nil {
+					return fail(fmt.Errorf("Wave %s failed waiting for dependencies: %s",
+						currentWave, err.Error()))
+				}
+			}
+
+			// A wave that would start after the window closes waits for the
+			// next one.
+			if !windowCloses.IsZero() && !wf.Now(ctx).Before(windowCloses) {
+				if err := waitForWindow(); err != nil {
+					return fail(fmt.Errorf("Wave %s failed waiting for a maintenance "+
+						"window: %s", currentWave, err.Error()))
+				}
+			}
+
+			currentState = "UpdateAppIntents"
+			index.setPhase(currentState)
+			ctx2 := ctxMap["UpdateAppIntents"]
+			var waveParam MigParam
+			err = wf.ExecuteActivity(ctx2, UpdateAppIntents, migParam.forWave()).
+				Get(ctx2, &waveParam)
+			if err != nil {
+				return fail(fmt.Errorf("Wave %s UpdateAppIntents failed: %s",
+					currentWave, err.Error()))
+			}
+			changed = append(changed, waveParam.ChangedAppIntents...)
+			sort.Strings(changed)
+			for key, diff := range waveParam.IntentDiffs {
+				diffs[key] = diff
+			}
+			migParam.ChangedAppIntents = changed
+			migParam.IntentDiffs = diffs
+			notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+			waveChanged := len(waveParam.ChangedAppIntents) > 0
+
+			// The other intents move with the last wave, when no app is left
+			// on the source clusters.
+			if i == len(migParam.Waves)-1 {
+				currentState = "UpdateOtherIntents"
+				index.setPhase(currentState)
+				undo.pushCleanup("RevertOtherIntents", func() error {
+					ctx7 := undoCtx("RevertOtherIntents")
+					return wf.ExecuteActivity(ctx7, RevertOtherIntents, migParam).
+						Get(ctx7, &migParam)
+				})
+				ctx7 := ctxMap["UpdateOtherIntents"]
+				err = wf.ExecuteActivity(ctx7, UpdateOtherIntents, migParam).Get(ctx7, &migParam)
+				if err != nil {
+					return fail(fmt.Errorf("Wave %s UpdateOtherIntents failed: %s",
+						currentWave, err.Error()))
+				}
+				notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+				waveChanged = waveChanged || len(migParam.OtherIntentSources) > 0
+			}
+
+			if waveChanged {
+				currentState = "DoDigUpdate"
+				index.setPhase(currentState)
+				ctx3 := ctxMap["DoDigUpdate"]
+				digUpdated = true
+				err = wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, &migParam)
+				if err != nil {
+					return fail(fmt.Errorf("Wave %s DoDigUpdate failed: %s",
+						currentWave, err.Error()))
+				}
+				notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+			}
+
+			currentState = "VerifyDIG"
+			index.setPhase(currentState)
+			ctx5 := ctxMap["VerifyDIG"]
+			err = wf.ExecuteActivity(ctx5, VerifyDIG, migParam).Get(ctx5, &migParam)
+			if err != nil {
+				return fail(fmt.Errorf("Wave %s VerifyDIG failed: %s",
+					currentWave, err.Error()))
+			}
+			notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+			if all_activities_params[HealthCheckURLParam] != "" {
+				currentState = "CheckWaveHealth"
+				index.setPhase(currentState)
+				ctx8 := ctxMap["CheckWaveHealth"]
+				err = wf.ExecuteActivity(ctx8, CheckWaveHealth, migParam).Get(ctx8, &migParam)
+				if err != nil {
+					return fail(fmt.Errorf("Wave %s CheckWaveHealth failed: %s",
+						currentWave, err.Error()))
+				}
+				notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+			}
+			notify.emit(EventMigrationWaveCompleted, &migParam, "wave "+currentWave, nil)
+		}
+		currentWave = ""
+		if digUpdated {
+			migParam.Result = ResultMigrated
+		} else {
+			migParam.Result = ResultAlreadyOnTarget
+		}
+	} else {
+		currentState = "UpdateAppIntents"
+		index.setPhase(currentState)
+		ctx2 := ctxMap["UpdateAppIntents"]
+		digUpdated := false // DoDigUpdate ran, maybe partly
+		undo.push("RevertAppIntents", func() error {
+			ctx2 := undoCtx("RevertAppIntents")
+			err := wf.ExecuteActivity(ctx2, RevertAppIntents, migParam).Get(ctx2, &migParam)
+			if err != nil || !digUpdated {
+				return err
+			}
+			ctx3 := undoCtx("DoDigUpdate")
+			return wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, &migParam)
+		})
+		if updatePerGPI {
+			err = updateAppIntentsPerGPI(ctx2, &migParam)
+		} else {
+			err = wf.ExecuteActivity(ctx2, UpdateAppIntents, migParam).Get(ctx2, &migParam)
+		}
+		if err != nil {
+			return fail(fmt.Errorf("UpdateAppIntents failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+		if version >= versionOtherIntents {
+			currentState = "UpdateOtherIntents"
+			index.setPhase(currentState)
+			undo.push("RevertOtherIntents", func() error {
+				ctx7 := undoCtx("RevertOtherIntents")
+				return wf.ExecuteActivity(ctx7, RevertOtherIntents, migParam).
+					Get(ctx7, &migParam)
+			})
+			ctx7 := ctxMap["UpdateOtherIntents"]
+			err = wf.ExecuteActivity(ctx7, UpdateOtherIntents, migParam).Get(ctx7, &migParam)
+			if err != nil {
+				return fail(fmt.Errorf("UpdateOtherIntents failed: %s", err.Error()))
+			}
+			notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+		}
+
+		if version >= versionAlreadyOnTarget && migParam.AlreadyOnTarget {
+			fmt.Printf("EmcoMigrateWorkflow: apps are already on the target cluster, " +
+				"skipping DoDigUpdate\n")
+			migParam.Result = ResultAlreadyOnTarget
+		} else {
+			currentState = "DoDigUpdate"
+			index.setPhase(currentState)
+			ctx3 := ctxMap["DoDigUpdate"]
+			digUpdated = true
+			err = wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, &migParam)
+			if err != nil {
+				return fail(fmt.Errorf("DoDigUpdate failed: %s", err.Error()))
+			}
+			notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+			if version >= versionAlreadyOnTarget {
+				migParam.Result = ResultMigrated
+			}
+		}
+	}
+
+	// A temporary migration moves the apps back once told to, or once its
+	// time is up, to the placement that GetDigAppIntents recorded.
+	succeeded := EventMigrationSucceeded
+	if failback.temporary && migParam.Result == ResultMigrated {
+		notify.emit(EventMigrationSucceeded, &migParam, "", nil)
+		succeeded = EventMigrationReturned
+
+		// Other migrations of the DIG may run meanwhile.
+		if releaseLock != nil {
+			releaseLock()
+			releaseLock = nil
+		}
+		currentState = StateWaitingToReturn
+		index.setPhase(currentState)
+		timerCtx, cancelTimer := wf.WithCancel(ctx)
+		selector := wf.NewSelector(ctx)
+		selector.AddReceive(wf.GetSignalChannel(ctx, ReturnSignal),
+			func(c wf.ReceiveChannel, more bool) {
+				c.Receive(ctx, nil)
+				fmt.Printf("EmcoMigrateWorkflow: got %s signal\n", ReturnSignal)
+			})
+		if failback.after > 0 {
+			waitingUntil = wf.Now(ctx).Add(failback.after).Format(time.RFC3339)
+			selector.AddFuture(wf.NewTimer(timerCtx, failback.after),
+				func(f wf.Future) {})
+		}
+		selector.AddReceive(ctx.Done(), func(c wf.ReceiveChannel, more bool) {})
+		selector.Select(ctx)
+		cancelTimer()
+		waitingUntil = ""
+		if err := ctx.Err(); err != nil {
+			// Cancelled: the apps stay on the target cluster.
+			if version >= versionReturnCanceled {
+				currentState = PhaseCanceled
+				index.setPhase(PhaseCanceled)
+				notify.emit(EventMigrationCanceled, &migParam, StateWaitingToReturn, err)
+			} else {
+				currentState = "completed"
+				index.setPhase(PhaseCompleted)
+			}
+			notify.wait()
+			return nil, err
+		}
+		notify.emit(EventMigrationReturning, &migParam, "", nil)
+
+		// The way back waits for the maintenance windows and the DIG lock
+		// as the migration did, and is undone as it would be.
+		if maintenance.isSet() {
+			if err := waitForWindow(); err != nil {
+				return fail(fmt.Errorf("Failed waiting for a maintenance window: %s",
+					err.Error()))
+			}
+		}
+		// The way back always waits for the lock, since failing fast would
+		// leave the apps on the target cluster for good.
+		returnParams := make(map[string]string, len(all_activities_params))
+		for name, value := range all_activities_params {
+			returnParams[name] = value
+		}
+		returnParams[DigLockParam] = DigLockWait
+		currentState = StateWaitingForLock
+		index.setPhase(currentState)
+		releaseLock, err = lockDIG(ctx, ctxMap["RequestDIGLock"], returnParams)
+		if err != nil {
+			return fail(fmt.Errorf("Failed to lock DIG: %s", err.Error()))
+		}
+		if maintenance.isSet() {
+			if err := waitForWindow(); err != nil {
+				return fail(fmt.Errorf("Failed waiting for a maintenance window: %s",
+					err.Error()))
+			}
+		}
+		undo = &rollback{}
+
+		// The apps go back only if the DIG is as the migration left it.
+		currentState = "GetDigAppIntents"
+		index.setPhase(currentState)
+		current := MigParam{InParams: all_activities_params, WorkflowVersion: workflowVersion}
+		err = wf.ExecuteActivity(ctx1, GetDigAppIntents, current).Get(ctx1, &current)
+		if err != nil {
+			return fail(fmt.Errorf("GetDigAppIntents failed: %s", err.Error()))
+		}
+		if changed := current.changedSince(migParam); len(changed) > 0 {
+			return fail(fmt.Errorf("Cannot move the apps back: app intents %s "+
+				"changed since the migration", strings.Join(changed, ", ")))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+		currentState = "RevertAppIntents"
+		index.setPhase(currentState)
+		digUpdated := false // DoDigUpdate ran, maybe partly
+		undo.push("UpdateAppIntents", func() error {
+			ctx2 := undoCtx("UpdateAppIntents")
+			err := wf.ExecuteActivity(ctx2, UpdateAppIntents, current).Get(ctx2, nil)
+			if err != nil || !digUpdated {
+				return err
+			}
+			ctx3 := undoCtx("DoDigUpdate")
+			return wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, nil)
+		})
+		ctx10 := ctxMap["RevertAppIntents"]
+		err = wf.ExecuteActivity(ctx10, RevertAppIntents, migParam).Get(ctx10, nil)
+		if err != nil {
+			return fail(fmt.Errorf("RevertAppIntents failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+		if len(migParam.OtherIntentSources) > 0 {
+			currentState = "RevertOtherIntents"
+			index.setPhase(currentState)
+			undo.push("UpdateOtherIntents", func() error {
+				ctx7 := undoCtx("UpdateOtherIntents")
+				return wf.ExecuteActivity(ctx7, UpdateOtherIntents, migParam).Get(ctx7, nil)
+			})
+			ctx11 := ctxMap["RevertOtherIntents"]
+			err = wf.ExecuteActivity(ctx11, RevertOtherIntents, migParam).Get(ctx11, nil)
+			if err != nil {
+				return fail(fmt.Errorf("RevertOtherIntents failed: %s", err.Error()))
+			}
+			notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+		}
+
+		currentState = "DoDigUpdate"
+		index.setPhase(currentState)
+		ctx3 := ctxMap["DoDigUpdate"]
+		digUpdated = true
+		err = wf.ExecuteActivity(ctx3, DoDigUpdate, migParam).Get(ctx3, nil)
+		if err != nil {
+			return fail(fmt.Errorf("DoDigUpdate failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+
+		currentState = "VerifyReturn"
+		index.setPhase(currentState)
+		ctx12 := ctxMap["VerifyReturn"]
+		err = wf.ExecuteActivity(ctx12, VerifyReturn, migParam).Get(ctx12, nil)
+		if err != nil {
+			return fail(fmt.Errorf("VerifyReturn failed: %s", err.Error()))
+		}
+		notify.emit(EventMigrationStepCompleted, &migParam, currentState, nil)
+		migParam.Result = ResultReturned
 	}
 	currentState = "completed"
+	index.setPhase(PhaseCompleted)
 
 	fmt.Printf("After all activities: migParam = %#v\n", migParam)
 
+	notify.emit(succeeded, &migParam, "", nil)
+	notify.wait()
+
 	return &migParam, nil
 }
 
+// updateAppIntentsPerGPI runs an UpdateAppIntents activity for each generic
+// placement intent at the same time, and merges their results into
+// migParam. It waits for all of them, and the error lists the generic
+// placement intents that failed.
+func updateAppIntentsPerGPI(ctx wf.Context, migParam *MigParam) error {
+	gpIntentNames := sortedGPIs(migParam.AppNameIntentPairs) // in a deterministic order
+	if len(gpIntentNames) <= 1 {
+		return wf.ExecuteActivity(ctx, UpdateAppIntents, *migParam).Get(ctx, migParam)
+	}
+
+	futures := make([]wf.Future, len(gpIntentNames))
+	for i, gpIntentName := range gpIntentNames {
+		gpiParam := *migParam
+		gpiParam.AppNameIntentPairs = map[string][]AppNameIntentPair{
+			gpIntentName: migParam.AppNameIntentPairs[gpIntentName],
+		}
+		futures[i] = wf.ExecuteActivity(ctx, UpdateAppIntents, gpiParam)
+	}
+
+	changed := []string{}
+	diffs := map[string][]string{}
+	alreadyOnTarget := true
+	failures := []string{}
+	for i, future := range futures {
+		var result MigParam
+		if err := future.Get(ctx, &result); err != nil {
+			failures = append(failures, gpIntentNames[i]+": "+err.Error())
+			continue
+		}
+		changed = append(changed, result.ChangedAppIntents...)
+		for key, diff := range result.IntentDiffs {
+			diffs[key] = diff
+		}
+		alreadyOnTarget = alreadyOnTarget && result.AlreadyOnTarget
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d generic placement intents failed:\n%s",
+			len(failures), len(gpIntentNames), strings.Join(failures, "\n"))
+	}
+	sort.Strings(changed)
+	migParam.ChangedAppIntents = changed
+	migParam.IntentDiffs = diffs
+	migParam.AlreadyOnTarget = alreadyOnTarget
+	return nil
+}
+
 // getActivityContextMap returns a list of Temporal contexts for each activity.
 // Note that this is generic code that is independent of user's app/workflows.
 func getActivityContextMap(ctx wf.Context, activityNames []string,
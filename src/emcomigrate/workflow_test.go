@@ -0,0 +1,1624 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package emcomigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	wf "go.temporal.io/sdk/workflow"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/fakeemco"
+)
+
+type WorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+	// the requests for the DIG lock, in turn
+	lockRequests []LockRequest
+}
+
+func TestWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(WorkflowTestSuite))
+}
+
+func (s *WorkflowTestSuite) SetupTest() {
+	s.newEnv()
+	s.passPreflight()
+	s.passOtherIntents()
+	s.passDependencies()
+}
+
+// newEnv sets up a new test environment, where the DIG lock is free.
+func (s *WorkflowTestSuite) newEnv() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.lockRequests = nil
+	s.env.OnActivity(RequestDIGLock, mock.Anything, testLockID, "proj1/capp1/v1/dig1",
+		mock.Anything).Return(
+		func(ctx context.Context, lockID, dig string, req LockRequest) error {
+			s.lockRequests = append(s.lockRequests, req)
+			s.env.SignalWorkflow(LockGrantSignal, LockGrant{Granted: true})
+			return nil
+		}).Maybe()
+	s.env.OnSignalExternalWorkflow(mock.Anything, testLockID, "", ReleaseLockSignal,
+		mock.Anything).Return(nil).Maybe()
+}
+
+// passPreflight makes the PreflightCheck activity pass.
+func (s *WorkflowTestSuite) passPreflight() {
+	s.env.OnActivity(PreflightCheck, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			return &migParam, nil
+		}).Maybe()
+}
+
+// passOtherIntents makes the UpdateOtherIntents activity find nothing to
+// change, and so RevertOtherIntents too.
+func (s *WorkflowTestSuite) passOtherIntents() {
+	pass := func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+		return &migParam, nil
+	}
+	s.env.OnActivity(UpdateOtherIntents, mock.Anything, mock.Anything).Return(pass).Maybe()
+	s.env.OnActivity(RevertOtherIntents, mock.Anything, mock.Anything).Return(pass).Maybe()
+}
+
+// passDependencies makes the GetAppDependencies activity find no
+// dependencies.
+func (s *WorkflowTestSuite) passDependencies() {
+	s.env.OnActivity(GetAppDependencies, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			return &migParam, nil
+		}).Maybe()
+}
+
+const testLockID = "dig-lock/proj1/capp1/v1/dig1"
+
+func (s *WorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+func testInParams() map[string]string {
+	return map[string]string{
+		"emcoURL":               "http://emco:30415",
+		"project":               "proj1",
+		"compositeApp":          "capp1",
+		"compositeAppVersion":   "v1",
+		"deploymentIntentGroup": "dig1",
+		"targetClusterProvider": "provider2",
+		"targetClusterName":     "cluster2",
+	}
+}
+
+func testWorkflowParams() *eta.WorkflowParams {
+	return &eta.WorkflowParams{
+		ActivityOpts: map[string]wf.ActivityOptions{
+			ALL_ACTIVITIES: {StartToCloseTimeout: 10 * time.Second},
+		},
+		ActivityParams: map[string]map[string]string{
+			ALL_ACTIVITIES: testInParams(),
+		},
+	}
+}
+
+// testMigParam returns what GetDigAppIntents finds for testInParams.
+func testMigParam(inParams map[string]string) *MigParam {
+	return &MigParam{
+		InParams: inParams,
+		GenericPlacementIntentURL: "http://emco:30415/v2/projects/proj1/composite-apps/" +
+			"capp1/v1/deployment-intent-groups/dig1/generic-placement-intents",
+		AppNameIntentPairs: map[string][]AppNameIntentPair{
+			"dig1-placement-intent": {
+				{AppName: "collectd", AppIntentName: "collectd-placement-intent"},
+			},
+		},
+		SourceClusters:  []string{"provider1+cluster1"},
+		WorkflowVersion: int(currentVersion),
+	}
+}
+
+// queryState returns the state in the migration-state query result.
+func (s *WorkflowTestSuite) queryState() string {
+	return s.queryMigState().State
+}
+
+func (s *WorkflowTestSuite) queryMigState() MigState {
+	value, err := s.env.QueryWorkflow(MigrationStateQuery)
+	s.NoError(err)
+	state, err := DecodeMigState(value)
+	s.NoError(err)
+	return state
+}
+
+func (s *WorkflowTestSuite) Test_Success() {
+	found := testMigParam(testInParams())
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			s.Equal(testInParams(), migParam.InParams)
+			return found, nil
+		}).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	migrated := *found
+	migrated.Result = ResultMigrated
+	s.Equal(migrated, result)
+	s.Equal(MigState{State: "completed", WorkflowVersion: int(currentVersion),
+		Strategy: StrategyUpdate},
+		s.queryMigState())
+}
+
+func (s *WorkflowTestSuite) Test_AlreadyOnTarget() {
+	found := testMigParam(testInParams())
+	updated := *found
+	updated.AlreadyOnTarget = true
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(&updated, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultAlreadyOnTarget, result.Result)
+	s.Equal("completed", s.queryState())
+}
+
+func (s *WorkflowTestSuite) Test_AlreadyOnTargetBeforeVersion() {
+	s.env.OnGetVersion(versionChangeID, wf.DefaultVersion, currentVersion).
+		Return(versionDIGLock)
+	found := testMigParam(testInParams())
+	found.WorkflowVersion = int(versionDIGLock)
+	updated := *found
+	updated.AlreadyOnTarget = true
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(&updated, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, updated).Return(&updated, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Empty(result.Result)
+}
+
+func (s *WorkflowTestSuite) Test_UnversionedRun() {
+	// as when replaying a run started before versioning was introduced
+	s.env.OnGetVersion(versionChangeID, wf.DefaultVersion, currentVersion).
+		Return(wf.DefaultVersion)
+	found := testMigParam(testInParams())
+	found.WorkflowVersion = 0
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil)
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil)
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil)
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(0, result.WorkflowVersion)
+	s.Equal(MigState{State: "completed", WorkflowVersion: 0,
+		Strategy: StrategyUpdate}, s.queryMigState())
+}
+
+func (s *WorkflowTestSuite) Test_CurrentStateQuery() {
+	found := testMigParam(testInParams())
+	states := []string{}
+	recordState := func() {
+		// the state name only, as before MigState
+		value, err := s.env.QueryWorkflow(CurrentStateQuery)
+		s.NoError(err)
+		var state string
+		s.NoError(value.Get(&state))
+		states = append(states, state)
+	}
+
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			recordState()
+			return found, nil
+		})
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			recordState()
+			return found, nil
+		})
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			recordState()
+			return found, nil
+		})
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.NoError(s.env.GetWorkflowError())
+	recordState()
+	s.Equal([]string{"GetDigAppIntents", "UpdateAppIntents", "DoDigUpdate", "completed"},
+		states)
+}
+
+// expectPhases expects the search attributes to be upserted as a run with
+// testInParams goes through the given phases.
+func (s *WorkflowTestSuite) expectPhases(phases ...string) {
+	// upserted by wf.GetVersion
+	s.env.OnUpsertSearchAttributes(map[string]interface{}{
+		"TemporalChangeVersion": []string{versionChangeID + "-" + strconv.Itoa(int(currentVersion))},
+	}).Return(nil).Once()
+	s.env.OnUpsertSearchAttributes(map[string]interface{}{
+		SearchAttrProject:        "proj1",
+		SearchAttrCompositeApp:   "capp1",
+		SearchAttrDIG:            "dig1",
+		SearchAttrTargetCluster:  "provider2+cluster2",
+		SearchAttrMigrationPhase: PhaseStarted,
+	}).Return(nil).Once()
+	for _, phase := range phases {
+		s.env.OnUpsertSearchAttributes(map[string]interface{}{
+			SearchAttrMigrationPhase: phase,
+		}).Return(nil).Once()
+	}
+}
+
+func (s *WorkflowTestSuite) Test_SearchAttributes() {
+	EnableSearchAttributes(true)
+	defer EnableSearchAttributes(false)
+	found := testMigParam(testInParams())
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil)
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil)
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil)
+	s.expectPhases(StateWaitingForLock, "PreflightCheck", "GetDigAppIntents",
+		"GetAppDependencies", "UpdateAppIntents", "UpdateOtherIntents", "DoDigUpdate",
+		PhaseCompleted)
+	s.env.OnUpsertSearchAttributes(map[string]interface{}{
+		SearchAttrSourceCluster: []string{"provider1+cluster1"},
+	}).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuite) Test_SearchAttributesFailure() {
+	EnableSearchAttributes(true)
+	defer EnableSearchAttributes(false)
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		nil, errors.New("EMCO is down"))
+	s.expectPhases(StateWaitingForLock, "PreflightCheck", "GetDigAppIntents", PhaseFailed)
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.Error(s.env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuite) Test_DIGLockWait() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.passPreflight()
+	s.passOtherIntents()
+	s.passDependencies()
+	found := testMigParam(testInParams())
+	s.env.OnActivity(RequestDIGLock, mock.Anything, testLockID, "proj1/capp1/v1/dig1",
+		LockRequest{WorkflowID: "default-test-workflow-id", RunID: "default-test-run-id"}).
+		Return(nil).Once()
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(StateWaitingForLock, s.queryState())
+		s.env.SignalWorkflow(LockGrantSignal, LockGrant{Granted: true})
+	}, time.Hour)
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnSignalExternalWorkflow(mock.Anything, testLockID, "", ReleaseLockSignal,
+		LockRequest{WorkflowID: "default-test-workflow-id", RunID: "default-test-run-id"}).
+		Return(nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuite) Test_DIGLockFailFast() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.passPreflight()
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][DigLockParam] = DigLockFailFast
+	s.env.OnActivity(RequestDIGLock, mock.Anything, testLockID, "proj1/capp1/v1/dig1",
+		LockRequest{WorkflowID: "default-test-workflow-id", RunID: "default-test-run-id",
+			FailFast: true}).Return(
+		func(ctx context.Context, lockID, dig string, req LockRequest) error {
+			s.env.SignalWorkflow(LockGrantSignal, LockGrant{Holder: "migrate-apps-1"})
+			return nil
+		}).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "DIG proj1/capp1/v1/dig1 is being migrated by workflow migrate-apps-1")
+	s.Equal(StateWaitingForLock, s.queryState())
+}
+
+func (s *WorkflowTestSuite) Test_DIGLockInvalidMode() {
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][DigLockParam] = "never"
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "Invalid digLock param")
+}
+
+func (s *WorkflowTestSuite) Test_DIGLockReleasedOnFailure() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.passPreflight()
+	s.env.OnActivity(RequestDIGLock, mock.Anything, mock.Anything, mock.Anything,
+		mock.Anything).Return(
+		func(ctx context.Context, lockID, dig string, req LockRequest) error {
+			s.env.SignalWorkflow(LockGrantSignal, LockGrant{Granted: true})
+			return nil
+		}).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil))
+	s.env.OnSignalExternalWorkflow(mock.Anything, testLockID, "", ReleaseLockSignal,
+		mock.Anything).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+	s.Error(s.env.GetWorkflowError())
+}
+
+// testPerGPIParams returns the params of a migration with one
+// UpdateAppIntents activity per generic placement intent, and what
+// GetDigAppIntents finds for it: two generic placement intents.
+func testPerGPIParams() (*eta.WorkflowParams, *MigParam) {
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][UpdatePerGPIParam] = "true"
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	found.AppNameIntentPairs["other-placement-intent"] = []AppNameIntentPair{
+		{AppName: "operator", AppIntentName: "operator-placement-intent"},
+	}
+	return params, found
+}
+
+// gpiParam returns migParam with only the given generic placement intent.
+func gpiParam(migParam *MigParam, gpIntentName string) MigParam {
+	result := *migParam
+	result.AppNameIntentPairs = map[string][]AppNameIntentPair{
+		gpIntentName: migParam.AppNameIntentPairs[gpIntentName],
+	}
+	return result
+}
+
+func (s *WorkflowTestSuite) Test_UpdatePerGPI() {
+	params, found := testPerGPIParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	for _, gpi := range []string{"other-placement-intent", "dig1-placement-intent"} {
+		updated := gpiParam(found, gpi)
+		updated.ChangedAppIntents = []string{gpi + "/" +
+			found.AppNameIntentPairs[gpi][0].AppIntentName}
+		s.env.OnActivity(UpdateAppIntents, mock.Anything, gpiParam(found, gpi)).
+			Return(&updated, nil).Once()
+	}
+	merged := *found
+	merged.ChangedAppIntents = []string{
+		"dig1-placement-intent/collectd-placement-intent",
+		"other-placement-intent/operator-placement-intent",
+	}
+	s.env.OnActivity(DoDigUpdate, mock.Anything, merged).Return(&merged, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(merged.ChangedAppIntents, result.ChangedAppIntents)
+	s.Equal(ResultMigrated, result.Result)
+}
+
+func (s *WorkflowTestSuite) Test_UpdatePerGPIAlreadyOnTarget() {
+	params, found := testPerGPIParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	for _, gpi := range []string{"dig1-placement-intent", "other-placement-intent"} {
+		updated := gpiParam(found, gpi)
+		updated.AlreadyOnTarget = true
+		s.env.OnActivity(UpdateAppIntents, mock.Anything, gpiParam(found, gpi)).
+			Return(&updated, nil).Once()
+	}
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultAlreadyOnTarget, result.Result)
+}
+
+func (s *WorkflowTestSuite) Test_UpdatePerGPIFailure() {
+	params, found := testPerGPIParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything,
+		gpiParam(found, "dig1-placement-intent")).Return(
+		nil, temporal.NewNonRetryableApplicationError(
+			"Failed to update 1 app intents: app collectd", "test", nil)).Once()
+	other := gpiParam(found, "other-placement-intent")
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, other).Return(&other, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "UpdateAppIntents failed: 1 of 2 generic placement intents failed")
+	s.Contains(err.Error(), "dig1-placement-intent: ")
+	s.Contains(err.Error(), "app collectd")
+	s.NotContains(err.Error(), "other-placement-intent")
+}
+
+func (s *WorkflowTestSuite) Test_InvalidParams() {
+	for param, value := range map[string]string{
+		UpdatePerGPIParam:        "maybe",
+		UpdateParallelismParam:   "many",
+		AddToLogicalCloudParam:   "yes",
+		RollbackParam:            "always",
+		IntentRewriteParam:       "swap",
+		ReplaceClusterParam:      "cluster1",
+		StrategyParam:            "move",
+		MaintenanceWindowsParam:  "0 2 * * SAT",
+		MaintenanceTimeZoneParam: "Mars/Olympus",
+		NotBeforeParam:           "tomorrow",
+		TemporaryParam:           "maybe",
+		ReturnAfterParam:         "soon",
+	} {
+		s.Run(param, func() {
+			s.newEnv()
+			params := testWorkflowParams()
+			params.ActivityParams[ALL_ACTIVITIES][param] = value
+			if param == ReplaceClusterParam {
+				params.ActivityParams[ALL_ACTIVITIES][IntentRewriteParam] =
+					IntentRewriteReplaceCluster
+			}
+			s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Never()
+
+			s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+			err := s.env.GetWorkflowError()
+			s.Error(err)
+			s.Contains(err.Error(), "Invalid "+param+" param")
+			s.env.AssertExpectations(s.T())
+		})
+	}
+}
+
+// testRollbackParams returns workflow params that add the target cluster
+// to the logical cloud and roll back on failure, and what
+// AddClusterToLogicalCloud and GetDigAppIntents find with them.
+func testRollbackParams() (*eta.WorkflowParams, *MigParam, *MigParam) {
+	params := testWorkflowParams()
+	inParams := params.ActivityParams[ALL_ACTIVITIES]
+	inParams[AddToLogicalCloudParam] = "true"
+	inParams[RollbackParam] = "true"
+	added := &MigParam{
+		InParams:              inParams,
+		WorkflowVersion:       int(currentVersion),
+		LogicalCloud:          "default",
+		AddedClusterReference: "provider2-cluster2",
+	}
+	found := testMigParam(inParams)
+	found.LogicalCloud = added.LogicalCloud
+	found.AddedClusterReference = added.AddedClusterReference
+	return params, added, found
+}
+
+func (s *WorkflowTestSuite) Test_AddToLogicalCloud() {
+	params, added, found := testRollbackParams()
+	s.env.OnActivity(AddClusterToLogicalCloud, mock.Anything, mock.Anything).Return(
+		added, nil).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, *added).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(RemoveClusterFromLogicalCloud, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal("provider2-cluster2", result.AddedClusterReference)
+}
+
+func (s *WorkflowTestSuite) Test_AddToLogicalCloudAfterPreflight() {
+	// A mistyped target cluster is not added to the logical cloud.
+	s.newEnv()
+	params, _, _ := testRollbackParams()
+	s.env.OnActivity(PreflightCheck, mock.Anything, mock.Anything).Return(nil,
+		temporal.NewNonRetryableApplicationError("Cluster cluster9 of provider "+
+			"provider2 does not exist", PreflightClusterNotFound, nil)).Once()
+	s.env.OnActivity(AddClusterToLogicalCloud, mock.Anything, mock.Anything).Never()
+	s.env.OnActivity(RemoveClusterFromLogicalCloud, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "PreflightCheck failed")
+	s.Contains(err.Error(), "does not exist")
+}
+
+func (s *WorkflowTestSuite) Test_Rollback() {
+	EnableSearchAttributes(true)
+	defer EnableSearchAttributes(false)
+	params, added, found := testRollbackParams()
+	s.env.OnActivity(AddClusterToLogicalCloud, mock.Anything, mock.Anything).Return(
+		added, nil).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil)).Once()
+	// undone in reverse order, with the DIG updated again
+	s.env.OnActivity(RevertAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+	removed := *found
+	removed.AddedClusterReference = ""
+	s.env.OnActivity(RemoveClusterFromLogicalCloud, mock.Anything, *found).Return(
+		&removed, nil).Once()
+	s.expectPhases(StateWaitingForLock, "PreflightCheck", "AddClusterToLogicalCloud",
+		"GetDigAppIntents", "GetAppDependencies", "UpdateAppIntents", "UpdateOtherIntents",
+		"DoDigUpdate", StateRollingBack, PhaseRolledBack)
+	s.env.OnUpsertSearchAttributes(map[string]interface{}{
+		SearchAttrSourceCluster: []string{"provider1+cluster1"},
+	}).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "DoDigUpdate failed")
+	s.NotContains(err.Error(), "rollback failed")
+	s.Equal("DoDigUpdate", s.queryState())
+}
+
+func (s *WorkflowTestSuite) Test_RollbackFailure() {
+	params, added, found := testRollbackParams()
+	s.env.OnActivity(AddClusterToLogicalCloud, mock.Anything, mock.Anything).Return(
+		added, nil).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil)).Once()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, *found).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is still down", "test", nil)).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Never()
+	// not removed while the apps may need it
+	s.env.OnActivity(RemoveClusterFromLogicalCloud, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "UpdateAppIntents failed")
+	s.Contains(err.Error(), "; rollback failed: RevertAppIntents failed")
+	s.Contains(err.Error(), "EMCO is still down")
+}
+
+func (s *WorkflowTestSuite) Test_RollbackNotRequested() {
+	params, added, found := testRollbackParams()
+	delete(params.ActivityParams[ALL_ACTIVITIES], RollbackParam)
+	s.env.OnActivity(AddClusterToLogicalCloud, mock.Anything, mock.Anything).Return(
+		added, nil).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil)).Once()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, mock.Anything).Never()
+	s.env.OnActivity(RemoveClusterFromLogicalCloud, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.NotContains(err.Error(), "rollback")
+}
+
+func (s *WorkflowTestSuite) Test_RollbackOtherIntents() {
+	s.newEnv()
+	s.passPreflight()
+	s.passDependencies()
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][RollbackParam] = "true"
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	updated := *found
+	updated.OtherIntentSources = map[string]json.RawMessage{
+		"generic-k8s-intents/gki1/resources/r1/customizations/c1": json.RawMessage(`{}`),
+	}
+	updated.IntentReport = []IntentReport{{
+		Intent:  "generic-k8s-intents/gki1/resources/r1/customizations/c1",
+		Changes: []string{"spec.clusterInfo: provider1+cluster1 -> provider2+cluster2"},
+	}}
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(UpdateOtherIntents, mock.Anything, *found).Return(&updated, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, updated).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil)).Once()
+	// the other intents first, then the app intents, then the DIG update
+	reverted := updated
+	reverted.OtherIntentSources = nil
+	var order []string
+	s.env.OnActivity(RevertOtherIntents, mock.Anything, updated).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			order = append(order, "RevertOtherIntents")
+			return &reverted, nil
+		}).Once()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			order = append(order, "RevertAppIntents")
+			return &reverted, nil
+		}).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Return(&reverted, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "DoDigUpdate failed")
+	s.NotContains(err.Error(), "rollback failed")
+	s.Equal([]string{"RevertOtherIntents", "RevertAppIntents"}, order)
+}
+
+// testCloneParams returns workflow params for the clone-dig strategy, and
+// what GetDigAppIntents and CloneDIG find with them.
+func testCloneParams() (*eta.WorkflowParams, *MigParam, *MigParam) {
+	params := testWorkflowParams()
+	inParams := params.ActivityParams[ALL_ACTIVITIES]
+	inParams[StrategyParam] = StrategyCloneDIG
+	found := testMigParam(inParams)
+	cloned := testMigParam(inParams)
+	cloned.CloneDIG = "dig1-cluster2"
+	cloned.ChangedAppIntents = []string{"dig1-placement-intent/collectd-placement-intent"}
+	return params, found, cloned
+}
+
+func (s *WorkflowTestSuite) Test_CloneDIG() {
+	EnableSearchAttributes(true)
+	defer EnableSearchAttributes(false)
+	params, found, cloned := testCloneParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(CloneDIG, mock.Anything, *found).Return(cloned, nil).Once()
+	s.env.OnActivity(VerifyDIG, mock.Anything, *cloned).Return(cloned, nil).Once()
+	s.env.OnActivity(TerminateDIG, mock.Anything, *cloned).Return(cloned, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Never()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Never()
+	s.env.OnActivity(DeleteDIGClone, mock.Anything, mock.Anything).Never()
+	s.expectPhases(StateWaitingForLock, "PreflightCheck", "GetDigAppIntents", "CloneDIG",
+		"VerifyDIG", "TerminateDIG", PhaseCompleted)
+	s.env.OnUpsertSearchAttributes(map[string]interface{}{
+		SearchAttrSourceCluster: []string{"provider1+cluster1"},
+	}).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal("dig1-cluster2", result.CloneDIG)
+	s.Equal(ResultMigrated, result.Result)
+}
+
+func (s *WorkflowTestSuite) Test_CloneDIGVerifyFailure() {
+	// The clone is deleted even without rollbackOnFailure.
+	params, found, cloned := testCloneParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(CloneDIG, mock.Anything, *found).Return(cloned, nil).Once()
+	s.env.OnActivity(VerifyDIG, mock.Anything, *cloned).Return(
+		nil, temporal.NewNonRetryableApplicationError("deployment failed",
+			DeploymentFailed, nil)).Once()
+	deleted := *cloned
+	deleted.CloneDIG = ""
+	s.env.OnActivity(DeleteDIGClone, mock.Anything, *cloned).Return(&deleted, nil).Once()
+	s.env.OnActivity(TerminateDIG, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "VerifyDIG failed")
+	s.NotContains(err.Error(), "cleanup failed")
+	s.Equal("VerifyDIG", s.queryState())
+}
+
+func (s *WorkflowTestSuite) Test_CloneDIGCleanupFailure() {
+	params, found, _ := testCloneParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(CloneDIG, mock.Anything, *found).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil)).Once()
+	// A failed CloneDIG may have created the clone.
+	s.env.OnActivity(DeleteDIGClone, mock.Anything, *found).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is still down", "test", nil)).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "CloneDIG failed")
+	s.Contains(err.Error(), "; cleanup failed: DeleteDIGClone failed")
+}
+
+func (s *WorkflowTestSuite) Test_CloneDIGTerminateFailure() {
+	// The verified clone is kept.
+	params, found, cloned := testCloneParams()
+	params.ActivityParams[ALL_ACTIVITIES][RollbackParam] = "true"
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(CloneDIG, mock.Anything, *found).Return(cloned, nil).Once()
+	s.env.OnActivity(VerifyDIG, mock.Anything, *cloned).Return(cloned, nil).Once()
+	s.env.OnActivity(TerminateDIG, mock.Anything, *cloned).Return(
+		nil, temporal.NewNonRetryableApplicationError("EMCO is down", "test", nil)).Once()
+	s.env.OnActivity(DeleteDIGClone, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "TerminateDIG failed")
+}
+
+func (s *WorkflowTestSuite) Test_MissingAllActivitiesParams() {
+	params := testWorkflowParams()
+	params.ActivityParams = map[string]map[string]string{
+		"GetDigAppIntents": testInParams(),
+	}
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.True(s.env.IsWorkflowCompleted())
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "expect all-activities parameters")
+}
+
+func (s *WorkflowTestSuite) Test_MissingNeededParam() {
+	params := testWorkflowParams()
+	delete(params.ActivityParams[ALL_ACTIVITIES], "targetClusterName")
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "targetClusterName")
+}
+
+func (s *WorkflowTestSuite) Test_InvalidActivityName() {
+	params := testWorkflowParams()
+	params.ActivityOpts["NoSuchActivity"] = wf.ActivityOptions{
+		StartToCloseTimeout: time.Second,
+	}
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "Invalid activity name in params: NoSuchActivity")
+}
+
+func (s *WorkflowTestSuite) Test_ActivityFailure() {
+	steps := []struct {
+		name     string
+		activity interface{}
+	}{
+		{"PreflightCheck", PreflightCheck},
+		{"GetDigAppIntents", GetDigAppIntents},
+		{"GetAppDependencies", GetAppDependencies},
+		{"UpdateAppIntents", UpdateAppIntents},
+		{"UpdateOtherIntents", UpdateOtherIntents},
+		{"DoDigUpdate", DoDigUpdate},
+	}
+	for failed, step := range steps {
+		s.Run(step.name, func() {
+			s.newEnv()
+			found := testMigParam(testInParams())
+			for i, other := range steps {
+				switch {
+				case i < failed:
+					s.env.OnActivity(other.activity, mock.Anything, mock.Anything).
+						Return(found, nil).Once()
+				case i == failed:
+					s.env.OnActivity(other.activity, mock.Anything, mock.Anything).
+						Return(nil, temporal.NewNonRetryableApplicationError(
+							"EMCO is down", "test", nil)).Once()
+				default:
+					s.env.OnActivity(other.activity, mock.Anything, mock.Anything).
+						Return(found, nil).Never()
+				}
+			}
+
+			s.env.ExecuteWorkflow(EmcoMigrateWorkflow, testWorkflowParams())
+
+			s.True(s.env.IsWorkflowCompleted())
+			err := s.env.GetWorkflowError()
+			s.Error(err)
+			s.Contains(err.Error(), step.name+" failed")
+			s.Contains(err.Error(), "EMCO is down")
+			s.Equal(step.name, s.queryState())
+			s.env.AssertExpectations(s.T())
+		})
+	}
+}
+
+func (s *WorkflowTestSuite) Test_ActivityRetries() {
+	params := testWorkflowParams()
+	params.ActivityOpts[ALL_ACTIVITIES] = wf.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 3},
+	}
+	found := testMigParam(testInParams())
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).
+		Return(nil, errors.New("connection refused")).Twice()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).
+		Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Return(found, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+}
+
+// activityOptionsWorkflow returns the activity options that
+// getActivityContextMap gives to each activity.
+func activityOptionsWorkflow(ctx wf.Context, activityNames []string,
+	optsMap map[string]wf.ActivityOptions) (map[string]wf.ActivityOptions, error) {
+
+	ctxMap, err := getActivityContextMap(ctx, activityNames, optsMap)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]wf.ActivityOptions, len(ctxMap))
+	for name, actCtx := range ctxMap {
+		result[name] = wf.GetActivityOptions(actCtx)
+	}
+	return result, nil
+}
+
+func (s *WorkflowTestSuite) activityOptions(activityNames []string,
+	optsMap map[string]wf.ActivityOptions) (map[string]wf.ActivityOptions, error) {
+
+	s.env.RegisterWorkflow(activityOptionsWorkflow)
+	s.env.ExecuteWorkflow(activityOptionsWorkflow, activityNames, optsMap)
+	if err := s.env.GetWorkflowError(); err != nil {
+		return nil, err
+	}
+	var result map[string]wf.ActivityOptions
+	s.NoError(s.env.GetWorkflowResult(&result))
+	return result, nil
+}
+
+func (s *WorkflowTestSuite) Test_ActivityOptionsPrecedence() {
+	names := []string{"GetDigAppIntents", "UpdateAppIntents", "DoDigUpdate"}
+	opts, err := s.activityOptions(names, map[string]wf.ActivityOptions{
+		ALL_ACTIVITIES: {StartToCloseTimeout: 30 * time.Second},
+		"DoDigUpdate": {
+			StartToCloseTimeout: 5 * time.Minute,
+			HeartbeatTimeout:    10 * time.Second,
+		},
+	})
+	s.NoError(err)
+
+	s.Equal(30*time.Second, opts["GetDigAppIntents"].StartToCloseTimeout)
+	s.Equal(30*time.Second, opts["UpdateAppIntents"].StartToCloseTimeout)
+	// Activity-specific options replace the all-activities options.
+	s.Equal(5*time.Minute, opts["DoDigUpdate"].StartToCloseTimeout)
+	s.Equal(10*time.Second, opts["DoDigUpdate"].HeartbeatTimeout)
+	s.Equal(time.Duration(0), opts["UpdateAppIntents"].HeartbeatTimeout)
+}
+
+func (s *WorkflowTestSuite) Test_ActivityOptionsDefault() {
+	names := []string{"GetDigAppIntents", "UpdateAppIntents"}
+	opts, err := s.activityOptions(names, map[string]wf.ActivityOptions{
+		"UpdateAppIntents": {StartToCloseTimeout: 2 * time.Minute},
+	})
+	s.NoError(err)
+
+	s.Equal(time.Minute, opts["GetDigAppIntents"].StartToCloseTimeout)
+	s.Equal(2*time.Minute, opts["UpdateAppIntents"].StartToCloseTimeout)
+}
+
+func (s *WorkflowTestSuite) Test_ActivityOptionsInvalidName() {
+	names := []string{"GetDigAppIntents"}
+	_, err := s.activityOptions(names, map[string]wf.ActivityOptions{
+		ALL_ACTIVITIES:    {StartToCloseTimeout: time.Minute},
+		"GetDigAppIntent": {StartToCloseTimeout: time.Minute},
+	})
+	s.Error(err)
+	s.Contains(err.Error(), "Invalid activity name in params: GetDigAppIntent")
+}
+
+// testWavesParams returns the params of a migration with the waves strategy
+// and a health check, and what GetDigAppIntents finds for them: two apps,
+// and so two waves.
+func testWavesParams() (*eta.WorkflowParams, *MigParam) {
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][StrategyParam] = StrategyWaves
+	params.ActivityParams[ALL_ACTIVITIES][HealthCheckURLParam] = "http://health/{app}"
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	found.AppNameIntentPairs["dig1-placement-intent"] = append(
+		found.AppNameIntentPairs["dig1-placement-intent"],
+		AppNameIntentPair{AppName: "operator", AppIntentName: "operator-placement-intent"})
+	return params, found
+}
+
+// onWaveActivity mocks a wave activity to record its calls, as
+// "activity wave", and to return its migParam, or the given error in the
+// given wave.
+func (s *WorkflowTestSuite) onWaveActivity(activity interface{}, name string, calls *[]string,
+	failWave int, err error) {
+
+	s.env.OnActivity(activity, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			*calls = append(*calls, fmt.Sprintf("%s %d", name, migParam.Wave))
+			if migParam.Wave == failWave {
+				return nil, err
+			}
+			return &migParam, nil
+		})
+}
+
+// onUpdateWave mocks UpdateAppIntents to record its calls, as
+// "UpdateAppIntents wave", and to change the app intents of the wave.
+func (s *WorkflowTestSuite) onUpdateWave(calls *[]string) {
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			*calls = append(*calls, fmt.Sprintf("UpdateAppIntents %d", migParam.Wave))
+			for gpIntentName, pairs := range migParam.AppNameIntentPairs {
+				for _, pair := range pairs {
+					key := gpIntentName + "/" + pair.AppIntentName
+					migParam.ChangedAppIntents = append(migParam.ChangedAppIntents, key)
+				}
+			}
+			return &migParam, nil
+		})
+}
+
+func (s *WorkflowTestSuite) Test_Waves() {
+	params, found := testWavesParams()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	calls := []string{}
+	s.onUpdateWave(&calls)
+	s.onWaveActivity(DoDigUpdate, "DoDigUpdate", &calls, 0, nil)
+	s.env.OnActivity(VerifyDIG, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			state := s.queryMigState()
+			calls = append(calls, fmt.Sprintf("VerifyDIG %d %s", migParam.Wave, state.Wave))
+			return &migParam, nil
+		}).Twice()
+	s.onWaveActivity(CheckWaveHealth, "CheckWaveHealth", &calls, 0, nil)
+	s.env.OnActivity(RevertAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		"UpdateAppIntents 1", "DoDigUpdate 1", "VerifyDIG 1 1/2", "CheckWaveHealth 1",
+		"UpdateAppIntents 2", "DoDigUpdate 2", "VerifyDIG 2 2/2", "CheckWaveHealth 2",
+	}, calls)
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultMigrated, result.Result)
+	s.Equal([][]string{{"collectd"}, {"operator"}}, result.Waves)
+	s.Equal([]string{"dig1-placement-intent/collectd-placement-intent",
+		"dig1-placement-intent/operator-placement-intent"}, result.ChangedAppIntents)
+	s.Equal(found.AppNameIntentPairs, result.AppNameIntentPairs)
+	s.Equal(MigState{State: "completed", WorkflowVersion: int(currentVersion),
+		Strategy: StrategyWaves},
+		s.queryMigState())
+}
+
+func (s *WorkflowTestSuite) Test_WavesHealthCheckFailure() {
+	// The waves done are reverted even without rollbackOnFailure.
+	params, found := testWavesParams()
+	params.ActivityParams[ALL_ACTIVITIES][WavesParam] = "operator"
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	calls := []string{}
+	s.onUpdateWave(&calls)
+	s.onWaveActivity(DoDigUpdate, "DoDigUpdate", &calls, 0, nil)
+	s.onWaveActivity(VerifyDIG, "VerifyDIG", &calls, 0, nil)
+	s.onWaveActivity(CheckWaveHealth, "CheckWaveHealth", &calls, 2,
+		temporal.NewNonRetryableApplicationError("collectd is down", "test", nil))
+	s.onWaveActivity(RevertAppIntents, "RevertAppIntents", &calls, 0, nil)
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "Wave 2/2 CheckWaveHealth failed")
+	s.NotContains(err.Error(), "cleanup failed")
+	s.Equal([]string{
+		"UpdateAppIntents 1", "DoDigUpdate 1", "VerifyDIG 1", "CheckWaveHealth 1",
+		"UpdateAppIntents 2", "DoDigUpdate 2", "VerifyDIG 2", "CheckWaveHealth 2",
+		"RevertAppIntents 2", "DoDigUpdate 2",
+	}, calls)
+	s.Equal(MigState{State: "CheckWaveHealth", WorkflowVersion: int(currentVersion),
+		Strategy: StrategyWaves, Wave: "2/2"}, s.queryMigState())
+}
+
+func (s *WorkflowTestSuite) Test_WavesInvalid() {
+	params, found := testWavesParams()
+	params.ActivityParams[ALL_ACTIVITIES][WavesParam] = "collectd;nginx"
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "App nginx of waves param has no app intent in the DIG")
+}
+
+func (s *WorkflowTestSuite) Test_DependencyOrder() {
+	// operator depends on collectd, so it is moved after collectd is ready
+	// on the target cluster.
+	s.newEnv()
+	s.passPreflight()
+	s.passOtherIntents()
+	params, found := testWavesParams()
+	delete(params.ActivityParams[ALL_ACTIVITIES], StrategyParam)
+	delete(params.ActivityParams[ALL_ACTIVITIES], HealthCheckURLParam)
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	dependencies := map[string][]AppDependency{
+		"operator": {{App: "collectd", OpStatus: "Ready", Wait: 10}},
+	}
+	s.env.OnActivity(GetAppDependencies, mock.Anything, *found).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			migParam.AppDependencies = dependencies
+			return &migParam, nil
+		}).Once()
+	calls := []string{}
+	s.onUpdateWave(&calls)
+	s.onWaveActivity(DoDigUpdate, "DoDigUpdate", &calls, 0, nil)
+	s.onWaveActivity(VerifyDIG, "VerifyDIG", &calls, 0, nil)
+	s.env.SetOnTimerScheduledListener(func(timerID string, duration time.Duration) {
+		calls = append(calls, fmt.Sprintf("%s %s", s.queryState(), duration))
+	})
+	// The events tell that the update strategy was turned into waves.
+	params.ActivityParams[ALL_ACTIVITIES][NotifyURLsParam] = "http://receiver"
+	events := []string{}
+	s.env.OnActivity(SendNotification, mock.Anything, "http://receiver", mock.Anything).Return(
+		func(ctx context.Context, url string, event CloudEvent) error {
+			events = append(events, strings.TrimSpace(fmt.Sprintf("%s %s %s",
+				event.Data.Strategy, event.Type, event.Data.Step)))
+			return nil
+		})
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		"UpdateAppIntents 1", "DoDigUpdate 1", "VerifyDIG 1",
+		StateWaitingForDependencies + " 10s",
+		"UpdateAppIntents 2", "DoDigUpdate 2", "VerifyDIG 2",
+	}, calls)
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultMigrated, result.Result)
+	s.Equal([][]string{{"collectd"}, {"operator"}}, result.Waves)
+	s.Equal(dependencies, result.AppDependencies)
+	s.Equal(StrategyWaves, s.queryMigState().Strategy)
+	s.Equal([]string{
+		"update migration.started",
+		"update migration.step.completed PreflightCheck",
+		"update migration.step.completed GetDigAppIntents",
+		"waves migration.step.completed GetAppDependencies",
+	}, events[:4])
+}
+
+func (s *WorkflowTestSuite) Test_IgnoreDependencies() {
+	s.newEnv()
+	s.passPreflight()
+	s.passOtherIntents()
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][IgnoreDependenciesParam] = "true"
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(GetAppDependencies, mock.Anything, mock.Anything).Never()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuite) Test_DependencyCycle() {
+	s.newEnv()
+	s.passPreflight()
+	params, found := testWavesParams()
+	delete(params.ActivityParams[ALL_ACTIVITIES], StrategyParam)
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(GetAppDependencies, mock.Anything, *found).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			migParam.AppDependencies = map[string][]AppDependency{
+				"collectd": {{App: "operator"}},
+				"operator": {{App: "collectd"}},
+			}
+			return &migParam, nil
+		}).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "Apps collectd, operator depend on each other")
+}
+
+func (s *WorkflowTestSuite) Test_MaintenanceWindowWait() {
+	// a Saturday, an hour before the window
+	s.env.SetStartTime(time.Date(2022, 6, 4, 1, 0, 0, 0, time.UTC))
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][MaintenanceWindowsParam] = "0 4 * * SAT 4h"
+	params.ActivityParams[ALL_ACTIVITIES][MaintenanceTimeZoneParam] = "Europe/Paris"
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	calls := []string{}
+	s.env.SetOnTimerScheduledListener(func(timerID string, duration time.Duration) {
+		state := s.queryMigState()
+		calls = append(calls, fmt.Sprintf("%s %s %s", state.State, state.WaitingUntil,
+			duration))
+	})
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			calls = append(calls, "GetDigAppIntents "+s.env.Now().UTC().Format(time.RFC3339))
+			return found, nil
+		}).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Return(found, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		StateWaitingForWindow + " 2022-06-04T02:00:00Z 1h0m0s",
+		"GetDigAppIntents 2022-06-04T02:00:00Z",
+	}, calls)
+	s.Equal(MigState{State: "completed", WorkflowVersion: int(currentVersion),
+		Strategy: StrategyUpdate},
+		s.queryMigState())
+}
+
+func (s *WorkflowTestSuite) Test_NotBefore() {
+	s.env.SetStartTime(time.Date(2022, 6, 4, 1, 0, 0, 0, time.UTC))
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][NotBeforeParam] = "2022-06-04T01:30:00Z"
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	timers := []time.Duration{}
+	s.env.SetOnTimerScheduledListener(func(timerID string, duration time.Duration) {
+		timers = append(timers, duration)
+	})
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Return(found, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]time.Duration{30 * time.Minute}, timers)
+}
+
+func (s *WorkflowTestSuite) Test_WavesPauseAtWindowEnd() {
+	// The window closes while the first wave is waited for, so the second
+	// wave waits for the window of the next day.
+	s.newEnv()
+	s.passPreflight()
+	s.passOtherIntents()
+	s.env.SetStartTime(time.Date(2022, 6, 4, 2, 0, 0, 0, time.UTC))
+	params, found := testWavesParams()
+	delete(params.ActivityParams[ALL_ACTIVITIES], HealthCheckURLParam)
+	params.ActivityParams[ALL_ACTIVITIES][MaintenanceWindowsParam] = "0 2 * * * 1m"
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(GetAppDependencies, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			migParam.AppDependencies = map[string][]AppDependency{
+				"operator": {{App: "collectd", Wait: 120}},
+			}
+			return &migParam, nil
+		})
+	calls := []string{}
+	s.onUpdateWave(&calls)
+	s.onWaveActivity(DoDigUpdate, "DoDigUpdate", &calls, 0, nil)
+	s.onWaveActivity(VerifyDIG, "VerifyDIG", &calls, 0, nil)
+	s.env.SetOnTimerScheduledListener(func(timerID string, duration time.Duration) {
+		state := s.queryMigState()
+		calls = append(calls, fmt.Sprintf("%s %s %s", state.State, state.Wave, duration))
+	})
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		"UpdateAppIntents 1", "DoDigUpdate 1", "VerifyDIG 1",
+		StateWaitingForDependencies + " 2/2 2m0s",
+		StateWaitingForWindow + " 2/2 23h58m0s",
+		"UpdateAppIntents 2", "DoDigUpdate 2", "VerifyDIG 2",
+	}, calls)
+}
+
+// testTemporaryParams returns workflow params for a temporary migration
+// with the given param, what GetDigAppIntents finds with them, what it
+// finds once the apps are on the target cluster, and the migration that
+// moves them back.
+func testTemporaryParams(param, value string) (*eta.WorkflowParams, *MigParam, *MigParam,
+	*MigParam) {
+
+	params := testWorkflowParams()
+	params.ActivityParams[ALL_ACTIVITIES][param] = value
+	found := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	onTarget := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	onTarget.AppNameIntentPairs["dig1-placement-intent"][0].OnTarget = true
+	onTarget.SourceClusters = []string{"provider2+cluster2"}
+	migrated := testMigParam(params.ActivityParams[ALL_ACTIVITIES])
+	migrated.Result = ResultMigrated
+	return params, found, onTarget, migrated
+}
+
+// onTemporary mocks the activities of a temporary migration that moves the
+// apps there and back, and records them with the time that they run.
+func (s *WorkflowTestSuite) onTemporary(found, onTarget, migrated *MigParam,
+	calls *[]string) {
+
+	record := func(name string, result *MigParam) func(context.Context,
+		MigParam) (*MigParam, error) {
+		return func(ctx context.Context, migParam MigParam) (*MigParam, error) {
+			*calls = append(*calls, name+" "+s.env.Now().UTC().Format(time.RFC3339))
+			return result, nil
+		}
+	}
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		record("GetDigAppIntents", found)).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		record("GetDigAppIntents", onTarget)).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(
+		record("UpdateAppIntents", found)).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(
+		record("DoDigUpdate", found)).Once()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, *migrated).Return(
+		record("RevertAppIntents", migrated)).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *migrated).Return(
+		record("DoDigUpdate", migrated)).Once()
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryReturnAfter() {
+	s.env.SetStartTime(time.Date(2022, 6, 4, 1, 0, 0, 0, time.UTC))
+	params, found, onTarget, migrated := testTemporaryParams(ReturnAfterParam, "72h")
+	calls := []string{}
+	s.onTemporary(found, onTarget, migrated, &calls)
+	s.env.OnActivity(VerifyReturn, mock.Anything, *migrated).Return(migrated, nil).Once()
+	s.env.SetOnTimerScheduledListener(func(timerID string, duration time.Duration) {
+		state := s.queryMigState()
+		calls = append(calls, fmt.Sprintf("%s %s %s", state.State, state.WaitingUntil,
+			duration))
+	})
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		"GetDigAppIntents 2022-06-04T01:00:00Z",
+		"UpdateAppIntents 2022-06-04T01:00:00Z",
+		"DoDigUpdate 2022-06-04T01:00:00Z",
+		StateWaitingToReturn + " 2022-06-07T01:00:00Z 72h0m0s",
+		"GetDigAppIntents 2022-06-07T01:00:00Z",
+		"RevertAppIntents 2022-06-07T01:00:00Z",
+		"DoDigUpdate 2022-06-07T01:00:00Z",
+	}, calls)
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	returned := *found
+	returned.Result = ResultReturned
+	s.Equal(returned, result)
+	s.Equal(MigState{State: "completed", WorkflowVersion: int(currentVersion),
+		Strategy: StrategyUpdate},
+		s.queryMigState())
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryReturnSignal() {
+	s.env.SetStartTime(time.Date(2022, 6, 4, 1, 0, 0, 0, time.UTC))
+	params, found, onTarget, migrated := testTemporaryParams(TemporaryParam, "true")
+	calls := []string{}
+	s.onTemporary(found, onTarget, migrated, &calls)
+	s.env.OnActivity(VerifyReturn, mock.Anything, *migrated).Return(migrated, nil).Once()
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(MigState{State: StateWaitingToReturn,
+			WorkflowVersion: int(currentVersion), Strategy: StrategyUpdate}, s.queryMigState())
+		s.env.SignalWorkflow(ReturnSignal, nil)
+	}, 5*time.Hour)
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal("RevertAppIntents 2022-06-04T06:00:00Z", calls[4])
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultReturned, result.Result)
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryReturnWaitsForLock() {
+	params, found, onTarget, migrated := testTemporaryParams(TemporaryParam, "true")
+	params.ActivityParams[ALL_ACTIVITIES][DigLockParam] = DigLockFailFast
+	calls := []string{}
+	s.onTemporary(found, onTarget, migrated, &calls)
+	s.env.OnActivity(VerifyReturn, mock.Anything, *migrated).Return(migrated, nil).Once()
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ReturnSignal, nil)
+	}, time.Hour)
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	// The migration fails fast, but the way back waits.
+	s.Require().Len(s.lockRequests, 2)
+	s.True(s.lockRequests[0].FailFast)
+	s.False(s.lockRequests[1].FailFast)
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultReturned, result.Result)
+	s.Equal(DigLockFailFast, result.InParams[DigLockParam])
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryAlreadyOnTarget() {
+	params, found, _, _ := testTemporaryParams(TemporaryParam, "true")
+	updated := *found
+	updated.AlreadyOnTarget = true
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(&updated, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, mock.Anything).Never()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultAlreadyOnTarget, result.Result)
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryCancel() {
+	params, found, _, _ := testTemporaryParams(TemporaryParam, "true")
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, mock.Anything).Never()
+	s.env.RegisterDelayedCallback(s.env.CancelWorkflow, time.Hour)
+	// The migration does not report that it completed.
+	EnableSearchAttributes(true)
+	defer EnableSearchAttributes(false)
+	s.expectPhases(StateWaitingForLock, "PreflightCheck", "GetDigAppIntents",
+		"GetAppDependencies", "UpdateAppIntents", "UpdateOtherIntents", "DoDigUpdate",
+		StateWaitingToReturn, PhaseCanceled)
+	s.env.OnUpsertSearchAttributes(map[string]interface{}{
+		SearchAttrSourceCluster: []string{"provider1+cluster1"},
+	}).Return(nil).Once()
+	params.ActivityParams[ALL_ACTIVITIES][NotifyURLsParam] = "http://receiver"
+	events := []string{}
+	s.env.OnActivity(SendNotification, mock.Anything, "http://receiver", mock.Anything).Return(
+		func(ctx context.Context, url string, event CloudEvent) error {
+			events = append(events, event.Type+" "+event.Data.Step)
+			return nil
+		})
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	var canceledErr *temporal.CanceledError
+	s.True(errors.As(err, &canceledErr))
+	s.Equal(PhaseCanceled, s.queryState())
+	s.Equal([]string{EventMigrationSucceeded + " ",
+		EventMigrationCanceled + " " + StateWaitingToReturn}, events[len(events)-2:])
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryChangedMeanwhile() {
+	params, found, onTarget, _ := testTemporaryParams(ReturnAfterParam, "1h")
+	onTarget.AppNameIntentPairs["dig1-placement-intent"][0].OnTarget = false
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(found, nil).Once()
+	s.env.OnActivity(GetDigAppIntents, mock.Anything, mock.Anything).Return(
+		onTarget, nil).Once()
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *found).Return(found, nil).Once()
+	s.env.OnActivity(RevertAppIntents, mock.Anything, mock.Anything).Never()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "Cannot move the apps back: app intents "+
+		"dig1-placement-intent/collectd-placement-intent changed since the migration")
+}
+
+func (s *WorkflowTestSuite) Test_TemporaryReturnRollback() {
+	params, found, onTarget, migrated := testTemporaryParams(ReturnAfterParam, "1h")
+	params.ActivityParams[ALL_ACTIVITIES][RollbackParam] = "true"
+	calls := []string{}
+	s.onTemporary(found, onTarget, migrated, &calls)
+	s.env.OnActivity(VerifyReturn, mock.Anything, *migrated).Return(nil,
+		temporal.NewNonRetryableApplicationError("collectd failed", DeploymentFailed,
+			nil)).Once()
+	// moved to the target cluster again
+	s.env.OnActivity(UpdateAppIntents, mock.Anything, *onTarget).Return(
+		onTarget, nil).Once()
+	s.env.OnActivity(DoDigUpdate, mock.Anything, *migrated).Return(migrated, nil).Once()
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "VerifyReturn failed")
+	s.NotContains(err.Error(), "rollback failed")
+	s.Equal("VerifyReturn", s.queryState())
+}
+
+// useFakeEmco sets up a new test environment where the activities of the
+// workflow run against a fake EMCO, loaded with samples/intents: the DIG
+// dig1 is instantiated with collectd on provider1+cluster1. It returns the
+// fake, and workflow params that migrate the DIG to provider2+cluster2.
+func (s *WorkflowTestSuite) useFakeEmco() (*fakeemco.Server, *eta.WorkflowParams) {
+	s.newFakeEmcoEnv()
+	emco := fakeemco.NewServer(fakeemco.Options{})
+	dir := filepath.Join("..", "..", "samples", "intents")
+	values, err := fakeemco.ReadValues(filepath.Join(dir, "values-1app-2clusters.yaml"))
+	s.Require().NoError(err)
+	s.Require().NoError(emco.LoadIntentFiles(values,
+		filepath.Join(dir, "00.define-clusters-proj.yaml"),
+		filepath.Join(dir, "01.instantiate-lc.yaml"),
+		filepath.Join(dir, "02.define-app-dig.yaml"),
+		filepath.Join(dir, "03.instantiate-dig.yaml")))
+	server := httptest.NewServer(emco)
+	s.T().Cleanup(server.Close)
+
+	params := testWorkflowParams()
+	params.ActivityOpts[ALL_ACTIVITIES] = wf.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 2},
+	}
+	params.ActivityParams[ALL_ACTIVITIES]["emcoURL"] = server.URL
+	return emco, params
+}
+
+// newFakeEmcoEnv sets up a new test environment, where the activities call
+// EMCO.
+func (s *WorkflowTestSuite) newFakeEmcoEnv() {
+	s.newEnv()
+	for _, activity := range []interface{}{AddClusterToLogicalCloud, PreflightCheck,
+		GetDigAppIntents, UpdateAppIntents, UpdateOtherIntents, DoDigUpdate,
+		CloneDIG, VerifyDIG, VerifyReturn, TerminateDIG, DeleteDIGClone,
+		RevertAppIntents, RevertOtherIntents, CheckWaveHealth, GetAppDependencies,
+		RemoveClusterFromLogicalCloud, SendNotification} {
+		s.env.RegisterActivity(activity)
+	}
+}
+
+// fakeDigClusters returns the clusters that each app of dig1 is deployed
+// on in the fake EMCO, as provider+cluster.
+func (s *WorkflowTestSuite) fakeDigClusters(emco *fakeemco.Server) map[string][]string {
+	status := s.fakeDigStatus(emco)
+	s.Equal(fakeemco.StateInstantiated, status.DeployedStatus)
+	clusters := map[string][]string{}
+	for _, app := range status.Apps {
+		clusters[app.Name] = []string{}
+		for _, c := range app.Clusters {
+			clusters[app.Name] = append(clusters[app.Name],
+				c.ClusterProvider+"+"+c.Cluster)
+		}
+	}
+	return clusters
+}
+
+// fakeDigActions returns the state changes of dig1 in the fake EMCO.
+func (s *WorkflowTestSuite) fakeDigActions(emco *fakeemco.Server) []fakeemco.StateAction {
+	return s.fakeDigStatus(emco).States.Actions
+}
+
+func (s *WorkflowTestSuite) fakeDigStatus(emco *fakeemco.Server) fakeemco.DigStatus {
+	_, result, err := emco.Do(http.MethodGet,
+		strings.TrimPrefix(testDigPath, "/v2/")+"/status", nil)
+	s.Require().NoError(err)
+	return result.(fakeemco.DigStatus)
+}
+
+func (s *WorkflowTestSuite) Test_FakeEmco() {
+	emco, params := s.useFakeEmco()
+	s.Equal(map[string][]string{"collectd": {"provider1+cluster1"}},
+		s.fakeDigClusters(emco))
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultMigrated, result.Result)
+	s.Equal(map[string][]string{"collectd": {"provider2+cluster2"}},
+		s.fakeDigClusters(emco))
+
+	_, intent, err := emco.Do(http.MethodGet,
+		strings.TrimPrefix(testAppPath, "/v2/")+"/collectd-placement-intent", nil)
+	s.NoError(err)
+	s.Equal(map[string]interface{}{"allOf": []interface{}{map[string]interface{}{
+		"clusterProvider": "provider2", "cluster": "cluster2"}}},
+		intent.(map[string]interface{})["spec"].(map[string]interface{})["intent"])
+}
+
+func (s *WorkflowTestSuite) Test_FakeEmcoRunAgain() {
+	emco, params := s.useFakeEmco()
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+	s.NoError(s.env.GetWorkflowError())
+	updates := len(s.fakeDigActions(emco))
+
+	// The apps are on the target cluster now: the pre-flight checks pass,
+	// and the DIG is not updated again.
+	s.newFakeEmcoEnv()
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	s.NoError(s.env.GetWorkflowError())
+	var result MigParam
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ResultAlreadyOnTarget, result.Result)
+	s.True(result.AlreadyOnTarget)
+	s.Empty(result.ChangedAppIntents)
+	s.Len(s.fakeDigActions(emco), updates)
+	s.Equal(map[string][]string{"collectd": {"provider2+cluster2"}},
+		s.fakeDigClusters(emco))
+}
+
+func (s *WorkflowTestSuite) Test_FakeEmcoRollback() {
+	EnableSearchAttributes(true)
+	defer EnableSearchAttributes(false)
+	emco, params := s.useFakeEmco()
+	params.ActivityParams[ALL_ACTIVITIES][RollbackParam] = "true"
+	params.ActivityOpts["UpdateAppIntents"] = wf.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+	}
+	// The app intent is updated, but the response is lost.
+	_, err := emco.AddFault(fakeemco.FaultRule{Method: http.MethodPut,
+		Path: "app-intents/", Nth: 1, Status: http.StatusInternalServerError,
+		ApplyBeforeError: true})
+	s.Require().NoError(err)
+	s.expectPhases(StateWaitingForLock, "PreflightCheck", "GetDigAppIntents",
+		"GetAppDependencies", "UpdateAppIntents", StateRollingBack, PhaseRolledBack)
+
+	s.env.ExecuteWorkflow(EmcoMigrateWorkflow, params)
+
+	err = s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "UpdateAppIntents failed")
+	s.NotContains(err.Error(), "rollback failed")
+	// RevertAppIntents put the app intent back, with a second PUT.
+	s.Equal(2, emco.Faults()[0].Matched)
+	_, intent, err := emco.Do(http.MethodGet,
+		strings.TrimPrefix(testAppPath, "/v2/")+"/collectd-placement-intent", nil)
+	s.NoError(err)
+	s.Equal(map[string]interface{}{"allOf": []interface{}{map[string]interface{}{
+		"clusterProvider": "provider1", "clusterLabel": "edge-cluster"}}},
+		intent.(map[string]interface{})["spec"].(map[string]interface{})["intent"])
+	s.Equal(map[string][]string{"collectd": {"provider1+cluster1"}},
+		s.fakeDigClusters(emco))
+}
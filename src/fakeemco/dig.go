@@ -0,0 +1,442 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package fakeemco
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DIG states, as reported by EMCO
+const (
+	StateCreated      = "Created"
+	StateApproved     = "Approved"
+	StateInstantiated = "Instantiated"
+	StateTerminated   = "Terminated"
+)
+
+// Per-cluster deployment and readiness states
+const (
+	DeployedApplied = "Applied"
+	DeployedDeleted = "Deleted"
+	DeployedFailed  = "Failed"
+	ReadyReady      = "Ready"
+	ReadyNotReady   = "NotReady"
+)
+
+// digState is the simulated lifecycle of a deployment intent group.
+type digState struct {
+	actions []StateAction
+	// deployments of each app, indexed by app name
+	deployments map[string][]*deployment
+	// whether the next instantiate or update deploys failed apps
+	failNext bool
+}
+
+// deployment is an app deployed to, or being removed from, one cluster.
+type deployment struct {
+	provider string
+	cluster  string
+	since    time.Time // when it was deployed or removed
+	removed  bool
+	failed   bool
+}
+
+// StateAction is one lifecycle action on a DIG or logical cloud.
+type StateAction struct {
+	State    string    `json:"state"`
+	Instance string    `json:"instance"`
+	Time     time.Time `json:"time"`
+}
+
+// StateInfo is the lifecycle history of a DIG.
+type StateInfo struct {
+	Actions []StateAction `json:"actions"`
+}
+
+// DigStatus is the response of the DIG status API.
+type DigStatus struct {
+	Project             string      `json:"project"`
+	CompositeApp        string      `json:"compositeApp"`
+	CompositeAppVersion string      `json:"compositeAppVersion"`
+	Name                string      `json:"name"`
+	States              StateInfo   `json:"states"`
+	DeployedStatus      string      `json:"deployedStatus,omitempty"`
+	ReadyStatus         string      `json:"readyStatus,omitempty"`
+	Apps                []AppStatus `json:"apps"`
+}
+
+// AppStatus is the status of one app of a DIG.
+type AppStatus struct {
+	Name     string          `json:"name"`
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+// ClusterStatus is the status of one app in one cluster.
+type ClusterStatus struct {
+	ClusterProvider string `json:"clusterProvider"`
+	Cluster         string `json:"cluster"`
+	DeployedStatus  string `json:"deployedStatus"`
+	ReadyStatus     string `json:"readyStatus"`
+}
+
+func (d *digState) state() string {
+	if len(d.actions) == 0 {
+		return StateCreated
+	}
+	return d.actions[len(d.actions)-1].State
+}
+
+func isDigPath(segs []string) bool {
+	return len(segs) == 7 && segs[0] == "projects" &&
+		segs[2] == "composite-apps" && segs[5] == "deployment-intent-groups"
+}
+
+func isLogicalCloudPath(segs []string) bool {
+	return len(segs) == 4 && segs[0] == "projects" && segs[2] == "logical-clouds"
+}
+
+var digActions = map[string]bool{
+	"approve": true, "instantiate": true, "update": true,
+	"terminate": true, "status": true,
+}
+
+var cloudActions = map[string]bool{
+	"instantiate": true, "update": true, "terminate": true, "status": true,
+}
+
+// splitAction splits a path like .../deployment-intent-groups/dig1/update
+// into the DIG path and the action.
+func (s *Server) splitAction(segs []string) (string, string, bool) {
+	n := len(segs)
+	if n < 2 {
+		return "", "", false
+	}
+	parent, action := segs[:n-1], segs[n-1]
+	if (isDigPath(parent) && digActions[action]) ||
+		(isLogicalCloudPath(parent) && cloudActions[action]) {
+		return strings.Join(parent, "/"), action, true
+	}
+	return "", "", false
+}
+
+func (s *Server) doAction(method, path, action string) (int, interface{}, error) {
+	if _, ok := s.resources[path]; !ok {
+		return 0, nil, errorf(http.StatusNotFound, "%s not found", path)
+	}
+	wantMethod := http.MethodPost
+	if action == "status" {
+		wantMethod = http.MethodGet
+	}
+	if method != wantMethod {
+		return 0, nil, errorf(http.StatusMethodNotAllowed,
+			"%s requires %s", action, wantMethod)
+	}
+
+	if isLogicalCloudPath(strings.Split(path, "/")) {
+		return s.doCloudAction(path, action)
+	}
+
+	dig := s.dig(path)
+	now := s.opts.Now()
+	switch action {
+	case "approve":
+		if dig.state() != StateCreated {
+			return 0, nil, errorf(http.StatusConflict,
+				"DIG must be %s to approve, not %s", StateCreated, dig.state())
+		}
+		dig.actions = append(dig.actions, StateAction{State: StateApproved, Time: now})
+		return http.StatusAccepted, nil, nil
+
+	case "instantiate", "update":
+		want := StateApproved
+		if action == "update" {
+			want = StateInstantiated
+		}
+		if dig.state() != want {
+			return 0, nil, errorf(http.StatusConflict,
+				"DIG must be %s to %s, not %s", want, action, dig.state())
+		}
+		placements, err := s.placements(path)
+		if err != nil {
+			return 0, nil, err
+		}
+		dig.deploy(placements, now)
+		dig.actions = append(dig.actions, StateAction{State: StateInstantiated, Time: now})
+		return http.StatusAccepted, nil, nil
+
+	case "terminate":
+		if dig.state() != StateInstantiated {
+			return 0, nil, errorf(http.StatusConflict,
+				"DIG must be %s to terminate, not %s", StateInstantiated, dig.state())
+		}
+		dig.deploy(nil, now)
+		dig.actions = append(dig.actions, StateAction{State: StateTerminated, Time: now})
+		return http.StatusAccepted, nil, nil
+
+	case "status":
+		return http.StatusOK, s.digStatus(path, dig, now), nil
+	}
+	return 0, nil, errorf(http.StatusNotFound, "unknown action %s", action)
+}
+
+func (s *Server) dig(path string) *digState {
+	dig, ok := s.digs[path]
+	if !ok {
+		dig = &digState{deployments: make(map[string][]*deployment)}
+		s.digs[path] = dig
+	}
+	return dig
+}
+
+// deploy moves the DIG's apps to the given placements, which map app names
+// to provider+cluster names. Deployments that are not in the placements
+// start being removed. If failNext is set, the apps deployed to new
+// clusters fail.
+func (d *digState) deploy(placements map[string][]string, now time.Time) {
+	failed := d.failNext
+	d.failNext = false
+	for app, deps := range d.deployments {
+		for _, dep := range deps {
+			if !dep.removed && !contains(placements[app], dep.provider+"+"+dep.cluster) {
+				dep.removed = true
+				dep.since = now
+			}
+		}
+	}
+	for app, clusters := range placements {
+		for _, pc := range clusters {
+			provider, cluster := splitCluster(pc)
+			found := false
+			for _, dep := range d.deployments[app] {
+				if dep.provider == provider && dep.cluster == cluster {
+					if dep.removed {
+						dep.removed = false
+						dep.failed = failed
+						dep.since = now
+					}
+					found = true
+				}
+			}
+			if !found {
+				d.deployments[app] = append(d.deployments[app],
+					&deployment{provider: provider, cluster: cluster,
+						since: now, failed: failed})
+			}
+		}
+	}
+}
+
+func (s *Server) digStatus(path string, dig *digState, now time.Time) DigStatus {
+	segs := strings.Split(path, "/")
+	status := DigStatus{
+		Project:             segs[1],
+		CompositeApp:        segs[3],
+		CompositeAppVersion: segs[4],
+		Name:                segs[6],
+		States:              StateInfo{Actions: append([]StateAction{}, dig.actions...)},
+		DeployedStatus:      dig.state(),
+		ReadyStatus:         ReadyReady,
+		Apps:                []AppStatus{},
+	}
+	if dig.state() != StateInstantiated {
+		status.ReadyStatus = ""
+	}
+
+	for _, app := range s.appNames(path) {
+		appStatus := AppStatus{Name: app, Clusters: []ClusterStatus{}}
+		for _, dep := range dig.deployments[app] {
+			settled := now.Sub(dep.since) >= s.opts.ReadyDelay
+			if dep.removed && settled {
+				continue
+			}
+			cs := ClusterStatus{
+				ClusterProvider: dep.provider,
+				Cluster:         dep.cluster,
+				DeployedStatus:  DeployedApplied,
+				ReadyStatus:     ReadyReady,
+			}
+			if dep.removed {
+				cs.DeployedStatus = DeployedDeleted
+			}
+			if dep.failed && !dep.removed {
+				cs.DeployedStatus = DeployedFailed
+				cs.ReadyStatus = ReadyNotReady
+				status.ReadyStatus = ReadyNotReady
+			} else if !settled {
+				cs.ReadyStatus = ReadyNotReady
+				if status.ReadyStatus == ReadyReady {
+					status.ReadyStatus = ReadyNotReady
+				}
+			}
+			appStatus.Clusters = append(appStatus.Clusters, cs)
+		}
+		status.Apps = append(status.Apps, appStatus)
+	}
+	return status
+}
+
+// appNames returns the names of the apps of the DIG's composite app, and of
+// any other apps that have app intents in the DIG.
+func (s *Server) appNames(digPath string) []string {
+	segs := strings.Split(digPath, "/")
+	apps := append([]string{}, s.collections[strings.Join(segs[:5], "/")+"/apps"]...)
+	for _, intent := range s.appIntents(digPath) {
+		if app := specString(intent, "app"); app != "" && !contains(apps, app) {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
+
+// appIntents returns all app intents of all generic placement intents of
+// the DIG.
+func (s *Server) appIntents(digPath string) []map[string]interface{} {
+	intents := []map[string]interface{}{}
+	gpis := digPath + "/generic-placement-intents"
+	for _, gpi := range s.collections[gpis] {
+		appIntents := gpis + "/" + gpi + "/app-intents"
+		for _, name := range s.collections[appIntents] {
+			intents = append(intents, s.resources[appIntents+"/"+name])
+		}
+	}
+	return intents
+}
+
+// placements resolves the app intents of a DIG to the clusters that each
+// app is placed in. In allOf, every cluster is used; in anyOf, the first
+// matching cluster is used. Cluster labels match the clusters of the given
+// provider with that label.
+func (s *Server) placements(digPath string) (map[string][]string, error) {
+	placements := make(map[string][]string)
+	for _, intent := range s.appIntents(digPath) {
+		app := specString(intent, "app")
+		spec, _ := intent["spec"].(map[string]interface{})
+		placement, _ := spec["intent"].(map[string]interface{})
+
+		clusters := []string{}
+		for _, item := range listOf(placement["allOf"]) {
+			clusters = append(clusters, s.resolveCluster(item)...)
+			if anyOf := listOf(item["anyOf"]); len(anyOf) > 0 {
+				clusters = append(clusters, s.firstCluster(anyOf)...)
+			}
+		}
+		clusters = append(clusters, s.firstCluster(listOf(placement["anyOf"]))...)
+		if len(clusters) == 0 {
+			return nil, errorf(http.StatusBadRequest,
+				"app %s of DIG %s is not placed on any cluster", app, digPath)
+		}
+		for _, c := range clusters {
+			if !contains(placements[app], c) {
+				placements[app] = append(placements[app], c)
+			}
+		}
+	}
+	return placements, nil
+}
+
+func (s *Server) firstCluster(anyOf []map[string]interface{}) []string {
+	for _, item := range anyOf {
+		if clusters := s.resolveCluster(item); len(clusters) > 0 {
+			return clusters[:1]
+		}
+	}
+	return nil
+}
+
+// resolveCluster returns the clusters named by a placement item, as
+// provider+cluster, if they exist.
+func (s *Server) resolveCluster(item map[string]interface{}) []string {
+	provider, _ := item["clusterProvider"].(string)
+	cluster, _ := item["cluster"].(string)
+	label, _ := item["clusterLabel"].(string)
+	clustersPath := "cluster-providers/" + provider + "/clusters"
+
+	if cluster != "" {
+		if _, ok := s.resources[clustersPath+"/"+cluster]; ok {
+			return []string{provider + "+" + cluster}
+		}
+		return nil
+	}
+	clusters := []string{}
+	if label != "" {
+		for _, name := range s.collections[clustersPath] {
+			if _, ok := s.resources[clustersPath+"/"+name+"/labels/"+label]; ok {
+				clusters = append(clusters, provider+"+"+name)
+			}
+		}
+	}
+	return clusters
+}
+
+func (s *Server) doCloudAction(path, action string) (int, interface{}, error) {
+	state := s.clouds[path]
+	now := s.opts.Now()
+	switch action {
+	case "instantiate":
+		if state == StateInstantiated {
+			return 0, nil, errorf(http.StatusConflict,
+				"logical cloud %s is already instantiated", path)
+		}
+		s.clouds[path] = StateInstantiated
+	case "update":
+		if state != StateInstantiated {
+			return 0, nil, errorf(http.StatusConflict,
+				"logical cloud %s is not instantiated", path)
+		}
+	case "terminate":
+		if state != StateInstantiated {
+			return 0, nil, errorf(http.StatusConflict,
+				"logical cloud %s is not instantiated", path)
+		}
+		s.clouds[path] = StateTerminated
+	case "status":
+		if state == "" {
+			state = StateCreated
+		}
+		return http.StatusOK, map[string]interface{}{
+			"name":           path[strings.LastIndex(path, "/")+1:],
+			"deployedStatus": state,
+			"readyStatus":    ReadyReady,
+			"time":           now,
+		}, nil
+	}
+	return http.StatusAccepted, nil, nil
+}
+
+func specString(res map[string]interface{}, key string) string {
+	spec, _ := res["spec"].(map[string]interface{})
+	value, _ := spec[key].(string)
+	return value
+}
+
+// listOf converts a decoded JSON list of objects to a slice of maps.
+func listOf(v interface{}) []map[string]interface{} {
+	items, _ := v.([]interface{})
+	list := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+func splitCluster(pc string) (string, string) {
+	i := strings.Index(pc, "+")
+	if i < 0 {
+		return "", pc
+	}
+	return pc[:i], pc[i+1:]
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package fakeemco
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FaultsURL is the admin URL to list, add and clear fault rules.
+const FaultsURL = "/admin/faults"
+
+// Duration is a time.Duration that is encoded in JSON as a string such as
+// "500ms". Numbers are accepted as nanoseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+// FaultRule injects a fault into the API calls that it matches. A rule is
+// triggered by the Nth matching call and the Count-1 calls after it. If Nth
+// is 0, it is triggered from the first matching call. If Count is 0, it is
+// triggered once if Nth is given, and by every matching call otherwise.
+//
+// A triggered rule first adds Latency. Then it resets the connection if
+// Reset is set, or fails the call with Status if that is set. With
+// ApplyBeforeError, the call takes effect before failing, as if the
+// response was lost. With DeploymentFailure, a DIG instantiate or update
+// succeeds with 202, but the apps it deploys to clusters fail.
+type FaultRule struct {
+	ID                int      `json:"id"`
+	Method            string   `json:"method,omitempty"` // "" matches all
+	Path              string   `json:"path,omitempty"`   // regexp on the path under /v2
+	Nth               int      `json:"nth,omitempty"`
+	Count             int      `json:"count,omitempty"`
+	Latency           Duration `json:"latency,omitempty"`
+	Reset             bool     `json:"reset,omitempty"`
+	Status            int      `json:"status,omitempty"`
+	ApplyBeforeError  bool     `json:"applyBeforeError,omitempty"`
+	DeploymentFailure bool     `json:"deploymentFailure,omitempty"`
+
+	// Matched is the number of calls that matched the rule so far.
+	Matched int `json:"matched"`
+
+	pathRE *regexp.Regexp
+}
+
+// AddFault adds a fault rule and returns its ID.
+func (s *Server) AddFault(rule FaultRule) (int, error) {
+	if rule.Path != "" {
+		re, err := regexp.Compile(rule.Path)
+		if err != nil {
+			return 0, fmt.Errorf("invalid path regexp: %s", err)
+		}
+		rule.pathRE = re
+	}
+	if rule.Status != 0 && (rule.Status < 100 || rule.Status > 599) {
+		return 0, fmt.Errorf("invalid status %d", rule.Status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextFaultID++
+	rule.ID = s.nextFaultID
+	rule.Matched = 0
+	s.faults = append(s.faults, &rule)
+	return rule.ID, nil
+}
+
+// RemoveFault removes the fault rule with the given ID.
+func (s *Server) RemoveFault(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, rule := range s.faults {
+		if rule.ID == id {
+			s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearFaults removes all fault rules.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Faults returns copies of the current fault rules.
+func (s *Server) Faults() []FaultRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := make([]FaultRule, 0, len(s.faults))
+	for _, rule := range s.faults {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// fault is the combined effect of the rules triggered by a call.
+type fault struct {
+	latency           time.Duration
+	reset             bool
+	status            int
+	applyBeforeError  bool
+	deploymentFailure bool
+}
+
+// matchFaults counts the call against every matching rule and returns the
+// fault to inject, if any.
+func (s *Server) matchFaults(method, path string) *fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var f *fault
+	for _, rule := range s.faults {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+		if rule.pathRE != nil && !rule.pathRE.MatchString(path) {
+			continue
+		}
+		rule.Matched++
+
+		first := rule.Nth
+		if first < 1 {
+			first = 1
+		}
+		count := rule.Count
+		if count == 0 && rule.Nth > 0 {
+			count = 1
+		}
+		if rule.Matched < first || (count > 0 && rule.Matched >= first+count) {
+			continue
+		}
+
+		if f == nil {
+			f = &fault{}
+		}
+		f.latency += time.Duration(rule.Latency)
+		f.reset = f.reset || rule.Reset
+		if rule.Status != 0 && f.status == 0 {
+			f.status = rule.Status
+			f.applyBeforeError = rule.ApplyBeforeError
+		}
+		f.deploymentFailure = f.deploymentFailure || rule.DeploymentFailure
+	}
+	return f
+}
+
+// injectFault applies a fault to a call. It returns true if the call has
+// been fully handled.
+func (s *Server) injectFault(w http.ResponseWriter, r *http.Request, path string,
+	body map[string]interface{}, f *fault) bool {
+
+	if f.latency > 0 {
+		select {
+		case <-time.After(f.latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	if f.reset {
+		resetConnection(w)
+		return true
+	}
+	if f.deploymentFailure {
+		s.failNextDeployment(path)
+	}
+	if f.status != 0 {
+		if f.applyBeforeError {
+			s.Do(r.Method, path, body)
+		}
+		http.Error(w, "injected fault: "+http.StatusText(f.status), f.status)
+		return true
+	}
+	return false
+}
+
+// resetConnection closes the client connection abruptly, with a TCP RST.
+func resetConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "injected fault: connection reset", http.StatusBadGateway)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// failNextDeployment makes the next instantiate or update of the DIG at the
+// given action path deploy failed apps.
+func (s *Server) failNextDeployment(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digPath, action, ok := s.splitAction(strings.Split(path, "/"))
+	if !ok || (action != "instantiate" && action != "update") {
+		return
+	}
+	s.dig(digPath).failNext = true
+}
+
+// serveFaults serves the fault rules admin API:
+//
+//	GET    /admin/faults      lists the rules
+//	POST   /admin/faults      adds the rule in the body
+//	DELETE /admin/faults      removes all rules
+//	DELETE /admin/faults/{id} removes one rule
+func (s *Server) serveFaults(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, FaultsURL), "/")
+	switch {
+	case r.Method == http.MethodGet && idStr == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Faults())
+
+	case r.Method == http.MethodPost && idStr == "":
+		var rule FaultRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid fault rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := s.AddFault(rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.ID = id
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	case r.Method == http.MethodDelete && idStr == "":
+		s.ClearFaults()
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete:
+		id, err := strconv.Atoi(idStr)
+		if err != nil || !s.RemoveFault(id) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
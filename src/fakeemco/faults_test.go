@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package fakeemco
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultWindows(t *testing.T) {
+	const path = "projects/proj1/composite-apps/capp1/v1/deployment-intent-groups/dig1/update"
+	tests := []struct {
+		name      string
+		rule      FaultRule
+		triggered []bool // by calls 1 to 6
+	}{
+		{"every call", FaultRule{},
+			[]bool{true, true, true, true, true, true}},
+		{"nth only", FaultRule{Nth: 2},
+			[]bool{false, true, false, false, false, false}},
+		{"nth and count", FaultRule{Nth: 2, Count: 3},
+			[]bool{false, true, true, true, false, false}},
+		{"count from the first", FaultRule{Count: 2},
+			[]bool{true, true, false, false, false, false}},
+		{"method", FaultRule{Method: "post"},
+			[]bool{true, true, true, true, true, true}},
+		{"other method", FaultRule{Method: "PUT"},
+			[]bool{false, false, false, false, false, false}},
+		{"path", FaultRule{Path: "/update$", Nth: 6},
+			[]bool{false, false, false, false, false, true}},
+		{"other path", FaultRule{Path: "/status$"},
+			[]bool{false, false, false, false, false, false}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewServer(Options{})
+			tc.rule.Status = http.StatusServiceUnavailable
+			_, err := s.AddFault(tc.rule)
+			require.NoError(t, err)
+			triggered := []bool{}
+			for i := 0; i < 6; i++ {
+				triggered = append(triggered, s.matchFaults(http.MethodPost, path) != nil)
+			}
+			assert.Equal(t, tc.triggered, triggered)
+		})
+	}
+}
+
+func TestFaultRulesCombine(t *testing.T) {
+	s := NewServer(Options{})
+	rules := []FaultRule{
+		{Path: "status", Latency: Duration(time.Second)},
+		{Path: "status", Latency: Duration(2 * time.Second), Status: 502},
+		{Path: "status", Status: 500, ApplyBeforeError: true},
+		{Path: "update", Reset: true},
+	}
+	for _, rule := range rules {
+		_, err := s.AddFault(rule)
+		require.NoError(t, err)
+	}
+
+	// latencies add up, and the first status wins
+	assert.Equal(t, &fault{latency: 3 * time.Second, status: 502},
+		s.matchFaults(http.MethodGet, "dig1/status"))
+	assert.Equal(t, &fault{reset: true}, s.matchFaults(http.MethodPost, "dig1/update"))
+	assert.Nil(t, s.matchFaults(http.MethodGet, "dig1"))
+
+	matched := []int{}
+	for _, rule := range s.Faults() {
+		matched = append(matched, rule.Matched)
+	}
+	assert.Equal(t, []int{1, 1, 1, 1}, matched)
+}
+
+func TestApplyBeforeError(t *testing.T) {
+	for _, apply := range []bool{false, true} {
+		fake, server, _ := newTestServer(t, "00.define-clusters-proj.yaml",
+			"01.instantiate-lc.yaml", "02.define-app-dig.yaml")
+		_, err := fake.AddFault(FaultRule{Method: "PUT", Path: "app-intents/",
+			Status: http.StatusInternalServerError, ApplyBeforeError: apply})
+		require.NoError(t, err)
+
+		code, result := call(t, server, "PUT", testAppIntentPath,
+			placedOn("provider2", "cluster2"))
+		assert.Equal(t, http.StatusInternalServerError, code)
+		assert.Equal(t, "injected fault: Internal Server Error\n", result)
+
+		code, result = call(t, server, "GET", testAppIntentPath, nil)
+		require.Equal(t, http.StatusOK, code)
+		intent := result.(map[string]interface{})["spec"].(map[string]interface{})["intent"]
+		if apply {
+			assert.Equal(t, map[string]interface{}{"allOf": []interface{}{
+				map[string]interface{}{"clusterProvider": "provider2",
+					"cluster": "cluster2"}}}, intent, "applied before error")
+		} else {
+			assert.Equal(t, map[string]interface{}{"allOf": []interface{}{
+				map[string]interface{}{"clusterProvider": "provider1",
+					"clusterLabel": "edge-cluster"}}}, intent, "not applied")
+		}
+	}
+}
+
+func TestDeploymentFailure(t *testing.T) {
+	fake, server, clock := newTestServer(t, "00.define-clusters-proj.yaml",
+		"01.instantiate-lc.yaml", "02.define-app-dig.yaml", "03.instantiate-dig.yaml")
+	clock.Advance(5 * time.Second)
+	_, err := fake.AddFault(FaultRule{Path: "/update$", DeploymentFailure: true})
+	require.NoError(t, err)
+
+	code, _ := call(t, server, "PUT", testAppIntentPath, placedOn("provider2", "cluster2"))
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, server, "POST", testDigPath+"/update", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	clock.Advance(5 * time.Second)
+	status := getStatus(t, server)
+	assert.Equal(t, StateInstantiated, status.DeployedStatus)
+	assert.Equal(t, ReadyNotReady, status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider2", "cluster2", DeployedFailed, ReadyNotReady}}}}, status.Apps)
+
+	// Only the next deployment fails.
+	fake.ClearFaults()
+	code, _ = call(t, server, "PUT", testAppIntentPath, placedOn("provider1", "cluster1"))
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, server, "POST", testDigPath+"/update", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+	clock.Advance(5 * time.Second)
+	status = getStatus(t, server)
+	assert.Equal(t, ReadyReady, status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider1", "cluster1", DeployedApplied, ReadyReady}}}}, status.Apps)
+}
+
+func TestResetAndLatency(t *testing.T) {
+	fake, server, _ := newTestServer(t, "00.define-clusters-proj.yaml")
+	_, err := fake.AddFault(FaultRule{Method: "GET", Path: "^projects$", Reset: true})
+	require.NoError(t, err)
+	_, err = fake.AddFault(FaultRule{Method: "GET", Path: "^projects/proj1$",
+		Latency: Duration(100 * time.Millisecond)})
+	require.NoError(t, err)
+
+	_, err = http.Get(server.URL + "/v2/projects")
+	assert.Error(t, err, "connection reset")
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/v2/projects/proj1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestFaultsAdmin(t *testing.T) {
+	fake, server, _ := newTestServer(t, "00.define-clusters-proj.yaml")
+	post := func(rule string) (int, interface{}) {
+		resp, err := http.Post(server.URL+FaultsURL, "application/json",
+			strings.NewReader(rule))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var result interface{}
+		if resp.StatusCode == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		}
+		return resp.StatusCode, result
+	}
+
+	code, rule := post(`{"method":"GET","path":"^projects$","nth":2,"status":503,` +
+		`"latency":"10ms"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, map[string]interface{}{"id": float64(1), "method": "GET",
+		"path": "^projects$", "nth": float64(2), "status": float64(503),
+		"latency": "10ms", "matched": float64(0)}, rule)
+	code, _ = post(`{"path":"/status$","latency":20000000}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, Duration(20*time.Millisecond), fake.Faults()[1].Latency)
+
+	for _, bad := range []string{`{"path":"("}`, `{"status":42}`, `{"latency":"soon"}`, `[`} {
+		code, _ = post(bad)
+		assert.Equal(t, http.StatusBadRequest, code, bad)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK} {
+		code, _ := call(t, server, "GET", "/v2/projects", nil)
+		assert.Equal(t, want, code)
+	}
+	code, rules := call(t, server, "GET", FaultsURL, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, float64(3), rules.([]interface{})[0].(map[string]interface{})["matched"])
+
+	code, _ = call(t, server, "DELETE", FaultsURL+"/1", nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = call(t, server, "DELETE", FaultsURL+"/1", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Len(t, fake.Faults(), 1)
+	code, _ = call(t, server, "DELETE", FaultsURL, nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Empty(t, fake.Faults())
+	code, _ = call(t, server, "PUT", FaultsURL, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
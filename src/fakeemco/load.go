@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package fakeemco
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// intentDoc is one document of an emcoctl YAML file.
+type intentDoc struct {
+	Version         string `yaml:"version"`
+	ResourceContext struct {
+		Anchor string `yaml:"anchor"`
+	} `yaml:"resourceContext"`
+	Metadata     map[string]interface{} `yaml:"metadata"`
+	Spec         map[string]interface{} `yaml:"spec"`
+	ClusterLabel string                 `yaml:"clusterLabel"`
+}
+
+// ReadValues merges the given emcoctl values files; later files take
+// precedence.
+func ReadValues(filenames ...string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("Failed to parse values file %s: %s", filename, err)
+		}
+		for k, v := range fileValues {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// LoadIntentFiles applies the given emcoctl YAML files, such as those in
+// samples/intents, in order. See LoadIntents.
+func (s *Server) LoadIntentFiles(values map[string]interface{}, filenames ...string) error {
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if err := s.LoadIntents(bytes.NewReader(data), values); err != nil {
+			return fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// LoadIntents applies an emcoctl YAML file, after expanding it as a Go
+// template with the given values, as "emcoctl apply" would. Documents with
+// metadata create a resource in the anchor collection; documents without it
+// run the action named by the anchor, such as approve or instantiate.
+// Referenced files, such as helm charts and kubeconfigs, are ignored.
+func (s *Server) LoadIntents(r io.Reader, values map[string]interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New("intents").Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(&buf)
+	for {
+		var doc intentDoc
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		anchor := strings.Trim(doc.ResourceContext.Anchor, "/")
+		if anchor == "" {
+			continue
+		}
+
+		var body map[string]interface{}
+		switch {
+		case doc.Metadata != nil:
+			body = map[string]interface{}{"metadata": doc.Metadata}
+			if doc.Spec != nil {
+				body["spec"] = doc.Spec
+			}
+		case doc.ClusterLabel != "":
+			body = map[string]interface{}{"clusterLabel": doc.ClusterLabel}
+		}
+
+		if _, _, err := s.Do(http.MethodPost, anchor, body); err != nil {
+			return fmt.Errorf("POST %s: %s", anchor, err)
+		}
+	}
+}
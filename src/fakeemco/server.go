@@ -0,0 +1,353 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+// Package fakeemco is an in-memory stand-in for the EMCO REST APIs used by
+// the migrate workflow, so that the workflow can be run on a laptop or in
+// tests without a live EMCO.
+//
+// Resources are kept as generic JSON documents ({"metadata": ..., "spec":
+// ...}) in a tree that mirrors the EMCO URLs under /v2. Any resource can be
+// created with a POST to its collection, replaced with a PUT, read with a
+// GET and removed with a DELETE, as with emcoctl. On top of that, the
+// deployment intent group (DIG) and logical cloud lifecycle actions
+// (approve, instantiate, update, terminate, status) are simulated, including
+// per-cluster deployment state that becomes ready after a delay.
+package fakeemco
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure a Server.
+type Options struct {
+	// Time for an app deployed to a cluster to become ready, and for an app
+	// removed from a cluster to disappear. Zero means immediately.
+	ReadyDelay time.Duration
+	// Clock used for the simulated deployment state. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Server is a fake EMCO. It implements http.Handler.
+type Server struct {
+	mu   sync.Mutex
+	opts Options
+
+	// resources indexed by path under /v2, such as "projects/proj1"
+	resources map[string]map[string]interface{}
+	// names of the resources in each collection, in creation order
+	collections map[string][]string
+
+	digs   map[string]*digState // indexed by DIG path
+	clouds map[string]string    // logical cloud path -> state
+
+	faults      []*FaultRule
+	nextFaultID int
+}
+
+// NewServer returns an empty fake EMCO.
+func NewServer(opts Options) *Server {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Server{
+		opts:        opts,
+		resources:   make(map[string]map[string]interface{}),
+		collections: make(map[string][]string),
+		digs:        make(map[string]*digState),
+		clouds:      make(map[string]string),
+	}
+}
+
+// httpError is an error with the HTTP status code to report it with.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP serves the EMCO APIs under /v2, with any faults injected by the
+// fault rules, and the fault rules admin API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, FaultsURL) {
+		s.serveFaults(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v2/") {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/"), "/")
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if f := s.matchFaults(r.Method, path); f != nil {
+		log.Printf("fakeemco: injecting fault %+v into %s %s\n", *f, r.Method, r.URL.Path)
+		if s.injectFault(w, r, path, body, f) {
+			return
+		}
+	}
+
+	status, result, err := s.Do(r.Method, path, body)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if herr, ok := err.(*httpError); ok {
+			code = herr.status
+		}
+		log.Printf("fakeemco: %s %s: %d %s\n", r.Method, r.URL.Path, code, err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// Do performs an API call on the path under /v2 and returns the HTTP status
+// and the response body. It is what ServeHTTP uses, and lets tests drive the
+// fake without HTTP.
+func (s *Server) Do(method, path string, body map[string]interface{}) (int, interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segs := strings.Split(path, "/")
+	if path == "" {
+		return 0, nil, errorf(http.StatusNotFound, "no resource at /v2/")
+	}
+	if parent, action, ok := s.splitAction(segs); ok {
+		return s.doAction(method, parent, action)
+	}
+
+	collection := isCollection(segs)
+	switch method {
+	case http.MethodGet:
+		if collection {
+			return http.StatusOK, s.list(path), nil
+		}
+		res, ok := s.resources[path]
+		if !ok {
+			return 0, nil, errorf(http.StatusNotFound, "%s not found", path)
+		}
+		return http.StatusOK, res, nil
+
+	case http.MethodPost:
+		if !collection {
+			return 0, nil, errorf(http.StatusMethodNotAllowed,
+				"POST is supported only on collections, not %s", path)
+		}
+		res, err := s.create(path, body)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusCreated, res, nil
+
+	case http.MethodPut:
+		if collection {
+			return 0, nil, errorf(http.StatusMethodNotAllowed,
+				"PUT is not supported on collection %s", path)
+		}
+		return s.replace(path, body)
+
+	case http.MethodDelete:
+		if collection {
+			return 0, nil, errorf(http.StatusMethodNotAllowed,
+				"DELETE is not supported on collection %s", path)
+		}
+		if _, ok := s.resources[path]; !ok {
+			return 0, nil, errorf(http.StatusNotFound, "%s not found", path)
+		}
+		s.remove(path)
+		return http.StatusNoContent, nil, nil
+	}
+	return 0, nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", method)
+}
+
+// isCollection reports whether the path segments name a collection, such as
+// projects/proj1/logical-clouds, rather than a resource in one. Collection
+// and resource names alternate in EMCO URLs, except that composite apps are
+// named by both name and version.
+func isCollection(segs []string) bool {
+	i := 0
+	for {
+		if i == len(segs)-1 {
+			return true
+		}
+		step := 2
+		if segs[i] == "composite-apps" {
+			step = 3
+		}
+		if i+step >= len(segs) {
+			return false
+		}
+		i += step
+	}
+}
+
+func parentOf(path string) (string, string) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+// collectionOf returns the collection that a resource path belongs to and
+// the resource's name in it.
+func collectionOf(path string) (string, string) {
+	parent, name := parentOf(path)
+	// composite-apps/{name}/{version} is one resource
+	if grand, appName := parentOf(parent); strings.HasSuffix(grand, "composite-apps") &&
+		isCollection(strings.Split(grand, "/")) {
+		return grand, appName + "/" + name
+	}
+	return parent, name
+}
+
+func (s *Server) list(collection string) []interface{} {
+	list := []interface{}{}
+	for _, name := range s.collections[collection] {
+		list = append(list, s.resources[collection+"/"+name])
+	}
+	return list
+}
+
+// resourceName returns the name of a resource to be created in a
+// collection. Cluster labels are named by their clusterLabel field.
+func resourceName(collection string, body map[string]interface{}) string {
+	if meta, ok := body["metadata"].(map[string]interface{}); ok {
+		if name, ok := meta["name"].(string); ok && name != "" {
+			if strings.HasSuffix(collection, "composite-apps") {
+				if spec, ok := body["spec"].(map[string]interface{}); ok {
+					if version, ok := spec["compositeAppVersion"].(string); ok {
+						return name + "/" + version
+					}
+				}
+			}
+			return name
+		}
+	}
+	if label, ok := body["clusterLabel"].(string); ok {
+		return label
+	}
+	return ""
+}
+
+func (s *Server) create(collection string, body map[string]interface{}) (map[string]interface{}, error) {
+	name := resourceName(collection, body)
+	if name == "" {
+		return nil, errorf(http.StatusBadRequest, "missing metadata.name")
+	}
+	if err := s.checkParent(collection); err != nil {
+		return nil, err
+	}
+	path := collection + "/" + name
+	if _, ok := s.resources[path]; ok {
+		return nil, errorf(http.StatusConflict, "%s already exists", path)
+	}
+	s.store(path, body)
+	return body, nil
+}
+
+func (s *Server) replace(path string, body map[string]interface{}) (int, interface{}, error) {
+	collection, name := collectionOf(path)
+	if bodyName := resourceName(collection, body); bodyName != "" && bodyName != name {
+		return 0, nil, errorf(http.StatusBadRequest,
+			"metadata.name %s does not match URL %s", bodyName, path)
+	}
+	if err := s.checkParent(collection); err != nil {
+		return 0, nil, err
+	}
+	status := http.StatusOK
+	if _, ok := s.resources[path]; !ok {
+		status = http.StatusCreated
+	}
+	s.store(path, body)
+	return status, body, nil
+}
+
+// checkParent verifies that the resource owning a collection exists.
+func (s *Server) checkParent(collection string) error {
+	owner, _ := parentOf(collection)
+	if owner == "" {
+		return nil
+	}
+	if _, ok := s.resources[owner]; !ok {
+		return errorf(http.StatusNotFound, "parent resource %s not found", owner)
+	}
+	return nil
+}
+
+func (s *Server) store(path string, body map[string]interface{}) {
+	collection, name := collectionOf(path)
+	if _, ok := s.resources[path]; !ok {
+		s.collections[collection] = append(s.collections[collection], name)
+	}
+	s.resources[path] = body
+}
+
+// remove deletes a resource together with everything under it.
+func (s *Server) remove(path string) {
+	collection, name := collectionOf(path)
+	names := s.collections[collection]
+	for i, n := range names {
+		if n == name {
+			s.collections[collection] = append(names[:i:i], names[i+1:]...)
+			break
+		}
+	}
+	prefix := path + "/"
+	for p := range s.resources {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(s.resources, p)
+		}
+	}
+	for c := range s.collections {
+		if strings.HasPrefix(c, prefix) {
+			delete(s.collections, c)
+		}
+	}
+	delete(s.digs, path)
+	delete(s.clouds, path)
+}
+
+// Paths returns the paths of all resources, sorted, for debugging and tests.
+func (s *Server) Paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0, len(s.resources))
+	for p := range s.resources {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,339 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package fakeemco
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDigPath       = "/v2/projects/proj1/composite-apps/capp1/v1/deployment-intent-groups/dig1"
+	testAppIntentPath = testDigPath +
+		"/generic-placement-intents/dig1-placement-intent/app-intents/collectd-placement-intent"
+)
+
+// testClock is a clock that only moves when told to.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestServer returns a fake EMCO loaded with the given files of
+// samples/intents, and an HTTP server for it. Apps become ready 5s after
+// they are deployed, on the returned clock.
+func newTestServer(t *testing.T, files ...string) (*Server, *httptest.Server, *testClock) {
+	clock := &testClock{now: time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)}
+	server := NewServer(Options{ReadyDelay: 5 * time.Second, Now: clock.Now})
+
+	dir := filepath.Join("..", "..", "samples", "intents")
+	values, err := ReadValues(filepath.Join(dir, "values-1app-2clusters.yaml"))
+	require.NoError(t, err)
+	for i, file := range files {
+		files[i] = filepath.Join(dir, file)
+	}
+	require.NoError(t, server.LoadIntentFiles(values, files...))
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return server, httpServer, clock
+}
+
+// call makes an API call and returns the status and the decoded response.
+func call(t *testing.T, server *httptest.Server, method, path string,
+	body interface{}) (int, interface{}) {
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var result interface{}
+	if resp.Header.Get("Content-Type") == "application/json" {
+		require.NoError(t, json.Unmarshal(data, &result))
+	} else if len(data) > 0 {
+		result = string(data)
+	}
+	return resp.StatusCode, result
+}
+
+// getStatus returns the DIG status of dig1.
+func getStatus(t *testing.T, server *httptest.Server) DigStatus {
+	resp, err := http.Get(server.URL + testDigPath + "/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var status DigStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+// placedOn returns the app intent of collectd placing it on the given
+// cluster.
+func placedOn(provider, cluster string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "collectd-placement-intent"},
+		"spec": map[string]interface{}{
+			"app": "collectd",
+			"intent": map[string]interface{}{
+				"allOf": []interface{}{map[string]interface{}{
+					"clusterProvider": provider, "cluster": cluster}},
+			},
+		},
+	}
+}
+
+func TestDigLifecycle(t *testing.T) {
+	_, server, clock := newTestServer(t, "00.define-clusters-proj.yaml",
+		"01.instantiate-lc.yaml", "02.define-app-dig.yaml")
+	start := clock.Now()
+
+	status := getStatus(t, server)
+	assert.Equal(t, DigStatus{Project: "proj1", CompositeApp: "capp1",
+		CompositeAppVersion: "v1", Name: "dig1", States: StateInfo{Actions: []StateAction{}},
+		DeployedStatus: StateCreated, Apps: []AppStatus{
+			{Name: "collectd", Clusters: []ClusterStatus{}}}}, status)
+
+	code, _ := call(t, server, "POST", testDigPath+"/instantiate", nil)
+	assert.Equal(t, http.StatusConflict, code, "instantiate before approve")
+	code, _ = call(t, server, "POST", testDigPath+"/approve", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+	code, _ = call(t, server, "POST", testDigPath+"/instantiate", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	// The cluster label of the app intent places it on cluster1.
+	status = getStatus(t, server)
+	assert.Equal(t, StateInstantiated, status.DeployedStatus)
+	assert.Equal(t, ReadyNotReady, status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider1", "cluster1", DeployedApplied, ReadyNotReady}}}}, status.Apps)
+
+	clock.Advance(5 * time.Second)
+	status = getStatus(t, server)
+	assert.Equal(t, ReadyReady, status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider1", "cluster1", DeployedApplied, ReadyReady}}}}, status.Apps)
+
+	// Moving the app to cluster2 removes it from cluster1 once updated.
+	code, _ = call(t, server, "PUT", testAppIntentPath, placedOn("provider2", "cluster2"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "provider1", getStatus(t, server).Apps[0].Clusters[0].ClusterProvider)
+	clock.Advance(time.Second)
+	code, _ = call(t, server, "POST", testDigPath+"/update", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	status = getStatus(t, server)
+	assert.Equal(t, ReadyNotReady, status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider1", "cluster1", DeployedDeleted, ReadyNotReady},
+		{"provider2", "cluster2", DeployedApplied, ReadyNotReady}}}}, status.Apps)
+
+	clock.Advance(5 * time.Second)
+	status = getStatus(t, server)
+	assert.Equal(t, ReadyReady, status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider2", "cluster2", DeployedApplied, ReadyReady}}}}, status.Apps)
+
+	code, _ = call(t, server, "POST", testDigPath+"/terminate", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+	status = getStatus(t, server)
+	assert.Equal(t, StateTerminated, status.DeployedStatus)
+	assert.Equal(t, "", status.ReadyStatus)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{
+		{"provider2", "cluster2", DeployedDeleted, ReadyNotReady}}}}, status.Apps)
+
+	clock.Advance(5 * time.Second)
+	status = getStatus(t, server)
+	assert.Equal(t, []AppStatus{{Name: "collectd", Clusters: []ClusterStatus{}}},
+		status.Apps)
+	assert.Equal(t, []StateAction{
+		{State: StateApproved, Time: start},
+		{State: StateInstantiated, Time: start},
+		{State: StateInstantiated, Time: start.Add(6 * time.Second)},
+		{State: StateTerminated, Time: start.Add(11 * time.Second)},
+	}, status.States.Actions)
+
+	code, _ = call(t, server, "POST", testDigPath+"/update", nil)
+	assert.Equal(t, http.StatusConflict, code, "update after terminate")
+	code, _ = call(t, server, "POST", testDigPath+"/terminate", nil)
+	assert.Equal(t, http.StatusConflict, code, "terminate after terminate")
+}
+
+func TestDigActionErrors(t *testing.T) {
+	_, server, _ := newTestServer(t, "00.define-clusters-proj.yaml",
+		"01.instantiate-lc.yaml", "02.define-app-dig.yaml", "03.instantiate-dig.yaml")
+	missing := "/v2/projects/proj1/composite-apps/capp1/v1/deployment-intent-groups/dig2"
+
+	tests := []struct {
+		method, path string
+		body         interface{}
+		code         int
+	}{
+		{"GET", testDigPath + "/status", nil, http.StatusOK},
+		{"POST", testDigPath + "/status", nil, http.StatusMethodNotAllowed},
+		{"GET", testDigPath + "/update", nil, http.StatusMethodNotAllowed},
+		{"GET", missing + "/status", nil, http.StatusNotFound},
+		{"POST", missing + "/instantiate", nil, http.StatusNotFound},
+		{"POST", testDigPath + "/approve", nil, http.StatusConflict},
+		{"POST", testDigPath + "/instantiate", nil, http.StatusConflict},
+		// an app intent on a cluster that does not exist
+		{"PUT", testAppIntentPath, placedOn("provider2", "cluster9"), http.StatusOK},
+		{"POST", testDigPath + "/update", nil, http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		code, _ := call(t, server, tc.method, tc.path, tc.body)
+		assert.Equal(t, tc.code, code, "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestResources(t *testing.T) {
+	fake, server, _ := newTestServer(t)
+	project := map[string]interface{}{"metadata": map[string]interface{}{"name": "proj1"}}
+	app := func(name string) map[string]interface{} {
+		return map[string]interface{}{"metadata": map[string]interface{}{"name": name}}
+	}
+	capp := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "capp1"},
+		"spec":     map[string]interface{}{"compositeAppVersion": "v1"},
+	}
+	apps := "/v2/projects/proj1/composite-apps/capp1/v1/apps"
+
+	tests := []struct {
+		method, path string
+		body         interface{}
+		code         int
+		result       interface{}
+	}{
+		{"POST", "/v2/projects/proj1/composite-apps", capp, http.StatusNotFound,
+			"parent resource projects/proj1 not found\n"},
+		{"POST", "/v2/projects", project, http.StatusCreated, project},
+		{"POST", "/v2/projects", project, http.StatusConflict,
+			"projects/proj1 already exists\n"},
+		{"POST", "/v2/projects", map[string]interface{}{}, http.StatusBadRequest,
+			"missing metadata.name\n"},
+		{"POST", "/v2/projects/proj1", project, http.StatusMethodNotAllowed,
+			"POST is supported only on collections, not projects/proj1\n"},
+		{"POST", "/v2/projects/proj1/composite-apps", capp, http.StatusCreated, capp},
+		{"GET", "/v2/projects/proj1/composite-apps/capp1/v1", nil, http.StatusOK, capp},
+		{"POST", apps, app("collectd"), http.StatusCreated, app("collectd")},
+		{"PUT", apps + "/operator", app("operator"), http.StatusCreated, app("operator")},
+		{"PUT", apps + "/operator", app("operator"), http.StatusOK, app("operator")},
+		{"PUT", apps + "/operator", app("sink"), http.StatusBadRequest,
+			"metadata.name sink does not match URL " +
+				"projects/proj1/composite-apps/capp1/v1/apps/operator\n"},
+		{"PUT", apps, app("sink"), http.StatusMethodNotAllowed,
+			"PUT is not supported on collection projects/proj1/composite-apps/capp1/v1/apps\n"},
+		{"GET", apps, nil, http.StatusOK,
+			[]interface{}{app("collectd"), app("operator")}},
+		{"DELETE", apps + "/collectd", nil, http.StatusNoContent, nil},
+		{"DELETE", apps + "/collectd", nil, http.StatusNotFound,
+			"projects/proj1/composite-apps/capp1/v1/apps/collectd not found\n"},
+		{"GET", apps, nil, http.StatusOK, []interface{}{app("operator")}},
+		{"GET", "/v2/projects/proj2", nil, http.StatusNotFound,
+			"projects/proj2 not found\n"},
+		{"GET", "/v1/projects", nil, http.StatusNotFound, "404 page not found\n"},
+		{"PATCH", "/v2/projects/proj1", nil, http.StatusMethodNotAllowed,
+			"method PATCH not allowed\n"},
+	}
+	for _, tc := range tests {
+		code, result := call(t, server, tc.method, tc.path, tc.body)
+		assert.Equal(t, tc.code, code, "%s %s", tc.method, tc.path)
+		assert.Equal(t, jsonValue(t, tc.result), result, "%s %s", tc.method, tc.path)
+	}
+
+	// Deleting a resource deletes everything under it.
+	code, _ := call(t, server, "DELETE", "/v2/projects/proj1", nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Empty(t, fake.Paths())
+}
+
+// jsonValue returns v as decoded from its JSON encoding, to compare with
+// responses.
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	if _, ok := v.(string); ok || v == nil {
+		return v
+	}
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	var value interface{}
+	require.NoError(t, json.Unmarshal(data, &value))
+	return value
+}
+
+func TestLogicalCloudActions(t *testing.T) {
+	_, server, _ := newTestServer(t, "00.define-clusters-proj.yaml")
+	cloud := "/v2/projects/proj1/logical-clouds/default"
+
+	state := func() interface{} {
+		code, result := call(t, server, "GET", cloud+"/status", nil)
+		require.Equal(t, http.StatusOK, code)
+		return result.(map[string]interface{})["deployedStatus"]
+	}
+	assert.Equal(t, StateCreated, state())
+	code, _ := call(t, server, "POST", cloud+"/update", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = call(t, server, "POST", cloud+"/instantiate", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, StateInstantiated, state())
+	code, _ = call(t, server, "POST", cloud+"/instantiate", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = call(t, server, "POST", cloud+"/update", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+	code, _ = call(t, server, "POST", cloud+"/terminate", nil)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, StateTerminated, state())
+}
+
+func TestIsCollection(t *testing.T) {
+	tests := []struct {
+		path       string
+		collection bool
+	}{
+		{"projects", true},
+		{"projects/proj1", false},
+		{"projects/proj1/composite-apps", true},
+		{"projects/proj1/composite-apps/capp1/v1", false},
+		{"projects/proj1/composite-apps/capp1/v1/apps", true},
+		{"projects/proj1/composite-apps/capp1/v1/apps/collectd", false},
+		{"cluster-providers/provider1/clusters/cluster1/labels", true},
+		{"cluster-providers/provider1/clusters/cluster1/labels/edge-cluster", false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.collection, isCollection(strings.Split(tc.path, "/")), tc.path)
+	}
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+// Fake EMCO server for local development and tests.
+// Serves the EMCO APIs used by the migrate workflow from memory, optionally
+// preloaded from emcoctl YAML files such as those in samples/intents.
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/handlers"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/fakeemco"
+)
+
+type multiFlag []string
+
+func (m *multiFlag) String() string { return strings.Join(*m, ",") }
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+func main() {
+	var intentFiles, valuesFiles multiFlag
+	port := flag.String("port", "30415", "Port to listen on")
+	readyDelay := flag.Duration("ready-delay", 5*time.Second,
+		"Time for an app deployed to a cluster to become ready")
+	flag.Var(&intentFiles, "f", "emcoctl YAML file to load at startup (repeatable)")
+	flag.Var(&valuesFiles, "v", "Values file for the YAML files (repeatable)")
+	flag.Parse()
+
+	server := fakeemco.NewServer(fakeemco.Options{ReadyDelay: *readyDelay})
+
+	values, err := fakeemco.ReadValues(valuesFiles...)
+	if err != nil {
+		log.Fatalln("unable to read values files", err)
+	}
+	if err := server.LoadIntentFiles(values, intentFiles...); err != nil {
+		log.Fatalln("unable to load intents", err)
+	}
+	for _, path := range server.Paths() {
+		log.Printf("Loaded /v2/%s\n", path)
+	}
+
+	log.Printf("Starting fake EMCO on port %s\n", *port)
+	err = http.ListenAndServe(":"+*port, handlers.LoggingHandler(os.Stdout, server))
+	log.Printf("fake EMCO returned: %s\n", err)
+}
@@ -4,9 +4,11 @@ package main
 // Registers app-specific workflow and activity code, then runs them.
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -15,8 +17,9 @@ import (
 )
 
 const (
-	temporal_env_var = "TEMPORAL_SERVER"
-	temporal_port    = "7233"
+	temporal_env_var     = "TEMPORAL_SERVER"
+	temporal_port        = "7233"
+	search_attrs_env_var = "SEARCH_ATTRIBUTES" // "on", "off", or unset to detect
 )
 
 func main() {
@@ -36,12 +39,55 @@ func main() {
 		log.Fatalln("unable to create Temporal client", err)
 	}
 	defer c.Close()
+	emcomigrate.SetTemporalClient(c)
+
+	// Keep the search attributes of migrations up to date, if the Temporal
+	// server knows them. Servers that register search attributes per
+	// namespace do not list them to this client, so $SEARCH_ATTRIBUTES can
+	// override the check.
+	switch os.Getenv(search_attrs_env_var) {
+	case "on":
+		emcomigrate.EnableSearchAttributes(true)
+		log.Printf("Search attributes enabled by $%s\n", search_attrs_env_var)
+	case "off":
+		log.Printf("Search attributes disabled by $%s\n", search_attrs_env_var)
+	default:
+		resp, err := c.GetSearchAttributes(context.Background())
+		if err != nil {
+			log.Printf("Search attributes disabled: failed to get them from Temporal: %s\n", err)
+		} else if missing := emcomigrate.MissingSearchAttributes(resp.GetKeys()); len(missing) > 0 {
+			log.Printf("Search attributes disabled: not registered with Temporal: %s\n",
+				strings.Join(missing, ", "))
+		} else {
+			emcomigrate.EnableSearchAttributes(true)
+			log.Printf("Search attributes enabled\n")
+		}
+	}
+
 	// This worker hosts both Workflow and Activity functions
 	w := worker.New(c, emcomigrate.MigTaskQueue, worker.Options{})
 	w.RegisterWorkflow(emcomigrate.EmcoMigrateWorkflow)
+	w.RegisterWorkflow(emcomigrate.DigLockWorkflow)
+	w.RegisterWorkflow(emcomigrate.MigScheduleWorkflow)
+	w.RegisterActivity(emcomigrate.AddClusterToLogicalCloud)
+	w.RegisterActivity(emcomigrate.PreflightCheck)
 	w.RegisterActivity(emcomigrate.GetDigAppIntents)
 	w.RegisterActivity(emcomigrate.UpdateAppIntents)
+	w.RegisterActivity(emcomigrate.UpdateOtherIntents)
 	w.RegisterActivity(emcomigrate.DoDigUpdate)
+	w.RegisterActivity(emcomigrate.CloneDIG)
+	w.RegisterActivity(emcomigrate.VerifyDIG)
+	w.RegisterActivity(emcomigrate.VerifyReturn)
+	w.RegisterActivity(emcomigrate.TerminateDIG)
+	w.RegisterActivity(emcomigrate.DeleteDIGClone)
+	w.RegisterActivity(emcomigrate.RevertAppIntents)
+	w.RegisterActivity(emcomigrate.RevertOtherIntents)
+	w.RegisterActivity(emcomigrate.CheckWaveHealth)
+	w.RegisterActivity(emcomigrate.GetAppDependencies)
+	w.RegisterActivity(emcomigrate.RemoveClusterFromLogicalCloud)
+	w.RegisterActivity(emcomigrate.SendNotification)
+	w.RegisterActivity(emcomigrate.RequestDIGLock)
+	w.RegisterActivity(emcomigrate.IsWorkflowRunning)
 
 	// Start listening to the Task Queue
 	err = w.Run(worker.InterruptCh())
@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// How long an invoke with an identical payload is treated as a retry of an
+// earlier one, even after the workflow it started has closed.
+const dedupWindow = 10 * time.Minute
+
+// invocation records a workflow run started by this server.
+type invocation struct {
+	runID     string
+	digest    string
+	startedAt time.Time
+}
+
+// invokeResult is the response body returned when an invoke is deduplicated.
+type invokeResult struct {
+	WorkflowID   string `json:"workflowID"`
+	RunID        string `json:"runID"`
+	Deduplicated bool   `json:"deduplicated"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// idLock is a reference-counted lock for a single workflow ID.
+type idLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// dedupCache remembers recent invocations by workflow ID and serializes
+// concurrent invocations for the same workflow ID.
+type dedupCache struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]invocation
+	locks   map[string]*idLock
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: make(map[string]invocation),
+		locks:   make(map[string]*idLock),
+	}
+}
+
+// lock blocks till no other invocation holds the given workflow ID and
+// returns the function that releases it.
+func (d *dedupCache) lock(wfID string) func() {
+	d.mu.Lock()
+	l, ok := d.locks[wfID]
+	if !ok {
+		l = &idLock{}
+		d.locks[wfID] = l
+	}
+	l.refs++
+	d.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		d.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(d.locks, wfID)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// recent returns the invocation for wfID if it was made within the window.
+func (d *dedupCache) recent(wfID string) (invocation, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	inv, ok := d.entries[wfID]
+	if !ok {
+		return invocation{}, false
+	}
+	if time.Since(inv.startedAt) > d.window {
+		delete(d.entries, wfID)
+		return invocation{}, false
+	}
+	return inv, true
+}
+
+func (d *dedupCache) record(wfID string, inv invocation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Drop expired entries so the map does not grow without bound.
+	for id, old := range d.entries {
+		if time.Since(old.startedAt) > d.window {
+			delete(d.entries, id)
+		}
+	}
+	d.entries[wfID] = inv
+}
+
+// payloadDigest returns a digest of the POST body that ignores formatting
+// and key order, so that re-encoded retries of the same payload match.
+func payloadDigest(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canon, err := json.Marshal(v); err == nil {
+			body = canon
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// findExistingRun decides whether an invoke for wfID should reuse an existing
+// workflow run instead of starting a new one. It returns nil if a new run
+// should be started. Without a Temporal client, only the in-memory record of
+// recent invocations is consulted.
+func (d *dedupCache) findExistingRun(ctx context.Context, c client.Client,
+	wfID string, reusePolicy enumspb.WorkflowIdReusePolicy,
+	digest string) (*invokeResult, error) {
+
+	inv, isRecent := d.recent(wfID)
+	sameAsRecent := isRecent && inv.digest == digest
+
+	if c == nil {
+		if sameAsRecent {
+			return &invokeResult{WorkflowID: wfID, RunID: inv.runID,
+				Deduplicated: true,
+				Reason:       "identical payload was recently invoked"}, nil
+		}
+		return nil, nil
+	}
+
+	resp, err := c.DescribeWorkflowExecution(ctx, wfID, "")
+	if err != nil {
+		if _, ok := err.(*serviceerror.NotFound); ok {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to describe workflow %s: %s", wfID, err)
+	}
+	info := resp.GetWorkflowExecutionInfo()
+	runID := info.GetExecution().GetRunId()
+	status := info.GetStatus()
+
+	result := &invokeResult{WorkflowID: wfID, RunID: runID, Deduplicated: true}
+	switch {
+	case status == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING:
+		result.Reason = "workflow is already running"
+	case sameAsRecent && inv.runID == runID:
+		result.Reason = "identical payload was recently invoked"
+	case !reuseAllowed(reusePolicy, status):
+		result.Reason = fmt.Sprintf("%s does not allow a new run after a "+
+			"run with status %s", reusePolicy, status)
+	default:
+		return nil, nil
+	}
+	return result, nil
+}
+
+// reuseAllowed reports whether Temporal would start a new run for a workflow
+// ID whose latest run closed with the given status.
+func reuseAllowed(policy enumspb.WorkflowIdReusePolicy,
+	status enumspb.WorkflowExecutionStatus) bool {
+
+	switch policy {
+	case enumspb.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE:
+		return false
+	case enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY:
+		return status != enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED
+	default: // unspecified defaults to ALLOW_DUPLICATE in Temporal
+		return true
+	}
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+)
+
+const (
+	allowDuplicate = enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE
+	failedOnly     = enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY
+	rejectDup      = enumspb.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE
+
+	running    = enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING
+	completed  = enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED
+	failed     = enumspb.WORKFLOW_EXECUTION_STATUS_FAILED
+	canceled   = enumspb.WORKFLOW_EXECUTION_STATUS_CANCELED
+	terminated = enumspb.WORKFLOW_EXECUTION_STATUS_TERMINATED
+)
+
+// describeResponse returns the response of DescribeWorkflowExecution for a
+// latest run of wfID with the given ID and status.
+func describeResponse(wfID, runID string,
+	status enumspb.WorkflowExecutionStatus) *workflowservice.DescribeWorkflowExecutionResponse {
+
+	return &workflowservice.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{
+			Execution: &commonpb.WorkflowExecution{WorkflowId: wfID, RunId: runID},
+			Status:    status,
+		},
+	}
+}
+
+func TestReuseAllowed(t *testing.T) {
+	tests := []struct {
+		policy enumspb.WorkflowIdReusePolicy
+		status enumspb.WorkflowExecutionStatus
+		want   bool
+	}{
+		{enumspb.WORKFLOW_ID_REUSE_POLICY_UNSPECIFIED, completed, true},
+		{enumspb.WORKFLOW_ID_REUSE_POLICY_UNSPECIFIED, failed, true},
+		{allowDuplicate, completed, true},
+		{allowDuplicate, failed, true},
+		{allowDuplicate, canceled, true},
+		{allowDuplicate, terminated, true},
+		{failedOnly, completed, false},
+		{failedOnly, failed, true},
+		{failedOnly, canceled, true},
+		{failedOnly, terminated, true},
+		{rejectDup, completed, false},
+		{rejectDup, failed, false},
+		{rejectDup, canceled, false},
+		{rejectDup, terminated, false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, reuseAllowed(tc.policy, tc.status),
+			"%s after %s", tc.policy, tc.status)
+	}
+}
+
+func TestPayloadDigest(t *testing.T) {
+	base := payloadDigest([]byte(`{"workflowID":"w1","params":{"a":"1","b":"2"}}`))
+	tests := []struct {
+		name string
+		body string
+		same bool
+	}{
+		{"reformatted", "{\n  \"workflowID\": \"w1\",\n  \"params\": {\"a\": \"1\", \"b\": \"2\"}\n}", true},
+		{"reordered", `{"params":{"b":"2","a":"1"},"workflowID":"w1"}`, true},
+		{"changed value", `{"workflowID":"w1","params":{"a":"1","b":"3"}}`, false},
+		{"extra key", `{"workflowID":"w1","params":{"a":"1","b":"2","c":"3"}}`, false},
+		{"not json", `workflowID=w1`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.same, payloadDigest([]byte(tc.body)) == base)
+		})
+	}
+
+	// bodies that are not JSON are digested as they are
+	assert.Equal(t, payloadDigest([]byte("a b")), payloadDigest([]byte("a b")))
+	assert.NotEqual(t, payloadDigest([]byte("a b")), payloadDigest([]byte("a  b")))
+}
+
+func TestFindExistingRun(t *testing.T) {
+	const wfID = "migrate-dig1"
+	tests := []struct {
+		name     string
+		recorded *invocation // of run1, made a minute ago with digest d1
+		digest   string
+		policy   enumspb.WorkflowIdReusePolicy
+		status   enumspb.WorkflowExecutionStatus // of run1; 0 if not found
+		reason   string                          // "" if a new run should start
+	}{
+		{"not found", nil, "d1", allowDuplicate, 0, ""},
+		{"running", nil, "d1", allowDuplicate, running,
+			"workflow is already running"},
+		{"running, different payload", &invocation{runID: "run1", digest: "d1"}, "d2",
+			allowDuplicate, running, "workflow is already running"},
+		{"completed, recent identical payload", &invocation{runID: "run1", digest: "d1"},
+			"d1", allowDuplicate, completed, "identical payload was recently invoked"},
+		{"completed, recent different payload", &invocation{runID: "run1", digest: "d1"},
+			"d2", allowDuplicate, completed, ""},
+		{"completed, identical payload of an older run", &invocation{runID: "run0", digest: "d1"},
+			"d1", allowDuplicate, completed, ""},
+		{"completed, not recorded", nil, "d1", allowDuplicate, completed, ""},
+		{"completed, failed only", nil, "d1", failedOnly, completed,
+			"AllowDuplicateFailedOnly does not allow a new run after a run with " +
+				"status Completed"},
+		{"failed, failed only", nil, "d1", failedOnly, failed, ""},
+		{"failed, reject duplicate", nil, "d1", rejectDup, failed,
+			"RejectDuplicate does not allow a new run after a run with status Failed"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDedupCache(dedupWindow)
+			if tc.recorded != nil {
+				inv := *tc.recorded
+				inv.startedAt = time.Now().Add(-time.Minute)
+				d.record(wfID, inv)
+			}
+			c := &mocks.Client{}
+			if tc.status == 0 {
+				c.On("DescribeWorkflowExecution", mock.Anything, wfID, "").
+					Return(nil, serviceerror.NewNotFound("workflow not found"))
+			} else {
+				c.On("DescribeWorkflowExecution", mock.Anything, wfID, "").
+					Return(describeResponse(wfID, "run1", tc.status), nil)
+			}
+
+			result, err := d.findExistingRun(context.Background(), c, wfID,
+				tc.policy, tc.digest)
+			require.NoError(t, err)
+			c.AssertExpectations(t)
+			if tc.reason == "" {
+				assert.Nil(t, result)
+				return
+			}
+			assert.Equal(t, &invokeResult{WorkflowID: wfID, RunID: "run1",
+				Deduplicated: true, Reason: tc.reason}, result)
+		})
+	}
+}
+
+func TestFindExistingRunDescribeError(t *testing.T) {
+	c := &mocks.Client{}
+	c.On("DescribeWorkflowExecution", mock.Anything, "w1", "").
+		Return(nil, errors.New("connection refused"))
+
+	d := newDedupCache(dedupWindow)
+	_, err := d.findExistingRun(context.Background(), c, "w1", allowDuplicate, "d1")
+	require.Error(t, err)
+	assert.Equal(t, "Failed to describe workflow w1: connection refused", err.Error())
+}
+
+func TestFindExistingRunWithoutClient(t *testing.T) {
+	d := newDedupCache(dedupWindow)
+	d.record("w1", invocation{runID: "run1", digest: "d1", startedAt: time.Now()})
+
+	result, err := d.findExistingRun(context.Background(), nil, "w1", rejectDup, "d1")
+	require.NoError(t, err)
+	assert.Equal(t, &invokeResult{WorkflowID: "w1", RunID: "run1", Deduplicated: true,
+		Reason: "identical payload was recently invoked"}, result)
+
+	for _, tc := range []struct{ wfID, digest string }{{"w1", "d2"}, {"w2", "d1"}} {
+		result, err = d.findExistingRun(context.Background(), nil, tc.wfID, rejectDup,
+			tc.digest)
+		require.NoError(t, err)
+		assert.Nil(t, result, "%s with %s", tc.wfID, tc.digest)
+	}
+}
+
+func TestDedupWindowExpiry(t *testing.T) {
+	d := newDedupCache(dedupWindow)
+	d.record("old", invocation{runID: "run1", digest: "d1",
+		startedAt: time.Now().Add(-dedupWindow - time.Second)})
+	d.record("new", invocation{runID: "run2", digest: "d2",
+		startedAt: time.Now().Add(-dedupWindow + time.Minute)})
+
+	_, ok := d.recent("old")
+	assert.False(t, ok, "invocation older than the window")
+	inv, ok := d.recent("new")
+	assert.True(t, ok, "invocation within the window")
+	assert.Equal(t, "run2", inv.runID)
+
+	// an expired invocation no longer deduplicates, even without a client
+	result, err := d.findExistingRun(context.Background(), nil, "old", rejectDup, "d1")
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	// recording drops expired entries
+	d.record("old2", invocation{runID: "run3", digest: "d3",
+		startedAt: time.Now().Add(-2 * dedupWindow)})
+	d.record("w3", invocation{runID: "run4", digest: "d4", startedAt: time.Now()})
+	assert.NotContains(t, d.entries, "old2")
+	assert.Contains(t, d.entries, "new")
+	assert.Contains(t, d.entries, "w3")
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+const (
+	eventsURL    = "/workflows/{id}/events" // URL to stream workflow progress
+	sseKeepAlive = 15 * time.Second
+)
+
+// stateEvent is the data of a "state" event in the stream.
+type stateEvent struct {
+	WorkflowID      string    `json:"workflowID"`
+	RunID           string    `json:"runID"`
+	State           string    `json:"state"`
+	WorkflowVersion int       `json:"workflowVersion"`
+	Wave            string    `json:"wave,omitempty"`
+	WaitingUntil    string    `json:"waitingUntil,omitempty"`
+	EventID         int64     `json:"eventID"`
+	Time            time.Time `json:"time"`
+}
+
+// endEvent is the data of the "end" event that closes the stream.
+type endEvent struct {
+	WorkflowID string `json:"workflowID"`
+	RunID      string `json:"runID"`
+	Status     string `json:"status"`
+}
+
+// streamWorkflowEvents streams the state transitions of a workflow run as
+// server-sent events, till the run closes or the client disconnects.
+//
+//	The URL is expected to be of the form /workflows/$workflow_id/events ,
+//	optionally with a runId query parameter; the latest run is followed by
+//	default. The workflow history is long-polled and the migration-state query
+//	is run whenever a workflow task completes, since the state can change
+//	only then. A "state" event is sent each time the state changes, and an
+//	"end" event with the final status when the run closes.
+func streamWorkflowEvents(w http.ResponseWriter, r *http.Request) {
+	if temporalClient == nil {
+		err := fmt.Errorf("Cannot stream events: $%s is not defined",
+			temporal_env_var)
+		log.Printf(err.Error())
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	wfID := mux.Vars(r)["id"]
+	runID := r.URL.Query().Get("runId")
+	if runID == "" {
+		// Pin the run so that the history and queries refer to the same run.
+		resp, err := temporalClient.DescribeWorkflowExecution(ctx, wfID, "")
+		if err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(*serviceerror.NotFound); ok {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		runID = resp.GetWorkflowExecutionInfo().GetExecution().GetRunId()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := make(chan *historypb.HistoryEvent)
+	iterErr := make(chan error, 1)
+	go func() {
+		defer close(events)
+		iter := temporalClient.GetWorkflowHistory(ctx, wfID, runID, true,
+			enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+		for iter.HasNext() {
+			event, err := iter.Next()
+			if err != nil {
+				iterErr <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	lastState := ""
+	sendState := func(event *historypb.HistoryEvent) {
+		state, err := emcomigrate.QueryMigState(ctx, temporalClient, wfID, runID)
+		if err != nil {
+			log.Printf("Query %s failed for workflow %s: %s\n",
+				emcomigrate.MigrationStateQuery, wfID, err)
+			return
+		}
+		key := state.State + " " + state.Wave + " " + state.WaitingUntil
+		if key == lastState {
+			return
+		}
+		lastState = key
+		data := stateEvent{
+			WorkflowID:      wfID,
+			RunID:           runID,
+			State:           state.State,
+			WorkflowVersion: state.WorkflowVersion,
+			Wave:            state.Wave,
+			WaitingUntil:    state.WaitingUntil,
+			EventID:         event.GetEventId(),
+		}
+		if t := event.GetEventTime(); t != nil {
+			data.Time = t.UTC()
+		}
+		writeSSE(w, "state", event.GetEventId(), data)
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case event, ok := <-events:
+			if !ok {
+				select {
+				case err := <-iterErr:
+					log.Printf("History of workflow %s failed: %s\n", wfID, err)
+					writeSSE(w, "error", 0, map[string]string{"error": err.Error()})
+					flusher.Flush()
+				default:
+				}
+				return
+			}
+			eventType := event.GetEventType()
+			if status, closed := closedStatus(eventType); closed {
+				sendState(event)
+				writeSSE(w, "end", event.GetEventId(), endEvent{
+					WorkflowID: wfID,
+					RunID:      runID,
+					Status:     status,
+				})
+				flusher.Flush()
+				return
+			}
+			if eventType == enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED {
+				sendState(event)
+			}
+		}
+	}
+}
+
+// closedStatus returns the workflow status for history events that close a
+// workflow run.
+func closedStatus(eventType enumspb.EventType) (string, bool) {
+	switch eventType {
+	case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED:
+		return "completed", true
+	case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_FAILED:
+		return "failed", true
+	case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_TIMED_OUT:
+		return "timed-out", true
+	case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_CANCELED:
+		return "canceled", true
+	case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_TERMINATED:
+		return "terminated", true
+	case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_CONTINUED_AS_NEW:
+		return "continued-as-new", true
+	}
+	return "", false
+}
+
+// writeSSE writes one server-sent event with JSON data.
+func writeSSE(w http.ResponseWriter, event string, id int64, data interface{}) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %s\n", event, err)
+		return
+	}
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, dataJSON)
+}
@@ -0,0 +1,307 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+// queryValue is the result of a workflow query, as the SDK returns it.
+type queryValue struct {
+	value interface{}
+}
+
+func (v queryValue) HasValue() bool {
+	return v.value != nil
+}
+
+func (v queryValue) Get(valuePtr interface{}) error {
+	data, err := json.Marshal(v.value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, valuePtr)
+}
+
+// historyIterator returns the given history events, and then err if set, or
+// else blocks till ctx is done if it is set.
+type historyIterator struct {
+	events []*historypb.HistoryEvent
+	err    error
+	ctx    context.Context
+}
+
+func (it *historyIterator) HasNext() bool {
+	if len(it.events) > 0 || it.err != nil {
+		return true
+	}
+	if it.ctx != nil {
+		<-it.ctx.Done()
+	}
+	return false
+}
+
+func (it *historyIterator) Next() (*historypb.HistoryEvent, error) {
+	if len(it.events) == 0 {
+		return nil, it.err
+	}
+	event := it.events[0]
+	it.events = it.events[1:]
+	return event, nil
+}
+
+func historyEvent(id int64, eventType enumspb.EventType) *historypb.HistoryEvent {
+	t := time.Date(2022, 6, 1, 10, 0, int(id), 0, time.UTC)
+	return &historypb.HistoryEvent{EventId: id, EventType: eventType, EventTime: &t}
+}
+
+// withClient makes the handlers use c as the Temporal client during the test.
+func withClient(t *testing.T, c client.Client) {
+	saved := temporalClient
+	temporalClient = c
+	t.Cleanup(func() { temporalClient = saved })
+}
+
+// newTestServer returns a server that routes path to handler.
+func newTestServer(t *testing.T, path, method string, handler http.HandlerFunc) *httptest.Server {
+	router := mux.NewRouter()
+	router.HandleFunc(path, handler).Methods(method)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// sseFrame is one event of a server-sent event stream.
+type sseFrame struct {
+	id, event, data string
+}
+
+// readSSE reads the events of a stream till it ends.
+func readSSE(t *testing.T, resp *http.Response) []sseFrame {
+	frames := []sseFrame{}
+	frame := sseFrame{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if frame.event != "" {
+				frames = append(frames, frame)
+			}
+			frame = sseFrame{}
+		case strings.HasPrefix(line, "id: "):
+			frame.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			frame.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			frame.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return frames
+}
+
+func TestStreamWorkflowEvents(t *testing.T) {
+	c := &mocks.Client{}
+	withClient(t, c)
+	c.On("DescribeWorkflowExecution", mock.Anything, "w1", "").
+		Return(describeResponse("w1", "run1", running), nil)
+	c.On("GetWorkflowHistory", mock.Anything, "w1", "run1", true,
+		enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT).
+		Return(&historyIterator{events: []*historypb.HistoryEvent{
+			historyEvent(1, enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED),
+			historyEvent(2, enumspb.EVENT_TYPE_WORKFLOW_TASK_SCHEDULED),
+			historyEvent(4, enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED),
+			historyEvent(5, enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED),
+			historyEvent(10, enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED),
+			historyEvent(16, enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED),
+			historyEvent(17, enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED),
+			historyEvent(18, enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED), // not read
+		}})
+	// The state is queried on each completed workflow task, and when the
+	// run closes; a state that did not change is not sent again.
+	for _, state := range []emcomigrate.MigState{
+		{State: "PreflightCheck", WorkflowVersion: 14},
+		{State: "PreflightCheck", WorkflowVersion: 14},
+		{State: "UpdateAppIntents", WorkflowVersion: 14, Wave: "1/2"},
+		{State: "completed", WorkflowVersion: 14},
+	} {
+		c.On("QueryWorkflow", mock.Anything, "w1", "run1",
+			emcomigrate.MigrationStateQuery).Return(queryValue{state}, nil).Once()
+	}
+
+	server := newTestServer(t, eventsURL, "GET", streamWorkflowEvents)
+	resp, err := http.Get(server.URL + "/workflows/w1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	assert.Equal(t, []sseFrame{
+		{"4", "state", `{"workflowID":"w1","runID":"run1","state":"PreflightCheck",` +
+			`"workflowVersion":14,"eventID":4,"time":"2022-06-01T10:00:04Z"}`},
+		{"16", "state", `{"workflowID":"w1","runID":"run1","state":"UpdateAppIntents",` +
+			`"workflowVersion":14,"wave":"1/2","eventID":16,"time":"2022-06-01T10:00:16Z"}`},
+		{"17", "state", `{"workflowID":"w1","runID":"run1","state":"completed",` +
+			`"workflowVersion":14,"eventID":17,"time":"2022-06-01T10:00:17Z"}`},
+		{"17", "end", `{"workflowID":"w1","runID":"run1","status":"completed"}`},
+	}, readSSE(t, resp))
+	c.AssertExpectations(t)
+}
+
+func TestStreamWorkflowEventsOfRun(t *testing.T) {
+	c := &mocks.Client{}
+	withClient(t, c)
+	c.On("GetWorkflowHistory", mock.Anything, "w1", "run0", true,
+		enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT).
+		Return(&historyIterator{events: []*historypb.HistoryEvent{
+			historyEvent(3, enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED),
+			historyEvent(7, enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_FAILED),
+		}})
+	c.On("QueryWorkflow", mock.Anything, "w1", "run0", emcomigrate.MigrationStateQuery).
+		Return(nil, errors.New("query failed")).Once()
+	// workers that predate the migration-state query return the state name
+	// only, with the current-state query
+	c.On("QueryWorkflow", mock.Anything, "w1", "run0", emcomigrate.MigrationStateQuery).
+		Return(nil, serviceerror.NewQueryFailed("unknown queryType migration-state")).Once()
+	c.On("QueryWorkflow", mock.Anything, "w1", "run0", emcomigrate.CurrentStateQuery).
+		Return(queryValue{"UpdateAppIntents"}, nil).Once()
+
+	server := newTestServer(t, eventsURL, "GET", streamWorkflowEvents)
+	resp, err := http.Get(server.URL + "/workflows/w1/events?runId=run0")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []sseFrame{
+		{"7", "state", `{"workflowID":"w1","runID":"run0","state":"UpdateAppIntents",` +
+			`"workflowVersion":0,"eventID":7,"time":"2022-06-01T10:00:07Z"}`},
+		{"7", "end", `{"workflowID":"w1","runID":"run0","status":"failed"}`},
+	}, readSSE(t, resp))
+	c.AssertNotCalled(t, "DescribeWorkflowExecution", mock.Anything, mock.Anything,
+		mock.Anything)
+	c.AssertExpectations(t)
+}
+
+func TestStreamWorkflowEventsHistoryError(t *testing.T) {
+	c := &mocks.Client{}
+	withClient(t, c)
+	c.On("GetWorkflowHistory", mock.Anything, "w1", "run1", true,
+		enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT).
+		Return(&historyIterator{err: errors.New("history unavailable")})
+
+	server := newTestServer(t, eventsURL, "GET", streamWorkflowEvents)
+	resp, err := http.Get(server.URL + "/workflows/w1/events?runId=run1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []sseFrame{
+		{"", "error", `{"error":"history unavailable"}`},
+	}, readSSE(t, resp))
+}
+
+func TestStreamWorkflowEventsDisconnect(t *testing.T) {
+	c := &mocks.Client{}
+	withClient(t, c)
+	iter := &historyIterator{events: []*historypb.HistoryEvent{
+		historyEvent(4, enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED),
+	}}
+	c.On("GetWorkflowHistory", mock.Anything, "w1", "run1", true,
+		enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT).
+		Run(func(args mock.Arguments) {
+			iter.ctx = args.Get(0).(context.Context) // long-polls till it is done
+		}).
+		Return(iter)
+	c.On("QueryWorkflow", mock.Anything, "w1", "run1", emcomigrate.MigrationStateQuery).
+		Return(queryValue{emcomigrate.MigState{State: emcomigrate.StateWaitingForLock}}, nil)
+
+	done := make(chan struct{})
+	server := newTestServer(t, eventsURL, "GET", func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		streamWorkflowEvents(w, r)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		server.URL+"/workflows/w1/events?runId=run1", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The state event is flushed before the history is read further.
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	assert.Equal(t, []string{"id: 4", "event: state",
+		`data: {"workflowID":"w1","runID":"run1","state":"waiting-for-lock",` +
+			`"workflowVersion":0,"eventID":4,"time":"2022-06-01T10:00:04Z"}`}, lines)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end when the client disconnected")
+	}
+}
+
+func TestStreamWorkflowEventsErrors(t *testing.T) {
+	c := &mocks.Client{}
+	c.On("DescribeWorkflowExecution", mock.Anything, "missing", "").
+		Return(nil, serviceerror.NewNotFound("workflow not found"))
+	c.On("DescribeWorkflowExecution", mock.Anything, "w1", "").
+		Return(nil, errors.New("connection refused"))
+
+	tests := []struct {
+		name   string
+		client client.Client
+		wfID   string
+		status int
+		body   string
+	}{
+		{"no client", nil, "w1", http.StatusServiceUnavailable,
+			"Cannot stream events: $TEMPORAL_SERVER is not defined\n"},
+		{"not found", c, "missing", http.StatusNotFound, "workflow not found\n"},
+		{"describe failed", c, "w1", http.StatusInternalServerError,
+			"connection refused\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withClient(t, tc.client)
+			server := newTestServer(t, eventsURL, "GET", streamWorkflowEvents)
+			resp, err := http.Get(server.URL + "/workflows/" + tc.wfID + "/events")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+			assert.Equal(t, tc.body, string(body))
+		})
+	}
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -13,9 +14,13 @@ import (
 	"os/exec"
 	"os/signal"
 	"path"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"go.temporal.io/sdk/client"
+
+	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
 )
 
 const (
@@ -23,11 +28,23 @@ const (
 	execDir    = "/opt/emco"
 	invokerURL = "/invoke/{wfclient:[a-zA-Z0-9-_]+}" // URL to invoke the workflow client
 	httpPort   = "9090"
+
+	temporal_env_var = "TEMPORAL_SERVER"
+	temporal_port    = "7233"
+)
+
+var (
+	// temporalClient is nil if $TEMPORAL_SERVER is not defined.
+	temporalClient client.Client
+	invocations    = newDedupCache(dedupWindow)
 )
 
 // runWorkflowClient runs the workflow client named by the URL.
 //  The URL is expected to be of the form /invoke/$workflow_client_name .
 //  The executable binary for the workflow client must be in execDir.
+//  If the POST body names a workflow ID that is already running, or that
+//  was recently invoked with an identical body, the workflow client is not
+//  run and the existing run ID is returned instead.
 func runWorkflowClient(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -41,6 +58,35 @@ func runWorkflowClient(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	wfClientName := params["wfclient"]
 
+	// Bodies that are not a Temporal spec with a workflow ID are passed
+	// through to the workflow client without deduplication.
+	var spec eta.WfTemporalSpec
+	wfID := ""
+	if err := json.Unmarshal(body, &spec); err == nil {
+		wfID = spec.WfStartOpts.ID
+	}
+	digest := payloadDigest(body)
+	if wfID != "" {
+		unlock := invocations.lock(wfID)
+		defer unlock()
+
+		existing, err := invocations.findExistingRun(r.Context(),
+			temporalClient, wfID, spec.WfStartOpts.WorkflowIDReusePolicy, digest)
+		if err != nil {
+			log.Printf(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			log.Printf("Not invoking %s for workflow %s: %s. Existing run ID: %s\n",
+				wfClientName, wfID, existing.Reason, existing.RunID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(existing)
+			return
+		}
+	}
+
 	// Create a temp file, in /tmp by default.
 	// NOTE: Go replaces "*" in the name with a random number.
 	tmpfile, err := ioutil.TempFile("", wfClientName+".*.json")
@@ -52,6 +98,9 @@ func runWorkflowClient(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Created file: %s", tmpfile.Name())
 
+	// Add the callback URLs configured for this server, if any.
+	body = addNotifyURLs(body, os.Getenv(notify_env_var))
+
 	// Write POST body to the temp file.
 	if err = ioutil.WriteFile(tmpfile.Name(), body, 0444); err != nil {
 		wrapErr := fmt.Errorf("Failed to write POST body to temp file %s\n"+
@@ -75,20 +124,67 @@ func runWorkflowClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("\nOutput from %s :\n%s\n", wfClient, cmdOutErr)
+
+	if wfID != "" {
+		invocations.record(wfID, invocation{
+			runID:     latestRunID(r.Context(), wfID),
+			digest:    digest,
+			startedAt: time.Now(),
+		})
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// latestRunID returns the run ID of the latest run of the given workflow,
+// or "" if it cannot be determined.
+func latestRunID(ctx context.Context, wfID string) string {
+	if temporalClient == nil {
+		return ""
+	}
+	resp, err := temporalClient.DescribeWorkflowExecution(ctx, wfID, "")
+	if err != nil {
+		log.Printf("Failed to get run ID of workflow %s: %s\n", wfID, err)
+		return ""
+	}
+	return resp.GetWorkflowExecutionInfo().GetExecution().GetRunId()
+}
+
 // NewRouter creates a router that registers the various urls that are supported
 func NewRouter() *mux.Router {
 
 	router := mux.NewRouter()
 
 	router.HandleFunc(invokerURL, runWorkflowClient).Methods("POST")
+	router.HandleFunc(eventsURL, streamWorkflowEvents).Methods("GET")
+	router.HandleFunc(migrationsURL, listMigrations).Methods("GET")
+	router.HandleFunc(returnURL, returnMigration).Methods("POST")
+	router.HandleFunc(schedulesURL, listSchedules).Methods("GET")
+	router.HandleFunc(schedulesURL, createSchedule).Methods("POST")
+	router.HandleFunc(scheduleURL, getSchedule).Methods("GET")
+	router.HandleFunc(scheduleURL, deleteSchedule).Methods("DELETE")
+	router.HandleFunc(scheduleActionURL, pauseSchedule).Methods("POST")
 
 	return router
 }
 
 func main() {
+	// Get the Temporal Server's IP. The server can run without it, but can
+	// then deduplicate invocations only from its own in-memory records.
+	temporal_server := os.Getenv(temporal_env_var)
+	if temporal_server == "" {
+		log.Printf("Warning: $%s is not defined, so invocations will not "+
+			"be checked against running workflows\n", temporal_env_var)
+	} else {
+		hostPort := temporal_server + ":" + temporal_port
+		log.Printf("Temporal server endpoint: (%s)\n", hostPort)
+		c, err := client.NewClient(client.Options{HostPort: hostPort})
+		if err != nil {
+			log.Fatalln("unable to create Temporal client", err)
+		}
+		defer c.Close()
+		temporalClient = c
+	}
+
 	httpRouter := NewRouter()
 	loggedRouter := handlers.LoggingHandler(os.Stdout, httpRouter)
 	log.Println("Starting http server")
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+const (
+	migrationsURL      = "/migrations"            // URL to list migrations
+	returnURL          = "/workflows/{id}/return" // URL to end a temporary migration
+	migrationsPageSize = 100
+)
+
+// migration describes a migration in the list returned by listMigrations.
+type migration struct {
+	WorkflowID     string               `json:"workflowID"`
+	RunID          string               `json:"runID"`
+	Status         string               `json:"status"`
+	StartTime      *time.Time           `json:"startTime,omitempty"`
+	CloseTime      *time.Time           `json:"closeTime,omitempty"`
+	SourceClusters []string             `json:"sourceClusters,omitempty"`
+	Phase          string               `json:"phase,omitempty"`
+	Plan           *emcomigrate.MigPlan `json:"plan,omitempty"`
+}
+
+// listMigrations returns the EmcoMigrateWorkflow executions that match the
+// given search attributes as JSON, newest first.
+//  The URL is expected to be of the form /migrations , optionally with the
+//  query parameters project, compositeApp, dig, cluster (source or target),
+//  targetCluster, phase and pageSize. Clusters are given as provider+cluster.
+//  This needs advanced visibility in the Temporal server, and the search
+//  attributes registered with it.
+func listMigrations(w http.ResponseWriter, r *http.Request) {
+	if temporalClient == nil {
+		err := fmt.Errorf("Cannot list migrations: $%s is not defined",
+			temporal_env_var)
+		log.Printf(err.Error())
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	params := r.URL.Query()
+	filter := emcomigrate.MigFilter{
+		Project:       params.Get("project"),
+		CompositeApp:  params.Get("compositeApp"),
+		DIG:           params.Get("dig"),
+		Cluster:       params.Get("cluster"),
+		TargetCluster: params.Get("targetCluster"),
+		Phase:         params.Get("phase"),
+	}
+	query, err := filter.Query()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageSize := migrationsPageSize
+	if s := params.Get("pageSize"); s != "" {
+		if pageSize, err = strconv.Atoi(s); err != nil || pageSize <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid pageSize %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	typeQuery := "WorkflowType = 'EmcoMigrateWorkflow'"
+	if query != "" {
+		query = typeQuery + " and " + query
+	} else {
+		query = typeQuery
+	}
+	resp, err := temporalClient.ListWorkflow(r.Context(),
+		&workflowservice.ListWorkflowExecutionsRequest{
+			PageSize: int32(pageSize),
+			Query:    query,
+		})
+	if err != nil {
+		log.Printf("Failed to list migrations with query %q: %s\n", query, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	migrations := []migration{}
+	for _, info := range resp.GetExecutions() {
+		m := migration{
+			WorkflowID: info.GetExecution().GetWorkflowId(),
+			RunID:      info.GetExecution().GetRunId(),
+			Status:     info.GetStatus().String(),
+			StartTime:  info.GetStartTime(),
+			CloseTime:  info.GetCloseTime(),
+			SourceClusters: emcomigrate.SearchAttrStrings(info.GetSearchAttributes(),
+				emcomigrate.SearchAttrSourceCluster),
+			Plan: emcomigrate.DecodeMigPlan(info.GetMemo()),
+		}
+		if phase := emcomigrate.SearchAttrStrings(info.GetSearchAttributes(),
+			emcomigrate.SearchAttrMigrationPhase); len(phase) > 0 {
+			m.Phase = phase[0]
+		}
+		migrations = append(migrations, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(migrations); err != nil {
+		log.Printf("Failed to send migrations: %s\n", err)
+	}
+}
+
+// returnMigration tells a temporary migration to move its apps back to
+// where they were before it.
+//  The URL is expected to be of the form /workflows/$workflow_id/return ,
+//  optionally with a runId query parameter; the latest run by default.
+func returnMigration(w http.ResponseWriter, r *http.Request) {
+	if temporalClient == nil {
+		err := fmt.Errorf("Cannot return migrations: $%s is not defined",
+			temporal_env_var)
+		log.Printf(err.Error())
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	wfID := mux.Vars(r)["id"]
+	runID := r.URL.Query().Get("runId")
+	err := temporalClient.SignalWorkflow(r.Context(), wfID, runID,
+		emcomigrate.ReturnSignal, nil)
+	if err != nil {
+		log.Printf("Failed to signal workflow %s: %s\n", wfID, err)
+		status := http.StatusInternalServerError
+		if _, ok := err.(*serviceerror.NotFound); ok {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("Requested return of workflow %s\n", wfID)
+	w.WriteHeader(http.StatusNoContent)
+}
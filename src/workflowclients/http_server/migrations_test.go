@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/mocks"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+// payload encodes value as the SDK does for search attributes and memos.
+func payload(t *testing.T, value interface{}) *commonpb.Payload {
+	p, err := converter.GetDefaultDataConverter().ToPayload(value)
+	require.NoError(t, err)
+	return p
+}
+
+// get returns the status code and body of a GET request to url.
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestListMigrations(t *testing.T) {
+	start := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(5 * time.Minute)
+	executions := []*workflowpb.WorkflowExecutionInfo{
+		{
+			Execution: &commonpb.WorkflowExecution{WorkflowId: "w2", RunId: "run2"},
+			Status:    enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING,
+			StartTime: &end,
+			SearchAttributes: &commonpb.SearchAttributes{IndexedFields: map[string]*commonpb.Payload{
+				emcomigrate.SearchAttrSourceCluster: payload(t,
+					[]string{"provider1+cluster1", "provider1+cluster3"}),
+				emcomigrate.SearchAttrMigrationPhase: payload(t, "UpdateAppIntents"),
+			}},
+			Memo: &commonpb.Memo{Fields: map[string]*commonpb.Payload{
+				emcomigrate.MemoPlan: payload(t, emcomigrate.MigPlan{
+					Project: "proj1", CompositeApp: "capp1", CompositeAppVersion: "v1",
+					DIG: "dig1", TargetCluster: "provider2+cluster2",
+					Steps: []string{"UpdateAppIntents", "DoDigUpdate"}}),
+			}},
+		},
+		{
+			// started by a worker without search attributes
+			Execution: &commonpb.WorkflowExecution{WorkflowId: "w1", RunId: "run1"},
+			Status:    enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED,
+			StartTime: &start,
+			CloseTime: &end,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		params   string
+		query    string // nothing is listed if empty
+		pageSize int32
+		list     []*workflowpb.WorkflowExecutionInfo
+		listErr  error
+		code     int
+		body     string
+	}{
+		{"all", "", "WorkflowType = 'EmcoMigrateWorkflow'", migrationsPageSize, nil, nil,
+			http.StatusOK, "[]\n"},
+		{"filtered", "?project=proj1&compositeApp=capp1&dig=dig1" +
+			"&cluster=provider1%2Bcluster1&targetCluster=provider2%2Bcluster2" +
+			"&phase=UpdateAppIntents&pageSize=2",
+			"WorkflowType = 'EmcoMigrateWorkflow' and EmcoProject = 'proj1' and " +
+				"EmcoCompositeApp = 'capp1' and EmcoDIG = 'dig1' and " +
+				"(SourceCluster = 'provider1+cluster1' or TargetCluster = 'provider1+cluster1') and " +
+				"TargetCluster = 'provider2+cluster2' and MigrationPhase = 'UpdateAppIntents'",
+			2, executions[:1], nil, http.StatusOK,
+			`[{"workflowID":"w2","runID":"run2","status":"Running",` +
+				`"startTime":"2022-06-01T10:05:00Z",` +
+				`"sourceClusters":["provider1+cluster1","provider1+cluster3"],` +
+				`"phase":"UpdateAppIntents","plan":{"emcoURL":"","project":"proj1",` +
+				`"compositeApp":"capp1","compositeAppVersion":"v1",` +
+				`"deploymentIntentGroup":"dig1","targetCluster":"provider2+cluster2",` +
+				`"steps":["UpdateAppIntents","DoDigUpdate"]}}]` + "\n"},
+		{"without search attributes", "?dig=dig1",
+			"WorkflowType = 'EmcoMigrateWorkflow' and EmcoDIG = 'dig1'", migrationsPageSize,
+			executions[1:], nil, http.StatusOK,
+			`[{"workflowID":"w1","runID":"run1","status":"Completed",` +
+				`"startTime":"2022-06-01T10:00:00Z","closeTime":"2022-06-01T10:05:00Z"}]` + "\n"},
+		{"quote in filter", "?dig=dig1%27%20or%20EmcoDIG%20%3D%20%27dig2", "", 0, nil, nil,
+			http.StatusBadRequest,
+			`Invalid search attribute value "dig1' or EmcoDIG = 'dig2"` + "\n"},
+		{"zero page size", "?pageSize=0", "", 0, nil, nil,
+			http.StatusBadRequest, `Invalid pageSize "0"` + "\n"},
+		{"bad page size", "?pageSize=ten", "", 0, nil, nil,
+			http.StatusBadRequest, `Invalid pageSize "ten"` + "\n"},
+		{"no advanced visibility", "?phase=completed",
+			"WorkflowType = 'EmcoMigrateWorkflow' and MigrationPhase = 'completed'",
+			migrationsPageSize, nil,
+			serviceerror.NewInvalidArgument("operation ListWorkflowExecutions is not supported"),
+			http.StatusInternalServerError,
+			"operation ListWorkflowExecutions is not supported\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &mocks.Client{}
+			withClient(t, c)
+			if tc.query != "" {
+				resp := &workflowservice.ListWorkflowExecutionsResponse{Executions: tc.list}
+				if tc.listErr != nil {
+					resp = nil
+				}
+				c.On("ListWorkflow", mock.Anything, &workflowservice.ListWorkflowExecutionsRequest{
+					PageSize: tc.pageSize,
+					Query:    tc.query,
+				}).Return(resp, tc.listErr).Once()
+			}
+
+			server := newTestServer(t, migrationsURL, "GET", listMigrations)
+			code, body := get(t, server.URL+migrationsURL+tc.params)
+			assert.Equal(t, tc.code, code)
+			assert.Equal(t, tc.body, body)
+			c.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListMigrationsWithoutClient(t *testing.T) {
+	withClient(t, nil)
+	server := newTestServer(t, migrationsURL, "GET", listMigrations)
+	code, body := get(t, server.URL+migrationsURL)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "Cannot list migrations: $TEMPORAL_SERVER is not defined\n", body)
+}
+
+func TestReturnMigration(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		runID string
+		err   error
+		code  int
+	}{
+		{"latest run", "/workflows/w1/return", "", nil, http.StatusNoContent},
+		{"given run", "/workflows/w1/return?runId=run1", "run1", nil, http.StatusNoContent},
+		{"not found", "/workflows/w1/return", "",
+			serviceerror.NewNotFound("workflow not found"), http.StatusNotFound},
+		{"failed", "/workflows/w1/return", "",
+			errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &mocks.Client{}
+			withClient(t, c)
+			c.On("SignalWorkflow", mock.Anything, "w1", tc.runID, emcomigrate.ReturnSignal,
+				nil).Return(tc.err).Once()
+
+			server := newTestServer(t, returnURL, "POST", returnMigration)
+			resp, err := http.Post(server.URL+tc.url, "application/json", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tc.code, resp.StatusCode)
+			c.AssertExpectations(t)
+		})
+	}
+}
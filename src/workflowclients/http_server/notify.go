@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// Comma-separated callback URLs added to every workflow invoked.
+	notify_env_var = "MIGRATION_NOTIFY_URLS"
+	// Workflow param that the migrate workflow reads callback URLs from.
+	notifyURLsParam = "notifyURLs"
+	allActivities   = "all-activities"
+)
+
+// addNotifyURLs appends the given callback URLs to the notifyURLs param in
+// the all-activities params of a Temporal spec. The body is returned as is
+// if it is not a Temporal spec with all-activities params. Unknown fields in
+// the body are preserved.
+func addNotifyURLs(body []byte, urls string) []byte {
+	if strings.TrimSpace(urls) == "" {
+		return body
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		return body
+	}
+	wfParams, ok := spec["workflowParams"].(map[string]interface{})
+	if !ok {
+		return body
+	}
+	actParams, ok := wfParams["activityParams"].(map[string]interface{})
+	if !ok {
+		return body
+	}
+	allParams, ok := actParams[allActivities].(map[string]interface{})
+	if !ok {
+		return body
+	}
+
+	if existing, ok := allParams[notifyURLsParam].(string); ok && existing != "" {
+		urls = existing + "," + urls
+	}
+	allParams[notifyURLsParam] = urls
+
+	newBody, err := json.Marshal(spec)
+	if err != nil {
+		return body
+	}
+	return newBody
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.temporal.io/api/serviceerror"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+const (
+	schedulesURL      = "/schedules"                           // URL to list or create schedules
+	scheduleURL       = "/schedules/{id:[a-zA-Z0-9-_.]+}"      // URL of a schedule
+	scheduleActionURL = scheduleURL + "/{action:pause|resume}" // URL to pause or resume one
+)
+
+// scheduleError returns the HTTP status for an error from the Temporal
+// client about a schedule.
+func scheduleError(err error) int {
+	switch err.(type) {
+	case *serviceerror.NotFound:
+		return http.StatusNotFound
+	case *serviceerror.WorkflowExecutionAlreadyStarted:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// checkTemporalClient fails the request if the server has no Temporal
+// client.
+func checkTemporalClient(w http.ResponseWriter) bool {
+	if temporalClient == nil {
+		err := fmt.Errorf("Cannot manage schedules: $%s is not defined",
+			temporal_env_var)
+		log.Printf(err.Error())
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to send response: %s\n", err)
+	}
+}
+
+// createSchedule creates a recurring migration.
+//  The POST body is an emcomigrate.MigSchedule as JSON: id, cron, timeZone,
+//  targets (provider+cluster) and the workflowParams of each run, without
+//  the target cluster. The callback URLs configured for this server are
+//  added to the workflow params, as for the migrations that it invokes.
+func createSchedule(w http.ResponseWriter, r *http.Request) {
+	if !checkTemporalClient(w) {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("POST body read err; %v", err), http.StatusBadRequest)
+		return
+	}
+	body = addNotifyURLs(body, os.Getenv(notify_env_var))
+
+	var schedule emcomigrate.MigSchedule
+	if err := json.Unmarshal(body, &schedule); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid schedule: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := schedule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	we, err := emcomigrate.StartMigSchedule(r.Context(), temporalClient, schedule)
+	if err != nil {
+		log.Printf("Failed to create schedule %s: %s\n", schedule.ID, err)
+		http.Error(w, err.Error(), scheduleError(err))
+		return
+	}
+	log.Printf("Created schedule %s, run ID %s\n", schedule.ID, we.GetRunID())
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"id":         schedule.ID,
+		"workflowID": we.GetID(),
+		"runID":      we.GetRunID(),
+	})
+}
+
+// listSchedules returns the recurring migrations as JSON.
+func listSchedules(w http.ResponseWriter, r *http.Request) {
+	if !checkTemporalClient(w) {
+		return
+	}
+	schedules, err := emcomigrate.ListMigSchedules(r.Context(), temporalClient)
+	if err != nil {
+		log.Printf("Failed to list schedules: %s\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+// getSchedule returns a recurring migration as JSON.
+//  The URL is expected to be of the form /schedules/{id} .
+func getSchedule(w http.ResponseWriter, r *http.Request) {
+	if !checkTemporalClient(w) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	schedule, err := emcomigrate.GetMigSchedule(r.Context(), temporalClient, id)
+	if err != nil {
+		http.Error(w, err.Error(), scheduleError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+// pauseSchedule pauses or resumes a recurring migration.
+//  The URL is expected to be of the form /schedules/{id}/pause or
+//  /schedules/{id}/resume .
+func pauseSchedule(w http.ResponseWriter, r *http.Request) {
+	if !checkTemporalClient(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	err := emcomigrate.PauseMigSchedule(r.Context(), temporalClient, vars["id"],
+		vars["action"] == "pause")
+	if err != nil {
+		log.Printf("Failed to %s schedule %s: %s\n", vars["action"], vars["id"], err)
+		http.Error(w, err.Error(), scheduleError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteSchedule deletes a recurring migration. The migration that it
+// started last, if still running, is left running.
+//  The URL is expected to be of the form /schedules/{id} .
+func deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	if !checkTemporalClient(w) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := emcomigrate.DeleteMigSchedule(r.Context(), temporalClient, id); err != nil {
+		log.Printf("Failed to delete schedule %s: %s\n", id, err)
+		http.Error(w, err.Error(), scheduleError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+const testScheduleWorkflowID = "migration-schedule/follow-the-sun"
+
+// testScheduleBody creates a schedule that migrates at 8am and 4pm to two
+// clusters in turn.
+const testScheduleBody = `{"id":"follow-the-sun","cron":"0 8,16 * * *",` +
+	`"targets":["provider1+cluster1","provider2+cluster2"],` +
+	`"workflowParams":{"activityParams":{"all-activities":{` +
+	`"emcoURL":"http://192.168.1.201:30415","project":"proj1",` +
+	`"compositeApp":"capp1","compositeAppVersion":"v1",` +
+	`"deploymentIntentGroup":"dig1"}}}}`
+
+// testScheduleState returns the state of the schedule of testScheduleBody
+// once it has run once.
+func testScheduleState() emcomigrate.MigSchedule {
+	lastRun := time.Date(2022, 6, 4, 8, 0, 0, 0, time.UTC)
+	return emcomigrate.MigSchedule{
+		ID:         "follow-the-sun",
+		Cron:       "0 8,16 * * *",
+		Targets:    []string{"provider1+cluster1", "provider2+cluster2"},
+		NextTarget: 1,
+		NextRun:    "2022-06-04T16:00:00Z",
+		LastFire:   &lastRun,
+		Runs:       1,
+	}
+}
+
+const testScheduleStateJSON = `{"id":"follow-the-sun","cron":"0 8,16 * * *",` +
+	`"targets":["provider1+cluster1","provider2+cluster2"],` +
+	`"workflowParams":{},` +
+	`"paused":false,"nextTarget":1,"nextRun":"2022-06-04T16:00:00Z",` +
+	`"lastFire":"2022-06-04T08:00:00Z","runs":1,"skipped":0,"failedStarts":0}`
+
+// newScheduleServer returns a server with the schedule routes.
+func newScheduleServer(t *testing.T) *httptest.Server {
+	router := mux.NewRouter()
+	router.HandleFunc(schedulesURL, listSchedules).Methods("GET")
+	router.HandleFunc(schedulesURL, createSchedule).Methods("POST")
+	router.HandleFunc(scheduleURL, getSchedule).Methods("GET")
+	router.HandleFunc(scheduleURL, deleteSchedule).Methods("DELETE")
+	router.HandleFunc(scheduleActionURL, pauseSchedule).Methods("POST")
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// do sends a request to the server, and returns the status code and body
+// of the response.
+func do(t *testing.T, server *httptest.Server, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestSchedules(t *testing.T) {
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		client       func(c *mocks.Client)
+		code         int
+		response     string
+	}{
+		{"create", "POST", "/schedules", testScheduleBody, func(c *mocks.Client) {
+			run := &mocks.WorkflowRun{}
+			run.On("GetID").Return(testScheduleWorkflowID)
+			run.On("GetRunID").Return("run0")
+			c.On("ExecuteWorkflow", mock.Anything, mock.MatchedBy(
+				func(opts client.StartWorkflowOptions) bool {
+					return opts.ID == testScheduleWorkflowID &&
+						opts.WorkflowExecutionErrorWhenAlreadyStarted
+				}), mock.Anything, mock.MatchedBy(
+				func(schedule emcomigrate.MigSchedule) bool {
+					return schedule.ID == "follow-the-sun" && len(schedule.Targets) == 2
+				})).Return(run, nil)
+		}, http.StatusCreated, `{"id":"follow-the-sun","runID":"run0",` +
+			`"workflowID":"migration-schedule/follow-the-sun"}` + "\n"},
+		{"create existing", "POST", "/schedules", testScheduleBody, func(c *mocks.Client) {
+			c.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything,
+				mock.Anything).Return(nil, serviceerror.NewWorkflowExecutionAlreadyStarted(
+				"workflow execution already started", "", "run0"))
+		}, http.StatusConflict, "workflow execution already started\n"},
+		{"create bad JSON", "POST", "/schedules", `{"id":`, nil, http.StatusBadRequest,
+			"Invalid schedule: unexpected end of JSON input\n"},
+		{"create invalid", "POST", "/schedules",
+			strings.Replace(testScheduleBody, "0 8,16 * * *", "daily", 1), nil,
+			http.StatusBadRequest, "Invalid schedule follow-the-sun: "},
+		{"list", "GET", "/schedules", "", func(c *mocks.Client) {
+			c.On("ListOpenWorkflow", mock.Anything, mock.Anything).Return(
+				&workflowservice.ListOpenWorkflowExecutionsResponse{
+					Executions: []*workflowpb.WorkflowExecutionInfo{{
+						Execution: &commonpb.WorkflowExecution{
+							WorkflowId: testScheduleWorkflowID, RunId: "run0"},
+					}},
+				}, nil)
+			c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "run0",
+				emcomigrate.ScheduleStateQuery).Return(queryValue{testScheduleState()}, nil)
+		}, http.StatusOK, "[" + testScheduleStateJSON + "]\n"},
+		{"list none", "GET", "/schedules", "", func(c *mocks.Client) {
+			c.On("ListOpenWorkflow", mock.Anything, mock.Anything).Return(
+				&workflowservice.ListOpenWorkflowExecutionsResponse{}, nil)
+		}, http.StatusOK, "[]\n"},
+		{"list failed", "GET", "/schedules", "", func(c *mocks.Client) {
+			c.On("ListOpenWorkflow", mock.Anything, mock.Anything).
+				Return(nil, serviceerror.NewUnavailable("connection refused"))
+		}, http.StatusInternalServerError, "connection refused\n"},
+		{"state", "GET", "/schedules/follow-the-sun", "", func(c *mocks.Client) {
+			c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "",
+				emcomigrate.ScheduleStateQuery).Return(queryValue{testScheduleState()}, nil)
+		}, http.StatusOK, testScheduleStateJSON + "\n"},
+		{"state missing", "GET", "/schedules/follow-the-sun", "", func(c *mocks.Client) {
+			c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "",
+				emcomigrate.ScheduleStateQuery).
+				Return(nil, serviceerror.NewNotFound("workflow not found"))
+		}, http.StatusNotFound, "workflow not found\n"},
+		{"pause", "POST", "/schedules/follow-the-sun/pause", "", func(c *mocks.Client) {
+			c.On("SignalWorkflow", mock.Anything, testScheduleWorkflowID, "",
+				emcomigrate.PauseScheduleSignal, nil).Return(nil)
+		}, http.StatusNoContent, ""},
+		{"resume", "POST", "/schedules/follow-the-sun/resume", "", func(c *mocks.Client) {
+			c.On("SignalWorkflow", mock.Anything, testScheduleWorkflowID, "",
+				emcomigrate.ResumeScheduleSignal, nil).Return(nil)
+		}, http.StatusNoContent, ""},
+		{"pause missing", "POST", "/schedules/follow-the-sun/pause", "", func(c *mocks.Client) {
+			c.On("SignalWorkflow", mock.Anything, testScheduleWorkflowID, "",
+				emcomigrate.PauseScheduleSignal, nil).
+				Return(serviceerror.NewNotFound("workflow not found"))
+		}, http.StatusNotFound, "workflow not found\n"},
+		{"unknown action", "POST", "/schedules/follow-the-sun/run", "", nil,
+			http.StatusNotFound, "404 page not found\n"},
+		{"delete", "DELETE", "/schedules/follow-the-sun", "", func(c *mocks.Client) {
+			c.On("CancelWorkflow", mock.Anything, testScheduleWorkflowID, "").Return(nil)
+		}, http.StatusNoContent, ""},
+		{"delete failed", "DELETE", "/schedules/follow-the-sun", "", func(c *mocks.Client) {
+			c.On("CancelWorkflow", mock.Anything, testScheduleWorkflowID, "").
+				Return(errors.New("connection refused"))
+		}, http.StatusInternalServerError, "connection refused\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &mocks.Client{}
+			if tc.client != nil {
+				tc.client(c)
+			}
+			withClient(t, c)
+
+			code, body := do(t, newScheduleServer(t), tc.method, tc.path, tc.body)
+			assert.Equal(t, tc.code, code)
+			if strings.HasSuffix(tc.response, "\n") || tc.response == "" {
+				assert.Equal(t, tc.response, body)
+			} else {
+				assert.Contains(t, body, tc.response)
+			}
+			c.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSchedulesWithoutClient(t *testing.T) {
+	withClient(t, nil)
+	server := newScheduleServer(t)
+	for _, req := range []struct{ method, path, body string }{
+		{"GET", "/schedules", ""},
+		{"POST", "/schedules", testScheduleBody},
+		{"GET", "/schedules/follow-the-sun", ""},
+		{"POST", "/schedules/follow-the-sun/pause", ""},
+		{"DELETE", "/schedules/follow-the-sun", ""},
+	} {
+		code, body := do(t, server, req.method, req.path, req.body)
+		assert.Equal(t, http.StatusServiceUnavailable, code, "%s %s", req.method, req.path)
+		assert.Equal(t, "Cannot manage schedules: $TEMPORAL_SERVER is not defined\n", body)
+	}
+}
@@ -0,0 +1,577 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	enumspb "go.temporal.io/api/enums/v1"
+	filterpb "go.temporal.io/api/filter/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+// Workflow type name that EmcoMigrateWorkflow is registered under.
+const migWorkflowType = "EmcoMigrateWorkflow"
+
+// exitCodeFor maps an error from the Temporal client to an exit code.
+func exitCodeFor(err error) int {
+	switch err.(type) {
+	case *serviceerror.NotFound:
+		return exitNotFound
+	case *serviceerror.WorkflowExecutionAlreadyStarted:
+		return exitAlreadyStarted
+	}
+	return exitError
+}
+
+// exitCodeForStatus maps the status of a workflow execution to an exit code.
+func exitCodeForStatus(status enumspb.WorkflowExecutionStatus) int {
+	switch status {
+	case enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED,
+		enumspb.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW:
+		return exitOK
+	case enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING:
+		return exitRunning
+	}
+	return exitWorkflowFailed
+}
+
+// newFlagSet returns a flag set for a subcommand that reports parse errors
+// instead of exiting, so that they map to exitUsage.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// workflowFlags adds the flags that identify a workflow execution.
+func workflowFlags(fs *flag.FlagSet) (wfID, runID *string) {
+	wfID = fs.String("w", "", "Workflow ID (required)")
+	runID = fs.String("r", "", "Run ID (default: latest run)")
+	return wfID, runID
+}
+
+// parseWorkflowFlags parses args and checks that a workflow ID was given.
+func parseWorkflowFlags(fs *flag.FlagSet, args []string, wfID *string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if *wfID == "" {
+		fmt.Fprintf(os.Stderr, "Error: Need to provide a workflow ID with -w\n")
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// runStart starts a migration from a workflow spec given as a file, flags
+// or both.
+func runStart(args []string) int {
+	fs := newFlagSet("start")
+	sf := addSpecFlags(fs)
+	wait := fs.Bool("wait", false,
+		"Wait for the workflow to finish and print its result")
+	printOnly := fs.Bool("print", false,
+		"Print the workflow spec as JSON instead of starting the workflow")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	spec, err := sf.buildSpec()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintf(os.Stderr, "Quitting due to errors.\n")
+		return exitUsage
+	}
+	fmt.Fprintf(os.Stderr, "raw spec: %#v\n", spec)
+	if err := validateSpec(spec); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if *printOnly {
+		if err := printJSON(spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+	spec.WfStartOpts.TaskQueue = emcomigrate.MigTaskQueue //override task queue
+	// Keep the plan with the workflow, so that it is shown when listed.
+	if spec.WfStartOpts.Memo == nil {
+		spec.WfStartOpts.Memo = map[string]interface{}{}
+	}
+	if _, ok := spec.WfStartOpts.Memo[emcomigrate.MemoPlan]; !ok {
+		spec.WfStartOpts.Memo[emcomigrate.MemoPlan] = emcomigrate.NewMigPlan(
+			spec.WfParams.ActivityParams[emcomigrate.ALL_ACTIVITIES])
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	// NOTE: This cast assumes Temporal's StartWorkflowOptions == EMCO's version.
+	options := client.StartWorkflowOptions(spec.WfStartOpts)
+	ctx := context.Background()
+	we, err := c.ExecuteWorkflow(ctx, options,
+		emcomigrate.EmcoMigrateWorkflow, &spec.WfParams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error starting Migration Workflow: %s\n", err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Started workflow. WorkflowID: %s RunID: %s\n", we.GetID(), we.GetRunID())
+	if !*wait {
+		return exitOK
+	}
+
+	var result emcomigrate.MigParam
+	if err := we.Get(ctx, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "Workflow %s failed: %s\n", we.GetID(), err)
+		return exitWorkflowFailed
+	}
+	fmt.Printf("Finished workflow. WorkflowID: %s RunID: %s\n", we.GetID(), we.GetRunID())
+	if err := printJSON(result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// workflowStatus is the output of the status subcommand.
+type workflowStatus struct {
+	WorkflowID        string            `json:"workflowID"`
+	RunID             string            `json:"runID"`
+	Type              string            `json:"type"`
+	Status            string            `json:"status"`
+	StartTime         string            `json:"startTime"`
+	CloseTime         string            `json:"closeTime"`
+	HistoryLength     int64             `json:"historyLength"`
+	CurrentState      string            `json:"currentState,omitempty"`
+	WorkflowVersion   int               `json:"workflowVersion,omitempty"`
+	Strategy          string            `json:"strategy,omitempty"`
+	Wave              string            `json:"wave,omitempty"`
+	WaitingUntil      string            `json:"waitingUntil,omitempty"`
+	QueryError        string            `json:"queryError,omitempty"`
+	PendingActivities []pendingActivity `json:"pendingActivities,omitempty"`
+}
+
+type pendingActivity struct {
+	Type        string `json:"type"`
+	State       string `json:"state"`
+	Attempt     int32  `json:"attempt"`
+	LastFailure string `json:"lastFailure,omitempty"`
+}
+
+// runStatus shows the status of a migration. The exit code reflects the
+// workflow status: exitOK if completed, exitRunning if still running, and
+// exitWorkflowFailed otherwise.
+func runStatus(args []string) int {
+	fs := newFlagSet("status")
+	wfID, runID := workflowFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the status as JSON")
+	if !parseWorkflowFlags(fs, args, wfID) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	desc, err := c.DescribeWorkflowExecution(ctx, *wfID, *runID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to describe workflow %s: %s\n", *wfID, err)
+		return exitCodeFor(err)
+	}
+	info := desc.GetWorkflowExecutionInfo()
+	status := workflowStatus{
+		WorkflowID:    info.GetExecution().GetWorkflowId(),
+		RunID:         info.GetExecution().GetRunId(),
+		Type:          info.GetType().GetName(),
+		Status:        info.GetStatus().String(),
+		StartTime:     formatTime(info.GetStartTime()),
+		CloseTime:     formatTime(info.GetCloseTime()),
+		HistoryLength: info.GetHistoryLength(),
+	}
+	for _, act := range desc.GetPendingActivities() {
+		pending := pendingActivity{
+			Type:    act.GetActivityType().GetName(),
+			State:   act.GetState().String(),
+			Attempt: act.GetAttempt(),
+		}
+		if failure := act.GetLastFailure(); failure != nil {
+			pending.LastFailure = failure.GetMessage()
+		}
+		status.PendingActivities = append(status.PendingActivities, pending)
+	}
+
+	state, err := emcomigrate.QueryMigState(ctx, c, status.WorkflowID, status.RunID)
+	if err == nil {
+		status.CurrentState = state.State
+		status.WorkflowVersion = state.WorkflowVersion
+		status.Strategy = state.Strategy
+		status.Wave = state.Wave
+		status.WaitingUntil = state.WaitingUntil
+	}
+	if err != nil {
+		status.QueryError = err.Error()
+	}
+
+	if *asJSON {
+		if err := printJSON(status); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	} else {
+		fmt.Printf("Workflow ID:    %s\n", status.WorkflowID)
+		fmt.Printf("Run ID:         %s\n", status.RunID)
+		fmt.Printf("Type:           %s\n", status.Type)
+		fmt.Printf("Status:         %s\n", status.Status)
+		fmt.Printf("Start time:     %s\n", status.StartTime)
+		fmt.Printf("Close time:     %s\n", status.CloseTime)
+		fmt.Printf("History length: %d\n", status.HistoryLength)
+		if status.QueryError != "" {
+			fmt.Printf("Current state:  unavailable (%s)\n", status.QueryError)
+		} else {
+			fmt.Printf("Current state:  %s\n", status.CurrentState)
+			if status.Strategy != "" {
+				fmt.Printf("Strategy:       %s\n", status.Strategy)
+			}
+			if status.Wave != "" {
+				fmt.Printf("Wave:           %s\n", status.Wave)
+			}
+			if status.WaitingUntil != "" {
+				fmt.Printf("Waiting until:  %s\n", status.WaitingUntil)
+			}
+			fmt.Printf("Code version:   %d\n", status.WorkflowVersion)
+		}
+		for _, act := range status.PendingActivities {
+			fmt.Printf("Pending:        %s %s attempt %d %s\n",
+				act.Type, act.State, act.Attempt, act.LastFailure)
+		}
+	}
+
+	return exitCodeForStatus(info.GetStatus())
+}
+
+// runCancel requests cancellation of a migration.
+func runCancel(args []string) int {
+	fs := newFlagSet("cancel")
+	wfID, runID := workflowFlags(fs)
+	if !parseWorkflowFlags(fs, args, wfID) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	if err := c.CancelWorkflow(context.Background(), *wfID, *runID); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to cancel workflow %s: %s\n", *wfID, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Requested cancellation of workflow %s\n", *wfID)
+	return exitOK
+}
+
+// runReturn tells a temporary migration to move its apps back to where
+// they were before it.
+func runReturn(args []string) int {
+	fs := newFlagSet("return")
+	wfID, runID := workflowFlags(fs)
+	if !parseWorkflowFlags(fs, args, wfID) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	err = c.SignalWorkflow(context.Background(), *wfID, *runID,
+		emcomigrate.ReturnSignal, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to signal workflow %s: %s\n", *wfID, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Requested return of workflow %s\n", *wfID)
+	return exitOK
+}
+
+// runTerminate terminates a migration without giving it a chance to clean up.
+func runTerminate(args []string) int {
+	fs := newFlagSet("terminate")
+	wfID, runID := workflowFlags(fs)
+	reason := fs.String("reason", "terminated by migrate_workflowclient",
+		"Reason recorded in the workflow history")
+	if !parseWorkflowFlags(fs, args, wfID) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	err = c.TerminateWorkflow(context.Background(), *wfID, *runID, *reason)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to terminate workflow %s: %s\n", *wfID, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Terminated workflow %s\n", *wfID)
+	return exitOK
+}
+
+// runSignal sends a signal, with optional JSON data, to a migration.
+func runSignal(args []string) int {
+	fs := newFlagSet("signal")
+	wfID, runID := workflowFlags(fs)
+	name := fs.String("n", "", "Signal name (required)")
+	data := fs.String("d", "", "Signal data as JSON")
+	if !parseWorkflowFlags(fs, args, wfID) {
+		return exitUsage
+	}
+	if *name == "" {
+		fmt.Fprintf(os.Stderr, "Error: Need to provide a signal name with -n\n")
+		return exitUsage
+	}
+	var arg interface{}
+	if *data != "" {
+		if err := json.Unmarshal([]byte(*data), &arg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid JSON signal data: %s\n", err)
+			return exitUsage
+		}
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	err = c.SignalWorkflow(context.Background(), *wfID, *runID, *name, arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to signal workflow %s: %s\n", *wfID, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Sent signal %s to workflow %s\n", *name, *wfID)
+	return exitOK
+}
+
+// runHistory exports the event history of a migration in the JSON format
+// that Temporal's tctl and worker.WorkflowReplayer use.
+func runHistory(args []string) int {
+	fs := newFlagSet("history")
+	wfID, runID := workflowFlags(fs)
+	outFile := fs.String("o", "", "Output file (default: stdout)")
+	if !parseWorkflowFlags(fs, args, wfID) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	history := &historypb.History{}
+	iter := c.GetWorkflowHistory(context.Background(), *wfID, *runID, false,
+		enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get history of workflow %s: %s\n",
+				*wfID, err)
+			return exitCodeFor(err)
+		}
+		history.Events = append(history.Events, event)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		out = f
+	}
+	marshaler := jsonpb.Marshaler{Indent: "  "}
+	if err := marshaler.Marshal(out, history); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode history: %s\n", err)
+		return exitError
+	}
+	fmt.Fprintln(out)
+	if *outFile != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d events to %s\n", len(history.Events), *outFile)
+	}
+	return exitOK
+}
+
+// runList lists recent migrations, newest first. By default, it lists open
+// and closed EmcoMigrateWorkflow executions started within the given
+// period. With -q, it runs the given visibility query instead, which needs
+// advanced visibility in the Temporal server. The search attribute flags,
+// such as -dig, add to that query, or make one of their own.
+func runList(args []string) int {
+	fs := newFlagSet("list")
+	max := fs.Int("n", 20, "Maximum number of migrations to list")
+	since := fs.Duration("since", 7*24*time.Hour,
+		"List migrations started within this period")
+	query := fs.String("q", "", "Visibility query, such as \"ExecutionStatus='Running'\"")
+	var filter emcomigrate.MigFilter
+	fs.StringVar(&filter.Project, "project", "", "List migrations of this project")
+	fs.StringVar(&filter.CompositeApp, "composite-app", "",
+		"List migrations of this composite app")
+	fs.StringVar(&filter.DIG, "dig", "", "List migrations of this deployment intent group")
+	fs.StringVar(&filter.Cluster, "cluster", "",
+		"List migrations from or to this cluster, as provider+cluster")
+	fs.StringVar(&filter.TargetCluster, "target-cluster", "",
+		"List migrations to this cluster, as provider+cluster")
+	fs.StringVar(&filter.Phase, "phase", "",
+		"List migrations in this phase: started, completed, failed or an activity name")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	filterQuery, err := filter.Query()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if filterQuery != "" {
+		filterQuery = fmt.Sprintf("WorkflowType = '%s' and StartTime > '%s' and %s",
+			migWorkflowType, time.Now().Add(-*since).UTC().Format(time.RFC3339), filterQuery)
+		if *query != "" {
+			filterQuery = "(" + *query + ") and " + filterQuery
+		}
+		*query = filterQuery
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	var executions []*workflowpb.WorkflowExecutionInfo
+	if *query != "" {
+		resp, err := c.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			PageSize: int32(*max),
+			Query:    *query,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list workflows: %s\n", err)
+			return exitCodeFor(err)
+		}
+		executions = resp.GetExecutions()
+	} else {
+		earliest := time.Now().Add(-*since)
+		latest := time.Now()
+		timeFilter := &filterpb.StartTimeFilter{
+			EarliestTime: &earliest,
+			LatestTime:   &latest,
+		}
+		typeFilter := &filterpb.WorkflowTypeFilter{Name: migWorkflowType}
+
+		open, err := c.ListOpenWorkflow(ctx, &workflowservice.ListOpenWorkflowExecutionsRequest{
+			MaximumPageSize: int32(*max),
+			StartTimeFilter: timeFilter,
+			Filters: &workflowservice.ListOpenWorkflowExecutionsRequest_TypeFilter{
+				TypeFilter: typeFilter,
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list open workflows: %s\n", err)
+			return exitCodeFor(err)
+		}
+		closed, err := c.ListClosedWorkflow(ctx, &workflowservice.ListClosedWorkflowExecutionsRequest{
+			MaximumPageSize: int32(*max),
+			StartTimeFilter: timeFilter,
+			Filters: &workflowservice.ListClosedWorkflowExecutionsRequest_TypeFilter{
+				TypeFilter: typeFilter,
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list closed workflows: %s\n", err)
+			return exitCodeFor(err)
+		}
+		executions = append(open.GetExecutions(), closed.GetExecutions()...)
+	}
+
+	sort.SliceStable(executions, func(i, j int) bool {
+		ti, tj := executions[i].GetStartTime(), executions[j].GetStartTime()
+		return ti != nil && (tj == nil || ti.After(*tj))
+	})
+	if len(executions) > *max {
+		executions = executions[:*max]
+	}
+
+	fmt.Printf("%-30s %-36s %-12s %-20s %-20s %-16s %-24s %-16s\n",
+		"WORKFLOW ID", "RUN ID", "STATUS", "START TIME", "CLOSE TIME",
+		"DIG", "TARGET CLUSTER", "PHASE")
+	for _, info := range executions {
+		dig, target := "-", "-"
+		if plan := emcomigrate.DecodeMigPlan(info.GetMemo()); plan != nil {
+			dig, target = plan.DIG, plan.TargetCluster
+		}
+		phase := "-"
+		if values := emcomigrate.SearchAttrStrings(info.GetSearchAttributes(),
+			emcomigrate.SearchAttrMigrationPhase); len(values) > 0 {
+			phase = values[0]
+		}
+		fmt.Printf("%-30s %-36s %-12s %-20s %-20s %-16s %-24s %-16s\n",
+			info.GetExecution().GetWorkflowId(),
+			info.GetExecution().GetRunId(),
+			info.GetStatus().String(),
+			formatTime(info.GetStartTime()),
+			formatTime(info.GetCloseTime()),
+			dig, target, phase)
+	}
+	return exitOK
+}
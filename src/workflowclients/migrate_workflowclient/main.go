@@ -1,18 +1,16 @@
 package main
 
+// Command line client for the migrate workflow.
+// Starts, inspects and controls EmcoMigrateWorkflow executions. The http
+// server runs it as "migrate_workflowclient -a file.json", which is the
+// same as "migrate_workflowclient start -a file.json".
+
 import (
-	"context"
 	"fmt"
-	"log"
-
-	//"encoding/json"
-	"flag"
 	"os"
+	"strings"
 
 	"go.temporal.io/sdk/client"
-
-	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
-	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
 )
 
 const (
@@ -20,47 +18,89 @@ const (
 	temporal_port    = "7233"
 )
 
-func main() {
-	var argFileName string
-	var spec *eta.WfTemporalSpec
+// Exit codes, so that scripts can act on the outcome of a subcommand.
+const (
+	exitOK             = 0 // success; for status, the workflow completed
+	exitError          = 1 // failed to talk to Temporal, or other errors
+	exitUsage          = 2 // bad subcommand, flags or workflow spec
+	exitNotFound       = 3 // no such workflow
+	exitWorkflowFailed = 4 // workflow failed, timed out, or was canceled or terminated
+	exitRunning        = 5 // status only: the workflow is still running
+	exitAlreadyStarted = 6 // start only: a workflow with that ID is running
+)
 
-	// Get the Temporal Server's IP
-	temporal_server := os.Getenv(temporal_env_var)
-	if temporal_server == "" {
-		fmt.Fprintf(os.Stderr, "Error: Need to define $TEMPORAL_SERVER\n")
-		os.Exit(1)
+// subcommand runs with the arguments after its name and returns an exit code.
+type subcommand struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var subcommands = []subcommand{
+	{"start", "start a migration, optionally waiting for its result", runStart},
+	{"status", "show the status and current state of a migration", runStatus},
+	{"cancel", "request cancellation of a migration", runCancel},
+	{"return", "move the apps of a temporary migration back", runReturn},
+	{"terminate", "forcibly terminate a migration", runTerminate},
+	{"signal", "send a signal to a migration", runSignal},
+	{"history", "export the event history of a migration as JSON", runHistory},
+	{"list", "list recent migrations", runList},
+	{"schedule", "create, list, pause, resume or delete recurring migrations", runSchedule},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <subcommand> [flags]\n\nSubcommands:\n",
+		os.Args[0])
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
 	}
-	hostPort := temporal_server + ":" + temporal_port
-	fmt.Printf("Temporal server endpoint: (%s)\n", hostPort)
+	fmt.Fprintf(os.Stderr, "\nRun '%s <subcommand> -h' for its flags.\n", os.Args[0])
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
 
-	// Get the JSON arg
-	flag.StringVar(&argFileName, "a", "", "Workflow params as JSON file")
-	flag.Parse()
-	if argFileName != "" {
-		fmt.Printf("Will read parameters from file: %s\n", argFileName)
+// run runs the subcommand named by args[0] and returns its exit code.
+func run(args []string) int {
+	// Without a subcommand, behave as the original client did.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" ||
+			args[0] == "--help") {
+			usage()
+			return exitOK
+		}
+		return runStart(args)
 	}
 
-	spec, err := getTemporalSpec(argFileName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Quitting due to errors.\n")
+	for _, cmd := range subcommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
 	}
-	spec.WfStartOpts.TaskQueue = emcomigrate.MigTaskQueue //override task queue
+	fmt.Fprintf(os.Stderr, "Error: unknown subcommand %q\n", args[0])
+	usage()
+	return exitUsage
+}
 
-	// Create the client object just once per process
-	clientOptions := client.Options{HostPort: hostPort}
-	c, err := client.NewClient(clientOptions)
-	if err != nil {
-		log.Fatalln("unable to create Temporal client", err)
+// newTemporalClient creates the Temporal client of a subcommand; tests
+// replace it.
+var newTemporalClient = dialTemporal
+
+// dialTemporal creates a Temporal client for the server in $TEMPORAL_SERVER.
+func dialTemporal() (client.Client, error) {
+	// Get the Temporal Server's IP
+	temporal_server := os.Getenv(temporal_env_var)
+	if temporal_server == "" {
+		return nil, fmt.Errorf("Error: Need to define $%s", temporal_env_var)
 	}
-	defer c.Close()
+	hostPort := temporal_server + ":" + temporal_port
+	fmt.Fprintf(os.Stderr, "Temporal server endpoint: (%s)\n", hostPort)
 
-	// NOTE: This cast assumes Temporal's StartWorkflowOptions == EMCO's version.
-	options := client.StartWorkflowOptions(spec.WfStartOpts)
-	//migParam := emcomigrate.MigParam{Name: "Ganesha"}
-	we, err := c.ExecuteWorkflow(context.Background(), options,
-		emcomigrate.EmcoMigrateWorkflow, &spec.WfParams)
+	// Create the client object just once per process
+	c, err := client.NewClient(client.Options{HostPort: hostPort})
 	if err != nil {
-		log.Fatalln("error starting Migration Workflow", err)
+		return nil, fmt.Errorf("unable to create Temporal client: %s", err)
 	}
-	log.Printf("\nFinished workflow. WorkflowID: %s RunID: %s\n", we.GetID(), we.GetRunID())
+	return c, nil
 }
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+)
+
+// withClient makes the subcommands use c as their Temporal client during
+// the test, or fail to create one if c is nil.
+func withClient(t *testing.T, c *mocks.Client) {
+	saved := newTemporalClient
+	newTemporalClient = func() (client.Client, error) {
+		if c == nil {
+			return nil, errors.New("Error: Need to define $TEMPORAL_SERVER")
+		}
+		return c, nil
+	}
+	t.Cleanup(func() { newTemporalClient = saved })
+}
+
+// captureStdout returns what f writes to stdout, and what it returns.
+func captureStdout(t *testing.T, f func() int) (string, int) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	saved := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+
+	code := f()
+	os.Stdout = saved
+	w.Close()
+	return <-out, code
+}
+
+func describeStatus(status enumspb.WorkflowExecutionStatus) *workflowservice.DescribeWorkflowExecutionResponse {
+	return &workflowservice.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{
+			Execution: &commonpb.WorkflowExecution{WorkflowId: "w1", RunId: "run1"},
+			Type:      &commonpb.WorkflowType{Name: migWorkflowType},
+			Status:    status,
+		},
+	}
+}
+
+// onStatus sets up c for the status subcommand on a run with the given status.
+func onStatus(status enumspb.WorkflowExecutionStatus) func(c *mocks.Client) {
+	return func(c *mocks.Client) {
+		c.On("DescribeWorkflowExecution", mock.Anything, "w1", "").
+			Return(describeStatus(status), nil)
+		c.On("QueryWorkflow", mock.Anything, "w1", "run1", mock.Anything).
+			Return(nil, errors.New("query timed out"))
+	}
+}
+
+func TestRun(t *testing.T) {
+	spec := "testdata/spec.json"
+	tests := []struct {
+		name   string
+		args   []string
+		client func(c *mocks.Client) // nil for no Temporal server
+		code   int
+	}{
+		{"usage", []string{"-h"}, nil, exitOK},
+		{"unknown subcommand", []string{"migrate"}, nil, exitUsage},
+		{"unknown flag", []string{"status", "-x"}, nil, exitUsage},
+		{"no workflow ID", []string{"cancel"}, nil, exitUsage},
+		{"no signal name", []string{"signal", "-w", "w1"}, nil, exitUsage},
+		{"bad signal data", []string{"signal", "-w", "w1", "-n", "s", "-d", "{"},
+			nil, exitUsage},
+		{"no spec", []string{}, nil, exitUsage},
+		{"-a and -f", []string{"start", "-a", spec, "-f", spec}, nil, exitUsage},
+		{"missing spec file", []string{"-a", "testdata/missing.json"}, nil, exitUsage},
+		{"print spec", []string{"start", "-a", spec, "-print"}, nil, exitOK},
+		{"no schedule action", []string{"schedule"}, nil, exitUsage},
+		{"unknown schedule action", []string{"schedule", "run"}, nil, exitUsage},
+		{"no Temporal server", []string{"status", "-w", "w1"}, nil, exitError},
+
+		{"legacy start", []string{"-a", spec}, func(c *mocks.Client) {
+			run := &mocks.WorkflowRun{}
+			run.On("GetID").Return("migrate-apps-1")
+			run.On("GetRunID").Return("run1")
+			c.On("ExecuteWorkflow", mock.Anything, mock.MatchedBy(
+				func(opts client.StartWorkflowOptions) bool {
+					return opts.ID == "migrate-apps-1"
+				}), mock.Anything, mock.Anything).Return(run, nil)
+		}, exitOK},
+		{"already started", []string{"start", "-a", spec}, func(c *mocks.Client) {
+			c.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything,
+				mock.Anything).Return(nil, serviceerror.NewWorkflowExecutionAlreadyStarted(
+				"workflow execution already started", "", "run0"))
+		}, exitAlreadyStarted},
+		{"start and wait", []string{"start", "-a", spec, "-wait"}, func(c *mocks.Client) {
+			run := &mocks.WorkflowRun{}
+			run.On("GetID").Return("migrate-apps-1")
+			run.On("GetRunID").Return("run1")
+			run.On("Get", mock.Anything, mock.Anything).Return(errors.New("activity error"))
+			c.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything,
+				mock.Anything).Return(run, nil)
+		}, exitWorkflowFailed},
+		{"status completed", []string{"status", "-w", "w1"},
+			onStatus(enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED), exitOK},
+		{"status running", []string{"status", "-w", "w1", "-json"},
+			onStatus(enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING), exitRunning},
+		{"status failed", []string{"status", "-w", "w1"},
+			onStatus(enumspb.WORKFLOW_EXECUTION_STATUS_FAILED), exitWorkflowFailed},
+		{"status not found", []string{"status", "-w", "w1"}, func(c *mocks.Client) {
+			c.On("DescribeWorkflowExecution", mock.Anything, "w1", "").
+				Return(nil, serviceerror.NewNotFound("workflow not found"))
+		}, exitNotFound},
+		{"cancel", []string{"cancel", "-w", "w1", "-r", "run1"}, func(c *mocks.Client) {
+			c.On("CancelWorkflow", mock.Anything, "w1", "run1").Return(nil)
+		}, exitOK},
+		{"cancel failed", []string{"cancel", "-w", "w1"}, func(c *mocks.Client) {
+			c.On("CancelWorkflow", mock.Anything, "w1", "").
+				Return(serviceerror.NewUnavailable("connection refused"))
+		}, exitError},
+		{"return", []string{"return", "-w", "w1"}, func(c *mocks.Client) {
+			c.On("SignalWorkflow", mock.Anything, "w1", "", "return", nil).Return(nil)
+		}, exitOK},
+		{"signal", []string{"signal", "-w", "w1", "-n", "s", "-d", `{"a": 1}`},
+			func(c *mocks.Client) {
+				c.On("SignalWorkflow", mock.Anything, "w1", "", "s",
+					map[string]interface{}{"a": float64(1)}).Return(nil)
+			}, exitOK},
+		{"terminate not found", []string{"terminate", "-w", "w1"}, func(c *mocks.Client) {
+			c.On("TerminateWorkflow", mock.Anything, "w1", "",
+				"terminated by migrate_workflowclient").
+				Return(serviceerror.NewNotFound("workflow not found"))
+		}, exitNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.client == nil {
+				withClient(t, nil)
+				_, code := captureStdout(t, func() int { return run(tc.args) })
+				assert.Equal(t, tc.code, code)
+				return
+			}
+			c := &mocks.Client{}
+			c.On("Close").Return()
+			tc.client(c)
+			withClient(t, c)
+			_, code := captureStdout(t, func() int { return run(tc.args) })
+			assert.Equal(t, tc.code, code)
+			c.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRunLegacyArgs(t *testing.T) {
+	withClient(t, nil)
+	legacy, code := captureStdout(t, func() int {
+		return run([]string{"-a", "testdata/spec.json", "-print"})
+	})
+	require.Equal(t, exitOK, code)
+	start, code := captureStdout(t, func() int {
+		return run([]string{"start", "-a", "testdata/spec.json", "-print"})
+	})
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, start, legacy)
+	assert.Contains(t, legacy, `"id": "migrate-apps-1"`)
+}
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{serviceerror.NewNotFound("workflow not found"), exitNotFound},
+		{serviceerror.NewWorkflowExecutionAlreadyStarted("already started", "", "run1"),
+			exitAlreadyStarted},
+		{serviceerror.NewInvalidArgument("bad query"), exitError},
+		{errors.New("connection refused"), exitError},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.code, exitCodeFor(tc.err), "%T", tc.err)
+	}
+}
+
+func TestExitCodeForStatus(t *testing.T) {
+	tests := []struct {
+		status enumspb.WorkflowExecutionStatus
+		code   int
+	}{
+		{enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED, exitOK},
+		{enumspb.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW, exitOK},
+		{enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING, exitRunning},
+		{enumspb.WORKFLOW_EXECUTION_STATUS_FAILED, exitWorkflowFailed},
+		{enumspb.WORKFLOW_EXECUTION_STATUS_CANCELED, exitWorkflowFailed},
+		{enumspb.WORKFLOW_EXECUTION_STATUS_TERMINATED, exitWorkflowFailed},
+		{enumspb.WORKFLOW_EXECUTION_STATUS_TIMED_OUT, exitWorkflowFailed},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.code, exitCodeForStatus(tc.status), "%s", tc.status)
+	}
+}
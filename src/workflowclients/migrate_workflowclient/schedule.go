@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+// scheduleActions are the actions of the schedule subcommand.
+var scheduleActions = []subcommand{
+	{"create", "create a recurring migration", runScheduleCreate},
+	{"list", "list the recurring migrations", runScheduleList},
+	{"show", "show a recurring migration and its last run", runScheduleShow},
+	{"pause", "pause a recurring migration", runSchedulePause},
+	{"resume", "resume a paused recurring migration", runScheduleResume},
+	{"delete", "delete a recurring migration; its last run runs on", runScheduleDelete},
+}
+
+func scheduleUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s schedule <action> [flags]\n\nActions:\n",
+		os.Args[0])
+	for _, action := range scheduleActions {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", action.name, action.usage)
+	}
+}
+
+// runSchedule runs an action on recurring migrations, which are run by a
+// MigScheduleWorkflow each.
+func runSchedule(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		scheduleUsage()
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	for _, action := range scheduleActions {
+		if action.name == args[0] {
+			return action.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown schedule action %q\n", args[0])
+	scheduleUsage()
+	return exitUsage
+}
+
+// scheduleIDFlag adds the flag that identifies a schedule.
+func scheduleIDFlag(fs *flag.FlagSet) *string {
+	return fs.String("id", "", "Schedule ID (required)")
+}
+
+// parseScheduleFlags parses args and checks that a schedule ID was given.
+func parseScheduleFlags(fs *flag.FlagSet, args []string, id *string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if *id == "" {
+		fmt.Fprintf(os.Stderr, "Error: Need to provide a schedule ID with -id\n")
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+// runScheduleCreate creates a recurring migration. The workflow params of
+// its runs are given as for the start subcommand, without the target
+// cluster, and the workflow ID is the schedule ID.
+func runScheduleCreate(args []string) int {
+	fs := newFlagSet("schedule create")
+	sf := addSpecFlags(fs)
+	cron := fs.String("cron", "",
+		"When to migrate, as a cron expression such as \"0 8,16 * * *\" (required)")
+	timeZone := fs.String("time-zone", "", "IANA time zone of -cron (default UTC)")
+	var targets multiFlag
+	fs.Var(&targets, "target",
+		"Target cluster as provider+cluster, taken in turn (repeatable, required)")
+	printOnly := fs.Bool("print", false,
+		"Print the schedule as JSON instead of creating it")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	spec, err := sf.buildSpec()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := validateSpec(spec); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	schedule := emcomigrate.MigSchedule{
+		ID:             spec.WfStartOpts.ID,
+		Cron:           *cron,
+		TimeZone:       *timeZone,
+		Targets:        targets,
+		WorkflowParams: spec.WfParams,
+	}
+	if err := schedule.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if *printOnly {
+		if err := printJSON(schedule); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	we, err := emcomigrate.StartMigSchedule(context.Background(), c, schedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create schedule %s: %s\n", schedule.ID, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Created schedule %s. WorkflowID: %s RunID: %s\n", schedule.ID,
+		we.GetID(), we.GetRunID())
+	return exitOK
+}
+
+// runScheduleList lists the recurring migrations.
+func runScheduleList(args []string) int {
+	fs := newFlagSet("schedule list")
+	asJSON := fs.Bool("json", false, "Print the schedules as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	schedules, err := emcomigrate.ListMigSchedules(context.Background(), c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list schedules: %s\n", err)
+		return exitCodeFor(err)
+	}
+	if *asJSON {
+		if err := printJSON(schedules); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	fmt.Printf("%-24s %-20s %-16s %-8s %-20s %-24s %-6s %-8s\n",
+		"SCHEDULE ID", "CRON", "TIME ZONE", "PAUSED", "NEXT RUN", "NEXT TARGET",
+		"RUNS", "SKIPPED")
+	for _, s := range schedules {
+		timeZone, nextRun := s.TimeZone, s.NextRun
+		if timeZone == "" {
+			timeZone = "UTC"
+		}
+		if nextRun == "" {
+			nextRun = "-"
+		}
+		fmt.Printf("%-24s %-20s %-16s %-8t %-20s %-24s %-6d %-8d\n",
+			s.ID, s.Cron, timeZone, s.Paused, nextRun,
+			s.Targets[s.NextTarget%len(s.Targets)], s.Runs, s.Skipped)
+	}
+	return exitOK
+}
+
+// runScheduleShow shows a recurring migration.
+func runScheduleShow(args []string) int {
+	fs := newFlagSet("schedule show")
+	id := scheduleIDFlag(fs)
+	asJSON := fs.Bool("json", false, "Print the schedule as JSON")
+	if !parseScheduleFlags(fs, args, id) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	s, err := emcomigrate.GetMigSchedule(context.Background(), c, *id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get schedule %s: %s\n", *id, err)
+		return exitCodeFor(err)
+	}
+	if *asJSON {
+		if err := printJSON(s); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	fmt.Printf("Schedule ID:    %s\n", s.ID)
+	fmt.Printf("Workflow ID:    %s\n", emcomigrate.MigScheduleWorkflowID(s.ID))
+	fmt.Printf("Cron:           %s\n", s.Cron)
+	if s.TimeZone != "" {
+		fmt.Printf("Time zone:      %s\n", s.TimeZone)
+	}
+	fmt.Printf("Targets:        %s\n", strings.Join(s.Targets, ", "))
+	fmt.Printf("Paused:         %t\n", s.Paused)
+	if s.NextRun != "" {
+		fmt.Printf("Next run:       %s to %s\n", s.NextRun,
+			s.Targets[s.NextTarget%len(s.Targets)])
+	}
+	fmt.Printf("Runs:           %d started, %d skipped, %d failed to start\n",
+		s.Runs, s.Skipped, s.FailedStarts)
+	if s.LastRun != nil {
+		fmt.Printf("Last run:       %s to %s, run ID %s\n", s.LastRun.WorkflowID,
+			s.LastRun.Target, s.LastRun.RunID)
+	}
+	return exitOK
+}
+
+// runSchedulePause pauses a recurring migration.
+func runSchedulePause(args []string) int {
+	return pauseSchedule("pause", args, true)
+}
+
+// runScheduleResume resumes a paused recurring migration.
+func runScheduleResume(args []string) int {
+	return pauseSchedule("resume", args, false)
+}
+
+func pauseSchedule(action string, args []string, pause bool) int {
+	fs := newFlagSet("schedule " + action)
+	id := scheduleIDFlag(fs)
+	if !parseScheduleFlags(fs, args, id) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	if err := emcomigrate.PauseMigSchedule(context.Background(), c, *id, pause); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s schedule %s: %s\n", action, *id, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Requested %s of schedule %s\n", action, *id)
+	return exitOK
+}
+
+// runScheduleDelete deletes a recurring migration. The migration that it
+// started last, if still running, is left running.
+func runScheduleDelete(args []string) int {
+	fs := newFlagSet("schedule delete")
+	id := scheduleIDFlag(fs)
+	if !parseScheduleFlags(fs, args, id) {
+		return exitUsage
+	}
+
+	c, err := newTemporalClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer c.Close()
+
+	if err := emcomigrate.DeleteMigSchedule(context.Background(), c, *id); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to delete schedule %s: %s\n", *id, err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("Deleted schedule %s\n", *id)
+	return exitOK
+}
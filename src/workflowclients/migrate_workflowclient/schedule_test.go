@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+const testScheduleWorkflowID = "migration-schedule/follow-the-sun"
+
+// testSchedule returns the state of a schedule that has run once.
+func testSchedule() emcomigrate.MigSchedule {
+	lastRun := time.Date(2022, 6, 4, 8, 0, 0, 0, time.UTC)
+	return emcomigrate.MigSchedule{
+		ID:         "follow-the-sun",
+		Cron:       "0 8,16 * * *",
+		Targets:    []string{"provider1+cluster1", "provider2+cluster2"},
+		NextTarget: 1,
+		NextRun:    "2022-06-04T16:00:00Z",
+		LastFire:   &lastRun,
+		Runs:       1,
+		LastRun: &emcomigrate.ScheduledRun{WorkflowID: "follow-the-sun-20220604T0800Z",
+			RunID: "run1", Target: "provider1+cluster1", Time: lastRun},
+	}
+}
+
+// scheduleValue returns the result of a query of the state of schedule.
+func scheduleValue(schedule emcomigrate.MigSchedule) *mocks.Value {
+	value := &mocks.Value{}
+	value.On("Get", mock.AnythingOfType("*emcomigrate.MigSchedule")).
+		Run(func(args mock.Arguments) {
+			*args.Get(0).(*emcomigrate.MigSchedule) = schedule
+		}).Return(nil)
+	return value
+}
+
+func TestRunSchedule(t *testing.T) {
+	spec := "testdata/spec.json"
+	create := []string{"schedule", "create", "-a", spec, "-id", "follow-the-sun",
+		"-cron", "0 8,16 * * *", "-target", "provider1+cluster1",
+		"-target", "provider2+cluster2"}
+	tests := []struct {
+		name   string
+		args   []string
+		client func(c *mocks.Client) // nil for no Temporal server
+		code   int
+		out    string
+	}{
+		{"usage", []string{"schedule", "-h"}, nil, exitOK, ""},
+		{"create without cron", []string{"schedule", "create", "-a", spec,
+			"-target", "provider1+cluster1"}, nil, exitUsage, ""},
+		{"create without targets", []string{"schedule", "create", "-a", spec,
+			"-cron", "0 8 * * *"}, nil, exitUsage, ""},
+		{"create with bad spec", []string{"schedule", "create", "-a", "testdata/missing.json",
+			"-cron", "0 8 * * *", "-target", "provider1+cluster1"}, nil, exitUsage, ""},
+		{"print", append(create, "-print"), nil, exitOK, `"cron": "0 8,16 * * *"`},
+		{"no Temporal server", create, nil, exitError, ""},
+		{"show without ID", []string{"schedule", "show"}, nil, exitUsage, ""},
+		{"pause without ID", []string{"schedule", "pause"}, nil, exitUsage, ""},
+		{"delete without ID", []string{"schedule", "delete"}, nil, exitUsage, ""},
+
+		{"create", create, func(c *mocks.Client) {
+			run := &mocks.WorkflowRun{}
+			run.On("GetID").Return(testScheduleWorkflowID)
+			run.On("GetRunID").Return("run0")
+			c.On("ExecuteWorkflow", mock.Anything, mock.MatchedBy(
+				func(opts client.StartWorkflowOptions) bool {
+					return opts.ID == testScheduleWorkflowID &&
+						opts.TaskQueue == emcomigrate.MigTaskQueue
+				}), mock.Anything, mock.MatchedBy(
+				func(schedule emcomigrate.MigSchedule) bool {
+					return schedule.ID == "follow-the-sun" &&
+						schedule.Cron == "0 8,16 * * *" &&
+						len(schedule.Targets) == 2 &&
+						schedule.WorkflowParams.ActivityParams["all-activities"]["project"] ==
+							"proj1"
+				})).Return(run, nil)
+		}, exitOK, "Created schedule follow-the-sun. WorkflowID: " +
+			testScheduleWorkflowID + " RunID: run0\n"},
+		{"create existing", create, func(c *mocks.Client) {
+			c.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything,
+				mock.Anything).Return(nil, serviceerror.NewWorkflowExecutionAlreadyStarted(
+				"workflow execution already started", "", "run0"))
+		}, exitAlreadyStarted, ""},
+		{"list", []string{"schedule", "list"}, func(c *mocks.Client) {
+			c.On("ListOpenWorkflow", mock.Anything, mock.Anything).Return(
+				&workflowservice.ListOpenWorkflowExecutionsResponse{
+					Executions: []*workflowpb.WorkflowExecutionInfo{{
+						Execution: &commonpb.WorkflowExecution{
+							WorkflowId: testScheduleWorkflowID, RunId: "run0"},
+					}},
+				}, nil)
+			c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "run0",
+				emcomigrate.ScheduleStateQuery).Return(scheduleValue(testSchedule()), nil)
+		}, exitOK, "follow-the-sun           0 8,16 * * *         UTC              " +
+			"false    2022-06-04T16:00:00Z provider2+cluster2       1      0       \n"},
+		{"list failed", []string{"schedule", "list", "-json"}, func(c *mocks.Client) {
+			c.On("ListOpenWorkflow", mock.Anything, mock.Anything).
+				Return(nil, serviceerror.NewUnavailable("connection refused"))
+		}, exitError, ""},
+		{"show", []string{"schedule", "show", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "",
+					emcomigrate.ScheduleStateQuery).Return(scheduleValue(testSchedule()), nil)
+			}, exitOK, "Next run:       2022-06-04T16:00:00Z to provider2+cluster2\n"},
+		{"show JSON", []string{"schedule", "show", "-id", "follow-the-sun", "-json"},
+			func(c *mocks.Client) {
+				c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "",
+					emcomigrate.ScheduleStateQuery).Return(scheduleValue(testSchedule()), nil)
+			}, exitOK, `"lastFire": "2022-06-04T08:00:00Z"`},
+		{"show missing", []string{"schedule", "show", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("QueryWorkflow", mock.Anything, testScheduleWorkflowID, "",
+					emcomigrate.ScheduleStateQuery).
+					Return(nil, serviceerror.NewNotFound("workflow not found"))
+			}, exitNotFound, ""},
+		{"pause", []string{"schedule", "pause", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("SignalWorkflow", mock.Anything, testScheduleWorkflowID, "",
+					emcomigrate.PauseScheduleSignal, nil).Return(nil)
+			}, exitOK, "Requested pause of schedule follow-the-sun\n"},
+		{"resume", []string{"schedule", "resume", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("SignalWorkflow", mock.Anything, testScheduleWorkflowID, "",
+					emcomigrate.ResumeScheduleSignal, nil).Return(nil)
+			}, exitOK, "Requested resume of schedule follow-the-sun\n"},
+		{"resume missing", []string{"schedule", "resume", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("SignalWorkflow", mock.Anything, testScheduleWorkflowID, "",
+					emcomigrate.ResumeScheduleSignal, nil).
+					Return(serviceerror.NewNotFound("workflow not found"))
+			}, exitNotFound, ""},
+		{"delete", []string{"schedule", "delete", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("CancelWorkflow", mock.Anything, testScheduleWorkflowID, "").Return(nil)
+			}, exitOK, "Deleted schedule follow-the-sun\n"},
+		{"delete failed", []string{"schedule", "delete", "-id", "follow-the-sun"},
+			func(c *mocks.Client) {
+				c.On("CancelWorkflow", mock.Anything, testScheduleWorkflowID, "").
+					Return(errors.New("connection refused"))
+			}, exitError, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var c *mocks.Client
+			if tc.client != nil {
+				c = &mocks.Client{}
+				c.On("Close").Return()
+				tc.client(c)
+			}
+			withClient(t, c)
+			out, code := captureStdout(t, func() int { return run(tc.args) })
+			assert.Equal(t, tc.code, code)
+			assert.Contains(t, out, tc.out)
+			if c != nil {
+				c.AssertExpectations(t)
+			}
+		})
+	}
+}
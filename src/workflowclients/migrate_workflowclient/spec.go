@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+// Keys of duration fields in a workflow spec. Their values may be given as
+// strings like "60s" instead of nanoseconds.
+var durationKeys = map[string]bool{
+	"workflowExecutionTimeout": true,
+	"workflowRunTimeout":       true,
+	"workflowTaskTimeout":      true,
+	"scheduleToCloseTimeout":   true,
+	"scheduleToStartTimeout":   true,
+	"startToCloseTimeout":      true,
+	"heartbeatTimeout":         true,
+	"initialInterval":          true,
+	"maximumInterval":          true,
+}
+
+// multiFlag is a flag that can be given more than once.
+type multiFlag []string
+
+func (m *multiFlag) String() string { return strings.Join(*m, ",") }
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// specFlags are the flags of the start subcommand that build a workflow
+// spec, or override parts of the spec read from a file.
+type specFlags struct {
+	argFile     string
+	specFile    string
+	valuesFiles multiFlag
+
+	id             string
+	emcoURL        string
+	project        string
+	compositeApp   string
+	version        string
+	dig            string
+	targetProvider string
+	targetCluster  string
+	params         multiFlag
+
+	startToClose  time.Duration
+	heartbeat     time.Duration
+	retryInterval time.Duration
+	maxAttempts   int
+}
+
+func addSpecFlags(fs *flag.FlagSet) *specFlags {
+	sf := &specFlags{}
+	fs.StringVar(&sf.argFile, "a", "", "Workflow params as JSON file")
+	fs.StringVar(&sf.specFile, "f", "",
+		"Workflow params as YAML file; may be a template, or an emcoctl workflow intent")
+	fs.Var(&sf.valuesFiles, "v", "Values file for the -f template (repeatable)")
+
+	fs.StringVar(&sf.id, "id", "", "Workflow ID")
+	fs.StringVar(&sf.emcoURL, "emco-url", "", "EMCO orchestrator URL, such as http://emco:30415")
+	fs.StringVar(&sf.project, "project", "", "EMCO project")
+	fs.StringVar(&sf.compositeApp, "composite-app", "", "Composite app name")
+	fs.StringVar(&sf.version, "version", "", "Composite app version")
+	fs.StringVar(&sf.dig, "dig", "", "Deployment intent group")
+	fs.StringVar(&sf.targetProvider, "target-provider", "", "Target cluster provider")
+	fs.StringVar(&sf.targetCluster, "target-cluster", "", "Target cluster")
+	fs.Var(&sf.params, "param", "Other workflow param as key=value (repeatable)")
+
+	fs.DurationVar(&sf.startToClose, "start-to-close", 0,
+		"StartToClose timeout of all activities, such as 60s")
+	fs.DurationVar(&sf.heartbeat, "heartbeat", 0,
+		"Heartbeat timeout of all activities, such as 10s")
+	fs.DurationVar(&sf.retryInterval, "retry-interval", 0,
+		"Initial retry interval of all activities, such as 1s")
+	fs.IntVar(&sf.maxAttempts, "max-attempts", 0,
+		"Maximum attempts of each activity (0: unlimited)")
+	return sf
+}
+
+// buildSpec builds the workflow spec from the spec file, if any, and the
+// flags, which take precedence over the file.
+func (sf *specFlags) buildSpec() (*eta.WfTemporalSpec, error) {
+	if sf.argFile != "" && sf.specFile != "" {
+		return nil, fmt.Errorf("Error: -a and -f cannot be used together")
+	}
+
+	doc := map[string]interface{}{}
+	var err error
+	switch {
+	case sf.argFile != "":
+		fmt.Fprintf(os.Stderr, "Will read parameters from file: %s\n", sf.argFile)
+		doc, err = readSpecDoc(sf.argFile, nil)
+	case sf.specFile != "":
+		fmt.Fprintf(os.Stderr, "Will read parameters from file: %s\n", sf.specFile)
+		var values map[string]interface{}
+		if values, err = readValues(sf.valuesFiles); err != nil {
+			return nil, err
+		}
+		doc, err = readSpecDoc(sf.specFile, values)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	setIfGiven(doc, sf.id, "workflowStartOptions", "id")
+
+	allParams := []string{"workflowParams", "activityParams", emcomigrate.ALL_ACTIVITIES}
+	paramFlags := map[string]string{
+		"emcoURL":               sf.emcoURL,
+		"project":               sf.project,
+		"compositeApp":          sf.compositeApp,
+		"compositeAppVersion":   sf.version,
+		"deploymentIntentGroup": sf.dig,
+		"targetClusterProvider": sf.targetProvider,
+		"targetClusterName":     sf.targetCluster,
+	}
+	for _, param := range sf.params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Error: -param must be key=value, got %q", param)
+		}
+		paramFlags[kv[0]] = kv[1]
+	}
+	for name, value := range paramFlags {
+		setIfGiven(doc, value, append(allParams, name)...)
+	}
+
+	allOpts := []string{"workflowParams", "activityOptions", emcomigrate.ALL_ACTIVITIES}
+	if sf.startToClose != 0 {
+		setPath(doc, int64(sf.startToClose), append(allOpts, "startToCloseTimeout")...)
+	}
+	if sf.heartbeat != 0 {
+		setPath(doc, int64(sf.heartbeat), append(allOpts, "heartbeatTimeout")...)
+	}
+	if sf.retryInterval != 0 {
+		setPath(doc, int64(sf.retryInterval),
+			append(allOpts, "retryPolicy", "initialInterval")...)
+	}
+	if sf.maxAttempts != 0 {
+		setPath(doc, sf.maxAttempts, append(allOpts, "retryPolicy", "maximumAttempts")...)
+	}
+
+	if err := normalizeDurations(doc); err != nil {
+		return nil, err
+	}
+	stringifyParams(doc)
+	specJSON, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var spec eta.WfTemporalSpec
+	if err := json.Unmarshal(specJSON, &spec); err != nil {
+		return nil, fmt.Errorf("Invalid workflow spec: %s", err)
+	}
+	return &spec, nil
+}
+
+// readSpecDoc reads a workflow spec from a JSON or YAML file. If values are
+// given, the file is first expanded as a Go template with those values, as
+// emcoctl does. The file may also be an emcoctl workflow intent, with the
+// workflow spec under spec.temporal; if it has several YAML documents, the
+// first workflow intent is used.
+func readSpecDoc(filename string, values map[string]interface{}) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if values != nil {
+		tmpl, err := template.New(filename).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template %s: %s", filename, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return nil, fmt.Errorf("Failed to expand template %s: %s", filename, err)
+		}
+		data = buf.Bytes()
+	}
+
+	var first map[string]interface{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc map[string]interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %s", filename, err)
+		}
+		if doc == nil {
+			continue
+		}
+		if spec, ok := doc["spec"].(map[string]interface{}); ok {
+			if temporal, ok := spec["temporal"].(map[string]interface{}); ok {
+				return temporal, nil
+			}
+		}
+		if first == nil {
+			first = doc
+		}
+	}
+	if first == nil {
+		first = map[string]interface{}{}
+	}
+	return first, nil
+}
+
+// readValues merges the given YAML values files; later files take precedence.
+func readValues(filenames []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("Failed to parse values file %s: %s", filename, err)
+		}
+		for k, v := range fileValues {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// setPath sets the value at the given path of nested maps, creating the
+// intermediate maps as needed.
+func setPath(doc map[string]interface{}, value interface{}, path ...string) {
+	m := doc
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+func setIfGiven(doc map[string]interface{}, value string, path ...string) {
+	if value != "" {
+		setPath(doc, value, path...)
+	}
+}
+
+// stringifyParams converts scalar activity parameter values, such as
+// rollbackOnFailure: true or updateParallelism: 4 in a YAML spec, to strings,
+// since the workflow spec only holds string parameters.
+func stringifyParams(doc map[string]interface{}) {
+	workflowParams, _ := doc["workflowParams"].(map[string]interface{})
+	activityParams, _ := workflowParams["activityParams"].(map[string]interface{})
+	for _, params := range activityParams {
+		params, ok := params.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range params {
+			switch value.(type) {
+			case bool, int, int64, uint64, float64:
+				params[key] = fmt.Sprint(value)
+			}
+		}
+	}
+}
+
+// normalizeDurations converts string values of duration fields, such as
+// "60s", to nanoseconds, which is what the workflow spec expects.
+func normalizeDurations(v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && durationKeys[key] {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return fmt.Errorf("Invalid duration for %s: %s", key, err)
+				}
+				v[key] = int64(d)
+				continue
+			}
+			if err := normalizeDurations(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if err := normalizeDurations(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
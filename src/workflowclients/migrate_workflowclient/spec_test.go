@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright (c) 2022 Intel Corporation
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	eta "gitlab.com/project-emco/core/emco-base/src/workflowmgr/pkg/emcotemporalapi"
+	"gitlab.com/project-emco/samples/temporal/migrate-workflow/src/emcomigrate"
+)
+
+// testSpec returns the workflow spec in testdata/spec.json.
+func testSpec() *eta.WfTemporalSpec {
+	return &eta.WfTemporalSpec{
+		WfClientName: "migrate_workflowclient",
+		WfStartOpts: eta.StartWorkflowOptions{
+			ID:          "migrate-apps-1",
+			RetryPolicy: &temporal.RetryPolicy{MaximumAttempts: 2},
+		},
+		WfParams: eta.WorkflowParams{
+			ActivityOpts: map[string]workflow.ActivityOptions{
+				emcomigrate.ALL_ACTIVITIES: {
+					StartToCloseTimeout: 60 * time.Second,
+					HeartbeatTimeout:    10 * time.Second,
+					RetryPolicy:         &temporal.RetryPolicy{InitialInterval: time.Second},
+				},
+			},
+			ActivityParams: map[string]map[string]string{
+				emcomigrate.ALL_ACTIVITIES: {
+					"emcoURL":               "http://192.168.1.201:30415",
+					"project":               "proj1",
+					"compositeApp":          "capp1",
+					"compositeAppVersion":   "v1",
+					"deploymentIntentGroup": "dig1",
+					"targetClusterProvider": "provider2",
+					"targetClusterName":     "cluster2",
+				},
+			},
+		},
+	}
+}
+
+// parseSpecFlags returns the spec flags of the start subcommand given args.
+func parseSpecFlags(t *testing.T, args ...string) *specFlags {
+	fs := flag.NewFlagSet("start", flag.ContinueOnError)
+	sf := addSpecFlags(fs)
+	require.NoError(t, fs.Parse(args))
+	return sf
+}
+
+func TestBuildSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		spec func(spec *eta.WfTemporalSpec) // changes to testSpec
+	}{
+		{"json", []string{"-a", "testdata/spec.json"}, nil},
+		{"yaml", []string{"-f", "testdata/spec.yaml"}, nil},
+		{"yaml with -a", []string{"-a", "testdata/spec.yaml"}, nil},
+		{"flags override the file", []string{"-f", "testdata/spec.yaml",
+			"-id", "migrate-apps-2", "-dig", "dig2", "-target-cluster", "cluster3",
+			"-param", "strategy=waves", "-param", "waveSize=2",
+			"-start-to-close", "2m", "-max-attempts", "5"},
+			func(spec *eta.WfTemporalSpec) {
+				spec.WfStartOpts.ID = "migrate-apps-2"
+				params := spec.WfParams.ActivityParams[emcomigrate.ALL_ACTIVITIES]
+				params["deploymentIntentGroup"] = "dig2"
+				params["targetClusterName"] = "cluster3"
+				params["strategy"] = "waves"
+				params["waveSize"] = "2"
+				opts := spec.WfParams.ActivityOpts[emcomigrate.ALL_ACTIVITIES]
+				opts.StartToCloseTimeout = 2 * time.Minute
+				opts.RetryPolicy.MaximumAttempts = 5
+				spec.WfParams.ActivityOpts[emcomigrate.ALL_ACTIVITIES] = opts
+			}},
+		{"flags only", []string{"-id", "migrate-apps-1",
+			"-emco-url", "http://192.168.1.201:30415", "-project", "proj1",
+			"-composite-app", "capp1", "-version", "v1", "-dig", "dig1",
+			"-target-provider", "provider2", "-target-cluster", "cluster2",
+			"-start-to-close", "60s", "-heartbeat", "10s", "-retry-interval", "1s"},
+			func(spec *eta.WfTemporalSpec) {
+				spec.WfClientName = ""
+				spec.WfStartOpts.RetryPolicy = nil
+			}},
+		{"template", []string{"-f", "testdata/workflow-intent.yaml",
+			"-v", "testdata/values.yaml"},
+			func(spec *eta.WfTemporalSpec) {
+				spec.WfStartOpts.ID = "migrate-dig1"
+				spec.WfStartOpts.RetryPolicy = nil
+				spec.WfStartOpts.WorkflowRunTimeout = time.Hour
+				spec.WfParams.ActivityParams[emcomigrate.ALL_ACTIVITIES]["targetClusterProvider"] =
+					"provider1"
+				opts := spec.WfParams.ActivityOpts[emcomigrate.ALL_ACTIVITIES]
+				opts.RetryPolicy = nil
+				spec.WfParams.ActivityOpts[emcomigrate.ALL_ACTIVITIES] = opts
+			}},
+		{"template with values files in turn", []string{"-f", "testdata/workflow-intent.yaml",
+			"-v", "testdata/values.yaml", "-v", "testdata/values-override.yaml"},
+			func(spec *eta.WfTemporalSpec) {
+				spec.WfStartOpts.ID = "migrate-dig2"
+				spec.WfStartOpts.RetryPolicy = nil
+				spec.WfStartOpts.WorkflowRunTimeout = time.Hour
+				params := spec.WfParams.ActivityParams[emcomigrate.ALL_ACTIVITIES]
+				params["targetClusterProvider"] = "provider1"
+				params["deploymentIntentGroup"] = "dig2"
+				params["targetClusterName"] = "cluster3"
+				opts := spec.WfParams.ActivityOpts[emcomigrate.ALL_ACTIVITIES]
+				opts.RetryPolicy = nil
+				spec.WfParams.ActivityOpts[emcomigrate.ALL_ACTIVITIES] = opts
+			}},
+		{"non-string params", []string{"-f", "testdata/spec-params.yaml"},
+			func(spec *eta.WfTemporalSpec) {
+				params := spec.WfParams.ActivityParams[emcomigrate.ALL_ACTIVITIES]
+				params["rollbackOnFailure"] = "true"
+				params["updateParallelism"] = "4"
+				params["updatePerGPI"] = "false"
+			}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := parseSpecFlags(t, tc.args...).buildSpec()
+			require.NoError(t, err)
+			want := testSpec()
+			if tc.spec != nil {
+				tc.spec(want)
+			}
+			assert.Equal(t, want, spec)
+		})
+	}
+}
+
+func TestBuildSpecErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+		return filename
+	}
+	badDuration := write("bad-duration.yaml",
+		"workflowStartOptions:\n  id: w1\n  workflowRunTimeout: 1 hour\n")
+	badTemplate := write("bad-template.yaml", "workflowStartOptions:\n  id: {{.ID\n")
+	badYAML := write("bad.yaml", "workflowStartOptions: [id: w1\n")
+	badSpec := write("bad-spec.yaml", "workflowStartOptions:\n  id: [w1]\n")
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-a", "testdata/spec.json", "-f", "testdata/spec.yaml"},
+			"Error: -a and -f cannot be used together"},
+		{[]string{"-a", "testdata/spec.json", "-param", "strategy"},
+			`Error: -param must be key=value, got "strategy"`},
+		{[]string{"-a", "testdata/spec.json", "-param", "=waves"},
+			`Error: -param must be key=value, got "=waves"`},
+		{[]string{"-f", badDuration},
+			`Invalid duration for workflowRunTimeout: time: `},
+		{[]string{"-f", badTemplate, "-v", "testdata/values.yaml"},
+			"Invalid template " + badTemplate},
+		{[]string{"-f", "testdata/workflow-intent.yaml", "-v", "testdata/values-override.yaml"},
+			"Failed to expand template testdata/workflow-intent.yaml"},
+		{[]string{"-f", badYAML}, "Failed to parse " + badYAML},
+		{[]string{"-f", "testdata/spec.yaml", "-v", badYAML},
+			"Failed to parse values file " + badYAML},
+		{[]string{"-f", badSpec}, "Invalid workflow spec"},
+		{[]string{"-a", "testdata/missing.json"}, "no such file or directory"},
+	}
+	for _, tc := range tests {
+		_, err := parseSpecFlags(t, tc.args...).buildSpec()
+		require.Error(t, err, "%v", tc.args)
+		assert.Contains(t, err.Error(), tc.err, "%v", tc.args)
+	}
+}
+
+func TestNormalizeDurations(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  interface{}
+		want interface{}
+	}{
+		{"duration keys",
+			map[string]interface{}{
+				"workflowRunTimeout":  "1h",
+				"startToCloseTimeout": "1m30s",
+				"heartbeatTimeout":    "500ms",
+			},
+			map[string]interface{}{
+				"workflowRunTimeout":  int64(time.Hour),
+				"startToCloseTimeout": int64(90 * time.Second),
+				"heartbeatTimeout":    int64(500 * time.Millisecond),
+			}},
+		{"nanoseconds kept",
+			map[string]interface{}{"heartbeatTimeout": 10000000000},
+			map[string]interface{}{"heartbeatTimeout": 10000000000}},
+		{"other keys kept",
+			map[string]interface{}{"returnAfter": "72h", "id": "10s"},
+			map[string]interface{}{"returnAfter": "72h", "id": "10s"}},
+		{"nested",
+			map[string]interface{}{"all-activities": map[string]interface{}{
+				"retryPolicy": map[string]interface{}{
+					"initialInterval": "1s", "maximumInterval": "1m",
+					"maximumAttempts": 5}}},
+			map[string]interface{}{"all-activities": map[string]interface{}{
+				"retryPolicy": map[string]interface{}{
+					"initialInterval": int64(time.Second),
+					"maximumInterval": int64(time.Minute),
+					"maximumAttempts": 5}}}},
+		{"lists",
+			[]interface{}{map[string]interface{}{"workflowTaskTimeout": "10s"}, "10s"},
+			[]interface{}{map[string]interface{}{"workflowTaskTimeout": int64(10 * time.Second)},
+				"10s"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, normalizeDurations(tc.doc))
+			assert.Equal(t, tc.want, tc.doc)
+		})
+	}
+
+	err := normalizeDurations(map[string]interface{}{"list": []interface{}{
+		map[string]interface{}{"scheduleToCloseTimeout": "soon"}}})
+	require.Error(t, err)
+	assert.Equal(t, `Invalid duration for scheduleToCloseTimeout: time: invalid `+
+		`duration "soon"`, err.Error())
+}